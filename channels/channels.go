@@ -158,6 +158,24 @@ func (c *Channels) InProgress() (map[datatransfer.ChannelID]datatransfer.Channel
 	return channels, nil
 }
 
+// ForEach calls cb with the state of each channel, stopping at and returning
+// the first error cb returns. The states are read from the store before cb is
+// first called, so each state is a consistent snapshot that is not affected
+// by events processed while iterating.
+func (c *Channels) ForEach(cb func(datatransfer.ChannelState) error) error {
+	var internalChannels []internal.ChannelState
+	err := c.stateMachines.List(&internalChannels)
+	if err != nil {
+		return err
+	}
+	for _, internalChannel := range internalChannels {
+		if err := cb(c.fromInternalChannelState(internalChannel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // GetByID searches for a channel in the slice of channels with id `chid`.
 // Returns datatransfer.EmptyChannelState if there is no channel with that id
 func (c *Channels) GetByID(ctx context.Context, chid datatransfer.ChannelID) (datatransfer.ChannelState, error) {
@@ -182,6 +200,12 @@ func (c *Channels) TransferInitiated(chid datatransfer.ChannelID) error {
 	return c.send(chid, datatransfer.TransferInitiated)
 }
 
+// TransferStarted records that the first block was sent or received over the
+// wire on the channel
+func (c *Channels) TransferStarted(chid datatransfer.ChannelID) error {
+	return c.send(chid, datatransfer.TransferStarted)
+}
+
 // Restart marks a data transfer as restarted
 func (c *Channels) Restart(chid datatransfer.ChannelID) error {
 	return c.send(chid, datatransfer.Restart)
@@ -349,6 +373,18 @@ func (c *Channels) SetDataLimit(chid datatransfer.ChannelID, dataLimit uint64) e
 	return c.send(chid, datatransfer.SetDataLimit, dataLimit)
 }
 
+// StoreError indicates that the transport could not use the store registered
+// for the channel
+func (c *Channels) StoreError(chid datatransfer.ChannelID, err error) error {
+	return c.send(chid, datatransfer.StoreError, err)
+}
+
+// ExpectedSizeReceived records the total size of the data the responder
+// expects to send on this channel
+func (c *Channels) ExpectedSizeReceived(chid datatransfer.ChannelID, size uint64) error {
+	return c.send(chid, datatransfer.ExpectedSizeReceived, size)
+}
+
 // SetRequiresFinalization sets the state of whether a data transfer can complete
 func (c *Channels) SetRequiresFinalization(chid datatransfer.ChannelID, RequiresFinalization bool) error {
 	return c.send(chid, datatransfer.SetRequiresFinalization, RequiresFinalization)
@@ -113,6 +113,12 @@ var ChannelEvents = fsm.Events{
 			chst.AddLog("")
 			return nil
 		}),
+	fsm.Event(datatransfer.ExpectedSizeReceived).FromAny().ToJustRecord().
+		Action(func(chst *internal.ChannelState, size uint64) error {
+			chst.TotalSize = size
+			chst.AddLog("expected size: %d", size)
+			return nil
+		}),
 	fsm.Event(datatransfer.SetRequiresFinalization).FromAny().ToJustRecord().
 		Action(func(chst *internal.ChannelState, RequiresFinalization bool) error {
 			chst.RequiresFinalization = RequiresFinalization
@@ -134,6 +140,16 @@ var ChannelEvents = fsm.Events{
 		chst.AddLog("data transfer receive error: %s", chst.Message)
 		return nil
 	}),
+	fsm.Event(datatransfer.TransferStarted).FromAny().ToJustRecord().
+		Action(func(chst *internal.ChannelState) error {
+			chst.AddLog("first block transferred")
+			return nil
+		}),
+	fsm.Event(datatransfer.StoreError).FromAny().ToNoChange().Action(func(chst *internal.ChannelState, err error) error {
+		chst.Message = err.Error()
+		chst.AddLog("data transfer store error: %s", chst.Message)
+		return nil
+	}),
 	fsm.Event(datatransfer.RequestCancelled).FromAny().ToNoChange().Action(func(chst *internal.ChannelState, err error) error {
 		chst.Message = err.Error()
 		chst.AddLog("data transfer request cancelled: %s", chst.Message)
@@ -81,6 +81,29 @@ func TestChannels(t *testing.T) {
 		require.Contains(t, inProgress, datatransfer.ChannelID{Initiator: peers[3], Responder: peers[2], ID: tid2})
 	})
 
+	t.Run("for each channel", func(t *testing.T) {
+		var chids []datatransfer.ChannelID
+		err := channelList.ForEach(func(chst datatransfer.ChannelState) error {
+			chids = append(chids, chst.ChannelID())
+			return nil
+		})
+		require.NoError(t, err)
+		require.ElementsMatch(t, []datatransfer.ChannelID{
+			{Initiator: peers[0], Responder: peers[1], ID: tid1},
+			{Initiator: peers[3], Responder: peers[2], ID: tid2},
+		}, chids)
+
+		// iteration stops at the first error
+		errStop := errors.New("stop")
+		calls := 0
+		err = channelList.ForEach(func(chst datatransfer.ChannelState) error {
+			calls++
+			return errStop
+		})
+		require.ErrorIs(t, err, errStop)
+		require.Equal(t, 1, calls)
+	})
+
 	t.Run("get by id", func(t *testing.T) {
 		state, err := channelList.GetByID(ctx, datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid1})
 		require.NoError(t, err)
@@ -286,6 +309,25 @@ func TestChannels(t *testing.T) {
 		require.Equal(t, uint64(850), state.Queued())
 	})
 
+	t.Run("expected size", func(t *testing.T) {
+		ds := dss.MutexWrap(datastore.NewMapDatastore())
+
+		channelList, err := channels.New(ds, notifier, &fakeEnv{}, peers[0])
+		require.NoError(t, err)
+		err = channelList.Start(ctx)
+		require.NoError(t, err)
+
+		_, err = channelList.CreateNew(peers[0], tid1, cids[0], selector, fv1, peers[1], peers[0], peers[1])
+		require.NoError(t, err)
+		state := checkEvent(ctx, t, received, datatransfer.Open)
+		require.Equal(t, uint64(0), state.TotalSize())
+
+		err = channelList.ExpectedSizeReceived(datatransfer.ChannelID{Initiator: peers[1], Responder: peers[0], ID: tid1}, 1000)
+		require.NoError(t, err)
+		state = checkEvent(ctx, t, received, datatransfer.ExpectedSizeReceived)
+		require.Equal(t, uint64(1000), state.TotalSize())
+	})
+
 	t.Run("pause/resume", func(t *testing.T) {
 		state, err := channelList.GetByID(ctx, datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: tid1})
 		require.NoError(t, err)
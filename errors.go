@@ -1,5 +1,7 @@
 package datatransfer
 
+import "errors"
+
 type errorType string
 
 func (e errorType) Error() string {
@@ -30,3 +32,71 @@ const ErrRejected = errorType("response rejected")
 
 // ErrUnsupported indicates an operation is not supported by the transport protocol
 const ErrUnsupported = errorType("unsupported")
+
+// ErrRequestorCancelled indicates the remote peer that opened a request
+// cancelled it
+const ErrRequestorCancelled = errorType("request cancelled by requestor")
+
+// ErrChannelNotReady means the channel exists but the transport request for it
+// has not been opened yet
+const ErrChannelNotReady = errorType("channel not ready")
+
+// ErrHookRegistrationFailed indicates the transport could not register a hook
+// with the underlying protocol
+const ErrHookRegistrationFailed = errorType("hook registration failed")
+
+// ErrMessageTooLarge indicates a received message, or a part of it, was larger
+// than the configured decode limit
+const ErrMessageTooLarge = errorType("message too large")
+
+// ErrRequestIDCollision indicates a transport request ID is already in use by
+// a different channel
+const ErrRequestIDCollision = errorType("request id already in use by another channel")
+
+// ErrPeerCircuitOpen indicates a channel was not opened because recent
+// transfers with the peer kept failing
+const ErrPeerCircuitOpen = errorType("circuit open for peer after repeated failures")
+
+// ErrSendQueueFull indicates a message was not sent because too many messages
+// are already being sent to the peer
+const ErrSendQueueFull = errorType("send queue full")
+
+// ErrRestartPeerMismatch indicates a channel was not restarted because the
+// peer recorded in its stored state is not the other party in its channel ID
+const ErrRestartPeerMismatch = errorType("restart peer does not match channel")
+
+// ErrChannelClosed indicates a channel was closed, failed or cleaned up
+// before its transfer completed
+const ErrChannelClosed = errorType("channel closed before transfer completed")
+
+// ErrInvalidSignature indicates a received message was not signed, or its
+// signature could not be verified
+const ErrInvalidSignature = errorType("invalid message signature")
+
+// ErrChannelStalled indicates a channel was cancelled because no data was
+// sent or received on it for longer than the stall timeout
+const ErrChannelStalled = errorType("channel stalled")
+
+// IsRetryable returns false if err, or an error it wraps, reports that the
+// operation that failed is not worth retrying by implementing
+// Retryable() bool, eg because the remote peer does not have the content.
+// Other errors are assumed to be retryable.
+func IsRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	return true
+}
+
+// ErrPendingExtensionsLimit indicates a message was not queued to be sent
+// when the requester restarts a cancelled request, because too much data is
+// already queued
+const ErrPendingExtensionsLimit = errorType("pending extensions limit exceeded")
+
+// ErrTransportClosed indicates a transport was used after it was shut down
+const ErrTransportClosed = errorType("transport closed")
+
+// ErrStoreLimit indicates a store was not registered for a channel because
+// the limit on the number of registered stores was reached
+const ErrStoreLimit = errorType("registered store limit reached")
@@ -129,6 +129,18 @@ const (
 
 	// SendMessageError indicates an error sending a data transfer message
 	SendMessageError
+
+	// ExpectedSizeReceived is fired when the responder reports the total size
+	// of the data it expects to send
+	ExpectedSizeReceived
+
+	// TransferStarted indicates the first block was sent or received over
+	// the wire
+	TransferStarted
+
+	// StoreError indicates that the transport could not use the store
+	// registered for the channel
+	StoreError
 )
 
 // Events are human readable names for data transfer events
@@ -169,6 +181,9 @@ var Events = map[EventCode]string{
 	DataLimitExceeded:           "DataLimitExceeded",
 	TransferInitiated:           "TransferInitiated",
 	SendMessageError:            "SendMessageError",
+	ExpectedSizeReceived:        "ExpectedSizeReceived",
+	TransferStarted:             "TransferStarted",
+	StoreError:                  "StoreError",
 }
 
 // Event is a struct containing information about a data transfer event
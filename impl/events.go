@@ -5,6 +5,7 @@ import (
 
 	"github.com/ipld/go-ipld-prime"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -36,7 +37,7 @@ func (m *manager) OnChannelOpened(chid datatransfer.ChannelID) error {
 // received some data from the sender.
 // It fires an event on the channel, updating the sum of received data and reports
 // back a pause to the transport if the data limit is exceeded
-func (m *manager) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) error {
+func (m *manager) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
 	ctx, _ := m.spansIndex.SpanForChannel(context.TODO(), chid)
 	_, span := otel.Tracer("data-transfer").Start(ctx, "dataReceived", trace.WithAttributes(
 		attribute.String("channelID", chid.String()),
@@ -52,11 +53,11 @@ func (m *manager) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, si
 		msg := message.UpdateResponse(chid.ID, true)
 		ctx, _ := m.spansIndex.SpanForChannel(context.TODO(), chid)
 		if err := m.dataTransferNetwork.SendMessage(ctx, chid.Initiator, msg); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return err
+	return nil, err
 }
 
 // OnDataQueued is called when the transport layer reports that it has queued
@@ -119,6 +120,9 @@ func (m *manager) OnRequestReceived(chid datatransfer.ChannelID, request datatra
 		log.Infof("channel %s: received cancel request, cleaning up channel", chid)
 
 		m.transport.CleanupChannel(chid)
+		if reason := request.Reason(); reason != "" {
+			return nil, m.remoteFailed(chid, reason)
+		}
 		return nil, m.channels.Cancel(chid)
 	}
 
@@ -136,6 +140,14 @@ func (m *manager) OnTransferInitiated(chid datatransfer.ChannelID) {
 	m.channels.TransferInitiated(chid)
 }
 
+// OnTransferStarted is called when the transport layer sends or receives the
+// first block on a channel
+func (m *manager) OnTransferStarted(chid datatransfer.ChannelID) {
+	if err := m.channels.TransferStarted(chid); err != nil {
+		log.Warnf("channel %s: recording transfer started: %s", chid, err)
+	}
+}
+
 // OnRequestReceived is called when a Response message is received from the responder
 // on the initiator
 func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datatransfer.Response) error {
@@ -143,6 +155,9 @@ func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datat
 	// if response is cancel, process as cancel
 	if response.IsCancel() {
 		log.Infof("channel %s: received cancel response, cancelling channel", chid)
+		if reason := response.Reason(); reason != "" {
+			return m.remoteFailed(chid, reason)
+		}
 		return m.channels.Cancel(chid)
 	}
 
@@ -166,6 +181,9 @@ func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datat
 		if !response.Accepted() {
 			// if not, error and fail
 			log.Infof("channel %s: received rejected response, erroring out channel", chid)
+			if reason := response.Reason(); reason != "" {
+				return m.channels.Error(chid, xerrors.Errorf("%s: %w", reason, datatransfer.ErrRejected))
+			}
 			return m.channels.Error(chid, datatransfer.ErrRejected)
 		}
 	}
@@ -180,6 +198,14 @@ func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datat
 		}
 	}
 
+	// did the responder tell us how much data to expect?
+	if size, ok := response.ExpectedSize(); ok {
+		err := m.channels.ExpectedSizeReceived(chid, size)
+		if err != nil {
+			return err
+		}
+	}
+
 	// was this a response to a restart attempt?
 	if response.IsRestart() {
 		log.Infof("channel %s: received restart response, restarting channel", chid)
@@ -225,12 +251,26 @@ func (m *manager) OnResponseReceived(chid datatransfer.ChannelID, response datat
 	return nil
 }
 
+// remoteFailed records the channel as failed with the reason the remote peer
+// gave for cancelling it
+func (m *manager) remoteFailed(chid datatransfer.ChannelID, reason string) error {
+	log.Warnf("channel %s: remote peer failed channel: %s", chid, reason)
+	return m.channels.Error(chid, xerrors.Errorf("remote peer failed channel: %s", reason))
+}
+
 // OnRequestCancelled is called when a transport reports a channel is cancelled
 func (m *manager) OnRequestCancelled(chid datatransfer.ChannelID, err error) error {
 	log.Warnf("channel %+v was cancelled: %s", chid, err)
 	return m.channels.RequestCancelled(chid, err)
 }
 
+// OnChannelCancelled is called when a transport reports that the request for
+// data on a channel was cancelled
+func (m *manager) OnChannelCancelled(chid datatransfer.ChannelID) error {
+	log.Infof("channel %s: transport request cancelled", chid)
+	return m.channels.RequestCancelled(chid, xerrors.New("transport request cancelled"))
+}
+
 // OnRequestCancelled is called when a transport reports a channel disconnected
 func (m *manager) OnRequestDisconnected(chid datatransfer.ChannelID, err error) error {
 	log.Warnf("channel %+v has stalled or disconnected: %s", chid, err)
@@ -249,6 +289,27 @@ func (m *manager) OnReceiveDataError(chid datatransfer.ChannelID, err error) err
 	return m.channels.ReceiveDataError(chid, err)
 }
 
+// OnStoreError is called when a transport could not use the store registered
+// for a channel
+func (m *manager) OnStoreError(chid datatransfer.ChannelID, err error) error {
+	log.Warnf("channel %+v could not use registered store: %s", chid, err)
+	return m.channels.StoreError(chid, err)
+}
+
+// OnMalformedMessage is called when a transport rejects a request from a
+// peer because its message could not be decoded
+func (m *manager) OnMalformedMessage(p peer.ID, extensions []string, err error) error {
+	log.Warnw("received malformed message", "peer", p, "extensions", extensions, "err", err)
+	return nil
+}
+
+// OnChannelError is called when a transport has failed a channel locally
+// with the given reason
+func (m *manager) OnChannelError(chid datatransfer.ChannelID, reason error) error {
+	log.Warnf("channel %+v was failed: %s", chid, reason)
+	return m.channels.Error(chid, reason)
+}
+
 // OnChannelCompleted is called
 // - by the requester when all data for a transfer has been received
 // - by the responder when all data for a transfer has been sent
@@ -265,7 +326,7 @@ func (m *manager) OnChannelCompleted(chid datatransfer.ChannelID, completeErr er
 		// send an error, but only if we haven't already errored for some reason
 		if chst.Status() != datatransfer.Failing && chst.Status() != datatransfer.Failed {
 			err := xerrors.Errorf("data transfer channel %s failed to transfer data: %w", chid, completeErr)
-			log.Warnf(err.Error())
+			log.Warnw(err.Error(), "retryable", datatransfer.IsRetryable(completeErr))
 			return m.channels.Error(chid, err)
 		}
 		return nil
@@ -301,6 +362,16 @@ func (m *manager) OnChannelCompleted(chid datatransfer.ChannelID, completeErr er
 	return m.channels.Complete(chid)
 }
 
+// OnChannelCompletedDetailed is called after OnChannelCompleted with a
+// description of how the transfer ended. The manager has already handled the
+// completion in OnChannelCompleted, so it only logs the result.
+func (m *manager) OnChannelCompletedDetailed(chid datatransfer.ChannelID, result datatransfer.CompletionResult) error {
+	log.Infow("channel completed", "chid", chid, "kind", datatransfer.CompletionKinds[result.Kind],
+		"side", datatransfer.CompletionSides[result.Side],
+		"bytes", result.BytesTransferred, "status", result.StatusCode)
+	return nil
+}
+
 // OnContextAugment provides an oppurtunity for transports to have data transfer add data to their context (i.e.
 // to tie into tracing, etc)
 func (m *manager) OnContextAugment(chid datatransfer.ChannelID) func(context.Context) context.Context {
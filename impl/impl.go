@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/hannahhoward/go-pubsub"
@@ -43,8 +44,21 @@ type manager struct {
 	transport            datatransfer.Transport
 	channelMonitor       *channelmonitor.Monitor
 	channelMonitorCfg    *channelmonitor.Config
-	transferIDGen        *timeCounter
+	transferIDGen        datatransfer.TransferIDGenerator
 	spansIndex           *tracing.SpansIndex
+
+	// Restarts that are waiting for the other peer to reconnect
+	restartOnReconnect bool
+	reconnectCtx       context.Context
+	cancelReconnects   context.CancelFunc
+	awaitingReconnect  map[datatransfer.ChannelID]struct{}
+	awaitingLk         sync.Mutex
+
+	// Restarts of channels this node is receiving data on that the other
+	// peer has not yet acknowledged, with the time the restart was requested
+	restartAckTimeout time.Duration
+	restartsInFlight  map[datatransfer.ChannelID]time.Time
+	restartsLk        sync.Mutex
 }
 
 type internalEvent struct {
@@ -89,6 +103,38 @@ func ChannelRestartConfig(cfg channelmonitor.Config) DataTransferOption {
 	}
 }
 
+// RestartOnReconnect sets whether restarting a channel that this node is
+// receiving data on waits for the other peer to reconnect, when the peer is
+// not connected. The restart request is then sent as soon as the peer
+// reconnects, instead of the restart failing.
+// Defaults to false
+func RestartOnReconnect(restartOnReconnect bool) DataTransferOption {
+	return func(m *manager) {
+		m.restartOnReconnect = restartOnReconnect
+	}
+}
+
+// RestartAckTimeout sets how long to wait for the other peer to acknowledge a
+// request to restart a channel that this node is receiving data on. The peer
+// acknowledges the request by sending the restarted data transfer request.
+// Until then, or until the timeout expires, restarting the channel again does
+// not send another request, so that slow restarts don't pile up.
+// Defaults to 0, which sends a request every time the channel is restarted
+func RestartAckTimeout(timeout time.Duration) DataTransferOption {
+	return func(m *manager) {
+		m.restartAckTimeout = timeout
+	}
+}
+
+// TransferIDs sets the generator that allocates transfer IDs for the channels
+// this node opens. By default transfer IDs count up from the time the manager
+// was created
+func TransferIDs(gen datatransfer.TransferIDGenerator) DataTransferOption {
+	return func(m *manager) {
+		m.transferIDGen = gen
+	}
+}
+
 // NewDataTransfer initializes a new instance of a data transfer manager
 func NewDataTransfer(ds datastore.Batching, dataTransferNetwork network.DataTransferNetwork, transport datatransfer.Transport, options ...DataTransferOption) (datatransfer.Manager, error) {
 	m := &manager{
@@ -101,7 +147,10 @@ func NewDataTransfer(ds datastore.Batching, dataTransferNetwork network.DataTran
 		transport:            transport,
 		transferIDGen:        newTimeCounter(),
 		spansIndex:           tracing.NewSpansIndex(),
+		awaitingReconnect:    make(map[datatransfer.ChannelID]struct{}),
+		restartsInFlight:     make(map[datatransfer.ChannelID]time.Time),
 	}
+	m.reconnectCtx, m.cancelReconnects = context.WithCancel(context.Background())
 
 	channels, err := channels.New(ds, m.notifier, &channelEnvironment{m}, dataTransferNetwork.ID())
 	if err != nil {
@@ -156,6 +205,7 @@ func (m *manager) OnReady(ready datatransfer.ReadyFunc) {
 // Stop terminates all data transfers and ends processing
 func (m *manager) Stop(ctx context.Context) error {
 	log.Info("stop data-transfer module")
+	m.cancelReconnects()
 	m.channelMonitor.Shutdown()
 	m.spansIndex.EndAll()
 	return m.transport.Shutdown(ctx)
@@ -218,6 +268,33 @@ func (m *manager) OpenPushDataChannel(ctx context.Context, requestTo peer.ID, vo
 // OpenPullDataChannel opens a data transfer that will request data from the sending peer and
 // transfer parts of the piece that match the selector
 func (m *manager) OpenPullDataChannel(ctx context.Context, requestTo peer.ID, voucher datatransfer.TypedVoucher, baseCid cid.Cid, selector datamodel.Node) (datatransfer.ChannelID, error) {
+	return m.openPullDataChannel(ctx, requestTo, voucher, baseCid, selector, nil)
+}
+
+// OpenEphemeralChannel opens a data transfer that will request data from the sending peer
+// and keep the data in a new in-memory store
+func (m *manager) OpenEphemeralChannel(ctx context.Context, requestTo peer.ID, voucher datatransfer.TypedVoucher, baseCid cid.Cid, selector datamodel.Node) (datatransfer.ChannelID, datatransfer.EphemeralStore, error) {
+	ephemeral, ok := m.transport.(datatransfer.EphemeralTransport)
+	if !ok {
+		return datatransfer.ChannelID{}, nil, datatransfer.ErrUnsupported
+	}
+
+	var store datatransfer.EphemeralStore
+	chid, err := m.openPullDataChannel(ctx, requestTo, voucher, baseCid, selector, func(chid datatransfer.ChannelID) error {
+		var err error
+		store, err = ephemeral.UseEphemeralStore(chid)
+		return err
+	})
+	if err != nil {
+		return chid, nil, err
+	}
+	return chid, store, nil
+}
+
+// openPullDataChannel opens a pull data transfer, calling useStore (if set) to
+// set the store for the channel before the request is sent
+func (m *manager) openPullDataChannel(ctx context.Context, requestTo peer.ID, voucher datatransfer.TypedVoucher, baseCid cid.Cid, selector datamodel.Node,
+	useStore func(datatransfer.ChannelID) error) (datatransfer.ChannelID, error) {
 	log.Infof("open pull channel to %s with base cid %s", requestTo, baseCid)
 
 	req, err := m.newRequest(ctx, selector, true, voucher, baseCid, requestTo)
@@ -236,6 +313,15 @@ func (m *manager) OpenPullDataChannel(ctx context.Context, requestTo peer.ID, vo
 		transportConfigurer := processor.(datatransfer.TransportConfigurer)
 		transportConfigurer(chid, voucher, m.transport)
 	}
+	if useStore != nil {
+		if err := useStore(chid); err != nil {
+			err = fmt.Errorf("unable to set store for channel: %w", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			_ = m.channels.Error(chid, err)
+			return chid, err
+		}
+	}
 	m.dataTransferNetwork.Protect(requestTo, chid.String())
 	monitoredChan := m.channelMonitor.AddPullChannel(chid)
 	if err := m.transport.OpenChannel(ctx, requestTo, chid, cidlink.Link{Cid: baseCid}, selector, nil, req); err != nil {
@@ -326,6 +412,37 @@ func (m *manager) SendVoucherResult(ctx context.Context, channelID datatransfer.
 	return m.channels.NewVoucherResult(channelID, voucherResult)
 }
 
+// SendExpectedSize sends the initiator an update with the total size of the
+// data the responder expects to send
+func (m *manager) SendExpectedSize(ctx context.Context, channelID datatransfer.ChannelID, size uint64) error {
+	chst, err := m.channels.GetByID(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	ctx, _ = m.spansIndex.SpanForChannel(ctx, channelID)
+	ctx, span := otel.Tracer("data-transfer").Start(ctx, "sendExpectedSize", trace.WithAttributes(
+		attribute.String("channelID", channelID.String()),
+		attribute.Int64("size", int64(size)),
+	))
+	defer span.End()
+	if channelID.Initiator == m.peerID {
+		err := errors.New("cannot send expected size for request we initiated")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	updateResponse := message.ExpectedSizeResponse(channelID.ID, chst.ResponderPaused(), size)
+	if err := m.dataTransferNetwork.SendMessage(ctx, chst.OtherPeer(), updateResponse); err != nil {
+		err = fmt.Errorf("unable to send expected size: %w", err)
+		_ = m.OnRequestDisconnected(channelID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
+}
+
 func (m *manager) UpdateValidationStatus(ctx context.Context, chid datatransfer.ChannelID, result datatransfer.ValidationResult) error {
 	ctx, _ = m.spansIndex.SpanForChannel(ctx, chid)
 	ctx, span := otel.Tracer("data-transfer").Start(ctx, "updateValidationStatus", trace.WithAttributes(
@@ -356,6 +473,48 @@ func (m *manager) updateValidationStatus(ctx context.Context, chid datatransfer.
 	return m.handleTransportUpdate(ctx, chst, response, result, err)
 }
 
+// RevalidateChannel re-runs the validator on the channel's voucher and
+// applies the result as UpdateValidationStatus would
+func (m *manager) RevalidateChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	ctx, _ = m.spansIndex.SpanForChannel(ctx, chid)
+	ctx, span := otel.Tracer("data-transfer").Start(ctx, "revalidateChannel", trace.WithAttributes(
+		attribute.String("channelID", chid.String()),
+	))
+	err := m.revalidateChannel(ctx, chid)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return err
+}
+
+// revalidateChannel is the implementation of the public method, which wraps this private method
+// in a trace
+func (m *manager) revalidateChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	// only the responder validates the request
+	if chid.Initiator == m.peerID {
+		return xerrors.Errorf("channel %s: cannot revalidate request we initiated", chid)
+	}
+
+	chst, err := m.channels.GetByID(ctx, chid)
+	if err != nil {
+		return err
+	}
+	if !chst.Status().IsAccepted() || chst.Status().TransferComplete() {
+		return xerrors.Errorf("channel %s: cannot revalidate channel in state %s", chid, datatransfer.Statuses[chst.Status()])
+	}
+
+	// if the validator could not run, leave the channel as it is
+	result, err := m.validateChannel(chst)
+	if err != nil {
+		return xerrors.Errorf("channel %s: revalidating voucher: %w", chid, err)
+	}
+
+	log.Infow("revalidated channel", "channelID", chid, "accepted", result.Accepted, "pause", result.LeaveRequestPaused(chst))
+	return m.updateValidationStatus(ctx, chid, result)
+}
+
 func (m *manager) processValidationUpdate(ctx context.Context, chid datatransfer.ChannelID, result datatransfer.ValidationResult) (datatransfer.ChannelState, datatransfer.Response, error) {
 
 	// read the channel state
@@ -591,6 +750,16 @@ func (m *manager) InProgressChannels(ctx context.Context) (map[datatransfer.Chan
 	return m.channels.InProgress()
 }
 
+// call cb with the state of each channel
+func (m *manager) InChannels(ctx context.Context, cb func(datatransfer.ChannelState) error) error {
+	return m.channels.ForEach(func(chst datatransfer.ChannelState) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return cb(chst)
+	})
+}
+
 // RegisterTransportConfigurer registers the given transport configurer to be run on requests with the given voucher
 // type
 func (m *manager) RegisterTransportConfigurer(voucherType datatransfer.TypeIdentifier, configurer datatransfer.TransportConfigurer) error {
@@ -603,6 +772,21 @@ func (m *manager) RegisterTransportConfigurer(voucherType datatransfer.TypeIdent
 
 // RestartDataTransferChannel restarts data transfer on the channel with the given channelId
 func (m *manager) RestartDataTransferChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	return m.restartChannel(ctx, chid, nil)
+}
+
+// RestartPullChannelWithSelector restarts data transfer on a pull channel this
+// node opened, requesting the data that matches the given selector
+func (m *manager) RestartPullChannelWithSelector(ctx context.Context, chid datatransfer.ChannelID, selector datamodel.Node) error {
+	if selector == nil {
+		return xerrors.Errorf("channel %s: restart selector must not be nil", chid)
+	}
+	return m.restartChannel(ctx, chid, selector)
+}
+
+// restartChannel restarts the channel, using the given selector in place of
+// the channel's selector if it's not nil
+func (m *manager) restartChannel(ctx context.Context, chid datatransfer.ChannelID, selector datamodel.Node) error {
 	log.Infof("restart channel %s", chid)
 
 	channel, err := m.channels.GetByID(ctx, chid)
@@ -621,6 +805,11 @@ func (m *manager) RestartDataTransferChannel(ctx context.Context, chid datatrans
 		return m.channels.CompleteCleanupOnRestart(channel.ChannelID())
 	}
 
+	// refuse to send anything if the stored peer doesn't match the channel
+	if err := m.validateRestartPeer(chid, channel); err != nil {
+		return err
+	}
+
 	ctx, _ = m.spansIndex.SpanForChannel(ctx, chid)
 	ctx, span := otel.Tracer("data-transfer").Start(ctx, "restartChannel", trace.WithAttributes(
 		attribute.String("channelID", chid.String()),
@@ -628,13 +817,41 @@ func (m *manager) RestartDataTransferChannel(ctx context.Context, chid datatrans
 	defer span.End()
 	// initiate restart
 	chType := m.channelDataTransferType(channel)
+	if selector != nil && chType != ManagerPeerCreatePull {
+		return xerrors.Errorf("channel %s: only a pull channel opened by this node can be restarted with a different selector: %w", chid, datatransfer.ErrUnsupported)
+	}
+
+	// the other peer must be asked to restart a channel we are receiving
+	// data on, which can't be done while it is disconnected
+	receiving := chType == ManagerPeerReceivePush || chType == ManagerPeerReceivePull
+	if receiving && m.restartOnReconnect && !m.dataTransferNetwork.IsConnected(channel.OtherPeer()) {
+		m.restartOnPeerReconnect(chid, channel.OtherPeer())
+		return nil
+	}
+
+	if receiving {
+		if !m.beginRestart(chid) {
+			log.Infof("channel %s: restart already requested, waiting for peer to acknowledge it", chid)
+			return nil
+		}
+		var err error
+		if chType == ManagerPeerReceivePush {
+			err = m.restartManagerPeerReceivePush(ctx, channel)
+		} else {
+			err = m.restartManagerPeerReceivePull(ctx, channel)
+		}
+		if err != nil {
+			m.endRestart(chid)
+		}
+		return err
+	}
+
 	switch chType {
-	case ManagerPeerReceivePush:
-		return m.restartManagerPeerReceivePush(ctx, channel)
-	case ManagerPeerReceivePull:
-		return m.restartManagerPeerReceivePull(ctx, channel)
 	case ManagerPeerCreatePull:
-		return m.openPullRestartChannel(ctx, channel)
+		if selector == nil {
+			selector = channel.Selector()
+		}
+		return m.openPullRestartChannel(ctx, channel, selector)
 	case ManagerPeerCreatePush:
 		return m.openPushRestartChannel(ctx, channel)
 	}
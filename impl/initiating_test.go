@@ -26,6 +26,7 @@ import (
 func TestDataTransferInitiating(t *testing.T) {
 	// create network
 	ctx := context.Background()
+	transferIDs := testutil.NewTransferIDSequence(2000)
 	testCases := map[string]struct {
 		expectedEvents []datatransfer.EventCode
 		options        []DataTransferOption
@@ -56,6 +57,37 @@ func TestDataTransferInitiating(t *testing.T) {
 				testutil.AssertTestVoucher(t, receivedRequest, h.voucher)
 			},
 		},
+		"OpenPushDataTransfer uses the configured transfer ID generator": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
+			options:        []DataTransferOption{TransferIDs(NewTransferIDGenerator(1000))},
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.Equal(t, datatransfer.TransferID(1001), channelID.ID)
+				require.Len(t, h.network.SentMessages, 1)
+				require.Equal(t, datatransfer.TransferID(1001), h.network.SentMessages[0].Message.TransferID())
+			},
+		},
+		"channels opened with a transfer ID sequence have predictable IDs": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Open},
+			options:        []DataTransferOption{TransferIDs(transferIDs)},
+			verify: func(t *testing.T, h *harness) {
+				chids := testutil.NewChannelIDs(h.peers[0], h.peers[1])
+				pushChannelID, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.Equal(t, chids.Nth(transferIDs, 1), pushChannelID)
+				pullChannelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.Equal(t, chids.Nth(transferIDs, 2), pullChannelID)
+				require.Equal(t, transferIDs.Nth(2), transferIDs.Current())
+
+				// the sequence can be replayed from its seed
+				transferIDs.Reset()
+				require.Equal(t, datatransfer.TransferID(2000), transferIDs.Current())
+				require.Equal(t, pushChannelID.ID, transferIDs.Next())
+				require.Equal(t, chids.Reversed().ID(pullChannelID.ID), datatransfer.ChannelID{ID: pullChannelID.ID, Initiator: h.peers[1], Responder: h.peers[0]})
+			},
+		},
 		"OpenPullDataTransfer": {
 			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
 			verify: func(t *testing.T, h *harness) {
@@ -83,6 +115,28 @@ func TestDataTransferInitiating(t *testing.T) {
 				testutil.AssertTestVoucher(t, receivedRequest, h.voucher)
 			},
 		},
+		"OpenEphemeralChannel": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
+			verify: func(t *testing.T, h *harness) {
+				channelID, store, err := h.dt.OpenEphemeralChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NotEmpty(t, channelID)
+				require.Len(t, h.transport.OpenedChannels, 1)
+				require.Equal(t, channelID, h.transport.OpenedChannels[0].ChannelID)
+				require.True(t, h.transport.OpenedChannels[0].Message.(datatransfer.Request).IsPull())
+				require.Same(t, h.transport.EphemeralStores[channelID], store)
+			},
+		},
+		"OpenEphemeralChannel fails if the store cannot be used": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Error, datatransfer.CleanupComplete},
+			verify: func(t *testing.T, h *harness) {
+				h.transport.UseEphemeralErr = errors.New("something went wrong")
+				_, store, err := h.dt.OpenEphemeralChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.EqualError(t, err, "unable to set store for channel: something went wrong")
+				require.Nil(t, store)
+				require.Len(t, h.transport.OpenedChannels, 0)
+			},
+		},
 		"SendVoucher with no channel open": {
 			verify: func(t *testing.T, h *harness) {
 				err := h.dt.SendVoucher(h.ctx, datatransfer.ChannelID{Initiator: h.peers[1], Responder: h.peers[0], ID: 999999}, h.voucher)
@@ -159,6 +213,23 @@ func TestDataTransferInitiating(t *testing.T) {
 				require.NoError(t, err)
 			},
 		},
+		"rejected response with a reason": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Error, datatransfer.CleanupComplete},
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.NotEmpty(t, channelID)
+				response, err := message.VoucherResultResponse(channelID.ID, false, false, nil)
+				require.NoError(t, err)
+				rejection, err := message.WithReason(response, "transfer cannot be resumed")
+				require.NoError(t, err)
+				err = h.transport.EventHandler.OnResponseReceived(channelID, rejection.(datatransfer.Response))
+				require.NoError(t, err)
+				chst, err := h.dt.ChannelState(h.ctx, channelID)
+				require.NoError(t, err)
+				require.Equal(t, "transfer cannot be resumed: "+datatransfer.ErrRejected.Error(), chst.Message())
+			},
+		},
 		"push request, pause behavior": {
 			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Accept, datatransfer.ResumeResponder, datatransfer.PauseInitiator, datatransfer.ResumeInitiator},
 			verify: func(t *testing.T, h *harness) {
@@ -350,6 +421,7 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 	ctx := context.Background()
 	testCases := map[string]struct {
 		expectedEvents []datatransfer.EventCode
+		options        []DataTransferOption
 		verify         func(t *testing.T, h *harness)
 	}{
 		"RestartDataTransferChannel: Manager Peer Create Pull Restart works": {
@@ -367,8 +439,10 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 				ev, ok := h.dt.(datatransfer.EventsHandler)
 				require.True(t, ok)
 				ev.OnTransferInitiated(channelID)
-				require.NoError(t, ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[0]}, 12345, 1, true))
-				require.NoError(t, ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[1]}, 12345, 2, true))
+				_, err = ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[0]}, 12345, 1, true)
+				require.NoError(t, err)
+				_, err = ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[1]}, 12345, 2, true)
+				require.NoError(t, err)
 
 				// restart that pull channel
 				err = h.dt.RestartDataTransferChannel(ctx, channelID)
@@ -399,6 +473,49 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 				testutil.AssertTestVoucher(t, receivedRequest, h.voucher)
 			},
 		},
+		"RestartPullChannelWithSelector: restarts with the given selector": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
+			verify: func(t *testing.T, h *harness) {
+				// open a pull channel
+				channelID, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				require.Len(t, h.transport.OpenedChannels, 1)
+
+				// restart it with a narrower selector
+				continuation := selectorparse.CommonSelector_MatchPoint
+				require.NoError(t, h.dt.RestartPullChannelWithSelector(ctx, channelID, continuation))
+				require.Len(t, h.transport.OpenedChannels, 2)
+
+				// the graphsync request and the restart request both carry the
+				// new selector, and the original voucher
+				openChannel := h.transport.OpenedChannels[1]
+				require.Equal(t, openChannel.ChannelID, channelID)
+				require.Equal(t, openChannel.Selector, continuation)
+				receivedRequest, ok := openChannel.Message.(datatransfer.Request)
+				require.True(t, ok)
+				require.True(t, receivedRequest.IsRestart())
+				receivedSelector, err := receivedRequest.Selector()
+				require.NoError(t, err)
+				require.Equal(t, receivedSelector, continuation)
+				testutil.AssertTestVoucher(t, receivedRequest, h.voucher)
+
+				// later restarts use the original selector
+				require.NoError(t, h.dt.RestartDataTransferChannel(ctx, channelID))
+				require.Len(t, h.transport.OpenedChannels, 3)
+				require.Equal(t, h.transport.OpenedChannels[2].Selector, h.stor)
+			},
+		},
+		"RestartPullChannelWithSelector: fails for a push channel": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
+			verify: func(t *testing.T, h *harness) {
+				channelID, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+
+				err = h.dt.RestartPullChannelWithSelector(ctx, channelID, selectorparse.CommonSelector_MatchPoint)
+				require.ErrorIs(t, err, datatransfer.ErrUnsupported)
+				require.Len(t, h.network.SentMessages, 1)
+			},
+		},
 		"RestartDataTransferChannel: Manager Peer Create Push Restart works": {
 			expectedEvents: []datatransfer.EventCode{datatransfer.Open},
 			verify: func(t *testing.T, h *harness) {
@@ -477,6 +594,86 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 				require.Equal(t, chid, achId)
 			},
 		},
+		"RestartDataTransferChannel: Manager Peer Receive Push Restart waits for peer to reconnect": {
+			expectedEvents: []datatransfer.EventCode{
+				datatransfer.Open,
+				datatransfer.Accept,
+			},
+			options: []DataTransferOption{RestartOnReconnect(true)},
+			verify: func(t *testing.T, h *harness) {
+				ctx := context.Background()
+
+				h.voucherValidator.ExpectSuccessPush()
+				h.voucherValidator.StubResult(datatransfer.ValidationResult{Accepted: true})
+
+				// receive a push request
+				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], h.pushRequest)
+				require.Len(t, h.transport.OpenedChannels, 1)
+				require.Equal(t, 0, h.network.SentMessageCount())
+
+				// restarting while the peer is disconnected does not send anything
+				h.voucherValidator.StubRestartResult(datatransfer.ValidationResult{Accepted: true})
+				chid := datatransfer.ChannelID{Initiator: h.peers[1], Responder: h.peers[0], ID: h.pushRequest.TransferID()}
+				h.network.Disconnect(h.peers[1])
+				require.NoError(t, h.dt.RestartDataTransferChannel(ctx, chid))
+				require.Equal(t, 0, h.network.SentMessageCount())
+
+				// the restart request is sent once the peer reconnects
+				h.network.Connect(h.peers[1])
+				require.Eventually(t, func() bool {
+					return h.network.SentMessageCount() == 1
+				}, time.Second, 10*time.Millisecond)
+				require.Len(t, h.voucherValidator.RevalidationsReceived, 1)
+
+				req := h.network.SentMessages[0]
+				require.Equal(t, req.PeerID, h.peers[1])
+				receivedRequest, ok := req.Message.(datatransfer.Request)
+				require.True(t, ok)
+				require.True(t, receivedRequest.IsRestartExistingChannelRequest())
+			},
+		},
+		"RestartDataTransferChannel: Manager Peer Receive Push Restart is not resent until acknowledged": {
+			expectedEvents: []datatransfer.EventCode{
+				datatransfer.Open,
+				datatransfer.Accept,
+				datatransfer.Restart,
+			},
+			options: []DataTransferOption{RestartAckTimeout(time.Minute)},
+			verify: func(t *testing.T, h *harness) {
+				ctx := context.Background()
+
+				h.voucherValidator.ExpectSuccessPush()
+				h.voucherValidator.StubResult(datatransfer.ValidationResult{Accepted: true})
+
+				// receive a push request
+				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], h.pushRequest)
+				require.Len(t, h.transport.OpenedChannels, 1)
+				require.Len(t, h.network.SentMessages, 0)
+
+				// restarting again before the peer acknowledges the first
+				// restart does not send another request
+				h.voucherValidator.ExpectSuccessValidateRestart()
+				h.voucherValidator.StubRestartResult(datatransfer.ValidationResult{Accepted: true})
+				chid := datatransfer.ChannelID{Initiator: h.peers[1], Responder: h.peers[0], ID: h.pushRequest.TransferID()}
+				require.NoError(t, h.dt.RestartDataTransferChannel(ctx, chid))
+				require.NoError(t, h.dt.RestartDataTransferChannel(ctx, chid))
+				require.Len(t, h.network.SentMessages, 1)
+
+				// the peer acknowledges the restart by sending the restarted
+				// push request
+				req, err := message.NewRequest(h.pushRequest.TransferID(), true, false, &h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], req)
+				require.Len(t, h.transport.OpenedChannels, 2)
+
+				// the channel can now be restarted again
+				require.NoError(t, h.dt.RestartDataTransferChannel(ctx, chid))
+				require.Len(t, h.network.SentMessages, 2)
+				receivedRequest, ok := h.network.SentMessages[1].Message.(datatransfer.Request)
+				require.True(t, ok)
+				require.True(t, receivedRequest.IsRestartExistingChannelRequest())
+			},
+		},
 		"RestartDataTransferChannel: Manager Peer Receive Pull Restart works ": {
 			expectedEvents: []datatransfer.EventCode{
 				datatransfer.Open,
@@ -563,6 +760,41 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 				require.EqualError(t, h.dt.RestartDataTransferChannel(ctx, chid), datatransfer.ErrRejected.Error())
 			},
 		},
+		"InChannels iterates over the state of each channel": {
+			expectedEvents: []datatransfer.EventCode{datatransfer.Open, datatransfer.Open},
+			verify: func(t *testing.T, h *harness) {
+				pushChid, err := h.dt.OpenPushDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+				pullChid, err := h.dt.OpenPullDataChannel(h.ctx, h.peers[1], h.voucher, h.baseCid, h.stor)
+				require.NoError(t, err)
+
+				var chids []datatransfer.ChannelID
+				err = h.dt.InChannels(h.ctx, func(chst datatransfer.ChannelState) error {
+					chids = append(chids, chst.ChannelID())
+					return nil
+				})
+				require.NoError(t, err)
+				require.ElementsMatch(t, []datatransfer.ChannelID{pushChid, pullChid}, chids)
+
+				// iteration stops at the first error
+				errStop := errors.New("stop")
+				calls := 0
+				err = h.dt.InChannels(h.ctx, func(chst datatransfer.ChannelState) error {
+					calls++
+					return errStop
+				})
+				require.ErrorIs(t, err, errStop)
+				require.Equal(t, 1, calls)
+
+				// and when the context is cancelled
+				ctx, cancel := context.WithCancel(h.ctx)
+				cancel()
+				err = h.dt.InChannels(ctx, func(chst datatransfer.ChannelState) error {
+					return nil
+				})
+				require.ErrorIs(t, err, context.Canceled)
+			},
+		},
 		"Fails if channel does not exist": {
 			expectedEvents: nil,
 			verify: func(t *testing.T, h *harness) {
@@ -587,7 +819,7 @@ func TestDataTransferRestartInitiating(t *testing.T) {
 			h.voucherValidator = testutil.NewStubbedValidator()
 
 			// setup data transfer``
-			dt, err := NewDataTransfer(h.ds, h.network, h.transport)
+			dt, err := NewDataTransfer(h.ds, h.network, h.transport, verify.options...)
 			require.NoError(t, err)
 			testutil.StartAndWaitForReady(ctx, t, dt)
 			h.dt = dt
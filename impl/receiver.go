@@ -182,7 +182,7 @@ func (r *receiver) ReceiveRestartExistingChannelRequest(ctx context.Context,
 			log.Errorf("failed to open push restart channel %s: %s", ch, err)
 		}
 	case ManagerPeerCreatePull:
-		if err := r.manager.openPullRestartChannel(ctx, channel); err != nil {
+		if err := r.manager.openPullRestartChannel(ctx, channel, channel.Selector()); err != nil {
 			log.Errorf("failed to open pull restart channel %s: %s", ch, err)
 		}
 	default:
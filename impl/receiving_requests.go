@@ -123,6 +123,10 @@ func (m *manager) acceptRequest(chid datatransfer.ChannelID, incoming datatransf
 func (m *manager) receiveRestartRequest(chid datatransfer.ChannelID, incoming datatransfer.Request) (datatransfer.Response, error) {
 	log.Infof("channel %s: received restart request", chid)
 
+	// the restart request acknowledges any request we sent asking the other
+	// peer to restart the channel
+	m.endRestart(chid)
+
 	// process the restart message, including validations
 	stayPaused, result, err := m.restartRequest(chid, incoming)
 
@@ -315,3 +319,20 @@ func (m *manager) validateRestart(chst datatransfer.ChannelState) (datatransfer.
 
 	return validator.ValidateRestart(chst.ChannelID(), chst)
 }
+
+// validateChannel looks up the appropriate validator and validates the
+// channel's latest voucher as if it were a new request
+func (m *manager) validateChannel(chst datatransfer.ChannelState) (datatransfer.ValidationResult, error) {
+	voucher := chst.LastVoucher()
+	processor, ok := m.validatedTypes.Processor(voucher.Type)
+	if !ok {
+		return datatransfer.ValidationResult{}, xerrors.Errorf("unknown voucher type: %s", voucher.Type)
+	}
+
+	validator := processor.(datatransfer.RequestValidator)
+	chid := chst.ChannelID()
+	if chst.IsPull() {
+		return validator.ValidatePull(chid, chid.Initiator, voucher.Voucher, chst.BaseCID(), chst.Selector())
+	}
+	return validator.ValidatePush(chid, chid.Initiator, voucher.Voucher, chst.BaseCID(), chst.Selector())
+}
@@ -379,7 +379,7 @@ func TestDataTransferResponding(t *testing.T) {
 			verify: func(t *testing.T, h *receiverHarness) {
 				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], h.pushRequest)
 				h.transport.EventHandler.OnTransferInitiated(channelID(h.id, h.peers))
-				err := h.transport.EventHandler.OnDataReceived(channelID(h.id, h.peers), cidlink.Link{Cid: testutil.GenerateCids(1)[0]}, 12345, 1, true)
+				_, err := h.transport.EventHandler.OnDataReceived(channelID(h.id, h.peers), cidlink.Link{Cid: testutil.GenerateCids(1)[0]}, 12345, 1, true)
 				require.EqualError(t, err, datatransfer.ErrPause.Error())
 				require.Len(t, h.network.SentMessages, 1)
 				response, ok := h.network.SentMessages[0].Message.(datatransfer.Response)
@@ -432,7 +432,7 @@ func TestDataTransferResponding(t *testing.T) {
 			verify: func(t *testing.T, h *receiverHarness) {
 				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], h.pushRequest)
 				h.transport.EventHandler.OnTransferInitiated(channelID(h.id, h.peers))
-				err := h.transport.EventHandler.OnDataReceived(channelID(h.id, h.peers), cidlink.Link{Cid: testutil.GenerateCids(1)[0]}, 12345, 1, true)
+				_, err := h.transport.EventHandler.OnDataReceived(channelID(h.id, h.peers), cidlink.Link{Cid: testutil.GenerateCids(1)[0]}, 12345, 1, true)
 				require.EqualError(t, err, datatransfer.ErrPause.Error())
 				require.Len(t, h.network.SentMessages, 1)
 				response, ok := h.network.SentMessages[0].Message.(datatransfer.Response)
@@ -463,6 +463,92 @@ func TestDataTransferResponding(t *testing.T) {
 				require.False(t, response.EmptyVoucherResult())
 			},
 		},
+		"revalidate channel on demand, rejected": {
+			expectedEvents: []datatransfer.EventCode{
+				datatransfer.Open,
+				datatransfer.Accept,
+				datatransfer.NewVoucherResult,
+				datatransfer.TransferInitiated,
+				datatransfer.NewVoucherResult,
+				datatransfer.Error,
+				datatransfer.CleanupComplete,
+			},
+			configureValidator: func(sv *testutil.StubbedValidator) {
+				sv.ExpectSuccessPush()
+				vr := testutil.NewTestTypedVoucher()
+				sv.StubResult(datatransfer.ValidationResult{Accepted: true, VoucherResult: &vr})
+			},
+			verify: func(t *testing.T, h *receiverHarness) {
+				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], h.pushRequest)
+				h.transport.EventHandler.OnTransferInitiated(channelID(h.id, h.peers))
+				vr := testutil.NewTestTypedVoucher()
+				h.sv.StubResult(datatransfer.ValidationResult{Accepted: false, VoucherResult: &vr})
+				err := h.dt.RevalidateChannel(h.ctx, channelID(h.id, h.peers))
+				require.NoError(t, err)
+				require.Len(t, h.sv.ValidationsReceived, 2)
+				require.Equal(t, h.sv.ValidationsReceived[1].Voucher, h.voucher.Voucher)
+				require.Len(t, h.transport.ClosedChannels, 1)
+				require.Equal(t, h.transport.ClosedChannels[0], channelID(h.id, h.peers))
+				require.Len(t, h.network.SentMessages, 1)
+				response, ok := h.network.SentMessages[0].Message.(datatransfer.Response)
+				require.True(t, ok)
+				require.False(t, response.Accepted())
+				require.Equal(t, response.TransferID(), h.id)
+				require.True(t, response.IsValidationResult())
+				require.False(t, response.EmptyVoucherResult())
+			},
+		},
+		"revalidate channel on demand, paused": {
+			expectedEvents: []datatransfer.EventCode{
+				datatransfer.Open,
+				datatransfer.Accept,
+				datatransfer.NewVoucherResult,
+				datatransfer.TransferInitiated,
+				datatransfer.PauseResponder,
+			},
+			configureValidator: func(sv *testutil.StubbedValidator) {
+				sv.ExpectSuccessPush()
+				vr := testutil.NewTestTypedVoucher()
+				sv.StubResult(datatransfer.ValidationResult{Accepted: true, VoucherResult: &vr})
+			},
+			verify: func(t *testing.T, h *receiverHarness) {
+				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], h.pushRequest)
+				h.transport.EventHandler.OnTransferInitiated(channelID(h.id, h.peers))
+				h.sv.StubResult(datatransfer.ValidationResult{Accepted: true, ForcePause: true})
+				err := h.dt.RevalidateChannel(h.ctx, channelID(h.id, h.peers))
+				require.NoError(t, err)
+				require.Len(t, h.transport.PausedChannels, 1)
+				require.Equal(t, h.transport.PausedChannels[0], channelID(h.id, h.peers))
+				require.Len(t, h.network.SentMessages, 1)
+				response, ok := h.network.SentMessages[0].Message.(datatransfer.Response)
+				require.True(t, ok)
+				require.True(t, response.Accepted())
+				require.True(t, response.IsPaused())
+			},
+		},
+		"revalidate channel on demand, validator error": {
+			expectedEvents: []datatransfer.EventCode{
+				datatransfer.Open,
+				datatransfer.Accept,
+				datatransfer.NewVoucherResult,
+				datatransfer.TransferInitiated,
+			},
+			configureValidator: func(sv *testutil.StubbedValidator) {
+				sv.ExpectSuccessPush()
+				vr := testutil.NewTestTypedVoucher()
+				sv.StubResult(datatransfer.ValidationResult{Accepted: true, VoucherResult: &vr})
+			},
+			verify: func(t *testing.T, h *receiverHarness) {
+				h.network.Delegate.ReceiveRequest(h.ctx, h.peers[1], h.pushRequest)
+				h.transport.EventHandler.OnTransferInitiated(channelID(h.id, h.peers))
+				h.sv.StubErrorPush()
+				err := h.dt.RevalidateChannel(h.ctx, channelID(h.id, h.peers))
+				require.Error(t, err)
+				require.Empty(t, h.transport.ClosedChannels)
+				require.Empty(t, h.transport.PausedChannels)
+				require.Empty(t, h.network.SentMessages)
+			},
+		},
 		"validate and revalidate successfully, pull": {
 			expectedEvents: []datatransfer.EventCode{
 				datatransfer.Open,
@@ -743,8 +829,10 @@ func TestDataTransferRestartResponding(t *testing.T) {
 				ev, ok := h.dt.(datatransfer.EventsHandler)
 				require.True(t, ok)
 				ev.OnTransferInitiated(chid)
-				require.NoError(t, ev.OnDataReceived(chid, cidlink.Link{Cid: testCids[0]}, 12345, 1, true))
-				require.NoError(t, ev.OnDataReceived(chid, cidlink.Link{Cid: testCids[1]}, 12345, 2, true))
+				_, err := ev.OnDataReceived(chid, cidlink.Link{Cid: testCids[0]}, 12345, 1, true)
+				require.NoError(t, err)
+				_, err = ev.OnDataReceived(chid, cidlink.Link{Cid: testCids[1]}, 12345, 2, true)
+				require.NoError(t, err)
 
 				// receive restart push request
 				req, err := message.NewRequest(h.pushRequest.TransferID(), true, false, &h.voucher, h.baseCid, h.stor)
@@ -989,8 +1077,10 @@ func TestDataTransferRestartResponding(t *testing.T) {
 				ev, ok := h.dt.(datatransfer.EventsHandler)
 				require.True(t, ok)
 				ev.OnTransferInitiated(channelID)
-				require.NoError(t, ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[0]}, 12345, 1, true))
-				require.NoError(t, ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[1]}, 12345, 2, true))
+				_, err = ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[0]}, 12345, 1, true)
+				require.NoError(t, err)
+				_, err = ev.OnDataReceived(channelID, cidlink.Link{Cid: testCids[1]}, 12345, 2, true)
+				require.NoError(t, err)
 
 				// send a request to restart the same pull channel
 				restartReq := message.RestartExistingChannelRequest(channelID)
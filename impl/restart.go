@@ -2,8 +2,10 @@ package impl
 
 import (
 	"context"
+	"time"
 
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"golang.org/x/xerrors"
@@ -30,6 +32,36 @@ const (
 	ManagerPeerReceivePush
 )
 
+// restartOnPeerReconnect restarts the channel once the given peer reconnects
+func (m *manager) restartOnPeerReconnect(chid datatransfer.ChannelID, p peer.ID) {
+	m.awaitingLk.Lock()
+	_, awaiting := m.awaitingReconnect[chid]
+	m.awaitingReconnect[chid] = struct{}{}
+	m.awaitingLk.Unlock()
+	if awaiting {
+		return
+	}
+
+	log.Infof("channel %s: peer %s is not connected, will restart channel when it reconnects", chid, p)
+	go func() {
+		err := m.dataTransferNetwork.WaitForConnection(m.reconnectCtx, p)
+
+		m.awaitingLk.Lock()
+		delete(m.awaitingReconnect, chid)
+		m.awaitingLk.Unlock()
+
+		if err != nil {
+			log.Debugf("channel %s: stopped waiting for peer %s to reconnect: %s", chid, p, err)
+			return
+		}
+
+		log.Infof("channel %s: peer %s reconnected, restarting channel", chid, p)
+		if err := m.RestartDataTransferChannel(m.reconnectCtx, chid); err != nil {
+			log.Warnf("channel %s: restarting channel after peer reconnected: %s", chid, err)
+		}
+	}()
+}
+
 func (m *manager) restartManagerPeerReceivePush(ctx context.Context, channel datatransfer.ChannelState) error {
 	result, err := m.validateRestart(channel)
 	if err != nil {
@@ -105,8 +137,9 @@ func (m *manager) openPushRestartChannel(ctx context.Context, channel datatransf
 	return nil
 }
 
-func (m *manager) openPullRestartChannel(ctx context.Context, channel datatransfer.ChannelState) error {
-	selector := channel.Selector()
+// openPullRestartChannel restarts a pull channel this node opened, requesting
+// the data that matches the given selector
+func (m *manager) openPullRestartChannel(ctx context.Context, channel datatransfer.ChannelState, selector datamodel.Node) error {
 	voucher := channel.Voucher()
 	baseCid := channel.BaseCID()
 	requestTo := channel.OtherPeer()
@@ -178,3 +211,53 @@ func (m *manager) validateRestartRequest(ctx context.Context, otherPeer peer.ID,
 
 	return nil
 }
+
+// validateRestartPeer checks that the peer a restart would be sent to, which
+// comes from the stored channel state, is the other party in the ID the
+// channel is stored under. A mismatch means the stored state is corrupt, and
+// restarting would send traffic to a peer that is not part of the channel.
+func (m *manager) validateRestartPeer(chid datatransfer.ChannelID, channel datatransfer.ChannelState) error {
+	if channel.ChannelID() != chid {
+		return xerrors.Errorf("channel %s: stored channel id %s: %w", chid, channel.ChannelID(), datatransfer.ErrRestartPeerMismatch)
+	}
+	if m.peerID != chid.Initiator && m.peerID != chid.Responder {
+		return xerrors.Errorf("channel %s: peer %s is not a party to the channel: %w", chid, m.peerID, datatransfer.ErrRestartPeerMismatch)
+	}
+	if expected := chid.OtherParty(m.peerID); channel.OtherPeer() != expected {
+		return xerrors.Errorf("channel %s: stored peer %s, expected %s: %w", chid, channel.OtherPeer(), expected, datatransfer.ErrRestartPeerMismatch)
+	}
+	return nil
+}
+
+// beginRestart records that a request to restart the channel is about to be
+// sent to the other peer. It returns false if a request was already sent
+// within the restart ack timeout and the peer has not yet acknowledged it.
+func (m *manager) beginRestart(chid datatransfer.ChannelID) bool {
+	if m.restartAckTimeout == 0 {
+		return true
+	}
+
+	now := time.Now()
+	m.restartsLk.Lock()
+	defer m.restartsLk.Unlock()
+
+	if requested, ok := m.restartsInFlight[chid]; ok && now.Sub(requested) < m.restartAckTimeout {
+		return false
+	}
+	// drop restarts that were never acknowledged
+	for other, requested := range m.restartsInFlight {
+		if now.Sub(requested) >= m.restartAckTimeout {
+			delete(m.restartsInFlight, other)
+		}
+	}
+	m.restartsInFlight[chid] = now
+	return true
+}
+
+// endRestart records that the restart of the channel was acknowledged by the
+// other peer, or that the request to restart it could not be sent
+func (m *manager) endRestart(chid datatransfer.ChannelID) {
+	m.restartsLk.Lock()
+	defer m.restartsLk.Unlock()
+	delete(m.restartsInFlight, chid)
+}
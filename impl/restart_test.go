@@ -0,0 +1,35 @@
+package impl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/go-data-transfer/v2/testutil"
+)
+
+func TestValidateRestartPeer(t *testing.T) {
+	peers := testutil.GeneratePeers(3)
+	self, other, stranger := peers[0], peers[1], peers[2]
+	chid := datatransfer.ChannelID{Initiator: self, Responder: other, ID: datatransfer.TransferID(1)}
+	m := &manager{peerID: self}
+
+	channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: chid, Self: self})
+	require.NoError(t, m.validateRestartPeer(chid, channel))
+
+	// stored under a different ID
+	otherChid := datatransfer.ChannelID{Initiator: self, Responder: stranger, ID: chid.ID}
+	channel = testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: otherChid, Self: self})
+	require.ErrorIs(t, m.validateRestartPeer(chid, channel), datatransfer.ErrRestartPeerMismatch)
+
+	// stored peer is not the other party
+	channel = testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: chid, Self: stranger})
+	require.Equal(t, self, channel.OtherPeer())
+	require.ErrorIs(t, m.validateRestartPeer(chid, channel), datatransfer.ErrRestartPeerMismatch)
+
+	// this node is not a party to the channel
+	m = &manager{peerID: stranger}
+	channel = testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: chid, Self: stranger})
+	require.ErrorIs(t, m.validateRestartPeer(chid, channel), datatransfer.ErrRestartPeerMismatch)
+}
@@ -3,6 +3,8 @@ package impl
 import (
 	"sync/atomic"
 	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
 )
 
 // timeCounter is used to generate a monotonically increasing sequence.
@@ -11,11 +13,32 @@ type timeCounter struct {
 	counter uint64
 }
 
+var _ datatransfer.TransferIDGenerator = (*timeCounter)(nil)
+
 func newTimeCounter() *timeCounter {
 	return &timeCounter{counter: uint64(time.Now().UnixNano())}
 }
 
+// NewTransferIDGenerator returns a generator that allocates monotonically
+// increasing transfer IDs, starting after the given seed. To avoid reusing
+// transfer IDs after a crash, persist the generator's current value and pass
+// it as the seed when the node restarts.
+func NewTransferIDGenerator(seed datatransfer.TransferID) datatransfer.TransferIDGenerator {
+	return &timeCounter{counter: uint64(seed)}
+}
+
 func (tc *timeCounter) next() uint64 {
 	counter := atomic.AddUint64(&tc.counter, 1)
 	return counter
 }
+
+// Next returns the next transfer ID in the sequence
+func (tc *timeCounter) Next() datatransfer.TransferID {
+	return datatransfer.TransferID(tc.next())
+}
+
+// Current returns the last transfer ID returned by Next, or the seed if Next
+// has not been called
+func (tc *timeCounter) Current() datatransfer.TransferID {
+	return datatransfer.TransferID(atomic.LoadUint64(&tc.counter))
+}
@@ -4,6 +4,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
 )
 
 func TestTimeCounter(t *testing.T) {
@@ -46,3 +48,22 @@ func TestTimeCounter(t *testing.T) {
 		t.Fatal("next() is not thread safe")
 	}
 }
+
+func TestTransferIDGenerator(t *testing.T) {
+	gen := NewTransferIDGenerator(100)
+	if gen.Current() != 100 {
+		t.Fatal("current should be the seed before any IDs are allocated", gen.Current())
+	}
+	if next := gen.Next(); next != 101 {
+		t.Fatal("first ID should follow the seed", next)
+	}
+	if gen.Current() != 101 {
+		t.Fatal("current should be the last ID allocated", gen.Current())
+	}
+
+	// A generator seeded from a persisted value never reuses an ID
+	restored := NewTransferIDGenerator(gen.Current())
+	if next := restored.Next(); next <= datatransfer.TransferID(101) {
+		t.Fatal("restored generator should not reuse an allocated ID", next)
+	}
+}
@@ -31,7 +31,7 @@ var resumeTransportStatesResponder = statusList{
 // newRequest encapsulates message creation
 func (m *manager) newRequest(ctx context.Context, selector datamodel.Node, isPull bool, voucher datatransfer.TypedVoucher, baseCid cid.Cid, to peer.ID) (datatransfer.Request, error) {
 	// Generate a new transfer ID for the request
-	tid := datatransfer.TransferID(m.transferIDGen.next())
+	tid := m.transferIDGen.Next()
 	return message.NewRequest(tid, false, isPull, &voucher, baseCid, selector)
 }
 
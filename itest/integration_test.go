@@ -1411,6 +1411,77 @@ func TestSimulatedRetrievalFlow(t *testing.T) {
 	}
 }
 
+func TestExpectedSize(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 4*time.Second)
+	defer cancel()
+
+	gsData := NewGraphsyncTestingData(ctx, t, nil, nil)
+	host1 := gsData.Host1 // responder, data sender
+
+	root := gsData.LoadUnixFSFile(t, false)
+	rootCid := root.(cidlink.Link).Cid
+	tp1 := gsData.SetupGSTransportHost1()
+	tp2 := gsData.SetupGSTransportHost2()
+
+	dt1, err := NewDataTransfer(gsData.DtDs1, gsData.DtNet1, tp1)
+	require.NoError(t, err)
+	testutil.StartAndWaitForReady(ctx, t, dt1)
+	dt2, err := NewDataTransfer(gsData.DtDs2, gsData.DtNet2, tp2)
+	require.NoError(t, err)
+	testutil.StartAndWaitForReady(ctx, t, dt2)
+
+	const expectedSize = uint64(123456)
+	errChan := make(chan string, 2)
+	// the responder tells the initiator how much data to expect once it
+	// accepts the request
+	dt1.SubscribeToEvents(func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+		if event.Code == datatransfer.Accept {
+			if err := dt1.SendExpectedSize(ctx, channelState.ChannelID(), expectedSize); err != nil {
+				errChan <- err.Error()
+			}
+		}
+	})
+	sizeReceived := make(chan uint64, 1)
+	clientFinished := make(chan struct{}, 1)
+	dt2.SubscribeToEvents(func(event datatransfer.Event, channelState datatransfer.ChannelState) {
+		if event.Code == datatransfer.ExpectedSizeReceived {
+			sizeReceived <- channelState.TotalSize()
+		}
+		if event.Code == datatransfer.Error {
+			errChan <- channelState.Message()
+		}
+		if channelState.Status() == datatransfer.Completed {
+			clientFinished <- struct{}{}
+		}
+	})
+
+	sv := testutil.NewStubbedValidator()
+	sv.StubResult(datatransfer.ValidationResult{Accepted: true})
+	require.NoError(t, dt1.RegisterVoucherType(testutil.TestVoucherType, sv))
+
+	chid, err := dt2.OpenPullDataChannel(ctx, host1.ID(), testutil.NewTestTypedVoucher(), rootCid, selectorparse.CommonSelector_ExploreAllRecursively)
+	require.NoError(t, err)
+
+	for sizeReceived != nil || clientFinished != nil {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Did not complete successful data transfer")
+		case size := <-sizeReceived:
+			require.Equal(t, expectedSize, size)
+			sizeReceived = nil
+		case <-clientFinished:
+			clientFinished = nil
+		case msg := <-errChan:
+			t.Fatalf("received unexpected error: %s", msg)
+		}
+	}
+	gsData.VerifyFileTransferred(t, root, true)
+
+	// only the responder can send the expected size
+	require.Error(t, dt2.SendExpectedSize(ctx, chid, expectedSize))
+}
+
 func TestPauseAndResume(t *testing.T) {
 	ctx := context.Background()
 	testCases := map[string]bool{
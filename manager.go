@@ -125,16 +125,36 @@ type Manager interface {
 	// transfer parts of the piece that match the selector
 	OpenPullDataChannel(ctx context.Context, to peer.ID, voucher TypedVoucher, baseCid cid.Cid, selector datamodel.Node) (ChannelID, error)
 
+	// open a data transfer that will request data from the sending peer and
+	// keep the data in a new in-memory store, rather than persisting it
+	// (only allowed if transport supports it). The store holds the blocks once
+	// the channel completes, and is discarded by the transport when the
+	// channel is cleaned up
+	OpenEphemeralChannel(ctx context.Context, to peer.ID, voucher TypedVoucher, baseCid cid.Cid, selector datamodel.Node) (ChannelID, EphemeralStore, error)
+
 	// send an intermediate voucher as needed when the receiver sends a request for revalidation
 	SendVoucher(ctx context.Context, chid ChannelID, voucher TypedVoucher) error
 
 	// send information from the responder to update the initiator on the state of their voucher
 	SendVoucherResult(ctx context.Context, chid ChannelID, voucherResult TypedVoucher) error
 
+	// SendExpectedSize tells the initiator the total size of the data the
+	// responder expects to send, which the initiator records as the channel's
+	// total size. Only the responder can send the expected size
+	SendExpectedSize(ctx context.Context, chid ChannelID, size uint64) error
+
 	// Update the validation status for a given channel, to change data limits, finalization, accepted status, and pause state
 	// and send new voucher results as
 	UpdateValidationStatus(ctx context.Context, chid ChannelID, validationResult ValidationResult) error
 
+	// RevalidateChannel validates the channel's latest voucher again, eg to
+	// check that a long-running transfer still satisfies policy, and applies
+	// the result as UpdateValidationStatus does: if the voucher is rejected the
+	// channel fails, and if the result requires it the channel is paused. An
+	// error from the validator is returned without changing the channel. Only
+	// the responder can revalidate a channel
+	RevalidateChannel(ctx context.Context, chid ChannelID) error
+
 	// close an open channel (effectively a cancel)
 	CloseDataTransferChannel(ctx context.Context, chid ChannelID) error
 
@@ -156,6 +176,21 @@ type Manager interface {
 	// get all in progress transfers
 	InProgressChannels(ctx context.Context) (map[ChannelID]ChannelState, error)
 
+	// InChannels calls cb with the state of each channel, eg to build an
+	// aggregate view of all transfers. Each state is a snapshot, so it is
+	// not changed by events processed while iterating. Iteration stops at
+	// the first error returned by cb (or when the context is cancelled), and
+	// that error is returned
+	InChannels(ctx context.Context, cb func(ChannelState) error) error
+
 	// RestartDataTransferChannel restarts an existing data transfer channel
 	RestartDataTransferChannel(ctx context.Context, chid ChannelID) error
+
+	// RestartPullChannelWithSelector restarts a pull channel that this node
+	// opened, requesting the data that matches the given selector instead of
+	// the channel's original selector, eg a selector for just the parts of the
+	// DAG that have not been received yet. The other peer validates the restart
+	// against the channel's original voucher. The selector is used for this
+	// restart only: later restarts use the original selector
+	RestartPullChannelWithSelector(ctx context.Context, chid ChannelID, selector datamodel.Node) error
 }
@@ -2,9 +2,11 @@ package datatransfer
 
 import (
 	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 )
 
@@ -27,6 +29,35 @@ type Message interface {
 	ToNet(w io.Writer) error
 	ToIPLD() datamodel.Node
 	MessageForProtocol(targetProtocol protocol.ID) (newMsg Message, err error)
+	// Sequence returns the sequence number the sender assigned to the message,
+	// or zero if the message is not sequenced
+	Sequence() uint64
+	// Reason returns the reason the sender gave for cancelling the transfer,
+	// or an empty string if no reason was given
+	Reason() string
+	// Deadline returns the time after which the receiver should ignore the
+	// message, or the zero time if the message has no deadline
+	Deadline() time.Time
+	// IsStatus returns true if the message asks for (request) or reports
+	// (response) the progress of the transfer
+	IsStatus() bool
+	// Signature returns the sender's signature over the message, or nil if
+	// the message is not signed
+	Signature() []byte
+}
+
+// MessageSigner signs the encoded form of outgoing messages
+type MessageSigner interface {
+	// Sign returns a signature over data, for a message sent to the given
+	// peer
+	Sign(to peer.ID, data []byte) ([]byte, error)
+}
+
+// MessageVerifier verifies the signatures of incoming messages
+type MessageVerifier interface {
+	// Verify returns an error if sig is not a valid signature over data by
+	// the given peer
+	Verify(from peer.ID, data []byte, sig []byte) error
 }
 
 // Request is a response message for the data transfer protocol
@@ -52,4 +83,10 @@ type Response interface {
 	VoucherResultType() TypeIdentifier
 	VoucherResult() (datamodel.Node, error)
 	EmptyVoucherResult() bool
+	// ExpectedSize returns the total size of the data the responder expects
+	// to send, if known
+	ExpectedSize() (uint64, bool)
+	// BytesTransferred returns the number of bytes the sender of a status
+	// response has transferred on the channel
+	BytesTransferred() uint64
 }
@@ -8,6 +8,7 @@ var NewRequest = message1_1.NewRequest
 var RestartExistingChannelRequest = message1_1.RestartExistingChannelRequest
 var UpdateRequest = message1_1.UpdateRequest
 var VoucherRequest = message1_1.VoucherRequest
+var StatusRequest = message1_1.StatusRequest
 
 // DEPRECATED: Use ValidationResultResponse
 var RestartResponse = message1_1.RestartResponse
@@ -21,7 +22,32 @@ var NewResponse = message1_1.NewResponse
 var VoucherResultResponse = message1_1.VoucherResultResponse
 var CancelResponse = message1_1.CancelResponse
 var UpdateResponse = message1_1.UpdateResponse
+var ExpectedSizeResponse = message1_1.ExpectedSizeResponse
+var StatusResponse = message1_1.StatusResponse
+var WithSequence = message1_1.WithSequence
+var WithReason = message1_1.WithReason
+var WithDeadline = message1_1.WithDeadline
+var WithVoucher = message1_1.WithVoucher
+var WithSignature = message1_1.WithSignature
+var SignedData = message1_1.SignedData
+var Sign = message1_1.Sign
+var Verify = message1_1.Verify
 var FromNet = message1_1.FromNet
+
+// DecodeOption sets a limit or check that FromNet applies when decoding a
+// message
+type DecodeOption = message1_1.DecodeOption
+
+var MaxMessageBytes = message1_1.MaxMessageBytes
+var MaxVoucherBytes = message1_1.MaxVoucherBytes
+var MaxSelectorBytes = message1_1.MaxSelectorBytes
+var VerifySignature = message1_1.VerifySignature
+
+// DefaultMaxVoucherBytes is the default limit on the encoded size of the
+// voucher in a request built by NewRequest or VoucherRequest
+const DefaultMaxVoucherBytes = message1_1.DefaultMaxVoucherBytes
+
+var SetMaxVoucherBytes = message1_1.SetMaxVoucherBytes
 var FromIPLD = message1_1.FromIPLD
 var CompleteResponse = message1_1.CompleteResponse
 var CancelRequest = message1_1.CancelRequest
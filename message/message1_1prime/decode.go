@@ -0,0 +1,163 @@
+package message1_1
+
+import (
+	"io"
+
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// DecodeOption sets a limit or check that FromNet applies when decoding a
+// message. By default no limits or checks are applied.
+//
+// Only MaxMessageBytes bounds the memory used to decode a message. The
+// voucher and selector limits are checked once the message has been decoded,
+// so they reject messages with oversized fields but do not stop those fields
+// being read into memory. There is no limit on the number of fields: the
+// dagcbor codec does not expose one, and every field takes at least a byte,
+// so MaxMessageBytes bounds the number of fields as well.
+type DecodeOption func(*decodeOptions)
+
+type decodeOptions struct {
+	maxMessageBytes  int64
+	maxVoucherBytes  int64
+	maxSelectorBytes int64
+	verifyFrom       peer.ID
+	verifier         datatransfer.MessageVerifier
+}
+
+// MaxMessageBytes limits the size of an encoded message. Decoding stops with
+// an error as soon as the limit is exceeded, so larger messages are never
+// fully read into memory. A limit of zero means no limit.
+func MaxMessageBytes(n int64) DecodeOption {
+	return func(o *decodeOptions) {
+		o.maxMessageBytes = n
+	}
+}
+
+// MaxVoucherBytes limits the encoded size of the voucher in a request, or of
+// the voucher result in a response. The limit is checked after the message
+// has been decoded, so use MaxMessageBytes to bound memory use. A limit of
+// zero means no limit.
+func MaxVoucherBytes(n int64) DecodeOption {
+	return func(o *decodeOptions) {
+		o.maxVoucherBytes = n
+	}
+}
+
+// MaxSelectorBytes limits the encoded size of the selector in a request.
+// The limit is checked after the message has been decoded, so use
+// MaxMessageBytes to bound memory use. A limit of zero means no limit.
+func MaxSelectorBytes(n int64) DecodeOption {
+	return func(o *decodeOptions) {
+		o.maxSelectorBytes = n
+	}
+}
+
+// VerifySignature checks that the message was signed by the given peer (see
+// Verify). Messages that are not signed, or whose signature does not verify,
+// fail to decode with an error wrapping ErrInvalidSignature.
+func VerifySignature(from peer.ID, verifier datatransfer.MessageVerifier) DecodeOption {
+	return func(o *decodeOptions) {
+		o.verifyFrom = from
+		o.verifier = verifier
+	}
+}
+
+func newDecodeOptions(opts []DecodeOption) decodeOptions {
+	var o decodeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// reader returns a reader that fails once more than the maximum message
+// size has been read
+func (o decodeOptions) reader(r io.Reader) *limitedReader {
+	return &limitedReader{r: r, limit: o.maxMessageBytes}
+}
+
+// decodeErr returns ErrMessageTooLarge if decoding failed because the
+// message is larger than the limit, otherwise it returns err
+func (o decodeOptions) decodeErr(lr *limitedReader, err error) error {
+	if lr.exceeded {
+		return xerrors.Errorf("message is larger than %d bytes: %w", o.maxMessageBytes, datatransfer.ErrMessageTooLarge)
+	}
+	return err
+}
+
+// check the decoded message against the voucher and selector limits, and
+// verify its signature if required
+func (o decodeOptions) check(msg datatransfer.Message) error {
+	if o.verifier != nil {
+		if err := Verify(msg, o.verifyFrom, o.verifier); err != nil {
+			return err
+		}
+	}
+	switch m := msg.(type) {
+	case *TransferRequest1_1:
+		if err := checkNodeSize("voucher", m.VoucherPtr, o.maxVoucherBytes); err != nil {
+			return err
+		}
+		return checkNodeSize("selector", m.SelectorPtr, o.maxSelectorBytes)
+	case *TransferResponse1_1:
+		return checkNodeSize("voucher result", m.VoucherResultPtr, o.maxVoucherBytes)
+	}
+	return nil
+}
+
+func checkNodeSize(name string, nd datamodel.Node, max int64) error {
+	if max <= 0 || nd == nil {
+		return nil
+	}
+	cw := &countingWriter{}
+	if err := dagcbor.Encode(nd, cw); err != nil {
+		return xerrors.Errorf("measuring %s size: %w", name, err)
+	}
+	if cw.n > max {
+		return xerrors.Errorf("%s is %d bytes, limit is %d bytes: %w", name, cw.n, max, datatransfer.ErrMessageTooLarge)
+	}
+	return nil
+}
+
+// limitedReader is like io.LimitedReader, but returns ErrMessageTooLarge
+// rather than io.EOF once the limit is reached, so that a message that is
+// cut off is not mistaken for the end of the stream. A limit of zero means
+// no limit.
+type limitedReader struct {
+	r        io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.limit <= 0 {
+		return l.r.Read(p)
+	}
+	remaining := l.limit - l.read
+	if remaining <= 0 {
+		l.exceeded = true
+		return 0, datatransfer.ErrMessageTooLarge
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
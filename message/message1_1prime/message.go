@@ -1,13 +1,17 @@
 package message1_1
 
 import (
+	"bytes"
 	"io"
+	"sync/atomic"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/ipld/go-ipld-prime/schema"
+	"github.com/libp2p/go-libp2p/core/peer"
 	xerrors "golang.org/x/xerrors"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
@@ -19,6 +23,19 @@ var emptyTypedVoucher = datatransfer.TypedVoucher{
 	Type:    datatransfer.EmptyTypeIdentifier,
 }
 
+// DefaultMaxVoucherBytes is the default limit on the encoded size of the
+// voucher in a new request
+const DefaultMaxVoucherBytes = 1 << 20
+
+var maxVoucherBytes int64 = DefaultMaxVoucherBytes
+
+// SetMaxVoucherBytes sets the limit on the encoded size of the voucher that
+// NewRequest and VoucherRequest accept, so that oversized vouchers are caught
+// before they are sent. A limit of zero means no limit.
+func SetMaxVoucherBytes(n int64) {
+	atomic.StoreInt64(&maxVoucherBytes, n)
+}
+
 // NewRequest generates a new request for the data transfer protocol
 func NewRequest(id datatransfer.TransferID, isRestart bool, isPull bool, voucher *datatransfer.TypedVoucher, baseCid cid.Cid, selector datamodel.Node) (datatransfer.Request, error) {
 	if voucher == nil {
@@ -27,6 +44,9 @@ func NewRequest(id datatransfer.TransferID, isRestart bool, isPull bool, voucher
 	if baseCid == cid.Undef {
 		return nil, xerrors.Errorf("base CID must be defined")
 	}
+	if err := checkNodeSize("voucher", voucher.Voucher, atomic.LoadInt64(&maxVoucherBytes)); err != nil {
+		return nil, err
+	}
 
 	var typ uint64
 	if isRestart {
@@ -80,6 +100,9 @@ func VoucherRequest(id datatransfer.TransferID, voucher *datatransfer.TypedVouch
 	if voucher == nil {
 		voucher = &emptyTypedVoucher
 	}
+	if err := checkNodeSize("voucher", voucher.Voucher, atomic.LoadInt64(&maxVoucherBytes)); err != nil {
+		return nil, err
+	}
 	return &TransferRequest1_1{
 		MessageType:           uint64(types.VoucherMessage),
 		VoucherPtr:            voucher.Voucher,
@@ -167,6 +190,41 @@ func UpdateResponse(id datatransfer.TransferID, isPaused bool) datatransfer.Resp
 	}
 }
 
+// ExpectedSizeResponse returns a new update response that tells the requester
+// the total size of the data the responder expects to send.
+// Note: peers running versions that predate the expected size field cannot
+// decode this message
+func ExpectedSizeResponse(id datatransfer.TransferID, isPaused bool, size uint64) datatransfer.Response {
+	return &TransferResponse1_1{
+		MessageType:     uint64(types.UpdateMessage),
+		Paused:          isPaused,
+		TransferId:      uint64(id),
+		ExpectedSizePtr: &size,
+	}
+}
+
+// StatusRequest returns a new request asking the remote peer for its view
+// of the progress of the transfer.
+// Note: peers running versions that predate status messages do not
+// understand this message
+func StatusRequest(id datatransfer.TransferID) datatransfer.Request {
+	return &TransferRequest1_1{
+		MessageType: uint64(types.StatusMessage),
+		TransferId:  uint64(id),
+	}
+}
+
+// StatusResponse returns a new response reporting the progress of the
+// transfer, in answer to a StatusRequest
+func StatusResponse(id datatransfer.TransferID, bytesTransferred uint64, isPaused bool) datatransfer.Response {
+	return &TransferResponse1_1{
+		MessageType: uint64(types.StatusMessage),
+		Paused:      isPaused,
+		TransferId:  uint64(id),
+		BytesPtr:    &bytesTransferred,
+	}
+}
+
 // CancelResponse makes a new cancel response message
 func CancelResponse(id datatransfer.TransferID) datatransfer.Response {
 	return &TransferResponse1_1{
@@ -190,22 +248,186 @@ func CompleteResponse(id datatransfer.TransferID, isAccepted bool, isPaused bool
 	}, nil
 }
 
-// FromNet can read a network stream to deserialize a GraphSyncMessage
-func FromNet(r io.Reader) (datatransfer.Message, error) {
-	tm, err := bindnodeRegistry.TypeFromReader(r, &TransferMessage1_1{}, dagcbor.Decode)
-	if err != nil {
+// WithSequence returns a copy of the given message with its sequence number
+// set to seq.
+// Note: peers running versions that predate the sequence field cannot decode
+// sequenced messages
+func WithSequence(msg datatransfer.Message, seq uint64) (datatransfer.Message, error) {
+	switch m := msg.(type) {
+	case *TransferRequest1_1:
+		seqMsg := *m
+		seqMsg.SequencePtr = &seq
+		return &seqMsg, nil
+	case *TransferResponse1_1:
+		seqMsg := *m
+		seqMsg.SequencePtr = &seq
+		return &seqMsg, nil
+	default:
+		return nil, xerrors.Errorf("cannot set sequence on message of type %T", msg)
+	}
+}
+
+// WithReason returns a copy of the given message with the reason for
+// cancelling the transfer set to reason.
+// Note: peers running versions that predate the reason field cannot decode
+// messages that have a reason
+func WithReason(msg datatransfer.Message, reason string) (datatransfer.Message, error) {
+	switch m := msg.(type) {
+	case *TransferRequest1_1:
+		reasonMsg := *m
+		reasonMsg.ReasonPtr = &reason
+		return &reasonMsg, nil
+	case *TransferResponse1_1:
+		reasonMsg := *m
+		reasonMsg.ReasonPtr = &reason
+		return &reasonMsg, nil
+	default:
+		return nil, xerrors.Errorf("cannot set reason on message of type %T", msg)
+	}
+}
+
+// WithDeadline returns a copy of the given message with a deadline, after
+// which the receiver should ignore the message. The deadline is sent with
+// a precision of one second, rounded up so that the message is never ignored
+// before the deadline.
+// Note: peers running versions that predate the deadline field cannot
+// decode messages that have a deadline
+func WithDeadline(msg datatransfer.Message, deadline time.Time) (datatransfer.Message, error) {
+	unix := deadline.Unix()
+	if deadline.Nanosecond() > 0 {
+		unix++
+	}
+	switch m := msg.(type) {
+	case *TransferRequest1_1:
+		deadlineMsg := *m
+		deadlineMsg.DeadlinePtr = &unix
+		return &deadlineMsg, nil
+	case *TransferResponse1_1:
+		deadlineMsg := *m
+		deadlineMsg.DeadlinePtr = &unix
+		return &deadlineMsg, nil
+	default:
+		return nil, xerrors.Errorf("cannot set deadline on message of type %T", msg)
+	}
+}
+
+// WithVoucher returns a copy of the given message with its voucher (for a
+// request) or voucher result (for a response) replaced by the given voucher
+func WithVoucher(msg datatransfer.Message, voucher datatransfer.TypedVoucher) (datatransfer.Message, error) {
+	switch m := msg.(type) {
+	case *TransferRequest1_1:
+		voucherMsg := *m
+		voucherMsg.VoucherPtr = voucher.Voucher
+		voucherMsg.VoucherTypeIdentifier = voucher.Type
+		return &voucherMsg, nil
+	case *TransferResponse1_1:
+		voucherMsg := *m
+		voucherMsg.VoucherResultPtr = voucher.Voucher
+		voucherMsg.VoucherTypeIdentifier = voucher.Type
+		return &voucherMsg, nil
+	default:
+		return nil, xerrors.Errorf("cannot set voucher on message of type %T", msg)
+	}
+}
+
+// WithSignature returns a copy of the given message with the given signature.
+// Note: peers running versions that predate the signature field cannot
+// decode messages that have a signature
+func WithSignature(msg datatransfer.Message, sig []byte) (datatransfer.Message, error) {
+	switch m := msg.(type) {
+	case *TransferRequest1_1:
+		sigMsg := *m
+		sigMsg.SignaturePtr = &sig
+		return &sigMsg, nil
+	case *TransferResponse1_1:
+		sigMsg := *m
+		sigMsg.SignaturePtr = &sig
+		return &sigMsg, nil
+	default:
+		return nil, xerrors.Errorf("cannot set signature on message of type %T", msg)
+	}
+}
+
+// SignedData returns the data that a message's signature is over: the
+// encoded message, without its signature
+func SignedData(msg datatransfer.Message) ([]byte, error) {
+	var unsigned datatransfer.Message
+	switch m := msg.(type) {
+	case *TransferRequest1_1:
+		unsignedMsg := *m
+		unsignedMsg.SignaturePtr = nil
+		unsigned = &unsignedMsg
+	case *TransferResponse1_1:
+		unsignedMsg := *m
+		unsignedMsg.SignaturePtr = nil
+		unsigned = &unsignedMsg
+	default:
+		return nil, xerrors.Errorf("cannot sign message of type %T", msg)
+	}
+	buf := new(bytes.Buffer)
+	if err := unsigned.ToNet(buf); err != nil {
 		return nil, err
 	}
+	return buf.Bytes(), nil
+}
+
+// Sign returns a copy of the given message, signed by signer for sending to
+// the given peer
+func Sign(msg datatransfer.Message, to peer.ID, signer datatransfer.MessageSigner) (datatransfer.Message, error) {
+	data, err := SignedData(msg)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding message to sign: %w", err)
+	}
+	sig, err := signer.Sign(to, data)
+	if err != nil {
+		return nil, xerrors.Errorf("signing message: %w", err)
+	}
+	return WithSignature(msg, sig)
+}
+
+// Verify checks the signature of a message received from the given peer.
+// It returns an error wrapping ErrInvalidSignature if the message is not
+// signed or the verifier rejects the signature.
+func Verify(msg datatransfer.Message, from peer.ID, verifier datatransfer.MessageVerifier) error {
+	sig := msg.Signature()
+	if sig == nil {
+		return xerrors.Errorf("message from %s is not signed: %w", from, datatransfer.ErrInvalidSignature)
+	}
+	data, err := SignedData(msg)
+	if err != nil {
+		return xerrors.Errorf("encoding message to verify: %w", err)
+	}
+	if err := verifier.Verify(from, data, sig); err != nil {
+		return xerrors.Errorf("message from %s: %s: %w", from, err, datatransfer.ErrInvalidSignature)
+	}
+	return nil
+}
+
+// FromNet can read a network stream to deserialize a GraphSyncMessage.
+// Decode options can be passed to limit the size of the message, eg when it
+// comes from an untrusted peer. Only MaxMessageBytes limits the memory used
+// while decoding.
+func FromNet(r io.Reader, opts ...DecodeOption) (datatransfer.Message, error) {
+	decodeOpts := newDecodeOptions(opts)
+	lr := decodeOpts.reader(r)
+	tm, err := bindnodeRegistry.TypeFromReader(lr, &TransferMessage1_1{}, dagcbor.Decode)
+	if err != nil {
+		return nil, decodeOpts.decodeErr(lr, err)
+	}
 	tresp := tm.(*TransferMessage1_1)
 
 	if (tresp.IsRequest && tresp.Request == nil) || (!tresp.IsRequest && tresp.Response == nil) {
 		return nil, xerrors.Errorf("invalid/malformed message")
 	}
 
+	var msg datatransfer.Message = tresp.Response
 	if tresp.IsRequest {
-		return tresp.Request, nil
+		msg = tresp.Request
 	}
-	return tresp.Response, nil
+	if err := decodeOpts.check(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
 // FromNet can read a network stream to deserialize a GraphSyncMessage
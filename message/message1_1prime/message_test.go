@@ -5,7 +5,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	basicnode "github.com/ipld/go-ipld-prime/node/basic"
@@ -16,6 +18,7 @@ import (
 
 	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
 	message1_1 "github.com/filecoin-project/go-data-transfer/v2/message/message1_1prime"
+	"github.com/filecoin-project/go-data-transfer/v2/message/types"
 	"github.com/filecoin-project/go-data-transfer/v2/testutil"
 )
 
@@ -47,6 +50,33 @@ func TestNewRequest(t *testing.T) {
 	assert.True(t, msg.IsNew())
 }
 
+func TestNewRequestVoucherLimit(t *testing.T) {
+	baseCid := testutil.GenerateCids(1)[0]
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	id := datatransfer.TransferID(rand.Int31())
+	voucher := datatransfer.TypedVoucher{
+		Voucher: basicnode.NewString(strings.Repeat("x", 100)),
+		Type:    testutil.TestVoucherType,
+	}
+
+	// the default limit accepts ordinary vouchers
+	_, err := message1_1.NewRequest(id, false, true, &voucher, baseCid, selector)
+	require.NoError(t, err)
+
+	message1_1.SetMaxVoucherBytes(50)
+	defer message1_1.SetMaxVoucherBytes(message1_1.DefaultMaxVoucherBytes)
+
+	_, err = message1_1.NewRequest(id, false, true, &voucher, baseCid, selector)
+	require.ErrorIs(t, err, datatransfer.ErrMessageTooLarge)
+	_, err = message1_1.VoucherRequest(id, &voucher)
+	require.ErrorIs(t, err, datatransfer.ErrMessageTooLarge)
+
+	// a limit of zero disables the check
+	message1_1.SetMaxVoucherBytes(0)
+	_, err = message1_1.NewRequest(id, false, true, &voucher, baseCid, selector)
+	require.NoError(t, err)
+}
+
 func TestRestartRequest(t *testing.T) {
 	baseCid := testutil.GenerateCids(1)[0]
 	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
@@ -345,6 +375,250 @@ func TestUpdateResponse(t *testing.T) {
 	assert.Equal(t, response.TransferID(), msg.TransferID())
 }
 
+func TestRestartResponse(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+	response, err := message1_1.RestartResponse(id, true, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, response.TransferID(), id)
+	assert.True(t, response.Accepted())
+	assert.True(t, response.IsRestart())
+	assert.True(t, response.IsValidationResult())
+	assert.False(t, response.IsNew())
+	assert.False(t, response.IsUpdate())
+	assert.False(t, response.IsPaused())
+	assert.False(t, response.IsRequest())
+
+	// the restart flag must survive a round trip over the wire
+	wbuf := new(bytes.Buffer)
+	require.NoError(t, response.ToNet(wbuf))
+	deserialized, err := message1_1.FromNet(wbuf)
+	require.NoError(t, err)
+	deserializedResponse, ok := deserialized.(datatransfer.Response)
+	require.True(t, ok)
+	assert.True(t, deserializedResponse.IsRestart())
+	assert.True(t, deserializedResponse.Accepted())
+	assert.Equal(t, id, deserializedResponse.TransferID())
+}
+
+func TestExpectedSizeResponse(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+	response := message1_1.ExpectedSizeResponse(id, true, 12345)
+	assert.Equal(t, response.TransferID(), id)
+	assert.True(t, response.IsUpdate())
+	assert.True(t, response.IsPaused())
+	size, ok := response.ExpectedSize()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(12345), size)
+
+	wbuf := new(bytes.Buffer)
+	require.NoError(t, response.ToNet(wbuf))
+	desMsg, err := message1_1.FromNet(wbuf)
+	require.NoError(t, err)
+	desResp, ok := desMsg.(datatransfer.Response)
+	require.True(t, ok)
+	size, ok = desResp.ExpectedSize()
+	require.True(t, ok)
+	require.Equal(t, uint64(12345), size)
+
+	// responses without an expected size report it as unknown
+	_, ok = message1_1.UpdateResponse(id, false).ExpectedSize()
+	assert.False(t, ok)
+}
+
+func TestWithSequence(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+
+	// messages are not sequenced by default
+	request := message1_1.UpdateRequest(id, true)
+	assert.Zero(t, request.Sequence())
+	response := message1_1.UpdateResponse(id, true)
+	assert.Zero(t, response.Sequence())
+
+	for _, msg := range []datatransfer.Message{request, response} {
+		seqMsg, err := message1_1.WithSequence(msg, 42)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(42), seqMsg.Sequence())
+		assert.Equal(t, msg.IsRequest(), seqMsg.IsRequest())
+		assert.Equal(t, msg.TransferID(), seqMsg.TransferID())
+		// the original message is unchanged
+		assert.Zero(t, msg.Sequence())
+
+		wbuf := new(bytes.Buffer)
+		require.NoError(t, seqMsg.ToNet(wbuf))
+		desMsg, err := message1_1.FromNet(wbuf)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(42), desMsg.Sequence())
+	}
+}
+
+func TestWithReason(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+
+	// messages have no reason by default
+	request := message1_1.CancelRequest(id)
+	assert.Empty(t, request.Reason())
+	response := message1_1.CancelResponse(id)
+	assert.Empty(t, response.Reason())
+
+	for _, msg := range []datatransfer.Message{request, response} {
+		reasonMsg, err := message1_1.WithReason(msg, "out of disk space")
+		require.NoError(t, err)
+		assert.Equal(t, "out of disk space", reasonMsg.Reason())
+		assert.True(t, reasonMsg.IsCancel())
+		assert.Equal(t, msg.IsRequest(), reasonMsg.IsRequest())
+		assert.Equal(t, msg.TransferID(), reasonMsg.TransferID())
+		// the original message is unchanged
+		assert.Empty(t, msg.Reason())
+
+		wbuf := new(bytes.Buffer)
+		require.NoError(t, reasonMsg.ToNet(wbuf))
+		desMsg, err := message1_1.FromNet(wbuf)
+		require.NoError(t, err)
+		assert.Equal(t, "out of disk space", desMsg.Reason())
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+	deadline := time.Unix(time.Now().Add(30*time.Second).Unix(), 0)
+
+	// messages have no deadline by default
+	request := message1_1.UpdateRequest(id, false)
+	assert.True(t, request.Deadline().IsZero())
+	response := message1_1.UpdateResponse(id, false)
+	assert.True(t, response.Deadline().IsZero())
+
+	for _, msg := range []datatransfer.Message{request, response} {
+		deadlineMsg, err := message1_1.WithDeadline(msg, deadline)
+		require.NoError(t, err)
+		assert.True(t, deadline.Equal(deadlineMsg.Deadline()))
+		assert.Equal(t, msg.IsRequest(), deadlineMsg.IsRequest())
+		assert.Equal(t, msg.TransferID(), deadlineMsg.TransferID())
+		// the original message is unchanged
+		assert.True(t, msg.Deadline().IsZero())
+
+		wbuf := new(bytes.Buffer)
+		require.NoError(t, deadlineMsg.ToNet(wbuf))
+		desMsg, err := message1_1.FromNet(wbuf)
+		require.NoError(t, err)
+		assert.True(t, deadline.Equal(desMsg.Deadline()))
+	}
+
+	// deadlines within a second are rounded up, not truncated
+	deadlineMsg, err := message1_1.WithDeadline(request, deadline.Add(time.Millisecond))
+	require.NoError(t, err)
+	assert.True(t, deadline.Add(time.Second).Equal(deadlineMsg.Deadline()))
+}
+
+func TestWithVoucher(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+	original := testutil.NewTestTypedVoucherWith("original")
+	replacement := testutil.NewTestTypedVoucherWith("replacement")
+
+	request, err := message1_1.VoucherRequest(id, &original)
+	require.NoError(t, err)
+	voucherReq, err := message1_1.WithVoucher(request, replacement)
+	require.NoError(t, err)
+	testutil.AssertTestVoucher(t, voucherReq.(datatransfer.Request), replacement)
+	assert.True(t, voucherReq.(datatransfer.Request).IsVoucher())
+	// the original message is unchanged
+	testutil.AssertTestVoucher(t, request, original)
+
+	response, err := message1_1.VoucherResultResponse(id, true, false, &original)
+	require.NoError(t, err)
+	voucherRes, err := message1_1.WithVoucher(response, replacement)
+	require.NoError(t, err)
+	testutil.AssertTestVoucherResult(t, voucherRes.(datatransfer.Response), replacement)
+	assert.True(t, voucherRes.(datatransfer.Response).Accepted())
+	testutil.AssertTestVoucherResult(t, response, original)
+
+	wbuf := new(bytes.Buffer)
+	require.NoError(t, voucherReq.ToNet(wbuf))
+	desMsg, err := message1_1.FromNet(wbuf)
+	require.NoError(t, err)
+	testutil.AssertTestVoucher(t, desMsg.(datatransfer.Request), replacement)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+	peers := testutil.GeneratePeers(3)
+	sender, receiver, other := peers[0], peers[1], peers[2]
+
+	// messages have no signature by default
+	request := message1_1.UpdateRequest(id, false)
+	assert.Nil(t, request.Signature())
+	response := message1_1.UpdateResponse(id, false)
+	assert.Nil(t, response.Signature())
+
+	for _, msg := range []datatransfer.Message{request, response} {
+		// unsigned messages don't verify
+		err := message1_1.Verify(msg, sender, testutil.FakeVerifier{Self: receiver})
+		require.ErrorIs(t, err, datatransfer.ErrInvalidSignature)
+
+		signedMsg, err := message1_1.Sign(msg, receiver, testutil.FakeSigner{Self: sender})
+		require.NoError(t, err)
+		assert.NotNil(t, signedMsg.Signature())
+		assert.Equal(t, msg.IsRequest(), signedMsg.IsRequest())
+		assert.Equal(t, msg.TransferID(), signedMsg.TransferID())
+		// the original message is unchanged
+		assert.Nil(t, msg.Signature())
+
+		// the signature survives a round trip over the network
+		wbuf := new(bytes.Buffer)
+		require.NoError(t, signedMsg.ToNet(wbuf))
+		desMsg, err := message1_1.FromNet(bytes.NewReader(wbuf.Bytes()), message1_1.VerifySignature(sender, testutil.FakeVerifier{Self: receiver}))
+		require.NoError(t, err)
+		assert.Equal(t, signedMsg.Signature(), desMsg.Signature())
+
+		// a message signed by another peer, or for another peer, doesn't verify
+		_, err = message1_1.FromNet(bytes.NewReader(wbuf.Bytes()), message1_1.VerifySignature(other, testutil.FakeVerifier{Self: receiver}))
+		require.ErrorIs(t, err, datatransfer.ErrInvalidSignature)
+		err = message1_1.Verify(desMsg, sender, testutil.FakeVerifier{Self: other})
+		require.ErrorIs(t, err, datatransfer.ErrInvalidSignature)
+
+		// a message altered after signing doesn't verify
+		tampered, err := message1_1.WithSequence(signedMsg, 7)
+		require.NoError(t, err)
+		err = message1_1.Verify(tampered, sender, testutil.FakeVerifier{Self: receiver})
+		require.ErrorIs(t, err, datatransfer.ErrInvalidSignature)
+	}
+}
+
+func TestStatusMessages(t *testing.T) {
+	id := datatransfer.TransferID(rand.Int31())
+
+	request := message1_1.StatusRequest(id)
+	assert.True(t, request.IsStatus())
+	assert.True(t, request.IsRequest())
+	assert.False(t, request.IsUpdate())
+	assert.Equal(t, id, request.TransferID())
+
+	response := message1_1.StatusResponse(id, 4096, true)
+	assert.True(t, response.IsStatus())
+	assert.False(t, response.IsRequest())
+	assert.False(t, response.IsValidationResult())
+	assert.True(t, response.IsPaused())
+	assert.Equal(t, uint64(4096), response.BytesTransferred())
+
+	// other messages are not status messages
+	assert.False(t, message1_1.UpdateRequest(id, false).IsStatus())
+	assert.False(t, message1_1.UpdateResponse(id, false).IsStatus())
+
+	for _, msg := range []datatransfer.Message{request, response} {
+		wbuf := new(bytes.Buffer)
+		require.NoError(t, msg.ToNet(wbuf))
+		desMsg, err := message1_1.FromNet(wbuf)
+		require.NoError(t, err)
+		assert.True(t, desMsg.IsStatus())
+		assert.Equal(t, msg.IsRequest(), desMsg.IsRequest())
+		assert.Equal(t, id, desMsg.TransferID())
+		if desResponse, ok := desMsg.(datatransfer.Response); ok {
+			assert.Equal(t, uint64(4096), desResponse.BytesTransferred())
+			assert.True(t, desResponse.IsPaused())
+		}
+	}
+}
+
 func TestCancelResponse(t *testing.T) {
 	id := datatransfer.TransferID(rand.Int31())
 	response := message1_1.CancelResponse(id)
@@ -385,6 +659,63 @@ func TestCompleteResponse(t *testing.T) {
 	assert.False(t, msg.IsUpdate())
 	assert.Equal(t, response.TransferID(), msg.TransferID())
 }
+func TestFromNetDecodeLimits(t *testing.T) {
+	baseCid := testutil.GenerateCids(1)[0]
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	id := datatransfer.TransferID(rand.Int31())
+	voucher := testutil.NewTestTypedVoucher()
+	request, err := message1_1.NewRequest(id, false, true, &voucher, baseCid, selector)
+	require.NoError(t, err)
+	wbuf := new(bytes.Buffer)
+	require.NoError(t, request.ToNet(wbuf))
+	encoded := wbuf.Bytes()
+
+	testCases := map[string]struct {
+		opts        []message1_1.DecodeOption
+		expectedErr bool
+	}{
+		"no limits": {},
+		"generous limits": {
+			opts: []message1_1.DecodeOption{
+				message1_1.MaxMessageBytes(int64(2 * len(encoded))),
+				message1_1.MaxVoucherBytes(1 << 20),
+				message1_1.MaxSelectorBytes(1 << 20),
+			},
+		},
+		"message too large": {
+			opts:        []message1_1.DecodeOption{message1_1.MaxMessageBytes(int64(len(encoded)) - 1)},
+			expectedErr: true,
+		},
+		"voucher too large": {
+			opts:        []message1_1.DecodeOption{message1_1.MaxVoucherBytes(1)},
+			expectedErr: true,
+		},
+		"selector too large": {
+			opts:        []message1_1.DecodeOption{message1_1.MaxSelectorBytes(1)},
+			expectedErr: true,
+		},
+	}
+	for testCase, data := range testCases {
+		t.Run(testCase, func(t *testing.T) {
+			msg, err := message1_1.FromNet(bytes.NewReader(encoded), data.opts...)
+			if data.expectedErr {
+				require.ErrorIs(t, err, datatransfer.ErrMessageTooLarge)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, request.TransferID(), msg.TransferID())
+		})
+	}
+
+	// voucher results on responses are limited by the voucher limit
+	response, err := message1_1.ValidationResultResponse(types.NewMessage, id, datatransfer.ValidationResult{Accepted: true, VoucherResult: &voucher}, nil, false)
+	require.NoError(t, err)
+	wbuf = new(bytes.Buffer)
+	require.NoError(t, response.ToNet(wbuf))
+	_, err = message1_1.FromNet(wbuf, message1_1.MaxVoucherBytes(1))
+	require.ErrorIs(t, err, datatransfer.ErrMessageTooLarge)
+}
+
 func TestToNetFromNetEquivalency(t *testing.T) {
 	t.Run("round-trip", func(t *testing.T) {
 		baseCid := testutil.GenerateCids(1)[0]
@@ -2,6 +2,7 @@ package message1_1
 
 import (
 	"io"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
@@ -28,6 +29,10 @@ type TransferRequest1_1 struct {
 	VoucherTypeIdentifier datatransfer.TypeIdentifier
 	TransferId            uint64
 	RestartChannel        datatransfer.ChannelID
+	SequencePtr           *uint64
+	ReasonPtr             *string
+	DeadlinePtr           *int64
+	SignaturePtr          *[]byte
 }
 
 func (trq *TransferRequest1_1) MessageForProtocol(targetProtocol protocol.ID) (datatransfer.Message, error) {
@@ -59,6 +64,12 @@ func (trq *TransferRequest1_1) RestartChannelId() (datatransfer.ChannelID, error
 	return trq.RestartChannel, nil
 }
 
+// IsStatus returns true if this request asks for the progress of the
+// transfer
+func (trq *TransferRequest1_1) IsStatus() bool {
+	return trq.MessageType == uint64(types.StatusMessage)
+}
+
 func (trq *TransferRequest1_1) IsNew() bool {
 	return trq.MessageType == uint64(types.NewMessage)
 }
@@ -79,6 +90,42 @@ func (trq *TransferRequest1_1) TransferID() datatransfer.TransferID {
 	return datatransfer.TransferID(trq.TransferId)
 }
 
+// Sequence returns the sequence number assigned by the sender, or zero if the
+// request is not sequenced
+func (trq *TransferRequest1_1) Sequence() uint64 {
+	if trq.SequencePtr == nil {
+		return 0
+	}
+	return *trq.SequencePtr
+}
+
+// Reason returns the reason the sender gave for cancelling the transfer, or
+// an empty string if no reason was given
+func (trq *TransferRequest1_1) Reason() string {
+	if trq.ReasonPtr == nil {
+		return ""
+	}
+	return *trq.ReasonPtr
+}
+
+// Deadline returns the time after which the receiver should ignore the
+// request, or the zero time if the request has no deadline
+func (trq *TransferRequest1_1) Deadline() time.Time {
+	if trq.DeadlinePtr == nil {
+		return time.Time{}
+	}
+	return time.Unix(*trq.DeadlinePtr, 0)
+}
+
+// Signature returns the sender's signature over the request, or nil if the
+// request is not signed
+func (trq *TransferRequest1_1) Signature() []byte {
+	if trq.SignaturePtr == nil {
+		return nil
+	}
+	return *trq.SignaturePtr
+}
+
 // ========= datatransfer.Request interface
 // IsPull returns true if this is a data pull request
 func (trq *TransferRequest1_1) IsPull() bool {
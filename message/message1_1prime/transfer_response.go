@@ -2,6 +2,7 @@ package message1_1
 
 import (
 	"io"
+	"time"
 
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/codec/dagcbor"
@@ -23,6 +24,12 @@ type TransferResponse1_1 struct {
 	TransferId            uint64
 	VoucherResultPtr      datamodel.Node
 	VoucherTypeIdentifier datatransfer.TypeIdentifier
+	ExpectedSizePtr       *uint64
+	BytesPtr              *uint64
+	SequencePtr           *uint64
+	ReasonPtr             *string
+	DeadlinePtr           *int64
+	SignaturePtr          *[]byte
 }
 
 func (trsp *TransferResponse1_1) TransferID() datatransfer.TransferID {
@@ -80,6 +87,66 @@ func (trsp *TransferResponse1_1) VoucherResult() (datamodel.Node, error) {
 	return trsp.VoucherResultPtr, nil
 }
 
+// ExpectedSize returns the total size of the data the responder expects to
+// send, and false if the responder did not include it in the response
+func (trsp *TransferResponse1_1) ExpectedSize() (uint64, bool) {
+	if trsp.ExpectedSizePtr == nil {
+		return 0, false
+	}
+	return *trsp.ExpectedSizePtr, true
+}
+
+// IsStatus returns true if this response reports the progress of the
+// transfer
+func (trsp *TransferResponse1_1) IsStatus() bool {
+	return trsp.MessageType == uint64(types.StatusMessage)
+}
+
+// BytesTransferred returns the number of bytes the responder reported it has
+// transferred on the channel, for status responses
+func (trsp *TransferResponse1_1) BytesTransferred() uint64 {
+	if trsp.BytesPtr == nil {
+		return 0
+	}
+	return *trsp.BytesPtr
+}
+
+// Sequence returns the sequence number assigned by the sender, or zero if the
+// response is not sequenced
+func (trsp *TransferResponse1_1) Sequence() uint64 {
+	if trsp.SequencePtr == nil {
+		return 0
+	}
+	return *trsp.SequencePtr
+}
+
+// Reason returns the reason the sender gave for cancelling the transfer, or
+// an empty string if no reason was given
+func (trsp *TransferResponse1_1) Reason() string {
+	if trsp.ReasonPtr == nil {
+		return ""
+	}
+	return *trsp.ReasonPtr
+}
+
+// Deadline returns the time after which the receiver should ignore the
+// response, or the zero time if the response has no deadline
+func (trsp *TransferResponse1_1) Deadline() time.Time {
+	if trsp.DeadlinePtr == nil {
+		return time.Time{}
+	}
+	return time.Unix(*trsp.DeadlinePtr, 0)
+}
+
+// Signature returns the sender's signature over the response, or nil if the
+// response is not signed
+func (trsp *TransferResponse1_1) Signature() []byte {
+	if trsp.SignaturePtr == nil {
+		return nil
+	}
+	return *trsp.SignaturePtr
+}
+
 func (trq *TransferResponse1_1) IsRestart() bool {
 	return trq.MessageType == uint64(types.RestartMessage)
 }
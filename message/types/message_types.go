@@ -13,4 +13,5 @@ const (
 
 	RestartMessage
 	RestartExistingChannelRequestMessage
+	StatusMessage
 )
@@ -20,6 +20,10 @@ type DataTransferNetwork interface {
 		peer.ID,
 		datatransfer.Message) error
 
+	// SendQueueDepth returns the number of messages that are currently being
+	// sent to a peer
+	SendQueueDepth(peer.ID) int
+
 	// SetDelegate registers the Reciver to handle messages received from the
 	// network.
 	SetDelegate(Receiver)
@@ -32,12 +36,34 @@ type DataTransferNetwork interface {
 	// the peer will accept messages on the protocol
 	ConnectWithRetry(ctx context.Context, p peer.ID) error
 
+	// IsConnected returns whether there is an open connection to the given peer
+	IsConnected(peer.ID) bool
+
+	// WaitForConnection blocks until there is an open connection to the given
+	// peer, or the context is cancelled. It does not try to connect to the
+	// peer itself
+	WaitForConnection(context.Context, peer.ID) error
+
 	// ID returns the peer id of this libp2p host
 	ID() peer.ID
 
 	// Protocol returns the protocol version of the peer, connecting to
 	// the peer if necessary
 	Protocol(context.Context, peer.ID) (protocol.ID, error)
+
+	// Probe checks whether the given peer supports the data-transfer
+	// protocol, making a single attempt to open a stream to the peer, and
+	// reports the protocol versions it supports
+	Probe(context.Context, peer.ID) (ProtocolInfo, error)
+}
+
+// ProtocolInfo describes the data-transfer protocol versions a peer supports
+type ProtocolInfo struct {
+	// Protocol is the protocol version used to talk to the peer
+	Protocol protocol.ID
+	// Protocols are the versions supported by the peer, among the versions
+	// supported by this node
+	Protocols []protocol.ID
 }
 
 // Receiver is an interface for receiving messages from the GraphSyncNetwork.
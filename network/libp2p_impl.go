@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
@@ -74,6 +75,55 @@ func RetryParameters(minDuration time.Duration, maxDuration time.Duration, attem
 	}
 }
 
+// MessageDecodeOptions sets limits on the size of the messages received from
+// other peers. By default no limits are applied. Set message.MaxMessageBytes
+// to bound the memory used to decode each message
+func MessageDecodeOptions(opts ...message.DecodeOption) Option {
+	return func(impl *libp2pDataTransferNetwork) {
+		impl.decodeOpts = opts
+	}
+}
+
+// MessageSigning signs every message sent with signer, and rejects messages
+// received from peers that are not signed or whose signature verifier does
+// not accept. Either may be nil to sign without verifying, or vice versa.
+// Note that peers running versions that predate message signing cannot
+// decode signed messages. By default messages are neither signed nor
+// verified.
+func MessageSigning(signer datatransfer.MessageSigner, verifier datatransfer.MessageVerifier) Option {
+	return func(impl *libp2pDataTransferNetwork) {
+		impl.signer = signer
+		impl.verifier = verifier
+	}
+}
+
+// SendQueueLimit limits the number of messages that can be sent to a peer at
+// once. Once the limit is reached, SendMessage fails with
+// datatransfer.ErrSendQueueFull rather than waiting for a slow peer. By
+// default there is no limit.
+func SendQueueLimit(limit int) Option {
+	return func(impl *libp2pDataTransferNetwork) {
+		impl.sendQueueLimit = limit
+	}
+}
+
+// VoucherStreaming sends vouchers and voucher results whose encoded size is
+// greater than threshold on a separate stream, in chunks, rather than in the
+// message itself (see ProtocolVoucherChunks). The message carries a small
+// handle in place of the voucher, which the receiving peer replaces with the
+// reassembled voucher before passing the message on. Vouchers streamed by
+// other peers are accepted up to maxVoucherBytes. If the remote peer does not
+// accept streamed vouchers, the voucher is sent in the message as usual.
+// Set threshold to zero to only receive streamed vouchers, or maxVoucherBytes
+// to zero to only send them.
+// By default vouchers are never streamed, and streamed vouchers are refused.
+func VoucherStreaming(threshold uint64, maxVoucherBytes uint64) Option {
+	return func(impl *libp2pDataTransferNetwork) {
+		impl.voucherStreamThreshold = threshold
+		impl.maxStreamedVoucherBytes = maxVoucherBytes
+	}
+}
+
 // NewFromLibp2pHost returns a GraphSyncNetwork supported by underlying Libp2p host.
 func NewFromLibp2pHost(host host.Host, options ...Option) DataTransferNetwork {
 	dataTransferNetwork := libp2pDataTransferNetwork{
@@ -85,12 +135,16 @@ func NewFromLibp2pHost(host host.Host, options ...Option) DataTransferNetwork {
 		minAttemptDuration:    defaultMinAttemptDuration,
 		maxAttemptDuration:    defaultMaxAttemptDuration,
 		backoffFactor:         defaultBackoffFactor,
+		sendQueue:             make(map[peer.ID]int),
 	}
 	dataTransferNetwork.setDataTransferProtocols(defaultDataTransferProtocols)
 
 	for _, option := range options {
 		option(&dataTransferNetwork)
 	}
+	if dataTransferNetwork.maxStreamedVoucherBytes > 0 {
+		dataTransferNetwork.streamedVouchers = newStreamedVouchers(dataTransferNetwork.sendMessageTimeout)
+	}
 
 	return &dataTransferNetwork
 }
@@ -108,8 +162,24 @@ type libp2pDataTransferNetwork struct {
 	minAttemptDuration    time.Duration
 	maxAttemptDuration    time.Duration
 	dtProtocols           []protocol.ID
+	decodeOpts            []message.DecodeOption
 	dtProtocolStrings     []string
 	backoffFactor         float64
+	signer                datatransfer.MessageSigner
+	verifier              datatransfer.MessageVerifier
+
+	// The number of messages being sent to each peer, limited to
+	// sendQueueLimit if it is set
+	sendQueueLk    sync.Mutex
+	sendQueueLimit int
+	sendQueue      map[peer.ID]int
+
+	// Vouchers larger than the threshold are sent on a side stream. Vouchers
+	// streamed by other peers are held until the message that refers to them
+	// arrives
+	voucherStreamThreshold  uint64
+	maxStreamedVoucherBytes uint64
+	streamedVouchers        *streamedVouchers
 }
 
 func (impl *libp2pDataTransferNetwork) openStream(ctx context.Context, id peer.ID, protocols ...protocol.ID) (network.Stream, error) {
@@ -173,6 +243,15 @@ func (dtnet *libp2pDataTransferNetwork) SendMessage(
 	))
 
 	defer span.End()
+
+	if !dtnet.queueSend(p) {
+		err := xerrors.Errorf("sending message to %s: %w", p, datatransfer.ErrSendQueueFull)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	defer dtnet.dequeueSend(p)
+
 	s, err := dtnet.openStream(ctx, p, dtnet.dtProtocols...)
 	if err != nil {
 		span.RecordError(err)
@@ -188,6 +267,25 @@ func (dtnet *libp2pDataTransferNetwork) SendMessage(
 		return err
 	}
 
+	// Send a large voucher on a side stream before the message that refers
+	// to it, so that the handle is what gets signed
+	outgoing, err = dtnet.streamLargeVoucher(ctx, p, outgoing)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	if dtnet.signer != nil {
+		outgoing, err = message.Sign(outgoing, p, dtnet.signer)
+		if err != nil {
+			err = xerrors.Errorf("failed to sign message: %w", err)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+	}
+
 	if err = dtnet.msgToStream(ctx, s, outgoing); err != nil {
 		if err2 := s.Reset(); err2 != nil {
 			log.Error(err)
@@ -203,17 +301,88 @@ func (dtnet *libp2pDataTransferNetwork) SendMessage(
 	return s.Close()
 }
 
+// queueSend adds a message to the peer's send queue, returning false if the
+// queue is full
+func (dtnet *libp2pDataTransferNetwork) queueSend(p peer.ID) bool {
+	dtnet.sendQueueLk.Lock()
+	defer dtnet.sendQueueLk.Unlock()
+
+	if dtnet.sendQueueLimit > 0 && dtnet.sendQueue[p] >= dtnet.sendQueueLimit {
+		return false
+	}
+	dtnet.sendQueue[p]++
+	return true
+}
+
+// dequeueSend removes a message that has been sent from the peer's send queue
+func (dtnet *libp2pDataTransferNetwork) dequeueSend(p peer.ID) {
+	dtnet.sendQueueLk.Lock()
+	defer dtnet.sendQueueLk.Unlock()
+
+	dtnet.sendQueue[p]--
+	if dtnet.sendQueue[p] == 0 {
+		delete(dtnet.sendQueue, p)
+	}
+}
+
+// SendQueueDepth returns the number of messages that are currently being sent
+// to the peer
+func (dtnet *libp2pDataTransferNetwork) SendQueueDepth(p peer.ID) int {
+	dtnet.sendQueueLk.Lock()
+	defer dtnet.sendQueueLk.Unlock()
+
+	return dtnet.sendQueue[p]
+}
+
 func (dtnet *libp2pDataTransferNetwork) SetDelegate(r Receiver) {
 	dtnet.receiver = r
 	for _, p := range dtnet.dtProtocols {
 		dtnet.host.SetStreamHandler(p, dtnet.handleNewStream)
 	}
+	if dtnet.streamedVouchers != nil {
+		dtnet.host.SetStreamHandler(ProtocolVoucherChunks, dtnet.handleVoucherStream)
+	}
 }
 
 func (dtnet *libp2pDataTransferNetwork) ConnectTo(ctx context.Context, p peer.ID) error {
 	return dtnet.host.Connect(ctx, peer.AddrInfo{ID: p})
 }
 
+// IsConnected returns whether the host has an open connection to the given peer
+func (dtnet *libp2pDataTransferNetwork) IsConnected(p peer.ID) bool {
+	return dtnet.host.Network().Connectedness(p) == network.Connected
+}
+
+// WaitForConnection blocks until the host has an open connection to the given
+// peer, or the context is cancelled
+func (dtnet *libp2pDataTransferNetwork) WaitForConnection(ctx context.Context, p peer.ID) error {
+	connected := make(chan struct{})
+	var once sync.Once
+	notifee := &network.NotifyBundle{
+		ConnectedF: func(_ network.Network, conn network.Conn) {
+			if conn.RemotePeer() == p {
+				once.Do(func() { close(connected) })
+			}
+		},
+	}
+
+	// Register for notifications before checking the connection, so that a
+	// connection made in between is not missed
+	dtnet.host.Network().Notify(notifee)
+	defer dtnet.host.Network().StopNotify(notifee)
+
+	if dtnet.IsConnected(p) {
+		return nil
+	}
+
+	select {
+	case <-connected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // ConnectWithRetry establishes a connection to the given peer, retrying if
 // necessary, and opens a stream on the data-transfer protocol to verify
 // the peer will accept messages on the protocol
@@ -240,12 +409,16 @@ func (dtnet *libp2pDataTransferNetwork) handleNewStream(s network.Stream) {
 	}
 
 	p := s.Conn().RemotePeer()
+	decodeOpts := dtnet.decodeOpts
+	if dtnet.verifier != nil {
+		decodeOpts = append(decodeOpts[:len(decodeOpts):len(decodeOpts)], message.VerifySignature(p, dtnet.verifier))
+	}
 	for {
 		var received datatransfer.Message
 		var err error
 		switch s.Protocol() {
 		case datatransfer.ProtocolDataTransfer1_2:
-			received, err = message.FromNet(s)
+			received, err = message.FromNet(s, decodeOpts...)
 		}
 
 		if err != nil {
@@ -257,6 +430,14 @@ func (dtnet *libp2pDataTransferNetwork) handleNewStream(s network.Stream) {
 			return
 		}
 
+		received, err = dtnet.resolveVoucherHandle(received)
+		if err != nil {
+			s.Reset() // nolint: errcheck,gosec
+			go dtnet.receiver.ReceiveError(err)
+			log.Debugf("net handleNewStream from %s error: %s", p, err)
+			return
+		}
+
 		ctx := context.Background()
 		log.Debugf("net handleNewStream from %s", p)
 
@@ -345,6 +526,36 @@ func (impl *libp2pDataTransferNetwork) Protocol(ctx context.Context, id peer.ID)
 	return s.Protocol(), nil
 }
 
+// Probe checks whether the given peer supports the data-transfer protocol.
+// Unlike ConnectWithRetry it makes a single attempt to open a stream, so that
+// it fails quickly for peers that don't support the protocol
+func (impl *libp2pDataTransferNetwork) Probe(ctx context.Context, id peer.ID) (ProtocolInfo, error) {
+	tctx, cancel := context.WithTimeout(ctx, impl.openStreamTimeout)
+	defer cancel()
+
+	// Opening the stream negotiates the protocol, and adds the protocols the
+	// peer supports to the peerstore
+	s, err := impl.host.NewStream(tctx, id, impl.dtProtocols...)
+	if err != nil {
+		return ProtocolInfo{}, xerrors.Errorf("probing peer %s for data-transfer protocol: %w", id, err)
+	}
+	proto := s.Protocol()
+	_ = s.Close()
+
+	info := ProtocolInfo{Protocol: proto, Protocols: []protocol.ID{proto}}
+	supported, err := impl.host.Peerstore().SupportsProtocols(id, impl.dtProtocolStrings...)
+	if err != nil {
+		log.Debugf("getting supported protocols of peer %s: %s", id, err)
+		return info, nil
+	}
+	for _, p := range supported {
+		if protocol.ID(p) != proto {
+			info.Protocols = append(info.Protocols, protocol.ID(p))
+		}
+	}
+	return info, nil
+}
+
 func (impl *libp2pDataTransferNetwork) setDataTransferProtocols(protocols []protocol.ID) {
 	impl.dtProtocols = append([]protocol.ID{}, protocols...)
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"strings"
 	"testing"
 	"time"
 
@@ -33,6 +34,7 @@ type receiver struct {
 	lastResponse       datatransfer.Response
 	lastSender         peer.ID
 	connectedPeers     chan peer.ID
+	receiveErrors      chan error
 }
 
 func (r *receiver) ReceiveRequest(
@@ -60,6 +62,9 @@ func (r *receiver) ReceiveResponse(
 }
 
 func (r *receiver) ReceiveError(err error) {
+	if r.receiveErrors != nil {
+		r.receiveErrors <- err
+	}
 }
 
 func (r *receiver) ReceiveRestartExistingChannelRequest(ctx context.Context, sender peer.ID, incoming datatransfer.Request) {
@@ -299,3 +304,251 @@ func TestSendMessageRetry(t *testing.T) {
 		})
 	}
 }
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	mn := mocknet.New()
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+	host2, err := mn.GenPeer()
+	require.NoError(t, err)
+	host3, err := mn.GenPeer()
+	require.NoError(t, err)
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	dtnet1 := network.NewFromLibp2pHost(host1)
+	dtnet2 := network.NewFromLibp2pHost(host2)
+	r := &receiver{
+		messageReceived: make(chan struct{}),
+		connectedPeers:  make(chan peer.ID, 2),
+	}
+	dtnet1.SetDelegate(r)
+	dtnet2.SetDelegate(r)
+
+	// host2 speaks data-transfer
+	info, err := dtnet1.Probe(ctx, host2.ID())
+	require.NoError(t, err)
+	require.Equal(t, datatransfer.ProtocolDataTransfer1_2, info.Protocol)
+	require.Equal(t, []protocol.ID{datatransfer.ProtocolDataTransfer1_2}, info.Protocols)
+
+	// host3 does not, so probing it fails without retrying
+	_, err = dtnet1.Probe(ctx, host3.ID())
+	require.Error(t, err)
+}
+
+func TestSendQueueLimit(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	mn := mocknet.New()
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+	host2, err := mn.GenPeer()
+	require.NoError(t, err)
+	host3, err := mn.GenPeer()
+	require.NoError(t, err)
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	// Retry opening streams after a long wait, so that a message to a peer
+	// that does not speak data-transfer stays in the send queue
+	retry := network.RetryParameters(time.Minute, time.Minute, 2, 1)
+	dtnet1 := network.NewFromLibp2pHost(host1, retry, network.SendQueueLimit(1))
+	dtnet2 := network.NewFromLibp2pHost(host2)
+	r := &receiver{
+		messageReceived: make(chan struct{}),
+		connectedPeers:  make(chan peer.ID, 2),
+	}
+	dtnet1.SetDelegate(r)
+	dtnet2.SetDelegate(r)
+
+	baseCid := testutil.GenerateCids(1)[0]
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	voucher := testutil.NewTestTypedVoucher()
+	request, err := message.NewRequest(datatransfer.TransferID(rand.Int31()), false, false, &voucher, baseCid, selector)
+	require.NoError(t, err)
+
+	// host3 does not speak data-transfer, so the message to it is stuck
+	// waiting to retry
+	slowCtx, slowCancel := context.WithCancel(ctx)
+	slowErr := make(chan error, 1)
+	go func() {
+		slowErr <- dtnet1.SendMessage(slowCtx, host3.ID(), request)
+	}()
+	require.Eventually(t, func() bool {
+		return dtnet1.SendQueueDepth(host3.ID()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// further messages to host3 are refused rather than queued
+	err = dtnet1.SendMessage(ctx, host3.ID(), request)
+	require.ErrorIs(t, err, datatransfer.ErrSendQueueFull)
+	require.Equal(t, 1, dtnet1.SendQueueDepth(host3.ID()))
+
+	// messages to other peers are unaffected
+	require.NoError(t, dtnet1.SendMessage(ctx, host2.ID(), request))
+	select {
+	case <-ctx.Done():
+		t.Fatal("did not receive message sent")
+	case <-r.messageReceived:
+	}
+	require.Equal(t, 0, dtnet1.SendQueueDepth(host2.ID()))
+
+	// once the stuck message gives up, the queue is empty again
+	slowCancel()
+	require.Error(t, <-slowErr)
+	require.Equal(t, 0, dtnet1.SendQueueDepth(host3.ID()))
+}
+
+func TestMessageSigning(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	mn := mocknet.New()
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+	host2, err := mn.GenPeer()
+	require.NoError(t, err)
+	host3, err := mn.GenPeer()
+	require.NoError(t, err)
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	// host1 signs its messages and host2 verifies them; host3 does not sign
+	dtnet1 := network.NewFromLibp2pHost(host1, network.MessageSigning(testutil.FakeSigner{Self: host1.ID()}, nil))
+	dtnet2 := network.NewFromLibp2pHost(host2, network.MessageSigning(nil, testutil.FakeVerifier{Self: host2.ID()}))
+	dtnet3 := network.NewFromLibp2pHost(host3)
+	r := &receiver{
+		messageReceived: make(chan struct{}),
+		connectedPeers:  make(chan peer.ID, 2),
+		receiveErrors:   make(chan error, 1),
+	}
+	dtnet1.SetDelegate(r)
+	dtnet2.SetDelegate(r)
+	dtnet3.SetDelegate(r)
+
+	baseCid := testutil.GenerateCids(1)[0]
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	voucher := testutil.NewTestTypedVoucher()
+	request, err := message.NewRequest(datatransfer.TransferID(rand.Int31()), false, false, &voucher, baseCid, selector)
+	require.NoError(t, err)
+
+	// a signed message is received
+	require.NoError(t, dtnet1.SendMessage(ctx, host2.ID(), request))
+	select {
+	case <-ctx.Done():
+		t.Fatal("did not receive message sent")
+	case <-r.messageReceived:
+	}
+	require.Equal(t, host1.ID(), r.lastSender)
+	require.NoError(t, message.Verify(r.lastRequest, host1.ID(), testutil.FakeVerifier{Self: host2.ID()}))
+
+	// an unsigned message is rejected
+	require.NoError(t, dtnet3.SendMessage(ctx, host2.ID(), request))
+	select {
+	case <-ctx.Done():
+		t.Fatal("did not reject unsigned message")
+	case <-r.messageReceived:
+		t.Fatal("received unsigned message")
+	case err := <-r.receiveErrors:
+		require.ErrorIs(t, err, datatransfer.ErrInvalidSignature)
+	}
+}
+
+func TestVoucherStreaming(t *testing.T) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	mn := mocknet.New()
+
+	host1, err := mn.GenPeer()
+	require.NoError(t, err)
+	host2, err := mn.GenPeer()
+	require.NoError(t, err)
+	host3, err := mn.GenPeer()
+	require.NoError(t, err)
+	host4, err := mn.GenPeer()
+	require.NoError(t, err)
+	err = mn.LinkAll()
+	require.NoError(t, err)
+
+	// host1 streams vouchers over 1KiB, host2 and host4 accept streamed
+	// vouchers (host4 only small ones), and host3 does not support voucher
+	// streaming
+	dtnet1 := network.NewFromLibp2pHost(host1, network.VoucherStreaming(1<<10, 0))
+	dtnet2 := network.NewFromLibp2pHost(host2, network.VoucherStreaming(0, 1<<20))
+	dtnet3 := network.NewFromLibp2pHost(host3)
+	dtnet4 := network.NewFromLibp2pHost(host4, network.VoucherStreaming(0, 100<<10))
+	r := &receiver{
+		messageReceived: make(chan struct{}),
+		connectedPeers:  make(chan peer.ID, 2),
+		receiveErrors:   make(chan error, 2),
+	}
+	dtnet1.SetDelegate(r)
+	dtnet2.SetDelegate(r)
+	dtnet3.SetDelegate(r)
+	dtnet4.SetDelegate(r)
+
+	baseCid := testutil.GenerateCids(1)[0]
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	largeVoucher := testutil.NewTestTypedVoucherWith(strings.Repeat("x", 600<<10))
+	largeRequest, err := message.NewRequest(datatransfer.TransferID(rand.Int31()), false, false, &largeVoucher, baseCid, selector)
+	require.NoError(t, err)
+	smallVoucher := testutil.NewTestTypedVoucher()
+	smallRequest, err := message.NewRequest(datatransfer.TransferID(rand.Int31()), false, false, &smallVoucher, baseCid, selector)
+	require.NoError(t, err)
+
+	receive := func(t *testing.T) {
+		select {
+		case <-ctx.Done():
+			t.Fatal("did not receive message sent")
+		case <-r.messageReceived:
+		}
+	}
+
+	t.Run("large voucher is streamed and reassembled", func(t *testing.T) {
+		require.NoError(t, dtnet1.SendMessage(ctx, host2.ID(), largeRequest))
+		receive(t)
+		require.Equal(t, host1.ID(), r.lastSender)
+		testutil.AssertTestVoucher(t, r.lastRequest, largeVoucher)
+	})
+
+	t.Run("small voucher is sent in the message", func(t *testing.T) {
+		require.NoError(t, dtnet1.SendMessage(ctx, host2.ID(), smallRequest))
+		receive(t)
+		testutil.AssertTestVoucher(t, r.lastRequest, smallVoucher)
+	})
+
+	t.Run("large voucher result is streamed and reassembled", func(t *testing.T) {
+		response, err := message.VoucherResultResponse(datatransfer.TransferID(rand.Int31()), true, false, &largeVoucher)
+		require.NoError(t, err)
+		require.NoError(t, dtnet1.SendMessage(ctx, host2.ID(), response))
+		receive(t)
+		testutil.AssertTestVoucherResult(t, r.lastResponse, largeVoucher)
+	})
+
+	t.Run("large voucher is sent in the message to peers that do not accept streamed vouchers", func(t *testing.T) {
+		require.NoError(t, dtnet1.SendMessage(ctx, host3.ID(), largeRequest))
+		receive(t)
+		require.Equal(t, host1.ID(), r.lastSender)
+		testutil.AssertTestVoucher(t, r.lastRequest, largeVoucher)
+	})
+
+	t.Run("streamed voucher over the receiver's limit is rejected", func(t *testing.T) {
+		// the receiver refuses the voucher, so the message is not sent
+		require.Error(t, dtnet1.SendMessage(ctx, host4.ID(), largeRequest))
+		select {
+		case <-ctx.Done():
+			t.Fatal("did not reject streamed voucher")
+		case <-r.messageReceived:
+			t.Fatal("received message with voucher over limit")
+		case err := <-r.receiveErrors:
+			require.ErrorIs(t, err, datatransfer.ErrMessageTooLarge)
+		}
+	})
+}
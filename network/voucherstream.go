@@ -0,0 +1,332 @@
+package network
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/go-data-transfer/v2/message"
+)
+
+// ProtocolVoucherChunks is the protocol of the side streams that large
+// vouchers are sent on (see VoucherStreaming).
+//
+// A stream carries a single voucher, encoded as dag-cbor, as a sequence of
+// uvarint length-prefixed frames:
+//   - the first frame is the sha256 hash of the encoded voucher, which
+//     identifies the voucher in the handle sent in its place in the message
+//   - each following frame is the next chunk of the encoded voucher, of at most
+//     voucherChunkSize bytes
+//   - an empty frame ends the voucher
+const ProtocolVoucherChunks protocol.ID = "/fil/datatransfer/voucher-chunks/1.0.0"
+
+// VoucherHandleType is the type of the handle sent in a message in place of a
+// voucher or voucher result that was streamed. The handle is a map with the
+// fields:
+// - Hash: the sha256 hash of the encoded voucher
+// - Size: the size of the encoded voucher
+// - Type: the type identifier of the voucher
+const VoucherHandleType = datatransfer.TypeIdentifier("DataTransferVoucherHandle")
+
+// The maximum size of each chunk of a streamed voucher
+const voucherChunkSize = 256 << 10
+
+// streamedVouchers holds the vouchers received on side streams until the
+// messages that refer to them arrive
+type streamedVouchers struct {
+	lk       sync.Mutex
+	vouchers map[string]*streamedVoucher
+	// how long to keep a voucher that no message has claimed
+	expiry time.Duration
+}
+
+type streamedVoucher struct {
+	// closed once the voucher has been received
+	arrived chan struct{}
+	data    []byte
+}
+
+func newStreamedVouchers(expiry time.Duration) *streamedVouchers {
+	return &streamedVouchers{vouchers: make(map[string]*streamedVoucher), expiry: expiry}
+}
+
+// get returns the entry for the voucher with the given hash, creating it if
+// necessary.
+// Note: must be called under the lock.
+func (sv *streamedVouchers) get(hash string) *streamedVoucher {
+	v, ok := sv.vouchers[hash]
+	if !ok {
+		v = &streamedVoucher{arrived: make(chan struct{})}
+		sv.vouchers[hash] = v
+		// forget the voucher if no message claims it (or it never arrives)
+		time.AfterFunc(sv.expiry, func() {
+			sv.lk.Lock()
+			defer sv.lk.Unlock()
+
+			if sv.vouchers[hash] == v {
+				delete(sv.vouchers, hash)
+			}
+		})
+	}
+	return v
+}
+
+// add records that the voucher with the given hash was received
+func (sv *streamedVouchers) add(hash string, data []byte) {
+	sv.lk.Lock()
+	defer sv.lk.Unlock()
+
+	v := sv.get(hash)
+	select {
+	case <-v.arrived:
+		// the same voucher was streamed twice
+	default:
+		v.data = data
+		close(v.arrived)
+	}
+}
+
+// wait waits for the voucher with the given hash to be received, then
+// returns it
+func (sv *streamedVouchers) wait(ctx context.Context, hash string) ([]byte, error) {
+	sv.lk.Lock()
+	v := sv.get(hash)
+	sv.lk.Unlock()
+
+	select {
+	case <-v.arrived:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	sv.lk.Lock()
+	defer sv.lk.Unlock()
+
+	if sv.vouchers[hash] == v {
+		delete(sv.vouchers, hash)
+	}
+	return v.data, nil
+}
+
+// messageVoucher returns the voucher of a request or the voucher result of a
+// response, or nil if it has none
+func messageVoucher(msg datatransfer.Message) (datamodel.Node, datatransfer.TypeIdentifier) {
+	var voucher datamodel.Node
+	var voucherType datatransfer.TypeIdentifier
+	var err error
+	switch m := msg.(type) {
+	case datatransfer.Request:
+		voucherType = m.VoucherType()
+		voucher, err = m.Voucher()
+	case datatransfer.Response:
+		voucherType = m.VoucherResultType()
+		voucher, err = m.VoucherResult()
+	}
+	if err != nil || voucherType == datatransfer.EmptyTypeIdentifier {
+		return nil, datatransfer.EmptyTypeIdentifier
+	}
+	return voucher, voucherType
+}
+
+// streamLargeVoucher sends the message's voucher to the peer on a side stream
+// if it is larger than the threshold, and returns the message with a handle
+// in place of the voucher. If the peer does not support voucher streaming,
+// the message is returned unchanged.
+func (dtnet *libp2pDataTransferNetwork) streamLargeVoucher(ctx context.Context, p peer.ID, msg datatransfer.Message) (datatransfer.Message, error) {
+	if dtnet.voucherStreamThreshold == 0 {
+		return msg, nil
+	}
+	voucher, voucherType := messageVoucher(msg)
+	if voucher == nil {
+		return msg, nil
+	}
+	data, err := ipld.Encode(voucher, dagcbor.Encode)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding voucher: %w", err)
+	}
+	if uint64(len(data)) <= dtnet.voucherStreamThreshold {
+		return msg, nil
+	}
+
+	openCtx, cancel := context.WithTimeout(ctx, dtnet.openStreamTimeout)
+	defer cancel()
+	s, err := dtnet.host.NewStream(openCtx, p, ProtocolVoucherChunks)
+	if err != nil {
+		log.Debugf("peer %s does not accept streamed vouchers, sending %d byte voucher in message: %s", p, len(data), err)
+		return msg, nil
+	}
+
+	hash := sha256.Sum256(data)
+	if err := dtnet.writeVoucherChunks(ctx, s, hash[:], data); err != nil {
+		s.Reset() // nolint: errcheck,gosec
+		return nil, xerrors.Errorf("streaming voucher to %s: %w", p, err)
+	}
+	if err := s.Close(); err != nil {
+		return nil, xerrors.Errorf("streaming voucher to %s: %w", p, err)
+	}
+
+	handle, err := qp.BuildMap(basicnode.Prototype.Map, 3, func(ma datamodel.MapAssembler) {
+		qp.MapEntry(ma, "Hash", qp.Bytes(hash[:]))
+		qp.MapEntry(ma, "Size", qp.Int(int64(len(data))))
+		qp.MapEntry(ma, "Type", qp.String(string(voucherType)))
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("building voucher handle: %w", err)
+	}
+	return message.WithVoucher(msg, datatransfer.TypedVoucher{Voucher: handle, Type: VoucherHandleType})
+}
+
+func (dtnet *libp2pDataTransferNetwork) writeVoucherChunks(ctx context.Context, s network.Stream, hash []byte, data []byte) error {
+	deadline := time.Now().Add(dtnet.sendMessageTimeout)
+	if dl, ok := ctx.Deadline(); ok {
+		deadline = dl
+	}
+	if err := s.SetWriteDeadline(deadline); err != nil {
+		log.Warnf("error setting deadline: %s", err)
+	}
+
+	w := bufio.NewWriter(s)
+	if err := writeFrame(w, hash); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > voucherChunkSize {
+			n = voucherChunkSize
+		}
+		if err := writeFrame(w, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	if err := writeFrame(w, nil); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func writeFrame(w io.Writer, frame []byte) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(frame)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
+// readFrame reads the next frame, returning an error if it is larger than max
+func readFrame(r *bufio.Reader, max uint64) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > max {
+		return nil, xerrors.Errorf("frame of %d bytes exceeds limit of %d bytes: %w", size, max, datatransfer.ErrMessageTooLarge)
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// handleVoucherStream receives a voucher streamed by a peer
+func (dtnet *libp2pDataTransferNetwork) handleVoucherStream(s network.Stream) {
+	defer s.Close() // nolint: errcheck,gosec
+
+	p := s.Conn().RemotePeer()
+	hash, data, err := dtnet.readVoucherChunks(s)
+	if err != nil {
+		s.Reset() // nolint: errcheck,gosec
+		log.Debugf("receiving streamed voucher from %s: %s", p, err)
+		if dtnet.receiver != nil {
+			go dtnet.receiver.ReceiveError(xerrors.Errorf("receiving streamed voucher from %s: %w", p, err))
+		}
+		return
+	}
+	dtnet.streamedVouchers.add(string(hash), data)
+}
+
+func (dtnet *libp2pDataTransferNetwork) readVoucherChunks(s network.Stream) ([]byte, []byte, error) {
+	r := bufio.NewReader(s)
+	hash, err := readFrame(r, sha256.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var data bytes.Buffer
+	for {
+		remaining := dtnet.maxStreamedVoucherBytes - uint64(data.Len())
+		chunk, err := readFrame(r, remaining)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		data.Write(chunk)
+	}
+
+	if sum := sha256.Sum256(data.Bytes()); !bytes.Equal(sum[:], hash) {
+		return nil, nil, xerrors.New("streamed voucher does not match its hash")
+	}
+	return hash, data.Bytes(), nil
+}
+
+// resolveVoucherHandle replaces the voucher handle in a received message, if
+// there is one, with the voucher that was streamed to the local node
+func (dtnet *libp2pDataTransferNetwork) resolveVoucherHandle(msg datatransfer.Message) (datatransfer.Message, error) {
+	if dtnet.streamedVouchers == nil {
+		return msg, nil
+	}
+	handle, voucherType := messageVoucher(msg)
+	if voucherType != VoucherHandleType {
+		return msg, nil
+	}
+
+	hashNode, err := handle.LookupByString("Hash")
+	if err != nil {
+		return nil, xerrors.Errorf("reading voucher handle: %w", err)
+	}
+	hash, err := hashNode.AsBytes()
+	if err != nil {
+		return nil, xerrors.Errorf("reading voucher handle: %w", err)
+	}
+	typeNode, err := handle.LookupByString("Type")
+	if err != nil {
+		return nil, xerrors.Errorf("reading voucher handle: %w", err)
+	}
+	typ, err := typeNode.AsString()
+	if err != nil {
+		return nil, xerrors.Errorf("reading voucher handle: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dtnet.sendMessageTimeout)
+	defer cancel()
+	data, err := dtnet.streamedVouchers.wait(ctx, string(hash))
+	if err != nil {
+		return nil, xerrors.Errorf("waiting for streamed voucher for transfer %d: %w", msg.TransferID(), err)
+	}
+	voucher, err := ipld.Decode(data, dagcbor.Decode)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding streamed voucher: %w", err)
+	}
+	return message.WithVoucher(msg, datatransfer.TypedVoucher{Voucher: voucher, Type: datatransfer.TypeIdentifier(typ)})
+}
@@ -0,0 +1,334 @@
+package testutil
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// DataEvent records a call to OnDataReceived, OnDataQueued or OnDataSent
+type DataEvent struct {
+	ChannelID datatransfer.ChannelID
+	Link      ipld.Link
+	Size      uint64
+	Index     int64
+	Unique    bool
+}
+
+// ErrorEvent records a call to an event that reports an error on a channel
+type ErrorEvent struct {
+	ChannelID datatransfer.ChannelID
+	Err       error
+}
+
+// ReceivedRequest records a call to OnRequestReceived
+type ReceivedRequest struct {
+	ChannelID datatransfer.ChannelID
+	Request   datatransfer.Request
+}
+
+// ReceivedResponse records a call to OnResponseReceived
+type ReceivedResponse struct {
+	ChannelID datatransfer.ChannelID
+	Response  datatransfer.Response
+}
+
+// MalformedMessageEvent records a call to OnMalformedMessage
+type MalformedMessageEvent struct {
+	Peer       peer.ID
+	Extensions []string
+	Err        error
+}
+
+// CompletionResultEvent records a call to OnChannelCompletedDetailed
+type CompletionResultEvent struct {
+	ChannelID datatransfer.ChannelID
+	Result    datatransfer.CompletionResult
+}
+
+// FakeEventsHandler is a datatransfer.EventsHandler that records every event
+// it receives and returns mocked results, for testing transports
+type FakeEventsHandler struct {
+	lk sync.Mutex
+
+	OpenedChannels            []datatransfer.ChannelID
+	OnChannelOpenedErr        error
+	ReceivedResponses         []ReceivedResponse
+	OnResponseReceivedErr     error
+	ReceivedData              []DataEvent
+	OnDataReceivedMessage     datatransfer.Message
+	OnDataReceivedErr         error
+	QueuedData                []DataEvent
+	OnDataQueuedMessage       datatransfer.Message
+	OnDataQueuedErr           error
+	SentData                  []DataEvent
+	OnDataSentErr             error
+	InitiatedTransfers        []datatransfer.ChannelID
+	StartedTransfers          []datatransfer.ChannelID
+	ReceivedRequests          []ReceivedRequest
+	OnRequestReceivedResponse datatransfer.Response
+	OnRequestReceivedErr      error
+	CompletedChannels         []ErrorEvent
+	CompletionResults         []CompletionResultEvent
+	CancelledRequests         []ErrorEvent
+	CancelledChannels         []datatransfer.ChannelID
+	DisconnectedRequests      []ErrorEvent
+	SendDataErrors            []ErrorEvent
+	ReceiveDataErrors         []ErrorEvent
+	StoreErrors               []ErrorEvent
+	ChannelErrors             []ErrorEvent
+	MalformedMessages         []MalformedMessageEvent
+}
+
+var _ datatransfer.EventsHandler = (*FakeEventsHandler)(nil)
+
+// NewFakeEventsHandler returns a new instance of FakeEventsHandler
+func NewFakeEventsHandler() *FakeEventsHandler {
+	return &FakeEventsHandler{}
+}
+
+// OnChannelOpened records the opened channel
+func (fe *FakeEventsHandler) OnChannelOpened(chid datatransfer.ChannelID) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.OpenedChannels = append(fe.OpenedChannels, chid)
+	return fe.OnChannelOpenedErr
+}
+
+// OnResponseReceived records the received response
+func (fe *FakeEventsHandler) OnResponseReceived(chid datatransfer.ChannelID, msg datatransfer.Response) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.ReceivedResponses = append(fe.ReceivedResponses, ReceivedResponse{chid, msg})
+	return fe.OnResponseReceivedErr
+}
+
+// OnDataReceived records the received data
+func (fe *FakeEventsHandler) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.ReceivedData = append(fe.ReceivedData, DataEvent{chid, link, size, index, unique})
+	return fe.OnDataReceivedMessage, fe.OnDataReceivedErr
+}
+
+// OnDataQueued records the queued data
+func (fe *FakeEventsHandler) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.QueuedData = append(fe.QueuedData, DataEvent{chid, link, size, index, unique})
+	return fe.OnDataQueuedMessage, fe.OnDataQueuedErr
+}
+
+// OnDataSent records the sent data
+func (fe *FakeEventsHandler) OnDataSent(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.SentData = append(fe.SentData, DataEvent{chid, link, size, index, unique})
+	return fe.OnDataSentErr
+}
+
+// OnTransferInitiated records the initiated transfer
+func (fe *FakeEventsHandler) OnTransferInitiated(chid datatransfer.ChannelID) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.InitiatedTransfers = append(fe.InitiatedTransfers, chid)
+}
+
+// OnTransferStarted records the started transfer
+func (fe *FakeEventsHandler) OnTransferStarted(chid datatransfer.ChannelID) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.StartedTransfers = append(fe.StartedTransfers, chid)
+}
+
+// OnRequestReceived records the received request
+func (fe *FakeEventsHandler) OnRequestReceived(chid datatransfer.ChannelID, msg datatransfer.Request) (datatransfer.Response, error) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.ReceivedRequests = append(fe.ReceivedRequests, ReceivedRequest{chid, msg})
+	return fe.OnRequestReceivedResponse, fe.OnRequestReceivedErr
+}
+
+// OnChannelCompleted records the completed channel
+func (fe *FakeEventsHandler) OnChannelCompleted(chid datatransfer.ChannelID, err error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.CompletedChannels = append(fe.CompletedChannels, ErrorEvent{chid, err})
+	return nil
+}
+
+// OnChannelCompletedDetailed records the completion result
+func (fe *FakeEventsHandler) OnChannelCompletedDetailed(chid datatransfer.ChannelID, result datatransfer.CompletionResult) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.CompletionResults = append(fe.CompletionResults, CompletionResultEvent{chid, result})
+	return nil
+}
+
+// OnRequestCancelled records the cancelled request
+func (fe *FakeEventsHandler) OnRequestCancelled(chid datatransfer.ChannelID, err error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.CancelledRequests = append(fe.CancelledRequests, ErrorEvent{chid, err})
+	return nil
+}
+
+// OnChannelCancelled records the cancelled channel
+func (fe *FakeEventsHandler) OnChannelCancelled(chid datatransfer.ChannelID) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.CancelledChannels = append(fe.CancelledChannels, chid)
+	return nil
+}
+
+// OnRequestDisconnected records the disconnected request
+func (fe *FakeEventsHandler) OnRequestDisconnected(chid datatransfer.ChannelID, err error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.DisconnectedRequests = append(fe.DisconnectedRequests, ErrorEvent{chid, err})
+	return nil
+}
+
+// OnSendDataError records the send error
+func (fe *FakeEventsHandler) OnSendDataError(chid datatransfer.ChannelID, err error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.SendDataErrors = append(fe.SendDataErrors, ErrorEvent{chid, err})
+	return nil
+}
+
+// OnReceiveDataError records the receive error
+func (fe *FakeEventsHandler) OnReceiveDataError(chid datatransfer.ChannelID, err error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.ReceiveDataErrors = append(fe.ReceiveDataErrors, ErrorEvent{chid, err})
+	return nil
+}
+
+// OnStoreError records the store error
+func (fe *FakeEventsHandler) OnStoreError(chid datatransfer.ChannelID, err error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.StoreErrors = append(fe.StoreErrors, ErrorEvent{chid, err})
+	return nil
+}
+
+// OnChannelError records the channel error
+func (fe *FakeEventsHandler) OnChannelError(chid datatransfer.ChannelID, reason error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.ChannelErrors = append(fe.ChannelErrors, ErrorEvent{chid, reason})
+	return nil
+}
+
+// OnMalformedMessage records the malformed message
+func (fe *FakeEventsHandler) OnMalformedMessage(p peer.ID, extensions []string, err error) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	fe.MalformedMessages = append(fe.MalformedMessages, MalformedMessageEvent{p, extensions, err})
+	return nil
+}
+
+// OnContextAugment returns the context unchanged
+func (fe *FakeEventsHandler) OnContextAugment(chid datatransfer.ChannelID) func(context.Context) context.Context {
+	return func(ctx context.Context) context.Context {
+		return ctx
+	}
+}
+
+// AssertChannelOpened asserts that OnChannelOpened was called for the channel
+func (fe *FakeEventsHandler) AssertChannelOpened(t *testing.T, chid datatransfer.ChannelID) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	require.Contains(t, fe.OpenedChannels, chid, "channel should be opened")
+}
+
+// AssertDataReceived asserts that OnDataReceived was called for the link on the channel
+func (fe *FakeEventsHandler) AssertDataReceived(t *testing.T, chid datatransfer.ChannelID, link ipld.Link) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	require.True(t, hasDataEvent(fe.ReceivedData, chid, link), "data should be received")
+}
+
+// AssertDataQueued asserts that OnDataQueued was called for the link on the channel
+func (fe *FakeEventsHandler) AssertDataQueued(t *testing.T, chid datatransfer.ChannelID, link ipld.Link) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	require.True(t, hasDataEvent(fe.QueuedData, chid, link), "data should be queued")
+}
+
+// AssertDataSent asserts that OnDataSent was called for the link on the channel
+func (fe *FakeEventsHandler) AssertDataSent(t *testing.T, chid datatransfer.ChannelID, link ipld.Link) {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	require.True(t, hasDataEvent(fe.SentData, chid, link), "data should be sent")
+}
+
+// AssertRequestReceived asserts that OnRequestReceived was called for the
+// channel, and returns the last request received
+func (fe *FakeEventsHandler) AssertRequestReceived(t *testing.T, chid datatransfer.ChannelID) datatransfer.Request {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	for i := len(fe.ReceivedRequests) - 1; i >= 0; i-- {
+		if fe.ReceivedRequests[i].ChannelID == chid {
+			return fe.ReceivedRequests[i].Request
+		}
+	}
+	require.FailNow(t, "request should be received")
+	return nil
+}
+
+// AssertResponseReceived asserts that OnResponseReceived was called for the
+// channel, and returns the last response received
+func (fe *FakeEventsHandler) AssertResponseReceived(t *testing.T, chid datatransfer.ChannelID) datatransfer.Response {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	for i := len(fe.ReceivedResponses) - 1; i >= 0; i-- {
+		if fe.ReceivedResponses[i].ChannelID == chid {
+			return fe.ReceivedResponses[i].Response
+		}
+	}
+	require.FailNow(t, "response should be received")
+	return nil
+}
+
+// AssertChannelCompleted asserts that OnChannelCompleted was called for the
+// channel, and returns the completion error
+func (fe *FakeEventsHandler) AssertChannelCompleted(t *testing.T, chid datatransfer.ChannelID) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	return assertErrorEvent(t, fe.CompletedChannels, chid, "channel should be completed")
+}
+
+// AssertRequestCancelled asserts that OnRequestCancelled was called for the
+// channel, and returns the cancellation error
+func (fe *FakeEventsHandler) AssertRequestCancelled(t *testing.T, chid datatransfer.ChannelID) error {
+	fe.lk.Lock()
+	defer fe.lk.Unlock()
+	return assertErrorEvent(t, fe.CancelledRequests, chid, "request should be cancelled")
+}
+
+func hasDataEvent(events []DataEvent, chid datatransfer.ChannelID, link ipld.Link) bool {
+	for _, evt := range events {
+		if evt.ChannelID == chid && evt.Link.String() == link.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func assertErrorEvent(t *testing.T, events []ErrorEvent, chid datatransfer.ChannelID, msg string) error {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].ChannelID == chid {
+			return events[i].Err
+		}
+	}
+	require.FailNow(t, msg)
+	return nil
+}
@@ -0,0 +1,41 @@
+package testutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// FakeSigner signs messages from Self with a hash of the sender, recipient
+// and data, which FakeVerifier can check
+type FakeSigner struct {
+	Self peer.ID
+}
+
+// Sign signs the data for sending to the given peer
+func (fs FakeSigner) Sign(to peer.ID, data []byte) ([]byte, error) {
+	return fakeSignature(fs.Self, to, data), nil
+}
+
+// FakeVerifier verifies messages sent to Self that were signed by FakeSigner
+type FakeVerifier struct {
+	Self peer.ID
+}
+
+// Verify checks that the data was signed by the given peer for Self
+func (fv FakeVerifier) Verify(from peer.ID, data []byte, sig []byte) error {
+	if !bytes.Equal(sig, fakeSignature(from, fv.Self, data)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func fakeSignature(from peer.ID, to peer.ID, data []byte) []byte {
+	h := sha256.New()
+	_, _ = h.Write([]byte(from))
+	_, _ = h.Write([]byte(to))
+	_, _ = h.Write(data)
+	return h.Sum(nil)
+}
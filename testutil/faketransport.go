@@ -2,7 +2,9 @@ package testutil
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -44,6 +46,8 @@ type FakeTransport struct {
 	ResumeChannelErr    error
 	CleanedUpChannels   []datatransfer.ChannelID
 	CustomizedTransfers []CustomizedTransfer
+	EphemeralStores     map[datatransfer.ChannelID]*FakeEphemeralStore
+	UseEphemeralErr     error
 	EventHandler        datatransfer.EventsHandler
 	SetEventHandlerErr  error
 }
@@ -99,3 +103,42 @@ func (ft *FakeTransport) CleanupChannel(chid datatransfer.ChannelID) {
 func (ft *FakeTransport) RecordCustomizedTransfer(chid datatransfer.ChannelID, voucher datatransfer.TypedVoucher) {
 	ft.CustomizedTransfers = append(ft.CustomizedTransfers, CustomizedTransfer{chid, voucher})
 }
+
+// UseEphemeralStore records the channel and returns an empty fake store for it
+func (ft *FakeTransport) UseEphemeralStore(chid datatransfer.ChannelID) (datatransfer.EphemeralStore, error) {
+	if ft.UseEphemeralErr != nil {
+		return nil, ft.UseEphemeralErr
+	}
+	if ft.EphemeralStores == nil {
+		ft.EphemeralStores = make(map[datatransfer.ChannelID]*FakeEphemeralStore)
+	}
+	store := &FakeEphemeralStore{Blocks: make(map[cid.Cid][]byte)}
+	ft.EphemeralStores[chid] = store
+	return store, nil
+}
+
+// FakeEphemeralStore is an in-memory store of blocks returned by
+// FakeTransport.UseEphemeralStore
+type FakeEphemeralStore struct {
+	Blocks map[cid.Cid][]byte
+}
+
+// Has indicates whether the store holds the block with the given CID
+func (fes *FakeEphemeralStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	_, ok := fes.Blocks[c]
+	return ok, nil
+}
+
+// Get returns the raw data of the block with the given CID
+func (fes *FakeEphemeralStore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	data, ok := fes.Blocks[c]
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	return data, nil
+}
+
+// Len returns the number of blocks in the store
+func (fes *FakeEphemeralStore) Len() int {
+	return len(fes.Blocks)
+}
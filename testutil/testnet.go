@@ -2,6 +2,7 @@ package testutil
 
 import (
 	"context"
+	"sync"
 
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
@@ -22,21 +23,40 @@ type FakeNetwork struct {
 	PeerID       peer.ID
 	SentMessages []FakeSentMessage
 	Delegate     network.Receiver
+
+	lk           sync.Mutex
+	disconnected map[peer.ID]chan struct{}
 }
 
 // NewFakeNetwork returns a new fake data transfer network instance
 func NewFakeNetwork(id peer.ID) *FakeNetwork {
-	return &FakeNetwork{PeerID: id}
+	return &FakeNetwork{PeerID: id, disconnected: make(map[peer.ID]chan struct{})}
 }
 
 var _ network.DataTransferNetwork = (*FakeNetwork)(nil)
 
 // SendMessage sends a GraphSync message to a peer.
 func (fn *FakeNetwork) SendMessage(ctx context.Context, p peer.ID, m datatransfer.Message) error {
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
 	fn.SentMessages = append(fn.SentMessages, FakeSentMessage{p, m})
 	return nil
 }
 
+// SentMessageCount returns the number of messages sent so far. Use it rather
+// than reading SentMessages while messages may be sent concurrently
+func (fn *FakeNetwork) SentMessageCount() int {
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	return len(fn.SentMessages)
+}
+
+// SendQueueDepth is always zero, as the fake network sends messages
+// immediately
+func (fn *FakeNetwork) SendQueueDepth(p peer.ID) int {
+	return 0
+}
+
 // SetDelegate registers the Reciver to handle messages received from the
 // network.
 func (fn *FakeNetwork) SetDelegate(receiver network.Receiver) {
@@ -52,6 +72,51 @@ func (fn *FakeNetwork) ConnectWithRetry(ctx context.Context, p peer.ID) error {
 	panic("implement me")
 }
 
+// IsConnected reports every peer as connected, unless Disconnect was called
+// for the peer
+func (fn *FakeNetwork) IsConnected(p peer.ID) bool {
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	_, disconnected := fn.disconnected[p]
+	return !disconnected
+}
+
+// WaitForConnection waits until Connect is called for a disconnected peer
+func (fn *FakeNetwork) WaitForConnection(ctx context.Context, p peer.ID) error {
+	fn.lk.Lock()
+	reconnected, disconnected := fn.disconnected[p]
+	fn.lk.Unlock()
+	if !disconnected {
+		return nil
+	}
+
+	select {
+	case <-reconnected:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Disconnect marks the peer as disconnected
+func (fn *FakeNetwork) Disconnect(p peer.ID) {
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	if _, ok := fn.disconnected[p]; !ok {
+		fn.disconnected[p] = make(chan struct{})
+	}
+}
+
+// Connect marks a disconnected peer as connected again
+func (fn *FakeNetwork) Connect(p peer.ID) {
+	fn.lk.Lock()
+	defer fn.lk.Unlock()
+	if reconnected, ok := fn.disconnected[p]; ok {
+		close(reconnected)
+		delete(fn.disconnected, p)
+	}
+}
+
 // ID returns a stubbed id for host of this network
 func (fn *FakeNetwork) ID() peer.ID {
 	return fn.PeerID
@@ -69,3 +134,11 @@ func (fn *FakeNetwork) Unprotect(id peer.ID, tag string) bool {
 func (fn *FakeNetwork) Protocol(ctx context.Context, id peer.ID) (protocol.ID, error) {
 	return datatransfer.ProtocolDataTransfer1_2, nil
 }
+
+// Probe reports that every peer supports the latest protocol version
+func (fn *FakeNetwork) Probe(ctx context.Context, id peer.ID) (network.ProtocolInfo, error) {
+	return network.ProtocolInfo{
+		Protocol:  datatransfer.ProtocolDataTransfer1_2,
+		Protocols: []protocol.ID{datatransfer.ProtocolDataTransfer1_2},
+	}, nil
+}
@@ -0,0 +1,91 @@
+package testutil
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// TransferIDSequence is a TransferIDGenerator that allocates transfer IDs
+// counting up from a fixed seed, so that tests can work out the IDs of the
+// channels a manager will open. Pass it to the manager with the TransferIDs
+// option.
+type TransferIDSequence struct {
+	seed datatransfer.TransferID
+
+	lk      sync.Mutex
+	current datatransfer.TransferID
+}
+
+var _ datatransfer.TransferIDGenerator = (*TransferIDSequence)(nil)
+
+// NewTransferIDSequence returns a sequence whose first transfer ID is seed+1,
+// matching the generator returned by impl.NewTransferIDGenerator
+func NewTransferIDSequence(seed datatransfer.TransferID) *TransferIDSequence {
+	return &TransferIDSequence{seed: seed, current: seed}
+}
+
+// Next returns the next transfer ID in the sequence
+func (s *TransferIDSequence) Next() datatransfer.TransferID {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.current++
+	return s.current
+}
+
+// Current returns the last transfer ID returned by Next, or the seed if Next
+// has not been called
+func (s *TransferIDSequence) Current() datatransfer.TransferID {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	return s.current
+}
+
+// Nth returns the nth transfer ID the sequence allocates, counting from 1,
+// however many IDs have been allocated so far
+func (s *TransferIDSequence) Nth(n int) datatransfer.TransferID {
+	return s.seed + datatransfer.TransferID(n)
+}
+
+// Reset starts the sequence again from its seed, so that a test can replay
+// the transfer IDs it allocated
+func (s *TransferIDSequence) Reset() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.current = s.seed
+}
+
+// ChannelIDs builds the IDs of channels between two peers, initiated by
+// Initiator
+type ChannelIDs struct {
+	Initiator peer.ID
+	Responder peer.ID
+}
+
+// NewChannelIDs returns a builder for the IDs of channels the initiator opens
+// with the responder
+func NewChannelIDs(initiator peer.ID, responder peer.ID) ChannelIDs {
+	return ChannelIDs{Initiator: initiator, Responder: responder}
+}
+
+// ID returns the ID of the channel with the given transfer ID
+func (c ChannelIDs) ID(id datatransfer.TransferID) datatransfer.ChannelID {
+	return datatransfer.ChannelID{ID: id, Initiator: c.Initiator, Responder: c.Responder}
+}
+
+// Nth returns the ID of the channel that is given the nth transfer ID
+// allocated by the sequence, counting from 1
+func (c ChannelIDs) Nth(seq *TransferIDSequence, n int) datatransfer.ChannelID {
+	return c.ID(seq.Nth(n))
+}
+
+// Reversed returns a builder for the IDs of channels the responder opens with
+// the initiator
+func (c ChannelIDs) Reversed() ChannelIDs {
+	return ChannelIDs{Initiator: c.Responder, Responder: c.Initiator}
+}
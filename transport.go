@@ -3,6 +3,7 @@ package datatransfer
 import (
 	"context"
 
+	"github.com/ipfs/go-cid"
 	ipld "github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	peer "github.com/libp2p/go-libp2p/core/peer"
@@ -24,7 +25,9 @@ type EventsHandler interface {
 	// - nil = proceed with sending data
 	// - error = cancel this request
 	// - err == ErrPause - pause this request
-	OnDataReceived(chid ChannelID, link ipld.Link, size uint64, index int64, unique bool) error
+	// - message = data transfer message to send to the sender (eg an updated
+	//   voucher), regardless of the error
+	OnDataReceived(chid ChannelID, link ipld.Link, size uint64, index int64, unique bool) (Message, error)
 
 	// OnDataQueued is called when data is queued for sending for the given channel ID
 	// return values are:
@@ -39,6 +42,10 @@ type EventsHandler interface {
 	// OnTransferInitiated is called when the transport layer initiates transfer
 	OnTransferInitiated(chid ChannelID)
 
+	// OnTransferStarted is called once per channel, the first time a block
+	// is sent or received over the wire
+	OnTransferStarted(chid ChannelID)
+
 	// OnRequestReceived is called when we receive a new request to send data
 	// for the given channel ID
 	// return values are:
@@ -52,12 +59,23 @@ type EventsHandler interface {
 	// OnChannelCompleted is called when we finish transferring data for the given channel ID
 	// Error returns are logged but otherwise have no effect
 	OnChannelCompleted(chid ChannelID, err error) error
+	// OnChannelCompletedDetailed is called after OnChannelCompleted, with a
+	// description of how the transfer of data for the given channel ID ended
+	// Error returns are logged but otherwise have no effect
+	OnChannelCompletedDetailed(chid ChannelID, result CompletionResult) error
 
 	// OnRequestCancelled is called when a request we opened (with the given channel Id) to
-	// receive data is cancelled by us.
+	// receive data is cancelled by us, or when a request the remote peer opened is
+	// cancelled by that peer (in which case err wraps ErrRequestorCancelled).
 	// Error returns are logged but otherwise have no effect
 	OnRequestCancelled(chid ChannelID, err error) error
 
+	// OnChannelCancelled is called when a request we opened to receive data
+	// is cancelled at the graphsync level. Transports only fire this event if
+	// configured to do so.
+	// Error returns are logged but otherwise have no effect
+	OnChannelCancelled(chid ChannelID) error
+
 	// OnRequestDisconnected is called when a network error occurs trying to send a request
 	OnRequestDisconnected(chid ChannelID, err error) error
 
@@ -69,11 +87,114 @@ type EventsHandler interface {
 	// at the transport layer
 	OnReceiveDataError(chid ChannelID, err error) error
 
+	// OnStoreError is called when the store registered for a channel could
+	// not be used by the transport, so blocks may be read from or written to
+	// the wrong store.
+	// Error returns are logged but otherwise have no effect
+	OnStoreError(chid ChannelID, err error) error
+
+	// OnMalformedMessage is called when a peer sent a request whose data
+	// transfer message could not be decoded, so the request was rejected.
+	// extensions are the names of the data transfer extensions present on
+	// the request, to help diagnose version mismatches.
+	// Error returns are logged but otherwise have no effect
+	OnMalformedMessage(p peer.ID, extensions []string, err error) error
+
+	// OnChannelError is called when a channel was failed locally with the
+	// given reason (eg by an administrator), after the remote peer has been
+	// told the reason and the transport request has been cancelled
+	OnChannelError(chid ChannelID, reason error) error
+
 	// OnContextAugment allows the transport to attach data transfer tracing information
 	// to its local context, in order to create a hierarchical trace
 	OnContextAugment(chid ChannelID) func(context.Context) context.Context
 }
 
+// ContextEventsHandler is implemented by events handlers that want the data
+// events for a channel to carry the context associated with the channel (eg
+// to continue a trace started when the channel was opened). Transports that
+// support channel contexts call these methods in place of the corresponding
+// EventsHandler methods if the handler implements them. ctx is
+// context.Background() if no context was associated with the channel.
+type ContextEventsHandler interface {
+	// OnDataReceivedContext is OnDataReceived with the channel's context
+	OnDataReceivedContext(ctx context.Context, chid ChannelID, link ipld.Link, size uint64, index int64, unique bool) (Message, error)
+	// OnDataQueuedContext is OnDataQueued with the channel's context
+	OnDataQueuedContext(ctx context.Context, chid ChannelID, link ipld.Link, size uint64, index int64, unique bool) (Message, error)
+	// OnDataSentContext is OnDataSent with the channel's context
+	OnDataSentContext(ctx context.Context, chid ChannelID, link ipld.Link, size uint64, index int64, unique bool) error
+}
+
+// MultiResponseEventsHandler is implemented by events handlers that answer a
+// new request with more than one message, eg an acceptance and a separate
+// message advertising capacity, without waiting for another round trip.
+// Transports that support it call OnRequestReceivedMulti in place of
+// OnRequestReceived when a new request arrives, and deliver the messages to
+// the other peer in order.
+type MultiResponseEventsHandler interface {
+	// OnRequestReceivedMulti is OnRequestReceived, returning the messages to
+	// send in reply in the order they should be received. The error has the
+	// same meaning as for OnRequestReceived
+	OnRequestReceivedMulti(chid ChannelID, msg Request) ([]Response, error)
+}
+
+// CompletionKind describes how the transfer of data on a channel ended
+type CompletionKind int
+
+const (
+	// CompletionFull means all the data requested was transferred
+	CompletionFull CompletionKind = iota
+	// CompletionPartial means the transfer ended without error, but some of
+	// the data requested was missing
+	CompletionPartial
+	// CompletionFailed means the transfer ended with an error
+	CompletionFailed
+)
+
+// CompletionKinds are human readable names for completion kinds
+var CompletionKinds = map[CompletionKind]string{
+	CompletionFull:    "CompletionFull",
+	CompletionPartial: "CompletionPartial",
+	CompletionFailed:  "CompletionFailed",
+}
+
+// CompletionSide is the part the local node played in the transport request
+// whose completion is being reported
+type CompletionSide int
+
+const (
+	// CompletionSideRequestor means the local node made the request for data,
+	// so the completion is of receiving the data
+	CompletionSideRequestor CompletionSide = iota
+	// CompletionSideResponder means the local node responded to the request
+	// for data, so the completion is of sending the data
+	CompletionSideResponder
+)
+
+// CompletionSides are human readable names for completion sides
+var CompletionSides = map[CompletionSide]string{
+	CompletionSideRequestor: "CompletionSideRequestor",
+	CompletionSideResponder: "CompletionSideResponder",
+}
+
+// CompletionResult describes how the transfer of data on a channel ended
+type CompletionResult struct {
+	// Kind is how the transfer ended
+	Kind CompletionKind
+	// Side is whether the local node completed the transfer as the
+	// requestor (receiving the data) or the responder (sending the data).
+	// For a push transfer the requestor is the channel's responder.
+	Side CompletionSide
+	// BytesTransferred is the number of bytes of data that were sent or
+	// received on the channel by the transport
+	BytesTransferred uint64
+	// StatusCode is the final transport specific status code of the transfer,
+	// eg a graphsync.ResponseStatusCode for the graphsync transport
+	StatusCode int
+	// Err is the error passed to OnChannelCompleted, if any
+	Err error
+}
+
 /*
 Transport defines the interface for a transport layer for data
 transfer. Where the data transfer manager will coordinate setting up push and
@@ -128,3 +249,25 @@ type PauseableTransport interface {
 		chid ChannelID,
 	) error
 }
+
+// EphemeralStore is an in-memory store that holds the blocks received on a
+// channel, for transfers whose data does not need to be persisted
+type EphemeralStore interface {
+	// Has indicates whether the store holds the block with the given CID
+	Has(ctx context.Context, c cid.Cid) (bool, error)
+	// Get returns the raw data of the block with the given CID
+	Get(ctx context.Context, c cid.Cid) ([]byte, error)
+	// Len returns the number of blocks in the store
+	Len() int
+}
+
+// EphemeralTransport is a transport that can store the data received on a
+// channel in memory
+type EphemeralTransport interface {
+	Transport
+	// UseEphemeralStore stores the data received on the given channel in a new
+	// in-memory store. The transport drops its reference to the store when the
+	// channel is cleaned up, so the memory is released once the caller is done
+	// with the store.
+	UseEphemeralStore(chid ChannelID) (EphemeralStore, error)
+}
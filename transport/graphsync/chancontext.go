@@ -0,0 +1,82 @@
+package graphsync
+
+import (
+	"context"
+
+	ipld "github.com/ipld/go-ipld-prime"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// SetChannelContext associates a context with the given channel, eg one that
+// carries the trace span of the caller that opened the channel. If the events
+// handler implements ContextEventsHandler, the context is passed to it with
+// each data event on the channel. Only the values of the context are used:
+// cancelling it has no effect on the channel.
+func (t *Transport) SetChannelContext(chid datatransfer.ChannelID, ctx context.Context) {
+	ch := t.trackDTChannel(chid)
+	ch.setContext(ctx)
+}
+
+func (c *dtChannel) setContext(ctx context.Context) {
+	c.ctxLk.Lock()
+	defer c.ctxLk.Unlock()
+
+	c.ctx = ctx
+}
+
+// The context associated with the channel, or context.Background() if there
+// is none
+func (c *dtChannel) getContext() context.Context {
+	c.ctxLk.RLock()
+	defer c.ctxLk.RUnlock()
+
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// channelContext returns the context associated with the channel, if the
+// transport is tracking it, or context.Background()
+func (t *Transport) channelContext(chid datatransfer.ChannelID) context.Context {
+	t.dtChannelsLk.RLock()
+	ch, ok := t.dtChannels[chid]
+	t.dtChannelsLk.RUnlock()
+	if !ok {
+		return context.Background()
+	}
+	return ch.getContext()
+}
+
+var _ datatransfer.ContextEventsHandler = (*mirroredEvents)(nil)
+
+func (me *mirroredEvents) OnDataReceivedContext(ctx context.Context, chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	ce, ok := me.events.(datatransfer.ContextEventsHandler)
+	if !ok {
+		return me.OnDataReceived(chid, link, size, index, unique)
+	}
+	msg, err := ce.OnDataReceivedContext(ctx, chid, link, size, index, unique)
+	me.publish(TransportEvent{Code: DataReceivedEvent, ChannelID: chid, Link: link, Size: size, Index: index, Unique: unique})
+	return msg, err
+}
+
+func (me *mirroredEvents) OnDataQueuedContext(ctx context.Context, chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	ce, ok := me.events.(datatransfer.ContextEventsHandler)
+	if !ok {
+		return me.OnDataQueued(chid, link, size, index, unique)
+	}
+	msg, err := ce.OnDataQueuedContext(ctx, chid, link, size, index, unique)
+	me.publish(TransportEvent{Code: DataQueuedEvent, ChannelID: chid, Link: link, Size: size, Index: index, Unique: unique})
+	return msg, err
+}
+
+func (me *mirroredEvents) OnDataSentContext(ctx context.Context, chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) error {
+	ce, ok := me.events.(datatransfer.ContextEventsHandler)
+	if !ok {
+		return me.OnDataSent(chid, link, size, index, unique)
+	}
+	err := ce.OnDataSentContext(ctx, chid, link, size, index, unique)
+	me.publish(TransportEvent{Code: DataSentEvent, ChannelID: chid, Link: link, Size: size, Index: index, Unique: unique})
+	return err
+}
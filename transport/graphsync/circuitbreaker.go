@@ -0,0 +1,92 @@
+package graphsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// peerFailures tracks the consecutive failures of the transfers with a peer
+type peerFailures struct {
+	consecutive int
+	// openUntil is set when the circuit is opened, and is the time until
+	// which new channels to the peer are refused
+	openUntil time.Time
+}
+
+// circuitBreaker refuses new channels to peers whose transfers keep failing,
+// until a cooldown period has passed
+type circuitBreaker struct {
+	lk        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	peers     map[peer.ID]*peerFailures
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		peers:     make(map[peer.ID]*peerFailures),
+	}
+}
+
+// failure records a failed transfer with the peer, opening the circuit once
+// the number of consecutive failures reaches the threshold
+func (cb *circuitBreaker) failure(p peer.ID) {
+	if cb == nil {
+		return
+	}
+
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+
+	pf, ok := cb.peers[p]
+	if !ok {
+		pf = &peerFailures{}
+		cb.peers[p] = pf
+	}
+	pf.consecutive++
+	if pf.consecutive >= cb.threshold {
+		pf.openUntil = time.Now().Add(cb.cooldown)
+		pf.consecutive = 0
+	}
+}
+
+// success records a successful transfer with the peer, which resets its count
+// of consecutive failures
+func (cb *circuitBreaker) success(p peer.ID) {
+	if cb == nil {
+		return
+	}
+
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+
+	if pf, ok := cb.peers[p]; ok && time.Now().After(pf.openUntil) {
+		delete(cb.peers, p)
+	}
+}
+
+// isOpen returns true if new channels to the peer should be refused
+func (cb *circuitBreaker) isOpen(p peer.ID) bool {
+	if cb == nil {
+		return false
+	}
+
+	cb.lk.Lock()
+	defer cb.lk.Unlock()
+
+	pf, ok := cb.peers[p]
+	if !ok {
+		return false
+	}
+	if time.Now().Before(pf.openUntil) {
+		return true
+	}
+	if pf.consecutive == 0 {
+		delete(cb.peers, p)
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package graphsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	p := peer.ID("p")
+	other := peer.ID("other")
+
+	cb := newCircuitBreaker(3, 50*time.Millisecond)
+
+	// a success resets the count of consecutive failures
+	cb.failure(p)
+	cb.failure(p)
+	cb.success(p)
+	cb.failure(p)
+	cb.failure(p)
+	require.False(t, cb.isOpen(p))
+
+	// the circuit opens once the threshold is reached, only for that peer
+	cb.failure(p)
+	require.True(t, cb.isOpen(p))
+	require.False(t, cb.isOpen(other))
+
+	// a success while the circuit is open does not close it early
+	cb.success(p)
+	require.True(t, cb.isOpen(p))
+
+	// the circuit closes once the cooldown has passed
+	require.Eventually(t, func() bool {
+		return !cb.isOpen(p)
+	}, time.Second, 10*time.Millisecond)
+
+	// the circuit breaker is disabled when nil
+	var disabled *circuitBreaker
+	disabled.failure(p)
+	require.False(t, disabled.isOpen(p))
+}
@@ -0,0 +1,159 @@
+package graphsync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-graphsync"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// channelOutcome is closed when a channel reaches a terminal state
+type channelOutcome struct {
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newChannelOutcome() *channelOutcome {
+	return &channelOutcome{done: make(chan struct{})}
+}
+
+// set the outcome, unless it has already been set
+func (o *channelOutcome) set(err error) {
+	o.once.Do(func() {
+		o.err = err
+		close(o.done)
+	})
+}
+
+func (o *channelOutcome) isSet() bool {
+	select {
+	case <-o.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForCompletion blocks until the channel reaches a terminal state and
+// returns the error the transfer completed with, or nil if it completed
+// successfully. It returns as soon as the channel completes, whichever side's
+// completion is seen first, and returns immediately if the channel has
+// already completed.
+// If the channel is closed, failed or cleaned up before it completes, the
+// error wraps ErrChannelClosed (or is the reason the channel failed).
+// Returns ErrChannelNotFound if the transport is not tracking the channel.
+func (t *Transport) WaitForCompletion(ctx context.Context, chid datatransfer.ChannelID) error {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return err
+	}
+
+	outcome := ch.getOutcome()
+	select {
+	case <-outcome.done:
+		return outcome.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *dtChannel) getOutcome() *channelOutcome {
+	c.outcomeLk.Lock()
+	defer c.outcomeLk.Unlock()
+	return c.outcome
+}
+
+// setOutcome records that the channel reached a terminal state, waking any
+// callers waiting for it to complete. Only the first outcome is recorded.
+func (c *dtChannel) setOutcome(err error) {
+	c.getOutcome().set(err)
+}
+
+// resetOutcome is called when a new graphsync request is opened for the
+// channel, so that a channel that is restarted after it failed can be waited
+// on again
+func (c *dtChannel) resetOutcome() {
+	c.outcomeLk.Lock()
+	defer c.outcomeLk.Unlock()
+	if c.outcome.isSet() {
+		c.outcome = newChannelOutcome()
+	}
+}
+
+// observeResponseStatus publishes an event when the status of the responses
+// to the channel's graphsync request changes to a non-terminal status that
+// has an event (see publishResponseStatus)
+func (t *Transport) observeResponseStatus(chid datatransfer.ChannelID, status graphsync.ResponseStatusCode) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return
+	}
+
+	ch.responseStatusLk.Lock()
+	changed := ch.responseStatus != status
+	ch.responseStatus = status
+	ch.responseStatusLk.Unlock()
+
+	if changed {
+		t.publishResponseStatus(chid, status)
+	}
+}
+
+// publishResponseStatus publishes RequestPausedEvent or PartialResponseEvent
+// for the matching graphsync status. These statuses are not terminal, so
+// they are never reported to the events handler as a completion.
+func (t *Transport) publishResponseStatus(chid datatransfer.ChannelID, status graphsync.ResponseStatusCode) {
+	var code TransportEventCode
+	switch status {
+	case graphsync.RequestPaused:
+		code = RequestPausedEvent
+	case graphsync.PartialResponse:
+		code = PartialResponseEvent
+	default:
+		return
+	}
+	t.events.publish(TransportEvent{Code: code, ChannelID: chid})
+}
+
+// CompletionError is the error a channel completes with when its graphsync
+// request or response does not complete in full
+type CompletionError struct {
+	// StatusCode is the graphsync status code the request or response ended
+	// with
+	StatusCode graphsync.ResponseStatusCode
+	Err        error
+}
+
+func (e CompletionError) Error() string {
+	return e.Err.Error()
+}
+
+func (e CompletionError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable returns false if the status code the request ended with means
+// that retrying the transfer with the same peer would fail again (see
+// datatransfer.IsRetryable)
+func (e CompletionError) Retryable() bool {
+	return isRetryableStatus(e.StatusCode)
+}
+
+// isRetryableStatus classifies the status codes a graphsync request can end
+// with as retryable (the responder may succeed if asked again) or permanent
+func isRetryableStatus(status graphsync.ResponseStatusCode) bool {
+	switch status {
+	case graphsync.RequestFailedContentNotFound,
+		// the responder does not have some of the blocks
+		graphsync.RequestCompletedPartial,
+		graphsync.RequestFailedLegal,
+		graphsync.RequestRejected:
+		return false
+	default:
+		// busy, unknown failures and anything else
+		return true
+	}
+}
@@ -0,0 +1,76 @@
+package graphsync
+
+import (
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// transferDirection is the direction data flows on a channel, relative to the
+// peer that initiated it
+type transferDirection int
+
+const (
+	// directionUnknown means no graphsync request has been made or received
+	// on the channel yet
+	directionUnknown transferDirection = iota
+	// directionPull means the initiator receives the data
+	directionPull
+	// directionPush means the initiator sends the data
+	directionPush
+)
+
+// setDirection records whether the channel is a pull or a push. It is called
+// when the channel is opened, or when the first graphsync request is made or
+// received on the channel.
+func (c *dtChannel) setDirection(isPull bool) {
+	c.directionLk.Lock()
+	defer c.directionLk.Unlock()
+
+	if isPull {
+		c.direction = directionPull
+	} else {
+		c.direction = directionPush
+	}
+}
+
+func (c *dtChannel) getDirection() transferDirection {
+	c.directionLk.RLock()
+	defer c.directionLk.RUnlock()
+
+	return c.direction
+}
+
+// isSendingData returns true if the local node sends the data on the channel,
+// ie it responds to the graphsync request. Returns false if the direction is
+// not known yet.
+func (c *dtChannel) isSendingData() bool {
+	switch c.getDirection() {
+	case directionPull:
+		return c.channelID.Responder == c.t.peerID
+	case directionPush:
+		return c.channelID.Initiator == c.t.peerID
+	default:
+		return false
+	}
+}
+
+// IsPull returns true if the initiator of the channel receives the data, or
+// false if the initiator sends it. Returns ErrChannelNotReady if no graphsync
+// request has been made or received on the channel yet, so its direction is
+// not known.
+func (t *Transport) IsPull(chid datatransfer.ChannelID) (bool, error) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return false, err
+	}
+
+	switch ch.getDirection() {
+	case directionPull:
+		return true, nil
+	case directionPush:
+		return false, nil
+	default:
+		return false, xerrors.Errorf("%s: direction not known: %w", chid, datatransfer.ErrChannelNotReady)
+	}
+}
@@ -0,0 +1,82 @@
+package graphsync
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// ephemeralStore is an in-memory store for the blocks received on a channel.
+// graphsync writes blocks to the store while the caller may be reading from
+// it, so access to the underlying memstore is guarded by a lock.
+type ephemeralStore struct {
+	lk    sync.RWMutex
+	store memstore.Store
+}
+
+var _ datatransfer.EphemeralStore = (*ephemeralStore)(nil)
+
+// Has indicates whether the store holds the block with the given CID
+func (s *ephemeralStore) Has(ctx context.Context, c cid.Cid) (bool, error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return s.store.Has(ctx, cidlink.Link{Cid: c}.Binary())
+}
+
+// Get returns the raw data of the block with the given CID
+func (s *ephemeralStore) Get(ctx context.Context, c cid.Cid) ([]byte, error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return s.store.Get(ctx, cidlink.Link{Cid: c}.Binary())
+}
+
+// Len returns the number of blocks in the store
+func (s *ephemeralStore) Len() int {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return len(s.store.Bag)
+}
+
+// ephemeralStorage is the storage that graphsync reads blocks from and
+// writes blocks to for an ephemeral store
+type ephemeralStorage struct {
+	s *ephemeralStore
+}
+
+func (es ephemeralStorage) Has(ctx context.Context, key string) (bool, error) {
+	es.s.lk.RLock()
+	defer es.s.lk.RUnlock()
+	return es.s.store.Has(ctx, key)
+}
+
+func (es ephemeralStorage) Get(ctx context.Context, key string) ([]byte, error) {
+	es.s.lk.RLock()
+	defer es.s.lk.RUnlock()
+	return es.s.store.Get(ctx, key)
+}
+
+func (es ephemeralStorage) Put(ctx context.Context, key string, content []byte) error {
+	es.s.lk.Lock()
+	defer es.s.lk.Unlock()
+	return es.s.store.Put(ctx, key, content)
+}
+
+// UseEphemeralStore tells the graphsync transport to store the data for this
+// channelID in a new in-memory store (see UseStore). The store is
+// unregistered from graphsync when the channel is cleaned up, so the memory
+// is released once the caller is done with the store.
+func (t *Transport) UseEphemeralStore(channelID datatransfer.ChannelID) (datatransfer.EphemeralStore, error) {
+	store := &ephemeralStore{}
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.SetReadStorage(ephemeralStorage{store})
+	lsys.SetWriteStorage(ephemeralStorage{store})
+	if err := t.UseStore(channelID, lsys); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
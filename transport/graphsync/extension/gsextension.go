@@ -2,9 +2,12 @@ package extension
 
 import (
 	"errors"
+	"sort"
 
 	"github.com/ipfs/go-graphsync"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/ipld/go-ipld-prime/fluent/qp"
+	basicnode "github.com/ipld/go-ipld-prime/node/basic"
 	"github.com/libp2p/go-libp2p/core/protocol"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
@@ -18,6 +21,10 @@ const (
 	ExtensionOutgoingBlock1_1 = graphsync.ExtensionName("fil/data-transfer/outgoing-block/1.1")
 	// ExtensionDataTransfer1_1 is the identifier for the v1.1 data transfer extension to graphsync
 	ExtensionDataTransfer1_1 = graphsync.ExtensionName("fil/data-transfer/1.1")
+	// ExtensionAdditionalMessages1_1 is the identifier for a list of messages
+	// sent along with the message in one of the extensions above, eg when a
+	// request is answered with more than one message
+	ExtensionAdditionalMessages1_1 = graphsync.ExtensionName("fil/data-transfer/additional-messages/1.1")
 )
 
 // ProtocolMap maps graphsync extensions to their libp2p protocols
@@ -27,7 +34,7 @@ var ProtocolMap = map[graphsync.ExtensionName]protocol.ID{
 	ExtensionDataTransfer1_1:    datatransfer.ProtocolDataTransfer1_2,
 }
 
-// ToExtensionData converts a message to a graphsync extension
+// ToExtensionData converts a message to graphsync extensions, sorted by name
 func ToExtensionData(msg datatransfer.Message, supportedExtensions []graphsync.ExtensionName) ([]graphsync.ExtensionData, error) {
 	exts := make([]graphsync.ExtensionData, 0, len(supportedExtensions))
 	for _, supportedExtension := range supportedExtensions {
@@ -48,9 +55,18 @@ func ToExtensionData(msg datatransfer.Message, supportedExtensions []graphsync.E
 	if len(exts) == 0 {
 		return nil, errors.New("message not encodable in any supported extensions")
 	}
+	SortExtensions(exts)
 	return exts, nil
 }
 
+// SortExtensions sorts extensions by name, so that the same set of extensions
+// is always attached to a graphsync message in the same order
+func SortExtensions(exts []graphsync.ExtensionData) {
+	sort.SliceStable(exts, func(i, j int) bool {
+		return exts[i].Name < exts[j].Name
+	})
+}
+
 // GsExtended is a small interface used by GetTransferData
 type GsExtended interface {
 	Extension(name graphsync.ExtensionName) (datamodel.Node, bool)
@@ -78,3 +94,52 @@ var decoders = map[graphsync.ExtensionName]decoder{
 	ExtensionOutgoingBlock1_1:   message.FromIPLD,
 	ExtensionDataTransfer1_1:    message.FromIPLD,
 }
+
+// ToAdditionalMessagesExtensionData converts a list of messages to a single
+// graphsync extension, preserving their order
+func ToAdditionalMessagesExtensionData(msgs []datatransfer.Message) (graphsync.ExtensionData, error) {
+	nds := make([]datamodel.Node, 0, len(msgs))
+	for _, msg := range msgs {
+		versionedMsg, err := msg.MessageForProtocol(datatransfer.ProtocolDataTransfer1_2)
+		if err != nil {
+			return graphsync.ExtensionData{}, err
+		}
+		nds = append(nds, versionedMsg.ToIPLD())
+	}
+	nd, err := qp.BuildList(basicnode.Prototype.List, int64(len(nds)), func(la datamodel.ListAssembler) {
+		for _, nd := range nds {
+			qp.ListEntry(la, qp.Node(nd))
+		}
+	})
+	if err != nil {
+		return graphsync.ExtensionData{}, err
+	}
+	return graphsync.ExtensionData{Name: ExtensionAdditionalMessages1_1, Data: nd}, nil
+}
+
+// GetAdditionalMessages unmarshals the list of messages in the
+// ExtensionAdditionalMessages1_1 extension, in the order they were sent.
+// Returns nil + nil if the extension is not found
+func GetAdditionalMessages(extendedData GsExtended) ([]datatransfer.Message, error) {
+	data, ok := extendedData.Extension(ExtensionAdditionalMessages1_1)
+	if !ok {
+		return nil, nil
+	}
+	msgs := make([]datatransfer.Message, 0, data.Length())
+	it := data.ListIterator()
+	if it == nil {
+		return nil, errors.New("additional messages extension is not a list")
+	}
+	for !it.Done() {
+		_, nd, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		msg, err := message.FromIPLD(nd)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
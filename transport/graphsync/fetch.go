@@ -0,0 +1,99 @@
+package graphsync
+
+import (
+	"context"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/datamodel"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// The number of progress events buffered for a fetch. Once the buffer is full,
+// progress events are dropped until the caller catches up, but the last event
+// is always delivered.
+const fetchBufferSize = 16
+
+// FetchEvent reports the progress of a fetch started with Fetch
+type FetchEvent struct {
+	// Link and Size describe the block that was received
+	Link ipld.Link
+	Size uint64
+	// Received is the total size of the blocks received so far
+	Received uint64
+
+	// Done is set on the last event of the fetch, after which the Go channel
+	// is closed
+	Done bool
+	// Err is set on the last event if the fetch failed
+	Err error
+}
+
+// Fetch opens a channel to pull the data under root that matches the selector
+// from dataSender, and returns a Go channel that receives an event as each
+// block arrives and a last event with Done set once the transfer has ended.
+// It is a convenience for one-off pulls that don't need the full events
+// handler, which must still be set on the transport.
+//
+// Progress events are dropped if the caller falls behind, so the caller
+// should use Received rather than summing the sizes of the blocks. The caller
+// must read from the Go channel until it is closed. If ctx is cancelled before
+// the transfer ends, the channel is closed and the last event reports
+// ctx.Err().
+func (t *Transport) Fetch(
+	ctx context.Context,
+	dataSender peer.ID,
+	chid datatransfer.ChannelID,
+	root ipld.Link,
+	stor datamodel.Node,
+	msg datatransfer.Message,
+) (<-chan FetchEvent, error) {
+	// Subscribe before opening the channel so that no events are missed
+	sub, unsubscribe := t.Subscribe()
+	if err := t.OpenChannel(ctx, dataSender, chid, root, stor, nil, msg); err != nil {
+		unsubscribe()
+		return nil, err
+	}
+
+	out := make(chan FetchEvent, fetchBufferSize)
+	go t.watchFetch(ctx, chid, sub, unsubscribe, out)
+	return out, nil
+}
+
+// watchFetch translates the transport events for the fetch's channel into
+// fetch events, until the transfer ends
+func (t *Transport) watchFetch(ctx context.Context, chid datatransfer.ChannelID, sub <-chan TransportEvent, unsubscribe func(), out chan<- FetchEvent) {
+	defer close(out)
+	defer unsubscribe()
+
+	var received uint64
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.CloseChannel(context.Background(), chid); err != nil {
+				t.log.Debugf("%s: closing channel after fetch was cancelled: %s", chid, err)
+			}
+			out <- FetchEvent{Received: received, Done: true, Err: ctx.Err()}
+			return
+		case evt, ok := <-sub:
+			if !ok {
+				return
+			}
+			if evt.ChannelID != chid {
+				continue
+			}
+			switch evt.Code {
+			case DataReceivedEvent:
+				received += evt.Size
+				select {
+				case out <- FetchEvent{Link: evt.Link, Size: evt.Size, Received: received}:
+				default:
+				}
+			case ChannelCompletedEvent, RequestCancelledEvent, RequestDisconnectedEvent, ChannelErrorEvent:
+				out <- FetchEvent{Received: received, Done: true, Err: evt.Err}
+				return
+			}
+		}
+	}
+}
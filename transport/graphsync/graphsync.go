@@ -4,30 +4,51 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/cidset"
 	"github.com/ipfs/go-graphsync/donotsendfirstblocks"
 	logging "github.com/ipfs/go-log/v2"
 	ipld "github.com/ipld/go-ipld-prime"
 	"github.com/ipld/go-ipld-prime/datamodel"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipld/go-ipld-prime/node/basicnode"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/xerrors"
 
 	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/go-data-transfer/v2/message"
+	"github.com/filecoin-project/go-data-transfer/v2/message/types"
 	"github.com/filecoin-project/go-data-transfer/v2/transport/graphsync/extension"
 )
 
 var log = logging.Logger("dt_graphsync")
 
+// Logger is the minimal logging interface used by the graphsync transport
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
 // When restarting a data transfer, we cancel the existing graphsync request
 // before opening a new one.
 // This constant defines the maximum time to wait for the request to be
 // cancelled.
 const maxGSCancelWait = time.Second
 
+// The prefix of the names that channel stores are registered with graphsync
+// under, by default
+const defaultPersistencePrefix = "data-transfer-"
+
 var defaultSupportedExtensions = []graphsync.ExtensionName{
 	extension.ExtensionDataTransfer1_1,
 }
@@ -52,6 +73,254 @@ func SupportedExtensions(supportedExtensions []graphsync.ExtensionName) Option {
 	}
 }
 
+// ExtraExtensions sets additional graphsync extensions that are attached to
+// every outgoing graphsync request alongside the data transfer extensions.
+// OpenChannel fails if an extra extension has the same name as one of the
+// data transfer extensions
+func ExtraExtensions(extraExtensions []graphsync.ExtensionData) Option {
+	return func(t *Transport) {
+		t.extraExtensions = extraExtensions
+	}
+}
+
+// AutoPauseRestart sets whether a restarted channel whose transfer has not
+// yet started (eg because the responder was still unsealing the data when the
+// channel was paused) is started in the paused state when the request for
+// data is received again. The responder is then expected to resume the
+// channel once the data is ready.
+// Defaults to true. Responders whose data is always immediately available can
+// set it to false so that restarts resume sending data straight away, saving
+// a round trip. A responder that is slow to make data ready should leave it
+// enabled, otherwise a restart may start sending before the data is ready.
+func AutoPauseRestart(autoPauseRestart bool) Option {
+	return func(t *Transport) {
+		t.autoPauseRestart = autoPauseRestart
+	}
+}
+
+// FireChannelCancelled sets whether the transport fires OnChannelCancelled
+// when a graphsync request it opened ends with a graphsync.RequestCancelledErr.
+// Note that graphsync reports this error both when the request is cancelled
+// by the remote peer and in some cases where it was cancelled locally, so the
+// event does not indicate which side cancelled.
+// Defaults to false, in which case the error is only logged
+func FireChannelCancelled(fireChannelCancelled bool) Option {
+	return func(t *Transport) {
+		t.fireChannelCancelled = fireChannelCancelled
+	}
+}
+
+// CleanupStoreOnError sets whether the transport unregisters a channel's
+// store (see UseStore) once OnChannelCompleted has fired with an error, so
+// that the store is not leaked if the channel is never cleaned up.
+// Defaults to true. Disable it if failed channels may be restarted, as a
+// restarted request will not use the channel's store once it is unregistered.
+func CleanupStoreOnError(cleanupStoreOnError bool) Option {
+	return func(t *Transport) {
+		t.cleanupStoreOnError = cleanupStoreOnError
+	}
+}
+
+// SequenceMessages sets whether the transport assigns an increasing sequence
+// number to each data transfer message it sends on a channel, so that the
+// receiving transport can drop messages that arrive out of order.
+// Defaults to false, as peers running versions that predate message sequence
+// numbers cannot decode sequenced messages. Received messages that have a
+// sequence number are checked regardless of this setting.
+func SequenceMessages(sequenceMessages bool) Option {
+	return func(t *Transport) {
+		t.sequenceMessages = sequenceMessages
+	}
+}
+
+// PersistencePrefix sets the prefix of the names that channel stores (see
+// UseStore) are registered with graphsync under. The name of a channel's
+// store is the prefix followed by the channel ID.
+// Defaults to "data-transfer-". Set it to namespace the stores when a
+// graphsync instance is shared with other subsystems.
+func PersistencePrefix(prefix string) Option {
+	return func(t *Transport) {
+		t.persistencePrefix = prefix
+	}
+}
+
+// RecordChannelHistory enables recording the last events the transport
+// reports for each channel (see ChannelHistory), keeping up to eventsPerChannel
+// events for each of the last maxChannels channels. Histories are kept after
+// a channel is cleaned up so that a failed transfer can be inspected.
+// Recording is disabled by default
+func RecordChannelHistory(eventsPerChannel int, maxChannels int) Option {
+	return func(t *Transport) {
+		if eventsPerChannel > 0 && maxChannels > 0 {
+			t.history = newChannelHistory(eventsPerChannel, maxChannels)
+		}
+	}
+}
+
+// PeerCircuitBreaker makes OpenChannel fail fast with ErrPeerCircuitOpen
+// for cooldown once threshold consecutive transfers with a peer have failed.
+// Each channel that completes with an error counts as one failure, and a
+// channel that completes successfully resets the count. Cancelled channels
+// are not counted. Disabled by default
+func PeerCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(t *Transport) {
+		if threshold > 0 {
+			t.circuitBreaker = newCircuitBreaker(threshold, cooldown)
+		}
+	}
+}
+
+// RetainExtensions sets the names of graphsync extensions whose data the
+// transport keeps from the last request, update or response received on each
+// channel, so that it can be read with LastExtension
+func RetainExtensions(names ...graphsync.ExtensionName) Option {
+	return func(t *Transport) {
+		t.retainedExtensions = names
+	}
+}
+
+// AsyncRequestValidation sets whether an incoming request for data (a pull)
+// is passed to OnRequestReceived in the background rather than in the
+// graphsync hook, so that slow validation does not hold up graphsync's
+// processing of other requests. The response is paused until validation
+// completes, then resumed, or cancelled if the request is rejected.
+// As graphsync selects the store for a response when the hook returns, a
+// channel that uses its own store must call UseStore before the request
+// arrives rather than during validation.
+// Defaults to false
+func AsyncRequestValidation(asyncRequestValidation bool) Option {
+	return func(t *Transport) {
+		t.asyncRequestValidation = asyncRequestValidation
+	}
+}
+
+// TransientResponseErrors sets a predicate that classifies the errors
+// returned by OnResponseReceived. An error for which isTransient returns true
+// is logged and the transfer continues; any other error terminates the
+// graphsync request. By default all errors terminate the request
+func TransientResponseErrors(isTransient func(chid datatransfer.ChannelID, err error) bool) Option {
+	return func(t *Transport) {
+		t.isTransientResponseErr = isTransient
+	}
+}
+
+// WithLogger sets the logger that the transport writes its logs to.
+// Defaults to the go-log "dt_graphsync" logger
+func WithLogger(logger Logger) Option {
+	return func(t *Transport) {
+		t.log = logger
+	}
+}
+
+// LoadCallback sets a function that is called with the transport load (see
+// TransportLoad) at the given interval, eg to export it as metrics.
+// The callback is called until the transport is shut down
+func LoadCallback(interval time.Duration, callback func(LoadStats)) Option {
+	return func(t *Transport) {
+		t.loadCallbackInterval = interval
+		t.loadCallback = callback
+	}
+}
+
+// ThroughputCallback sets a function that is called with the throughput of
+// each channel (see Throughput) at the given interval, eg to show transfer
+// speed in a progress UI.
+// The callback is called until the transport is shut down
+func ThroughputCallback(interval time.Duration, callback func(map[datatransfer.ChannelID]float64)) Option {
+	return func(t *Transport) {
+		t.throughputCallbackInterval = interval
+		t.throughputCallback = callback
+	}
+}
+
+// BlockInspector sets a function that is called with the data of each block
+// received on a channel, eg to scan content for policy violations. If the
+// function returns an error, the channel's graphsync request is terminated
+// with that error.
+// The block data is read from the channel's store (see UseStore), or from lsys
+// for channels that don't have their own store, so lsys should be the link
+// system graphsync was created with.
+// Note that graphsync stores each block before the transport sees it, so a
+// rejected block remains in the store
+func BlockInspector(lsys ipld.LinkSystem, inspector func(chid datatransfer.ChannelID, c cid.Cid, data []byte) error) Option {
+	return func(t *Transport) {
+		t.blockInspectorLsys = lsys
+		t.blockInspector = inspector
+	}
+}
+
+// StallTimeout cancels a channel's graphsync request if data has been sent or
+// received on the channel but then no block crosses the wire for the given
+// duration, eg because the connection to the remote peer is half-open. The
+// events handler's OnRequestDisconnected is called with an error that wraps
+// ErrChannelStalled. Time that the channel spends paused does not count
+// towards the timeout.
+// By default stalled channels are not detected.
+func StallTimeout(d time.Duration) Option {
+	return func(t *Transport) {
+		t.stallTimeout = d
+	}
+}
+
+// DisableResponderHooks stops the transport from registering the graphsync
+// hooks used to respond to requests for data (the incoming request, outgoing
+// block, block sent, request updated, completed response, requestor
+// cancelled and network error hooks), for nodes that only request data.
+// Requests for data from other peers are then left to graphsync's default
+// handling, so the node does not serve data-transfer channels.
+func DisableResponderHooks() Option {
+	return func(t *Transport) {
+		t.disableResponderHooks = true
+	}
+}
+
+// DisableRequestorHooks stops the transport from registering the graphsync
+// hooks used to request data (the outgoing request, incoming response,
+// incoming block and receiver network error hooks), for nodes that only
+// respond to requests for data. OpenChannel then fails with ErrUnsupported.
+func DisableRequestorHooks() Option {
+	return func(t *Transport) {
+		t.disableRequestorHooks = true
+	}
+}
+
+// MaxPendingExtensionBytes limits the size of the messages queued to be sent
+// to the requester of a channel when it restarts a request it cancelled (see
+// PendingExtensions), per channel and in total across all channels. Once a
+// limit is reached, resuming a channel with a message to queue fails with
+// ErrPendingExtensionsLimit. Set a limit to zero to disable it.
+// By default the queued messages are not limited.
+func MaxPendingExtensionBytes(perChannel uint64, total uint64) Option {
+	return func(t *Transport) {
+		t.maxPendingExtBytesPerChannel = perChannel
+		t.maxPendingExtBytesTotal = total
+	}
+}
+
+// MaxRegisteredStores limits the number of stores registered with graphsync,
+// counting the store of each channel (see UseStore) and each named store (see
+// RegisterNamedStore). Once the limit is reached, registering a store
+// unregisters the store of a channel that has completed, if there is one, and
+// otherwise fails with ErrStoreLimit. Set the limit to zero to disable it.
+// By default the number of stores is not limited.
+func MaxRegisteredStores(max int) Option {
+	return func(t *Transport) {
+		t.maxStores = max
+	}
+}
+
+// MessageSigning signs the data transfer messages the transport sends in
+// graphsync extensions with signer, and rejects messages received in graphsync
+// extensions that are not signed or whose signature verifier does not accept.
+// Either may be nil to sign without verifying, or vice versa. By default
+// messages are neither signed nor verified.
+func MessageSigning(signer datatransfer.MessageSigner, verifier datatransfer.MessageVerifier) Option {
+	return func(t *Transport) {
+		t.signer = signer
+		t.verifier = verifier
+	}
+}
+
 // RegisterCompletedRequestListener is used by the tests
 func RegisterCompletedRequestListener(l func(channelID datatransfer.ChannelID)) Option {
 	return func(t *Transport) {
@@ -66,17 +335,45 @@ func RegisterCompletedResponseListener(l func(channelID datatransfer.ChannelID))
 	}
 }
 
+// RegisterRequestReceivedListener is used by the tests to listen for when the
+// transport has processed an incoming graphsync request
+func RegisterRequestReceivedListener(l func(channelID datatransfer.ChannelID)) Option {
+	return func(t *Transport) {
+		t.requestReceivedListener = l
+	}
+}
+
+// RegisterOutgoingRequestListener is used by the tests to listen for when
+// graphsync has opened an outgoing request for a channel
+func RegisterOutgoingRequestListener(l func(channelID datatransfer.ChannelID)) Option {
+	return func(t *Transport) {
+		t.outgoingRequestListener = l
+	}
+}
+
 // Transport manages graphsync hooks for data transfer, translating from
 // graphsync hooks to semantic data transfer events
 type Transport struct {
-	events datatransfer.EventsHandler
+	events *mirroredEvents
 	gs     graphsync.GraphExchange
 	peerID peer.ID
+	log    Logger
 
 	supportedExtensions       []graphsync.ExtensionName
-	unregisterFuncs           []graphsync.UnregisterHookFunc
+	extraExtensions           []graphsync.ExtensionData
+	autoPauseRestart          bool
+	fireChannelCancelled      bool
+	cleanupStoreOnError       bool
+	sequenceMessages          bool
+	persistencePrefix         string
+	retainedExtensions        []graphsync.ExtensionName
+	asyncRequestValidation    bool
+	isTransientResponseErr    func(chid datatransfer.ChannelID, err error) bool
+	unregisterFuncs           []namedUnregisterFunc
 	completedRequestListener  func(channelID datatransfer.ChannelID)
 	completedResponseListener func(channelID datatransfer.ChannelID)
+	requestReceivedListener   func(channelID datatransfer.ChannelID)
+	outgoingRequestListener   func(channelID datatransfer.ChannelID)
 
 	// Map from data transfer channel ID to information about that channel
 	dtChannelsLk sync.RWMutex
@@ -85,6 +382,72 @@ type Transport struct {
 	// Used in graphsync callbacks to map from graphsync request to the
 	// associated data-transfer channel ID.
 	requestIDToChannelID *requestIDToChannelIDMap
+
+	// Running totals of bytes sent to / received from each peer over the wire
+	peerStats *peerStatsMap
+
+	// Channels that are currently paused at the transport
+	pausedChannels *channelIDSet
+
+	// Go channels that transport events are mirrored to
+	subscribers *subscribers
+
+	// Set to 1 by Shutdown, after which channels cannot be opened, paused or
+	// resumed
+	closed int32
+
+	// The last events reported for each channel, if recording is enabled
+	history *channelHistory
+
+	// Refuses new channels to peers whose transfers keep failing, if enabled
+	circuitBreaker *circuitBreaker
+
+	// Weights used to share sending bandwidth between channels to a peer
+	channelWeights *channelWeights
+
+	// Counts of the graphsync requests issued to each peer
+	requestLoad          *requestLoad
+	loadCallbackInterval time.Duration
+	loadCallback         func(LoadStats)
+	// stopLoadReports is closed on shutdown to stop the load and throughput
+	// callbacks
+	stopLoadReports     chan struct{}
+	stopLoadReportsOnce sync.Once
+
+	throughputCallbackInterval time.Duration
+	throughputCallback         func(map[datatransfer.ChannelID]float64)
+
+	// Called with the data of each block received, if set
+	blockInspector     func(chid datatransfer.ChannelID, c cid.Cid, data []byte) error
+	blockInspectorLsys ipld.LinkSystem
+
+	// Sign outgoing and verify incoming messages, if set
+	signer   datatransfer.MessageSigner
+	verifier datatransfer.MessageVerifier
+
+	// Cancel channels that transfer no data for this long, if set
+	stallTimeout time.Duration
+
+	// Graphsync hooks that are not registered, for nodes that only play one
+	// role in transfers
+	disableResponderHooks bool
+	disableRequestorHooks bool
+
+	// Limits on the size of the messages queued for requesters that
+	// cancelled, and the total size queued
+	maxPendingExtBytesPerChannel uint64
+	maxPendingExtBytesTotal      uint64
+	pendingExtLk                 sync.Mutex
+	pendingExtBytes              uint64
+
+	// Stores registered with graphsync once and shared by channels
+	namedStoresLk sync.RWMutex
+	namedStores   map[string]ipld.LinkSystem
+
+	// The number of stores registered with graphsync, and the limit on it
+	storesLk   sync.Mutex
+	storeCount int
+	maxStores  int
 }
 
 // NewTransport makes a new hooks manager with the given hook events interface
@@ -92,13 +455,30 @@ func NewTransport(peerID peer.ID, gs graphsync.GraphExchange, options ...Option)
 	t := &Transport{
 		gs:                   gs,
 		peerID:               peerID,
+		log:                  log,
 		supportedExtensions:  defaultSupportedExtensions,
+		autoPauseRestart:     true,
+		cleanupStoreOnError:  true,
+		persistencePrefix:    defaultPersistencePrefix,
 		dtChannels:           make(map[datatransfer.ChannelID]*dtChannel),
 		requestIDToChannelID: newRequestIDToChannelIDMap(),
-	}
+		peerStats:            newPeerStatsMap(),
+		pausedChannels:       newChannelIDSet(),
+		subscribers:          newSubscribers(),
+		requestLoad:          newRequestLoad(),
+		stopLoadReports:      make(chan struct{}),
+		namedStores:          make(map[string]ipld.LinkSystem),
+	}
+	t.channelWeights = newChannelWeights(t.resumeWeightedChannel)
 	for _, option := range options {
 		option(t)
 	}
+	if t.loadCallback != nil {
+		go t.reportLoad()
+	}
+	if t.throughputCallback != nil {
+		go t.reportThroughput()
+	}
 	return t
 }
 
@@ -107,6 +487,8 @@ func NewTransport(peerID peer.ID, gs graphsync.GraphExchange, options ...Option)
 // Note: from a data transfer symantic standpoint, it doesn't matter if the
 // request is push or pull -- OpenChannel is called by the party that is
 // intending to receive data
+// If ctx is cancelled while the request is in progress, the request is
+// cancelled and OnChannelCompleted fires with an error wrapping ctx.Err()
 func (t *Transport) OpenChannel(
 	ctx context.Context,
 	dataSender peer.ID,
@@ -120,24 +502,46 @@ func (t *Transport) OpenChannel(
 		return datatransfer.ErrHandlerNotSet
 	}
 
-	exts, err := extension.ToExtensionData(msg, t.supportedExtensions)
+	if err := t.checkOpen(); err != nil {
+		return xerrors.Errorf("%s: opening channel: %w", channelID, err)
+	}
+
+	if t.disableRequestorHooks {
+		return xerrors.Errorf("%s: requestor hooks are disabled: %w", channelID, datatransfer.ErrUnsupported)
+	}
+
+	if t.circuitBreaker.isOpen(dataSender) {
+		return xerrors.Errorf("%s: peer %s: %w", channelID, dataSender, datatransfer.ErrPeerCircuitOpen)
+	}
+
+	exts, err := t.toExtensionData(channelID, msg, t.supportedExtensionsFor(channelID))
 	if err != nil {
 		return err
 	}
 	// If this is a restart request, the client can indicate the blocks that
 	// it has already received, so that the provider knows not to resend
 	// those blocks
-	restartExts, err := t.getRestartExtension(ctx, dataSender, channel)
+	restartExts, err := t.getRestartExtension(ctx, dataSender, channelID, channel)
 	if err != nil {
 		return err
 	}
 	exts = append(exts, restartExts...)
 
-	// Start tracking the data-transfer channel
+	// Add any application specific extensions
+	exts, err = mergeExtensions(exts, t.applicationExtensionsFor(channelID))
+	if err != nil {
+		return err
+	}
+
+	// Start tracking the data-transfer channel. The local node requests the
+	// data, so the channel is a pull if the local node initiated it
 	ch := t.trackDTChannel(channelID)
+	ch.setDirection(channelID.Initiator == t.peerID)
 
 	// Open a graphsync request to the remote peer
+	t.requestLoad.opening(dataSender)
 	req, err := ch.open(ctx, channelID, dataSender, root, stor, channel, exts)
+	t.requestLoad.opened(dataSender, err == nil)
 	if err != nil {
 		return err
 	}
@@ -150,11 +554,63 @@ func (t *Transport) OpenChannel(
 
 // Get the extension data for sending a Restart message, depending on the
 // protocol version of the peer
-func (t *Transport) getRestartExtension(ctx context.Context, p peer.ID, channel datatransfer.ChannelState) ([]graphsync.ExtensionData, error) {
+func (t *Transport) getRestartExtension(ctx context.Context, p peer.ID, chid datatransfer.ChannelID, channel datatransfer.ChannelState) ([]graphsync.ExtensionData, error) {
 	if channel == nil {
 		return nil, nil
 	}
-	return getDoNotSendFirstBlocksExtension(channel)
+	exts, err := getDoNotSendFirstBlocksExtension(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the caller supplied the CIDs not to send, or the transport has been
+	// tracking the CIDs received on this channel, tell the provider not to
+	// send any of those CIDs either
+	t.dtChannelsLk.RLock()
+	ch, ok := t.dtChannels[chid]
+	t.dtChannelsLk.RUnlock()
+	if ok {
+		if encoded := ch.encodedDoNotSendCids(); encoded != nil {
+			exts = append(exts, graphsync.ExtensionData{
+				Name: graphsync.ExtensionDoNotSendCIDs,
+				Data: encoded,
+			})
+		} else if doNotSendCids := ch.receivedCidSet(); doNotSendCids != nil && doNotSendCids.Len() > 0 {
+			exts = append(exts, graphsync.ExtensionData{
+				Name: graphsync.ExtensionDoNotSendCIDs,
+				Data: cidset.EncodeCidSet(doNotSendCids),
+			})
+		}
+	}
+	return exts, nil
+}
+
+// EncodeDoNotSendCids encodes the CIDs read from the given channel into the
+// format used by the graphsync DoNotSendCIDs extension, without first
+// collecting them into a set. It stops when the channel is closed, or returns
+// an error if the context is cancelled first.
+func EncodeDoNotSendCids(ctx context.Context, cids <-chan cid.Cid) (datamodel.Node, error) {
+	nb := basicnode.Prototype.List.NewBuilder()
+	la, err := nb.BeginList(-1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case c, ok := <-cids:
+			if !ok {
+				if err := la.Finish(); err != nil {
+					return nil, err
+				}
+				return nb.Build(), nil
+			}
+			if err := la.AssembleValue().AssignLink(cidlink.Link{Cid: c}); err != nil {
+				return nil, xerrors.Errorf("encoding cid %s: %w", c, err)
+			}
+		}
+	}
 }
 
 // Skip the first N blocks because they were already received
@@ -167,19 +623,67 @@ func getDoNotSendFirstBlocksExtension(channel datatransfer.ChannelState) ([]grap
 	}}, nil
 }
 
+// Append the extra extensions to the data transfer extensions, returning an
+// error if any of the names collide. The merged extensions are sorted by name.
+func mergeExtensions(exts []graphsync.ExtensionData, extra []graphsync.ExtensionData) ([]graphsync.ExtensionData, error) {
+	names := make(map[graphsync.ExtensionName]struct{}, len(exts)+len(extra))
+	for _, ext := range exts {
+		names[ext.Name] = struct{}{}
+	}
+	for _, ext := range extra {
+		if _, ok := names[ext.Name]; ok {
+			return nil, xerrors.Errorf("extension %s conflicts with an existing extension on the request", ext.Name)
+		}
+		names[ext.Name] = struct{}{}
+		exts = append(exts, ext)
+	}
+	extension.SortExtensions(exts)
+	return exts, nil
+}
+
 // Read from the graphsync response and error channels until they are closed,
 // and return the last error on the error channel
 func (t *Transport) consumeResponses(req *gsReq) error {
-	var lastError error
-	for range req.responseChan {
+	// If a progress handler was registered for the channel, call it for each
+	// response so that the consumer can pause or abort the request
+	var handler ProgressHandler
+	if ch, err := t.getDTChannel(req.channelID); err == nil {
+		handler = ch.getProgressHandler()
 	}
-	log.Debugf("channel %s: finished consuming graphsync response channel", req.channelID)
 
+	var abortErr error
+	for progress := range req.responseChan {
+		if handler == nil || abortErr != nil {
+			continue
+		}
+		err := handler(progress)
+		switch {
+		case err == nil:
+		case err == datatransfer.ErrPause:
+			if err := t.PauseChannel(context.TODO(), req.channelID); err != nil {
+				t.log.Warnf("channel %s: pausing graphsync request from progress handler: %s", req.channelID, err)
+			}
+		default:
+			// Cancel the request, and keep consuming responses until
+			// graphsync closes the channel
+			abortErr = err
+			t.log.Infof("channel %s: progress handler aborted graphsync request: %s", req.channelID, err)
+			if err := t.CloseChannel(context.TODO(), req.channelID); err != nil {
+				t.log.Warnf("channel %s: cancelling graphsync request from progress handler: %s", req.channelID, err)
+			}
+		}
+	}
+	t.log.Debugf("channel %s: finished consuming graphsync response channel", req.channelID)
+
+	var lastError error
 	for err := range req.errChan {
 		lastError = err
 	}
-	log.Debugf("channel %s: finished consuming graphsync error channel", req.channelID)
+	t.log.Debugf("channel %s: finished consuming graphsync error channel", req.channelID)
 
+	if abortErr != nil {
+		return abortErr
+	}
 	return lastError
 }
 
@@ -188,39 +692,56 @@ func (t *Transport) consumeResponses(req *gsReq) error {
 func (t *Transport) executeGsRequest(req *gsReq) {
 	// Make sure to call the onComplete callback before returning
 	defer func() {
-		log.Infow("gs request complete for channel", "chid", req.channelID)
+		t.log.Infof("channel %s: gs request complete", req.channelID)
+		t.requestLoad.completed(req.channelID.OtherParty(t.peerID))
 		req.onComplete()
 	}()
 
 	// Consume the response and error channels for the graphsync request
 	lastError := t.consumeResponses(req)
 
+	// Request cancelled because the context it was opened with was
+	// cancelled. This is a terminal state for the channel, so it is reported
+	// as a completion with the context error.
+	if _, ok := lastError.(graphsync.RequestClientCancelledErr); ok && req.ctx.Err() != nil {
+		t.log.Warnf("channel %s: graphsync request context cancelled: %s", req.channelID, req.ctx.Err())
+		lastError = req.ctx.Err()
+	}
+
 	// Request cancelled by client
 	if _, ok := lastError.(graphsync.RequestClientCancelledErr); ok {
 		terr := xerrors.Errorf("graphsync request cancelled")
-		log.Warnf("channel %s: %s", req.channelID, terr)
+		t.log.Warnf("channel %s: %s", req.channelID, terr)
 		if err := t.events.OnRequestCancelled(req.channelID, terr); err != nil {
-			log.Error(err)
+			t.log.Errorf("channel %s: processing OnRequestCancelled: %s", req.channelID, err)
 		}
 		return
 	}
 
 	// Request cancelled by responder
 	if _, ok := lastError.(graphsync.RequestCancelledErr); ok {
-		log.Infof("channel %s: graphsync request cancelled by responder", req.channelID)
-		// TODO Should we do anything for RequestCancelledErr ?
+		t.log.Infof("channel %s: graphsync request cancelled", req.channelID)
+		if t.fireChannelCancelled {
+			if err := t.events.OnChannelCancelled(req.channelID); err != nil {
+				t.log.Errorf("channel %s: processing OnChannelCancelled: %s", req.channelID, err)
+			}
+		}
 		return
 	}
 
 	if lastError != nil {
-		log.Warnf("channel %s: graphsync error: %s", req.channelID, lastError)
+		t.log.Warnf("channel %s: graphsync error: %s", req.channelID, lastError)
 	}
 
-	log.Debugf("channel %s: finished executing graphsync request", req.channelID)
+	t.log.Debugf("channel %s: finished executing graphsync request", req.channelID)
 
+	status := gsErrorStatusCode(lastError)
 	var completeErr error
 	if lastError != nil {
-		completeErr = xerrors.Errorf("channel %s: graphsync request failed to complete: %w", req.channelID, lastError)
+		completeErr = CompletionError{
+			StatusCode: status,
+			Err:        xerrors.Errorf("channel %s: graphsync request failed to complete: %w", req.channelID, lastError),
+		}
 	}
 
 	// Used by the tests to listen for when a request completes
@@ -228,19 +749,62 @@ func (t *Transport) executeGsRequest(req *gsReq) {
 		t.completedRequestListener(req.channelID)
 	}
 
-	err := t.events.OnChannelCompleted(req.channelID, completeErr)
-	if err != nil {
-		log.Errorf("channel %s: processing OnChannelCompleted: %s", req.channelID, err)
+	t.recordOutcome(req.channelID.OtherParty(t.peerID), completeErr)
+
+	t.fireChannelCompleted(req.channelID, completeErr, status, datatransfer.CompletionSideRequestor)
+
+	if completeErr != nil {
+		t.releaseStore(req.channelID)
 	}
 }
 
 // PauseChannel pauses the given data-transfer channel
 func (t *Transport) PauseChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	if err := t.checkOpen(); err != nil {
+		return xerrors.Errorf("%s: pausing channel: %w", chid, err)
+	}
 	ch, err := t.getDTChannel(chid)
 	if err != nil {
 		return err
 	}
-	return ch.pause(ctx)
+	err = ch.pause(ctx)
+	if err != nil {
+		return err
+	}
+	t.pausedChannels.add(chid)
+	return nil
+}
+
+// TryPauseChannel pauses the given data-transfer channel if its graphsync
+// request has been opened. Otherwise it returns ErrChannelNotReady instead of
+// waiting for the request to open
+func (t *Transport) TryPauseChannel(ctx context.Context, chid datatransfer.ChannelID) error {
+	if err := t.checkChannelReady(chid); err != nil {
+		return err
+	}
+	return t.PauseChannel(ctx, chid)
+}
+
+// TryResumeChannel resumes the given data-transfer channel if its graphsync
+// request has been opened. Otherwise it returns ErrChannelNotReady instead of
+// waiting for the request to open
+func (t *Transport) TryResumeChannel(ctx context.Context, msg datatransfer.Message, chid datatransfer.ChannelID) error {
+	if err := t.checkChannelReady(chid); err != nil {
+		return err
+	}
+	return t.ResumeChannel(ctx, msg, chid)
+}
+
+// checkChannelReady returns ErrChannelNotReady if the channel is being tracked
+// but doesn't have an associated graphsync request yet
+func (t *Transport) checkChannelReady(chid datatransfer.ChannelID) error {
+	if _, err := t.getDTChannel(chid); err != nil {
+		return err
+	}
+	if !t.requestIDToChannelID.hasChannel(chid) {
+		return xerrors.Errorf("channel %s: %w", chid, datatransfer.ErrChannelNotReady)
+	}
+	return nil
 }
 
 // ResumeChannel resumes the given data-transfer channel and sends the message
@@ -250,11 +814,20 @@ func (t *Transport) ResumeChannel(
 	msg datatransfer.Message,
 	chid datatransfer.ChannelID,
 ) error {
+	if err := t.checkOpen(); err != nil {
+		return xerrors.Errorf("%s: resuming channel: %w", chid, err)
+	}
 	ch, err := t.getDTChannel(chid)
 	if err != nil {
 		return err
 	}
-	return ch.resume(ctx, msg)
+	err = ch.resume(ctx, msg)
+	if err != nil {
+		return err
+	}
+	ch.stallResumed()
+	t.pausedChannels.remove(chid)
+	return nil
 }
 
 // CloseChannel closes the given data-transfer channel
@@ -264,6 +837,7 @@ func (t *Transport) CloseChannel(ctx context.Context, chid datatransfer.ChannelI
 		return err
 	}
 
+	ch.setOutcome(xerrors.Errorf("%s: %w", chid, datatransfer.ErrChannelClosed))
 	err = ch.close(ctx)
 	if err != nil {
 		return xerrors.Errorf("closing channel: %w", err)
@@ -271,6 +845,99 @@ func (t *Transport) CloseChannel(ctx context.Context, chid datatransfer.ChannelI
 	return nil
 }
 
+// CloseChannelAfterDrain stops queuing new blocks on the given data-transfer
+// channel and closes it once the blocks that were already queued have been
+// sent, or once the timeout expires, whichever comes first
+func (t *Transport) CloseChannelAfterDrain(ctx context.Context, chid datatransfer.ChannelID, timeout time.Duration) error {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch.drain():
+	case <-timer.C:
+		t.log.Infof("%s: timed out waiting for queued blocks to be sent, closing channel", chid)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.CloseChannel(ctx, chid)
+}
+
+// FailChannel terminates the given data-transfer channel with the given
+// reason. The reason is sent to the remote peer in a cancel message before
+// the graphsync request is cancelled, and OnChannelError is fired with the
+// reason.
+func (t *Transport) FailChannel(ctx context.Context, chid datatransfer.ChannelID, reason error) error {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return err
+	}
+
+	var msg datatransfer.Message
+	if chid.Initiator == t.peerID {
+		msg = message.CancelRequest(chid.ID)
+	} else {
+		msg = message.CancelResponse(chid.ID)
+	}
+	msg, err = message.WithReason(msg, reason.Error())
+	if err != nil {
+		return err
+	}
+
+	ch.setOutcome(reason)
+	closeErr := ch.closeWithMessage(ctx, msg)
+
+	if err := t.events.OnChannelError(chid, reason); err != nil {
+		t.log.Errorf("%s: processing OnChannelError: %s", chid, err)
+	}
+
+	if closeErr != nil {
+		return xerrors.Errorf("failing channel: %w", closeErr)
+	}
+	return nil
+}
+
+// RejectResponse rejects the graphsync request that the local node is
+// responding to on the given channel. A response rejecting the request, with
+// the given reason, is sent to the requester before the graphsync response is
+// cancelled, so that the requester knows not to retry with the local node and
+// can fail over to another peer. Unlike FailChannel, no events are fired.
+// Returns ErrChannelNotReady if the local node is not responding to a
+// graphsync request on the channel.
+func (t *Transport) RejectResponse(ctx context.Context, chid datatransfer.ChannelID, reason error) error {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return err
+	}
+
+	ch.lk.RLock()
+	requestID := ch.requestID
+	ch.lk.RUnlock()
+	if requestID == nil || !ch.isSendingData() {
+		return xerrors.Errorf("%s: not responding to a graphsync request: %w", chid, datatransfer.ErrChannelNotReady)
+	}
+
+	msg, err := message.ValidationResultResponse(types.VoucherResultMessage, chid.ID, datatransfer.ValidationResult{Accepted: false}, nil, false)
+	if err != nil {
+		return err
+	}
+	reasonMsg, err := message.WithReason(msg, reason.Error())
+	if err != nil {
+		return err
+	}
+
+	ch.setOutcome(reason)
+	if err := ch.closeWithMessage(ctx, reasonMsg); err != nil {
+		return xerrors.Errorf("rejecting response: %w", err)
+	}
+	return nil
+}
+
 // CleanupChannel is called on the otherside of a cancel - removes any associated
 // data for the channel
 func (t *Transport) CleanupChannel(chid datatransfer.ChannelID) {
@@ -284,8 +951,12 @@ func (t *Transport) CleanupChannel(chid datatransfer.ChannelID) {
 
 	t.dtChannelsLk.Unlock()
 
+	t.pausedChannels.remove(chid)
+	t.channelWeights.remove(chid.OtherParty(t.peerID), chid)
+
 	// Clean up the channel
 	if ok {
+		ch.setOutcome(xerrors.Errorf("%s: cleaned up: %w", chid, datatransfer.ErrChannelClosed))
 		ch.cleanup()
 	}
 }
@@ -295,28 +966,107 @@ func (t *Transport) SetEventHandler(events datatransfer.EventsHandler) error {
 	if t.events != nil {
 		return datatransfer.ErrHandlerAlreadySet
 	}
-	t.events = events
-
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterIncomingRequestProcessingListener(t.gsRequestProcessingListener))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterOutgoingRequestProcessingListener(t.gsRequestProcessingListener))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterIncomingRequestHook(t.gsReqRecdHook))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterCompletedResponseListener(t.gsCompletedResponseListener))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterIncomingBlockHook(t.gsIncomingBlockHook))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterOutgoingBlockHook(t.gsOutgoingBlockHook))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterBlockSentListener(t.gsBlockSentHook))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterOutgoingRequestHook(t.gsOutgoingRequestHook))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterIncomingResponseHook(t.gsIncomingResponseHook))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterRequestUpdatedHook(t.gsRequestUpdatedHook))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterRequestorCancelledListener(t.gsRequestorCancelledListener))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterNetworkErrorListener(t.gsNetworkSendErrorListener))
-	t.unregisterFuncs = append(t.unregisterFuncs, t.gs.RegisterReceiverNetworkErrorListener(t.gsNetworkReceiveErrorListener))
+	// A transport with neither set of hooks would never see a transfer
+	if t.disableResponderHooks && t.disableRequestorHooks {
+		return xerrors.Errorf("cannot disable both requestor and responder hooks: %w", datatransfer.ErrUnsupported)
+	}
+	t.events = &mirroredEvents{events: events, subs: t.subscribers, history: t.history}
+
+	hooks := []struct {
+		name      string
+		requestor bool
+		register  func() graphsync.UnregisterHookFunc
+	}{
+		{"incoming request processing listener", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterIncomingRequestProcessingListener(t.gsRequestProcessingListener)
+		}},
+		{"outgoing request processing listener", true, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterOutgoingRequestProcessingListener(t.gsRequestProcessingListener)
+		}},
+		{"incoming request hook", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterIncomingRequestHook(t.gsReqRecdHook)
+		}},
+		{"completed response listener", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterCompletedResponseListener(t.gsCompletedResponseListener)
+		}},
+		{"incoming block hook", true, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterIncomingBlockHook(t.gsIncomingBlockHook)
+		}},
+		{"outgoing block hook", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterOutgoingBlockHook(t.gsOutgoingBlockHook)
+		}},
+		{"block sent listener", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterBlockSentListener(t.gsBlockSentHook)
+		}},
+		{"outgoing request hook", true, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterOutgoingRequestHook(t.gsOutgoingRequestHook)
+		}},
+		{"incoming response hook", true, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterIncomingResponseHook(t.gsIncomingResponseHook)
+		}},
+		{"request updated hook", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterRequestUpdatedHook(t.gsRequestUpdatedHook)
+		}},
+		{"requestor cancelled listener", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterRequestorCancelledListener(t.gsRequestorCancelledListener)
+		}},
+		{"network error listener", false, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterNetworkErrorListener(t.gsNetworkSendErrorListener)
+		}},
+		{"receiver network error listener", true, func() graphsync.UnregisterHookFunc {
+			return t.gs.RegisterReceiverNetworkErrorListener(t.gsNetworkReceiveErrorListener)
+		}},
+	}
+
+	// Register the hooks for the roles the transport plays with graphsync.
+	// If any hook fails to register, unregister the hooks registered so far
+	// so that the transport is not left partially wired up.
+	unregisterFuncs := make([]namedUnregisterFunc, 0, len(hooks))
+	for _, hook := range hooks {
+		if (hook.requestor && t.disableRequestorHooks) || (!hook.requestor && t.disableResponderHooks) {
+			continue
+		}
+		unregister := hook.register()
+		if unregister == nil {
+			for _, unregisterFunc := range unregisterFuncs {
+				unregisterFunc.unregister()
+			}
+			t.events = nil
+			return xerrors.Errorf("registering graphsync %s: %w", hook.name, datatransfer.ErrHookRegistrationFailed)
+		}
+		unregisterFuncs = append(unregisterFuncs, namedUnregisterFunc{name: hook.name, unregister: unregister})
+	}
+	t.unregisterFuncs = append(t.unregisterFuncs, unregisterFuncs...)
 	return nil
 }
 
-// Shutdown disconnects a transport interface from graphsync
+// namedUnregisterFunc unregisters a graphsync hook, and names the hook so
+// that it can be identified in errors
+type namedUnregisterFunc struct {
+	name       string
+	unregister graphsync.UnregisterHookFunc
+}
+
+// Shutdown disconnects a transport interface from graphsync.
+// If any hook has no unregister func, indicating that the transport was only
+// partially wired up to graphsync, Shutdown still shuts down all channels and
+// then returns an error listing the hooks, unless shutting down the channels
+// fails.
+// Once Shutdown has been called, OpenChannel, PauseChannel and ResumeChannel
+// return ErrTransportClosed.
 func (t *Transport) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&t.closed, 1)
+	t.stopLoadReportsOnce.Do(func() {
+		close(t.stopLoadReports)
+	})
+
+	var unregistered []string
 	for _, unregisterFunc := range t.unregisterFuncs {
-		unregisterFunc()
+		if unregisterFunc.unregister == nil {
+			unregistered = append(unregistered, unregisterFunc.name)
+			continue
+		}
+		unregisterFunc.unregister()
 	}
 
 	t.dtChannelsLk.Lock()
@@ -334,13 +1084,298 @@ func (t *Transport) Shutdown(ctx context.Context) error {
 	if err != nil {
 		return xerrors.Errorf("shutting down graphsync transport: %w", err)
 	}
+	if len(unregistered) > 0 {
+		return xerrors.Errorf("shutting down graphsync transport: no unregister func for graphsync %s: %w",
+			strings.Join(unregistered, ", "), datatransfer.ErrHookRegistrationFailed)
+	}
 	return nil
 }
 
-// UseStore tells the graphsync transport to use the given loader and storer for this channelID
-func (t *Transport) UseStore(channelID datatransfer.ChannelID, lsys ipld.LinkSystem) error {
-	ch := t.trackDTChannel(channelID)
-	return ch.useStore(lsys)
+// checkOpen returns ErrTransportClosed if the transport has been shut down
+func (t *Transport) checkOpen() error {
+	if atomic.LoadInt32(&t.closed) == 1 {
+		return datatransfer.ErrTransportClosed
+	}
+	return nil
+}
+
+// Reset cancels the graphsync requests for all channels and clears all
+// channel state, including the stores registered with graphsync.
+// Unlike Shutdown, the graphsync hooks stay registered so the transport can
+// go on to be used for new channels.
+// Reset must not be called while graphsync may fire hooks for existing
+// channels, as a hook could track a channel again as it is being cleared:
+// stop opening new channels and let in-progress requests finish first.
+func (t *Transport) Reset(ctx context.Context) error {
+	t.dtChannelsLk.Lock()
+	channels := t.dtChannels
+	t.dtChannels = make(map[datatransfer.ChannelID]*dtChannel)
+	t.dtChannelsLk.Unlock()
+
+	var eg errgroup.Group
+	for _, ch := range channels {
+		ch := ch
+		eg.Go(func() error {
+			return ch.shutdown(ctx)
+		})
+	}
+	err := eg.Wait()
+
+	for _, ch := range channels {
+		ch.cleanup()
+	}
+	t.requestIDToChannelID.clear()
+	t.pausedChannels.clear()
+	t.channelWeights.clear()
+	t.history.clear()
+
+	if err != nil {
+		return xerrors.Errorf("resetting graphsync transport: %w", err)
+	}
+	return nil
+}
+
+// RemoteStatus is the remote peer's view of the progress of a channel
+type RemoteStatus struct {
+	// BytesTransferred is the number of bytes the remote peer has sent or
+	// received on the channel
+	BytesTransferred uint64
+	// Paused is true if the channel is paused at the remote peer's transport
+	Paused bool
+}
+
+// RequestStatus asks the remote peer for its view of the progress of the
+// channel, and waits for the answer until the context is cancelled. It can be
+// used to reconcile the state of the two sides, eg after a network partition.
+// The remote peer's transport answers the request without involving its
+// events handler.
+func (t *Transport) RequestStatus(ctx context.Context, chid datatransfer.ChannelID) (RemoteStatus, error) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return RemoteStatus{}, err
+	}
+
+	statusCh, err := ch.requestStatus(ctx)
+	if err != nil {
+		return RemoteStatus{}, err
+	}
+	defer ch.stopAwaitingStatus(statusCh)
+
+	select {
+	case resp := <-statusCh:
+		return RemoteStatus{BytesTransferred: resp.BytesTransferred(), Paused: resp.IsPaused()}, nil
+	case <-ctx.Done():
+		return RemoteStatus{}, ctx.Err()
+	}
+}
+
+// LastExtension returns the data of the named graphsync extension from the
+// last request, update or response received on the channel that had the
+// extension. Only the extensions named with the RetainExtensions option are
+// kept.
+func (t *Transport) LastExtension(chid datatransfer.ChannelID, name graphsync.ExtensionName) (datamodel.Node, bool) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return nil, false
+	}
+	return ch.lastExtension(name)
+}
+
+// Keep the data of the retained extensions present in the received request,
+// update or response
+func (t *Transport) retainExtensions(chid datatransfer.ChannelID, gsMsg extension.GsExtended) {
+	var ch *dtChannel
+	for _, name := range t.retainedExtensions {
+		data, ok := gsMsg.Extension(name)
+		if !ok {
+			continue
+		}
+		if ch == nil {
+			ch = t.trackDTChannel(chid)
+		}
+		ch.setLastExtension(name, data)
+	}
+}
+
+// UseStore tells the graphsync transport to use the given loader and storer for this channelID
+func (t *Transport) UseStore(channelID datatransfer.ChannelID, lsys ipld.LinkSystem) error {
+	ch := t.trackDTChannel(channelID)
+	return ch.useStore(lsys)
+}
+
+// RegisterNamedStore registers the given loader and storer with graphsync
+// under the given name, once, so that channels can share it (see
+// UseNamedStore) rather than each channel registering and unregistering a
+// store of its own. Returns an error if a store is already registered with
+// the name.
+func (t *Transport) RegisterNamedStore(name string, lsys ipld.LinkSystem) error {
+	t.namedStoresLk.Lock()
+	defer t.namedStoresLk.Unlock()
+
+	if _, ok := t.namedStores[name]; ok {
+		return xerrors.Errorf("a store is already registered with name %s", name)
+	}
+	if err := t.reserveStore(nil); err != nil {
+		return xerrors.Errorf("registering store %s: %w", name, err)
+	}
+	if err := t.gs.RegisterPersistenceOption(t.namedStoreOption(name), lsys); err != nil {
+		t.releaseStoreCount()
+		return xerrors.Errorf("registering store %s: %w", name, err)
+	}
+	t.namedStores[name] = lsys
+	return nil
+}
+
+// UseNamedStore tells the graphsync transport to use the store registered
+// with RegisterNamedStore under the given name for this channelID, in place
+// of a store of the channel's own (see UseStore)
+func (t *Transport) UseNamedStore(channelID datatransfer.ChannelID, name string) error {
+	t.namedStoresLk.RLock()
+	lsys, ok := t.namedStores[name]
+	t.namedStoresLk.RUnlock()
+	if !ok {
+		return xerrors.Errorf("%s: no store registered with name %s", channelID, name)
+	}
+
+	ch := t.trackDTChannel(channelID)
+	ch.useNamedStore(name, lsys)
+	return nil
+}
+
+// The name that a named store is registered with graphsync under
+func (t *Transport) namedStoreOption(name string) string {
+	return t.persistencePrefix + "store-" + name
+}
+
+// UseExtensions tells the graphsync transport to use the given data transfer
+// extensions for messages sent on this channelID, in place of the extensions
+// the transport was configured with (see SupportedExtensions)
+func (t *Transport) UseExtensions(channelID datatransfer.ChannelID, exts []graphsync.ExtensionName) {
+	ch := t.trackDTChannel(channelID)
+	ch.setSupportedExtensions(exts)
+}
+
+// TrackReceivedCids tells the graphsync transport to keep a record of the CIDs
+// received on this channelID. The record is used to avoid receiving the same
+// blocks again if the channel is restarted, and can be read with ReceivedCids.
+func (t *Transport) TrackReceivedCids(channelID datatransfer.ChannelID) {
+	ch := t.trackDTChannel(channelID)
+	ch.trackReceivedCids()
+}
+
+// UseDoNotSendCids tells the graphsync transport to send the given encoded set
+// of CIDs (see EncodeDoNotSendCids) in the DoNotSendCIDs extension when the
+// channel is restarted, in place of any CIDs tracked with TrackReceivedCids.
+func (t *Transport) UseDoNotSendCids(channelID datatransfer.ChannelID, doNotSendCids datamodel.Node) {
+	ch := t.trackDTChannel(channelID)
+	ch.setDoNotSendCids(doNotSendCids)
+}
+
+// ProgressHandler is called with each response received on a graphsync
+// request for data. It can return datatransfer.ErrPause to pause the request,
+// or any other error to cancel it
+type ProgressHandler func(graphsync.ResponseProgress) error
+
+// UseProgressHandler tells the graphsync transport to call the given handler
+// for each response received on the graphsync request for this channel, so
+// that the consumer can apply backpressure on data it is receiving.
+// If the handler returns an error other than ErrPause, the request is
+// cancelled and the channel completes with that error.
+func (t *Transport) UseProgressHandler(channelID datatransfer.ChannelID, handler ProgressHandler) {
+	ch := t.trackDTChannel(channelID)
+	ch.setProgressHandler(handler)
+}
+
+// SetChannelWeight sets the share of the bandwidth to the other peer that
+// this channel gets when sending data, relative to the other weighted
+// channels to the same peer. For example a channel with weight 3 sends three
+// times as much data as a channel with weight 1, while both are sending.
+// A channel that gets too far ahead has its response paused until the other
+// channels catch up. Channels that have no weight are not throttled. A weight of zero removes
+// the channel's weight. The weight can be changed while the channel is
+// sending data.
+func (t *Transport) SetChannelWeight(chid datatransfer.ChannelID, weight uint64) {
+	t.channelWeights.set(chid.OtherParty(t.peerID), chid, weight)
+}
+
+// resumeWeightedChannel resumes a response that was paused because its
+// channel got too far ahead of the other weighted channels to the peer
+func (t *Transport) resumeWeightedChannel(chid datatransfer.ChannelID) {
+	// leave the response paused if it has since been paused for another
+	// reason
+	if t.pausedChannels.has(chid) {
+		return
+	}
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return
+	}
+	ch.resumeThrottled()
+}
+
+// ReceivedCids returns the CIDs received so far on the given channel. It
+// returns an error if TrackReceivedCids was not called for the channel.
+func (t *Transport) ReceivedCids(chid datatransfer.ChannelID) ([]cid.Cid, error) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return nil, err
+	}
+	cids := ch.receivedCidSet()
+	if cids == nil {
+		return nil, xerrors.Errorf("channel %s: received CIDs are not being tracked", chid)
+	}
+	return cids.Keys(), nil
+}
+
+// PendingExtensions returns the extension data queued to be sent to the
+// requester of a channel when it restarts a request it cancelled
+func (t *Transport) PendingExtensions(chid datatransfer.ChannelID) ([]graphsync.ExtensionData, error) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return nil, err
+	}
+	return ch.getPendingExtensions(), nil
+}
+
+// ClearPendingExtensions discards the extension data queued to be sent to the
+// requester of a channel when it restarts a request it cancelled
+func (t *Transport) ClearPendingExtensions(chid datatransfer.ChannelID) error {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return err
+	}
+	ch.clearPendingExtensions()
+	return nil
+}
+
+// SetChannelMetadata associates application-defined key/value metadata with
+// a channel (eg a trace ID), replacing any metadata set before. The metadata
+// is kept by the transport until the channel is cleaned up, is returned by
+// ChannelMetadata and ChannelsForPeer, and is never sent to the remote peer.
+func (t *Transport) SetChannelMetadata(chid datatransfer.ChannelID, metadata map[string]string) {
+	ch := t.trackDTChannel(chid)
+	ch.setMetadata(metadata)
+}
+
+// ChannelMetadata returns the metadata set for a channel with SetChannelMetadata
+func (t *Transport) ChannelMetadata(chid datatransfer.ChannelID) (map[string]string, error) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return nil, err
+	}
+	return ch.getMetadata(), nil
+}
+
+// SetStickyExtensions sets graphsync extensions that are attached to every
+// graphsync request opened for the channel, including the requests opened
+// when the channel is restarted, replacing any sticky extensions set before.
+// A sticky extension takes precedence over an extra extension (see
+// ExtraExtensions) with the same name. As with extra extensions, OpenChannel
+// fails if a sticky extension has the same name as one of the data transfer
+// extensions for the request.
+func (t *Transport) SetStickyExtensions(chid datatransfer.ChannelID, exts []graphsync.ExtensionData) {
+	ch := t.trackDTChannel(chid)
+	ch.setStickyExtensions(exts)
 }
 
 // ChannelGraphsyncRequests describes any graphsync request IDs associated with a given channel
@@ -351,6 +1386,8 @@ type ChannelGraphsyncRequests struct {
 	// has been restarted. We may be interested to know if these IDs are active
 	// on either side of the request
 	Previous []graphsync.RequestID
+	// Metadata is the metadata set for the channel with SetChannelMetadata
+	Metadata map[string]string
 }
 
 // ChannelsForPeer describes current active channels for a given peer and their
@@ -395,6 +1432,9 @@ func (t *Transport) ChannelsForPeer(p peer.ID) ChannelsForPeer {
 				// and it has not been cleaned up yet
 				channelGraphsyncRequests.Previous = append(channelGraphsyncRequests.Previous, requestID)
 			}
+			if ch := t.dtChannels[chid]; ch != nil {
+				channelGraphsyncRequests.Metadata = ch.getMetadata()
+			}
 			collection[chid] = channelGraphsyncRequests
 		}
 	})
@@ -404,6 +1444,106 @@ func (t *Transport) ChannelsForPeer(p peer.ID) ChannelsForPeer {
 	}
 }
 
+// PausedChannels returns the IDs of channels that are currently paused at the
+// transport, whether the local node is the requestor or the responder
+func (t *Transport) PausedChannels() []datatransfer.ChannelID {
+	return t.pausedChannels.list()
+}
+
+// PausePeer pauses every channel with the given peer that is not already
+// paused at the transport, returning an error for each channel that could not
+// be paused. The channels are paused one at a time, so a channel opened with
+// the peer while PausePeer runs may not be paused.
+func (t *Transport) PausePeer(ctx context.Context, p peer.ID) []error {
+	chids := t.FindChannels(func(chid datatransfer.ChannelID) bool {
+		return (chid.Initiator == p || chid.Responder == p) && !t.pausedChannels.has(chid)
+	})
+
+	var errs []error
+	for _, chid := range chids {
+		if err := t.PauseChannel(ctx, chid); err != nil {
+			errs = append(errs, xerrors.Errorf("pausing channel %s: %w", chid, err))
+		}
+	}
+	return errs
+}
+
+// ResumePeer resumes every channel with the given peer that is paused at the
+// transport, returning an error for each channel that could not be resumed.
+// If msgFor is not nil, it is called for each channel to get the message to
+// send to the peer with the resume, as messages carry the channel's transfer
+// ID. A nil message resumes the channel without sending a message.
+func (t *Transport) ResumePeer(ctx context.Context, p peer.ID, msgFor func(datatransfer.ChannelID) datatransfer.Message) []error {
+	var errs []error
+	for _, chid := range t.pausedChannels.list() {
+		if chid.Initiator != p && chid.Responder != p {
+			continue
+		}
+
+		var msg datatransfer.Message
+		if msgFor != nil {
+			msg = msgFor(chid)
+		}
+		if err := t.ResumeChannel(ctx, msg, chid); err != nil {
+			errs = append(errs, xerrors.Errorf("resuming channel %s: %w", chid, err))
+		}
+	}
+	return errs
+}
+
+// FindChannels returns the IDs of all channels tracked by the transport that
+// match the given predicate. The predicate is called on a snapshot of the
+// channel IDs, outside of any lock, so it is safe for it to be slow or to
+// call back into the transport.
+func (t *Transport) FindChannels(predicate func(datatransfer.ChannelID) bool) []datatransfer.ChannelID {
+	t.dtChannelsLk.RLock()
+	chids := make([]datatransfer.ChannelID, 0, len(t.dtChannels))
+	for chid := range t.dtChannels {
+		chids = append(chids, chid)
+	}
+	t.dtChannelsLk.RUnlock()
+
+	var matches []datatransfer.ChannelID
+	for _, chid := range chids {
+		if predicate(chid) {
+			matches = append(matches, chid)
+		}
+	}
+	return matches
+}
+
+// Subscribe returns a Go channel that receives a copy of every event the
+// transport reports to its events handler, and a function to unsubscribe.
+// Events are dropped rather than blocking the transport if the subscriber
+// falls behind (see DroppedEvents).
+func (t *Transport) Subscribe() (<-chan TransportEvent, func()) {
+	return t.subscribers.subscribe()
+}
+
+// DroppedEvents returns the total number of events that were dropped because
+// a subscriber's buffer was full
+func (t *Transport) DroppedEvents() uint64 {
+	return t.subscribers.droppedCount()
+}
+
+// PeerBytesSent returns the total number of bytes sent over the wire to the
+// given peer across all data transfer channels
+func (t *Transport) PeerBytesSent(p peer.ID) uint64 {
+	return t.peerStats.sent(p)
+}
+
+// PeerBytesReceived returns the total number of bytes received over the wire
+// from the given peer across all data transfer channels
+func (t *Transport) PeerBytesReceived(p peer.ID) uint64 {
+	return t.peerStats.received(p)
+}
+
+// ResetPeerStats zeroes the sent and received byte counters for the given peer
+// (eg at the end of a billing cycle)
+func (t *Transport) ResetPeerStats(p peer.ID) {
+	t.peerStats.reset(p)
+}
+
 // gsOutgoingRequestHook is called when a graphsync request is made
 func (t *Transport) gsOutgoingRequestHook(p peer.ID, request graphsync.RequestData, hookActions graphsync.OutgoingRequestHookActions) {
 	message, _ := extension.GetTransferData(request, t.supportedExtensions)
@@ -433,20 +1573,39 @@ func (t *Transport) gsOutgoingRequestHook(p peer.ID, request graphsync.RequestDa
 	}
 	chid := datatransfer.ChannelID{Initiator: initiator, Responder: responder, ID: message.TransferID()}
 
+	// Refuse to associate the request with this channel if another channel
+	// is already using its request ID, and abandon opening the request
+	if err := t.checkRequestIDCollision(request.ID(), chid); err != nil {
+		t.log.Errorf("%s", err)
+		if ch, chErr := t.getDTChannel(chid); chErr == nil {
+			ch.cancelPendingOpen()
+		}
+		if err := t.events.OnChannelError(chid, err); err != nil {
+			t.log.Errorf("%s: processing OnChannelError: %s", chid, err)
+		}
+		return
+	}
+
 	// A data transfer channel was opened
 	err := t.events.OnChannelOpened(chid)
 	if err != nil {
 		// There was an error opening the channel, bail out
-		log.Errorf("processing OnChannelOpened for %s: %s", chid, err)
+		t.log.Errorf("processing OnChannelOpened for %s: %s", chid, err)
 		t.CleanupChannel(chid)
 		return
 	}
 
 	// Start tracking the channel if we're not already
 	ch := t.trackDTChannel(chid)
+	ch.setDirection(message.IsRequest())
 
 	// Signal that the channel has been opened
 	ch.gsReqOpened(request.ID(), hookActions)
+
+	// Used by the tests to listen for when an outgoing request is opened
+	if t.outgoingRequestListener != nil {
+		t.outgoingRequestListener(chid)
+	}
 }
 
 // gsIncomingBlockHook is called when a block is received
@@ -456,17 +1615,90 @@ func (t *Transport) gsIncomingBlockHook(p peer.ID, response graphsync.ResponseDa
 		return
 	}
 
-	err := t.events.OnDataReceived(chid, block.Link(), block.BlockSize(), block.Index(), block.BlockSizeOnWire() != 0)
+	t.peerStats.addReceived(p, block.BlockSizeOnWire())
+
+	t.dtChannelsLk.RLock()
+	ch, ok := t.dtChannels[chid]
+	t.dtChannelsLk.RUnlock()
+
+	if t.blockInspector != nil {
+		if err := t.inspectBlock(chid, ch, block.Link()); err != nil {
+			hookActions.TerminateWithError(err)
+			return
+		}
+	}
+
+	if ok {
+		ch.addReceivedCid(block.Link())
+		if block.BlockSizeOnWire() != 0 {
+			ch.blockOnWire()
+			ch.addBytesTransferred(block.BlockSize())
+		}
+	}
+
+	// OnDataReceived can return a message to send back to the sender (eg an
+	// updated voucher to pay for the data received so far)
+	msg, err := t.events.OnDataReceivedContext(t.channelContext(chid), chid, block.Link(), block.BlockSize(), block.Index(), block.BlockSizeOnWire() != 0)
+	if msg != nil {
+		extensions, extErr := t.toExtensionData(chid, msg, t.supportedExtensionsFor(chid))
+		if extErr == nil {
+			extErr = t.gs.SendUpdate(context.TODO(), response.RequestID(), extensions...)
+		}
+		if extErr != nil {
+			t.log.Errorf("%s: sending message returned by OnDataReceived: %s", chid, extErr)
+		}
+	}
+
 	if err != nil && err != datatransfer.ErrPause {
 		hookActions.TerminateWithError(err)
 		return
 	}
 
 	if err == datatransfer.ErrPause {
+		t.pausedChannels.add(chid)
 		hookActions.PauseRequest()
 	}
 }
 
+// inspectBlock reads the block from the channel's store and passes its data
+// to the block inspector. ch is nil if the transport is not tracking the
+// channel
+func (t *Transport) inspectBlock(chid datatransfer.ChannelID, ch *dtChannel, link ipld.Link) error {
+	lsys := t.blockInspectorLsys
+	if ch != nil {
+		if storeLsys, ok := ch.store(); ok {
+			lsys = storeLsys
+		}
+	}
+
+	cl, ok := link.(cidlink.Link)
+	if !ok {
+		return xerrors.Errorf("%s: cannot inspect block with non-CID link %s", chid, link)
+	}
+	c := cl.Cid
+	data, err := readBlock(lsys, link)
+	if err != nil {
+		return xerrors.Errorf("%s: reading block %s to inspect it: %w", chid, c, err)
+	}
+	if err := t.blockInspector(chid, c, data); err != nil {
+		return xerrors.Errorf("%s: block %s rejected by inspector: %w", chid, c, err)
+	}
+	return nil
+}
+
+// readBlock reads the raw data of a block from the link system's storage.
+// graphsync has already verified the block, so the hash is not checked again
+func readBlock(lsys ipld.LinkSystem, link ipld.Link) ([]byte, error) {
+	if lsys.StorageReadOpener == nil {
+		return nil, xerrors.New("no storage to read block from")
+	}
+	r, err := lsys.StorageReadOpener(ipld.LinkContext{Ctx: context.TODO()}, link)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
 func (t *Transport) gsBlockSentHook(p peer.ID, request graphsync.RequestData, block graphsync.BlockData) {
 	// When a data transfer is restarted, the requester sends a list of CIDs
 	// that it already has. Graphsync calls the sent hook for all blocks even
@@ -482,8 +1714,16 @@ func (t *Transport) gsBlockSentHook(p peer.ID, request graphsync.RequestData, bl
 		return
 	}
 
-	if err := t.events.OnDataSent(chid, block.Link(), block.BlockSize(), block.Index(), block.BlockSizeOnWire() != 0); err != nil {
-		log.Errorf("failed to process data sent: %+v", err)
+	t.peerStats.addSent(p, block.BlockSizeOnWire())
+
+	if ch, err := t.getDTChannel(chid); err == nil {
+		ch.blockSent()
+		ch.blockOnWire()
+		ch.addBytesTransferred(block.BlockSize())
+	}
+
+	if err := t.events.OnDataSentContext(t.channelContext(chid), chid, block.Link(), block.BlockSize(), block.Index(), block.BlockSizeOnWire() != 0); err != nil {
+		t.log.Errorf("failed to process data sent: %+v", err)
 	}
 }
 
@@ -502,24 +1742,38 @@ func (t *Transport) gsOutgoingBlockHook(p peer.ID, request graphsync.RequestData
 		return
 	}
 
+	// If the channel has a weight and is now too far ahead of the other
+	// weighted channels to the peer, pause the response until they catch up
+	throttled := t.channelWeights.sent(p, chid, block.BlockSizeOnWire())
+
 	// OnDataQueued is called when a block is queued to be sent to the remote
 	// peer. It can return ErrPause to pause the response (eg if payment is
 	// required) and it can return a message that will be sent with the block
 	// (eg to ask for payment).
-	msg, err := t.events.OnDataQueued(chid, block.Link(), block.BlockSize(), block.Index(), block.BlockSizeOnWire() != 0)
+	msg, err := t.events.OnDataQueuedContext(t.channelContext(chid), chid, block.Link(), block.BlockSize(), block.Index(), block.BlockSizeOnWire() != 0)
 	if err != nil && err != datatransfer.ErrPause {
 		hookActions.TerminateWithError(err)
 		return
 	}
 
+	// Record that the block has been queued to be sent. If the channel is
+	// being drained, don't queue any further blocks
+	draining := false
+	if ch, err := t.getDTChannel(chid); err == nil {
+		draining = ch.blockQueued()
+	}
+
 	if err == datatransfer.ErrPause {
+		t.pausedChannels.add(chid)
+		hookActions.PauseResponse()
+	} else if draining || throttled {
 		hookActions.PauseResponse()
 	}
 
 	if msg != nil {
 		// gsOutgoingBlockHook uses a unique extension name so it can be attached with data from a different hook
 		// outgoingBlkExtensions also includes the default extension name so it remains compatible with all data-transfer protocol versions out there
-		extensions, err := extension.ToExtensionData(msg, outgoingBlkExtensions)
+		extensions, err := t.toExtensionData(chid, msg, outgoingBlkExtensions)
 		if err != nil {
 			hookActions.TerminateWithError(err)
 			return
@@ -546,6 +1800,10 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 	msg, err := extension.GetTransferData(request, t.supportedExtensions)
 	if err != nil {
 		hookActions.TerminateWithError(err)
+		exts := presentExtensions(request, t.supportedExtensions)
+		if handlerErr := t.events.OnMalformedMessage(p, exts, err); handlerErr != nil {
+			t.log.Errorf("processing OnMalformedMessage from %s: %s", p, handlerErr)
+		}
 		return
 	}
 
@@ -554,39 +1812,74 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 		return
 	}
 
+	if t.verifier != nil {
+		if err := message.Verify(msg, p, t.verifier); err != nil {
+			t.log.Warnf("rejecting incoming graphsync request from %s, req_id=%s: %s", p, request.ID(), err)
+			hookActions.TerminateWithError(err)
+			return
+		}
+	}
+
 	// An incoming graphsync request for data is received when either
 	// - The remote peer opened a data-transfer pull channel, so the local node
 	//   receives a graphsync request for the data
 	// - The local node opened a data-transfer push channel, and in response
 	//   the remote peer sent a graphsync request for the data, and now the
 	//   local node receives that request for data
-	var chid datatransfer.ChannelID
-	var responseMessage datatransfer.Message
+	chid := datatransfer.ChannelID{ID: msg.TransferID(), Initiator: p, Responder: t.peerID}
+	if !msg.IsRequest() {
+		chid = datatransfer.ChannelID{ID: msg.TransferID(), Initiator: t.peerID, Responder: p}
+	}
+
+	// The remote peer chooses the request ID, so make sure it cannot take
+	// over the request ID of another channel
+	if err := t.checkRequestIDCollision(request.ID(), chid); err != nil {
+		t.log.Warnf("rejecting incoming graphsync request from %s: %s", p, err)
+		hookActions.TerminateWithError(err)
+		return
+	}
+
+	// Used by the tests to listen for when an incoming request has been
+	// processed. Deferred so that it is called after the channel is unlocked.
+	if t.requestReceivedListener != nil {
+		defer t.requestReceivedListener(chid)
+	}
+
+	t.retainExtensions(chid, request)
+
+	if msg.IsRequest() && t.asyncRequestValidation {
+		t.gsReqRecdValidateAsync(chid, msg.(datatransfer.Request), request, hookActions)
+		return
+	}
+
+	var responseMessages []datatransfer.Response
 	var ch *dtChannel
 	if msg.IsRequest() {
 		// when a data transfer request comes in on graphsync, the remote peer
 		// initiated a pull
-		chid = datatransfer.ChannelID{ID: msg.TransferID(), Initiator: p, Responder: t.peerID}
-
-		log.Debugf("%s: received request for data (pull), req_id=%d", chid, request.ID())
+		t.log.Debugf("%s: received request for data (pull), req_id=%d", chid, request.ID())
 
 		// Lock the channel for the duration of this method
 		ch = t.trackDTChannel(chid)
+		ch.setDirection(true)
 		ch.lk.Lock()
 		defer ch.lk.Unlock()
 
 		request := msg.(datatransfer.Request)
-		responseMessage, err = t.events.OnRequestReceived(chid, request)
+		responseMessages, err = t.events.OnRequestReceivedMulti(chid, request)
+
+		// Remember the terms of the request so that subsequent updates can
+		// be checked against them
+		ch.setRequestTerms(request)
 	} else {
 		// when a data transfer response comes in on graphsync, this node
 		// initiated a push, and the remote peer responded with a request
 		// for data
-		chid = datatransfer.ChannelID{ID: msg.TransferID(), Initiator: t.peerID, Responder: p}
-
-		log.Debugf("%s: received request for data (push), req_id=%d", chid, request.ID())
+		t.log.Debugf("%s: received request for data (push), req_id=%d", chid, request.ID())
 
 		// Lock the channel for the duration of this method
 		ch = t.trackDTChannel(chid)
+		ch.setDirection(false)
 		ch.lk.Lock()
 		defer ch.lk.Unlock()
 
@@ -594,12 +1887,9 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 		err = t.events.OnResponseReceived(chid, response)
 	}
 
-	// If we need to send a response, add the response message as an extension
-	if responseMessage != nil {
-		// gsReqRecdHook uses a unique extension name so it can be attached with data from a different hook
-		// incomingReqExtensions also includes default extension name so it remains compatible with previous data-transfer
-		// protocol versions out there.
-		extensions, extensionErr := extension.ToExtensionData(responseMessage, incomingReqExtensions)
+	// If we need to send a response, add the response messages as extensions
+	if len(responseMessages) > 0 {
+		extensions, extensionErr := t.responseExtensions(chid, responseMessages)
 		if extensionErr != nil {
 			hookActions.TerminateWithError(err)
 			return
@@ -610,16 +1900,23 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 	}
 
 	if err != nil && err != datatransfer.ErrPause {
-		log.Infof("%s: terminating req_id=%d with error: %s", chid, request.ID(), err.Error())
+		t.log.Infof("%s: terminating req_id=%d with error: %s", chid, request.ID(), err.Error())
 		hookActions.TerminateWithError(err)
 		return
 	}
 
+	// If the remote peer has reopened the graphsync request for a push
+	// channel (eg because the channel was restarted), carry on from the
+	// existing channel state rather than treating it as a new transfer
+	if !msg.IsRequest() {
+		ch.gsPushRequestReopened(request.ID())
+	}
+
 	// Check if the callback indicated that the channel should be paused
 	// immediately (eg because data is still being unsealed)
 	paused := false
 	if err == datatransfer.ErrPause {
-		log.Debugf("%s: pausing graphsync response", chid)
+		t.log.Debugf("%s: pausing graphsync response", chid)
 
 		paused = true
 		hookActions.PauseResponse()
@@ -627,17 +1924,21 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 
 	// If this is a restart request, and the data transfer still hasn't got
 	// out of the paused state (eg because we're still unsealing), start this
-	// graphsync response in the paused state.
-	if ch.isOpen && !ch.xferStarted && !paused {
-		log.Debugf("%s: pausing graphsync response after restart", chid)
+	// graphsync response in the paused state (unless automatic pausing of
+	// restarts has been disabled).
+	if t.autoPauseRestart && ch.isRestart() && !ch.xferStarted && !paused {
+		t.log.Debugf("%s: pausing graphsync response after restart", chid)
 
 		paused = true
 		hookActions.PauseResponse()
 	}
 
 	// If the transfer is not paused, record that the transfer has started
-	if !paused {
+	if paused {
+		t.pausedChannels.add(chid)
+	} else {
 		ch.xferStarted = true
+		t.pausedChannels.remove(chid)
 	}
 
 	hookActions.AugmentContext(t.events.OnContextAugment(chid))
@@ -647,6 +1948,33 @@ func (t *Transport) gsReqRecdHook(p peer.ID, request graphsync.RequestData, hook
 	hookActions.ValidateRequest()
 }
 
+// gsReqRecdValidateAsync accepts an incoming request for data in the paused
+// state, then passes it to OnRequestReceived in the background, resuming or
+// cancelling the response depending on the result
+func (t *Transport) gsReqRecdValidateAsync(chid datatransfer.ChannelID, dtRequest datatransfer.Request, request graphsync.RequestData, hookActions graphsync.IncomingRequestHookActions) {
+	t.log.Debugf("%s: received request for data (pull), validating asynchronously, req_id=%d", chid, request.ID())
+
+	ch := t.trackDTChannel(chid)
+	ch.setDirection(true)
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+
+	ch.setRequestTerms(dtRequest)
+
+	// A restart whose transfer has not started yet stays paused after
+	// validation, as on the synchronous path
+	stayPaused := t.autoPauseRestart && ch.isRestart() && !ch.xferStarted
+
+	// Hold the response until the request has been validated
+	hookActions.PauseResponse()
+	t.pausedChannels.add(chid)
+	hookActions.AugmentContext(t.events.OnContextAugment(chid))
+	ch.gsDataRequestRcvd(request.ID(), hookActions)
+	hookActions.ValidateRequest()
+
+	go ch.validateRequest(dtRequest, request.ID(), stayPaused)
+}
+
 // gsCompletedResponseListener is a graphsync.OnCompletedResponseListener. We use it learn when the data transfer is complete
 // for the side that is responding to a graphsync request
 func (t *Transport) gsCompletedResponseListener(p peer.ID, request graphsync.RequestData, status graphsync.ResponseStatusCode) {
@@ -655,6 +1983,17 @@ func (t *Transport) gsCompletedResponseListener(p peer.ID, request graphsync.Req
 		return
 	}
 
+	// Graphsync only reports a response as completed with a terminal status,
+	// but make sure that a paused or partial response is never reported as a
+	// failed completion
+	if !status.IsTerminal() {
+		t.log.Warnf("%s: ignoring completion of response to peer %s with non-terminal status %s", chid, p, gsResponseStatusCodeString(status))
+		t.publishResponseStatus(chid, status)
+		return
+	}
+
+	t.channelWeights.remove(p, chid)
+
 	if status == graphsync.RequestCancelled {
 		return
 	}
@@ -662,7 +2001,10 @@ func (t *Transport) gsCompletedResponseListener(p peer.ID, request graphsync.Req
 	var completeErr error
 	if status != graphsync.RequestCompletedFull {
 		statusStr := gsResponseStatusCodeString(status)
-		completeErr = xerrors.Errorf("graphsync response to peer %s did not complete: response status code %s", p, statusStr)
+		completeErr = CompletionError{
+			StatusCode: status,
+			Err:        xerrors.Errorf("graphsync response to peer %s did not complete: response status code %s", p, statusStr),
+		}
 	}
 
 	// Used by the tests to listen for when a response completes
@@ -670,19 +2012,37 @@ func (t *Transport) gsCompletedResponseListener(p peer.ID, request graphsync.Req
 		t.completedResponseListener(chid)
 	}
 
-	err := t.events.OnChannelCompleted(chid, completeErr)
-	if err != nil {
-		log.Error(err)
+	t.recordOutcome(p, completeErr)
+
+	t.fireChannelCompleted(chid, completeErr, status, datatransfer.CompletionSideResponder)
+
+	if completeErr != nil {
+		t.releaseStore(chid)
 	}
 }
 
-// Remove this map once this PR lands: https://github.com/ipfs/go-graphsync/pull/148
-var gsResponseStatusCodes = map[graphsync.ResponseStatusCode]string{
-	graphsync.RequestAcknowledged:          "RequestAcknowledged",
-	graphsync.AdditionalPeers:              "AdditionalPeers",
-	graphsync.NotEnoughGas:                 "NotEnoughGas",
-	graphsync.OtherProtocol:                "OtherProtocol",
-	graphsync.PartialResponse:              "PartialResponse",
+// releaseStore unregisters the store for a channel that failed to complete,
+// unless the transport was configured to keep it
+func (t *Transport) releaseStore(chid datatransfer.ChannelID) {
+	if !t.cleanupStoreOnError {
+		return
+	}
+
+	t.dtChannelsLk.RLock()
+	ch, ok := t.dtChannels[chid]
+	t.dtChannelsLk.RUnlock()
+	if ok {
+		ch.releaseStore()
+	}
+}
+
+// Remove this map once this PR lands: https://github.com/ipfs/go-graphsync/pull/148
+var gsResponseStatusCodes = map[graphsync.ResponseStatusCode]string{
+	graphsync.RequestAcknowledged:          "RequestAcknowledged",
+	graphsync.AdditionalPeers:              "AdditionalPeers",
+	graphsync.NotEnoughGas:                 "NotEnoughGas",
+	graphsync.OtherProtocol:                "OtherProtocol",
+	graphsync.PartialResponse:              "PartialResponse",
 	graphsync.RequestPaused:                "RequestPaused",
 	graphsync.RequestCompletedFull:         "RequestCompletedFull",
 	graphsync.RequestCompletedPartial:      "RequestCompletedPartial",
@@ -702,16 +2062,81 @@ func gsResponseStatusCodeString(code graphsync.ResponseStatusCode) string {
 	return gsResponseStatusCodes[graphsync.RequestFailedUnknown]
 }
 
+// gsErrorStatusCode returns the graphsync status code that corresponds to the
+// last error received on a graphsync request
+func gsErrorStatusCode(err error) graphsync.ResponseStatusCode {
+	switch err.(type) {
+	case nil:
+		return graphsync.RequestCompletedFull
+	case graphsync.RemoteMissingBlockErr:
+		return graphsync.RequestCompletedPartial
+	case graphsync.RequestFailedBusyErr:
+		return graphsync.RequestFailedBusy
+	case graphsync.RequestFailedContentNotFoundErr:
+		return graphsync.RequestFailedContentNotFound
+	case graphsync.RequestFailedLegalErr:
+		return graphsync.RequestFailedLegal
+	default:
+		return graphsync.RequestFailedUnknown
+	}
+}
+
+// fireChannelCompleted fires OnChannelCompleted, followed by
+// OnChannelCompletedDetailed with the final graphsync status code and the
+// side of the graphsync request that completed
+func (t *Transport) fireChannelCompleted(chid datatransfer.ChannelID, completeErr error, status graphsync.ResponseStatusCode, side datatransfer.CompletionSide) {
+	ch, chErr := t.getDTChannel(chid)
+
+	// A transfer of an empty DAG (eg a root that is a single empty block)
+	// completes without any block crossing the wire, so make sure that
+	// OnTransferStarted has fired before the transfer completes
+	if chErr == nil && completeErr == nil {
+		ch.blockOnWire()
+	}
+	if chErr == nil {
+		defer ch.setOutcome(completeErr)
+	}
+
+	err := t.events.OnChannelCompleted(chid, completeErr)
+	if err != nil {
+		t.log.Errorf("%s: processing OnChannelCompleted: %s", chid, err)
+	}
+
+	result := datatransfer.CompletionResult{
+		Kind:       datatransfer.CompletionFailed,
+		Side:       side,
+		StatusCode: int(status),
+		Err:        completeErr,
+	}
+	switch {
+	case status == graphsync.RequestCompletedFull && completeErr == nil:
+		result.Kind = datatransfer.CompletionFull
+	case status == graphsync.RequestCompletedPartial:
+		result.Kind = datatransfer.CompletionPartial
+	}
+	if chErr == nil {
+		result.BytesTransferred = ch.getBytesTransferred()
+	}
+
+	err = t.events.OnChannelCompletedDetailed(chid, result)
+	if err != nil {
+		t.log.Errorf("%s: processing OnChannelCompletedDetailed: %s", chid, err)
+	}
+}
+
 func (t *Transport) gsRequestUpdatedHook(p peer.ID, request graphsync.RequestData, update graphsync.RequestData, hookActions graphsync.RequestUpdatedHookActions) {
 	chid, ok := t.requestIDToChannelID.load(request.ID())
 	if !ok {
 		return
 	}
 
-	responseMessage, err := t.processExtension(chid, update, p, t.supportedExtensions)
+	t.retainExtensions(chid, update)
+
+	supportedExtensions := t.supportedExtensionsFor(chid)
+	responseMessage, err := t.processExtension(chid, update, p, supportedExtensions)
 
 	if responseMessage != nil {
-		extensions, extensionErr := extension.ToExtensionData(responseMessage, t.supportedExtensions)
+		extensions, extensionErr := t.toExtensionData(chid, responseMessage, supportedExtensions)
 		if extensionErr != nil {
 			hookActions.TerminateWithError(err)
 			return
@@ -734,10 +2159,14 @@ func (t *Transport) gsIncomingResponseHook(p peer.ID, response graphsync.Respons
 		return
 	}
 
+	t.observeResponseStatus(chid, response.Status())
+
+	t.retainExtensions(chid, response)
+
 	responseMessage, err := t.processExtension(chid, response, p, incomingReqExtensions)
 
 	if responseMessage != nil {
-		extensions, extensionErr := extension.ToExtensionData(responseMessage, t.supportedExtensions)
+		extensions, extensionErr := t.toExtensionData(chid, responseMessage, t.supportedExtensionsFor(chid))
 		if extensionErr != nil {
 			hookActions.TerminateWithError(err)
 			return
@@ -749,6 +2178,10 @@ func (t *Transport) gsIncomingResponseHook(p peer.ID, response graphsync.Respons
 
 	if err != nil {
 		hookActions.TerminateWithError(err)
+	} else if err := t.processAdditionalMessages(chid, response, p); err != nil {
+		// Any messages sent along with the response are handled in order
+		// after it
+		hookActions.TerminateWithError(err)
 	}
 
 	// In a case where the transfer sends blocks immediately this extension may contain both a
@@ -761,6 +2194,18 @@ func (t *Transport) gsIncomingResponseHook(p peer.ID, response graphsync.Respons
 	}
 }
 
+// presentExtensions returns the names of the given extensions that are
+// present on a graphsync request or response
+func presentExtensions(gsMsg extension.GsExtended, exts []graphsync.ExtensionName) []string {
+	var present []string
+	for _, name := range exts {
+		if _, ok := gsMsg.Extension(name); ok {
+			present = append(present, string(name))
+		}
+	}
+	return present
+}
+
 func (t *Transport) processExtension(chid datatransfer.ChannelID, gsMsg extension.GsExtended, p peer.ID, exts []graphsync.ExtensionName) (datatransfer.Message, error) {
 
 	// if this is a push request the sender is us.
@@ -774,6 +2219,30 @@ func (t *Transport) processExtension(chid datatransfer.ChannelID, gsMsg extensio
 		return nil, nil
 	}
 
+	return t.processMessage(chid, msg, p)
+}
+
+// processMessage passes a message received from the peer on a graphsync
+// request or response to the events handler, returning the reply to send
+func (t *Transport) processMessage(chid datatransfer.ChannelID, msg datatransfer.Message, p peer.ID) (datatransfer.Message, error) {
+	if t.verifier != nil {
+		if err := message.Verify(msg, p, t.verifier); err != nil {
+			return nil, err
+		}
+	}
+
+	// Ignore messages that arrived after their deadline
+	if deadline := msg.Deadline(); !deadline.IsZero() && time.Now().After(deadline) {
+		t.log.Warnf("%s: dropping message from %s that expired at %s", chid, p, deadline)
+		return nil, nil
+	}
+
+	// Either peer may ask for the status of the channel, so status messages
+	// are answered by the transport rather than passed to the events handler
+	if msg.IsStatus() {
+		return t.processStatusMessage(chid, msg)
+	}
+
 	if msg.IsRequest() {
 
 		// only accept request message updates when original message was also request
@@ -781,6 +2250,12 @@ func (t *Transport) processExtension(chid datatransfer.ChannelID, gsMsg extensio
 			return nil, errors.New("received request on response channel")
 		}
 		dtRequest := msg.(datatransfer.Request)
+		if err := t.validateRequestUpdate(chid, dtRequest); err != nil {
+			return nil, err
+		}
+		if !t.inSequence(chid, msg) {
+			return nil, nil
+		}
 		return t.events.OnRequestReceived(chid, dtRequest)
 	}
 
@@ -789,8 +2264,115 @@ func (t *Transport) processExtension(chid datatransfer.ChannelID, gsMsg extensio
 		return nil, errors.New("received response on request channel")
 	}
 
+	if !t.inSequence(chid, msg) {
+		return nil, nil
+	}
+
 	dtResponse := msg.(datatransfer.Response)
-	return nil, t.events.OnResponseReceived(chid, dtResponse)
+	err := t.events.OnResponseReceived(chid, dtResponse)
+	if err != nil && t.isTransientResponseErr != nil && t.isTransientResponseErr(chid, err) {
+		t.log.Warnf("channel %s: ignoring transient error processing response: %s", chid, err)
+		return nil, nil
+	}
+	return nil, err
+}
+
+// processStatusMessage answers a status request from the transport's own
+// records for the channel, or passes a status response to the callers of
+// RequestStatus that are waiting for it
+func (t *Transport) processStatusMessage(chid datatransfer.ChannelID, msg datatransfer.Message) (datatransfer.Message, error) {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.IsRequest() {
+		return message.StatusResponse(chid.ID, ch.getBytesTransferred(), t.pausedChannels.has(chid)), nil
+	}
+
+	ch.statusReceived(msg.(datatransfer.Response))
+	return nil, nil
+}
+
+// Convert the message to graphsync extension data. The message is first
+// numbered and signed as configured (see prepareMessage).
+func (t *Transport) toExtensionData(chid datatransfer.ChannelID, msg datatransfer.Message, exts []graphsync.ExtensionName) ([]graphsync.ExtensionData, error) {
+	msg, err := t.prepareMessage(chid, msg)
+	if err != nil {
+		return nil, err
+	}
+	return extension.ToExtensionData(msg, exts)
+}
+
+// prepareMessage numbers and signs a message to be sent on the channel, as
+// configured
+func (t *Transport) prepareMessage(chid datatransfer.ChannelID, msg datatransfer.Message) (datatransfer.Message, error) {
+	if t.sequenceMessages && msg != nil {
+		ch := t.trackDTChannel(chid)
+		seqMsg, err := message.WithSequence(msg, ch.nextSequence())
+		if err != nil {
+			return nil, err
+		}
+		msg = seqMsg
+	}
+	if t.signer != nil && msg != nil {
+		signedMsg, err := message.Sign(msg, chid.OtherParty(t.peerID), t.signer)
+		if err != nil {
+			return nil, err
+		}
+		msg = signedMsg
+	}
+	return msg, nil
+}
+
+// inSequence checks the sequence number of a message received on the channel,
+// returning false if the message is older than a message already received
+// and so should be dropped. Messages without a sequence number are always in
+// sequence.
+func (t *Transport) inSequence(chid datatransfer.ChannelID, msg datatransfer.Message) bool {
+	seq := msg.Sequence()
+	if seq == 0 {
+		return true
+	}
+
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return true
+	}
+
+	// A new or restart message starts a new sequence, as the sender may have
+	// restarted since it last sent a message on the channel
+	if msg.IsNew() || msg.IsRestart() {
+		ch.resetReceivedSequence(seq)
+		return true
+	}
+	if !ch.receivedSequence(seq) {
+		t.log.Warnf("%s: dropping out of order message with sequence number %d", chid, seq)
+		return false
+	}
+	return true
+}
+
+// validateRequestUpdate checks that a request received as an update to an
+// existing channel does not change the base CID or selector that the channel
+// was originally opened with
+func (t *Transport) validateRequestUpdate(chid datatransfer.ChannelID, update datatransfer.Request) error {
+	ch, err := t.getDTChannel(chid)
+	if err != nil {
+		return err
+	}
+
+	ch.lk.RLock()
+	baseCid, selector := ch.baseCid, ch.selector
+	ch.lk.RUnlock()
+
+	if updateCid := update.BaseCid(); updateCid.Defined() && baseCid.Defined() && !updateCid.Equals(baseCid) {
+		return xerrors.Errorf("%s: update request base CID %s does not match original base CID %s", chid, updateCid, baseCid)
+	}
+	if updateSel, err := update.Selector(); err == nil && selector != nil && !ipld.DeepEqual(updateSel, selector) {
+		return xerrors.Errorf("%s: update request selector does not match original selector", chid)
+	}
+	return nil
 }
 
 func (t *Transport) gsRequestorCancelledListener(p peer.ID, request graphsync.RequestData) {
@@ -802,13 +2384,18 @@ func (t *Transport) gsRequestorCancelledListener(p peer.ID, request graphsync.Re
 	ch, err := t.getDTChannel(chid)
 	if err != nil {
 		if !xerrors.Is(datatransfer.ErrChannelNotFound, err) {
-			log.Errorf("requestor cancelled: getting channel %s: %s", chid, err)
+			t.log.Errorf("requestor cancelled: getting channel %s: %s", chid, err)
 		}
 		return
 	}
 
-	log.Debugf("%s: requester cancelled data-transfer", chid)
+	t.log.Debugf("%s: requester cancelled data-transfer", chid)
 	ch.onRequesterCancelled()
+
+	cerr := xerrors.Errorf("peer %s: %w", p, datatransfer.ErrRequestorCancelled)
+	if err := t.events.OnRequestCancelled(chid, cerr); err != nil {
+		t.log.Errorf("requestor cancelled: firing event for channel %s: %s", chid, err)
+	}
 }
 
 // Called when there is a graphsync error sending data
@@ -821,7 +2408,21 @@ func (t *Transport) gsNetworkSendErrorListener(p peer.ID, request graphsync.Requ
 
 	err := t.events.OnSendDataError(chid, gserr)
 	if err != nil {
-		log.Errorf("failed to fire transport send error %s: %s", gserr, err)
+		t.log.Errorf("failed to fire transport send error %s: %s", gserr, err)
+	}
+}
+
+// recordOutcome records whether a transfer with the peer succeeded, for the
+// circuit breaker. It is called once for each transfer, when the transfer
+// completes. A transfer that was cancelled locally is neither a success nor
+// a failure.
+func (t *Transport) recordOutcome(p peer.ID, completeErr error) {
+	switch {
+	case completeErr == nil:
+		t.circuitBreaker.success(p)
+	case errors.Is(completeErr, context.Canceled):
+	default:
+		t.circuitBreaker.failure(p)
 	}
 }
 
@@ -829,23 +2430,31 @@ func (t *Transport) gsNetworkSendErrorListener(p peer.ID, request graphsync.Requ
 func (t *Transport) gsNetworkReceiveErrorListener(p peer.ID, gserr error) {
 	// Fire a receive data error on all ongoing graphsync transfers with that
 	// peer
-	t.requestIDToChannelID.forEach(func(k graphsync.RequestID, sending bool, chid datatransfer.ChannelID) {
-		if chid.Initiator != p && chid.Responder != p {
-			return
-		}
-
+	for _, chid := range t.requestIDToChannelID.peerChannels(p) {
 		err := t.events.OnReceiveDataError(chid, gserr)
 		if err != nil {
-			log.Errorf("failed to fire transport receive error %s: %s", gserr, err)
+			t.log.Errorf("failed to fire transport receive error %s: %s", gserr, err)
 		}
-	})
+	}
+}
+
+// checkRequestIDCollision returns an error if the graphsync request ID is
+// already associated with a channel other than the given channel
+func (t *Transport) checkRequestIDCollision(requestID graphsync.RequestID, chid datatransfer.ChannelID) error {
+	other, ok := t.requestIDToChannelID.load(requestID)
+	if !ok || other == chid {
+		return nil
+	}
+	return xerrors.Errorf("%s: req_id=%s is used by channel %s: %w", chid, requestID, other, datatransfer.ErrRequestIDCollision)
 }
 
 func (t *Transport) newDTChannel(chid datatransfer.ChannelID) *dtChannel {
 	return &dtChannel{
-		t:         t,
-		channelID: chid,
-		opened:    make(chan graphsync.RequestID, 1),
+		t:          t,
+		channelID:  chid,
+		opened:     make(chan graphsync.RequestID, 1),
+		throughput: newThroughputMeter(time.Now()),
+		outcome:    newChannelOutcome(),
 	}
 }
 
@@ -862,6 +2471,48 @@ func (t *Transport) trackDTChannel(chid datatransfer.ChannelID) *dtChannel {
 	return ch
 }
 
+// supportedExtensionsFor returns the data transfer extensions to use for
+// messages on the given channel
+func (t *Transport) supportedExtensionsFor(chid datatransfer.ChannelID) []graphsync.ExtensionName {
+	t.dtChannelsLk.RLock()
+	ch, ok := t.dtChannels[chid]
+	t.dtChannelsLk.RUnlock()
+
+	if !ok {
+		return t.supportedExtensions
+	}
+	return ch.supportedExtensionsOrDefault()
+}
+
+// applicationExtensionsFor returns the sticky extensions set for the channel,
+// followed by the transport's extra extensions that the sticky extensions do
+// not override
+func (t *Transport) applicationExtensionsFor(chid datatransfer.ChannelID) []graphsync.ExtensionData {
+	t.dtChannelsLk.RLock()
+	ch, ok := t.dtChannels[chid]
+	t.dtChannelsLk.RUnlock()
+
+	if !ok {
+		return t.extraExtensions
+	}
+	sticky := ch.getStickyExtensions()
+	if len(sticky) == 0 {
+		return t.extraExtensions
+	}
+
+	names := make(map[graphsync.ExtensionName]struct{}, len(sticky))
+	for _, ext := range sticky {
+		names[ext.Name] = struct{}{}
+	}
+	exts := append([]graphsync.ExtensionData(nil), sticky...)
+	for _, ext := range t.extraExtensions {
+		if _, ok := names[ext.Name]; !ok {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
 func (t *Transport) getDTChannel(chid datatransfer.ChannelID) (*dtChannel, error) {
 	if t.events == nil {
 		return nil, datatransfer.ErrHandlerNotSet
@@ -882,22 +2533,125 @@ type dtChannel struct {
 	channelID datatransfer.ChannelID
 	t         *Transport
 
-	lk                 sync.RWMutex
-	isOpen             bool
+	lk     sync.RWMutex
+	isOpen bool
+	// rehydrated is set if the channel was restored from its persisted state
+	// after the process restarted (see RehydrateChannels)
+	rehydrated         bool
 	requestID          *graphsync.RequestID
 	completed          chan struct{}
 	requesterCancelled bool
 	xferStarted        bool
 	pendingExtensions  []graphsync.ExtensionData
+	// The size of the pending extensions, if their size is limited
+	pendingExtensionsSize uint64
+
+	// The base CID and selector of the request the channel was opened with
+	baseCid  cid.Cid
+	selector datamodel.Node
 
 	opened chan graphsync.RequestID
+	// cancelOpen cancels the graphsync request while it is being opened
+	pendingOpenLk sync.Mutex
+	cancelOpen    context.CancelFunc
+	// abandonedOpen is closed once an abandoned attempt to open a request
+	// has been cleaned up
+	abandonedOpen chan struct{}
+
+	// The sequence number of the last message sent and of the last message
+	// received on the channel
+	seqLk   sync.Mutex
+	sentSeq uint64
+	recvSeq uint64
+
+	// dataStarted is set once the first block is sent or received over the
+	// wire
+	dataStartedLk sync.Mutex
+	dataStarted   bool
 
 	storeLk         sync.RWMutex
 	storeRegistered bool
+	storeLsys       ipld.LinkSystem
+	// namedStore is the name of the shared store the channel uses (see
+	// UseNamedStore), if it doesn't have a store of its own
+	namedStore string
+	// storeErr is the error returned when registering the channel's store
+	// with graphsync failed
+	storeErr error
+
+	// progressHandler is called for each response on the channel's graphsync
+	// request, if set
+	progressLk      sync.RWMutex
+	progressHandler ProgressHandler
+
+	// supportedExtensions overrides the transport's supported extensions for
+	// this channel, if set
+	extsLk              sync.RWMutex
+	supportedExtensions []graphsync.ExtensionName
+
+	// Count of blocks queued to be sent but not yet sent, used to drain the
+	// channel before closing it
+	drainLk  sync.Mutex
+	inFlight int
+	drained  chan struct{}
+
+	// The number of bytes sent or received over the wire on the channel
+	bytesTransferred uint64
+	// The rate at which bytes are being sent or received on the channel
+	throughput *throughputMeter
+
+	// Whether the channel is a pull or a push
+	directionLk sync.RWMutex
+	direction   transferDirection
+
+	// Fires when no data has crossed the wire for the stall timeout
+	stallLk      sync.Mutex
+	stallTimer   *time.Timer
+	lastActivity time.Time
+
+	// The status of the last response received for the channel's graphsync
+	// request
+	responseStatusLk sync.Mutex
+	responseStatus   graphsync.ResponseStatusCode
+
+	// Set when the channel reaches a terminal state
+	outcomeLk sync.Mutex
+	outcome   *channelOutcome
+
+	// Go channels waiting for the remote peer to answer a status request
+	statusLk      sync.Mutex
+	statusWaiters []chan datatransfer.Response
+
+	// The data of the retained extensions last received on the channel
+	lastExtensionsLk sync.RWMutex
+	lastExtensions   map[graphsync.ExtensionName]datamodel.Node
+
+	// receivedCids is nil unless tracking of received CIDs has been enabled
+	// for the channel
+	receivedCidsLk sync.RWMutex
+	receivedCids   *cid.Set
+	// doNotSendCids is an encoded set of CIDs supplied by the caller to send
+	// in the DoNotSendCIDs extension on restart
+	doNotSendCids datamodel.Node
+
+	// Extensions attached to every graphsync request opened for the channel
+	stickyExtsLk sync.RWMutex
+	stickyExts   []graphsync.ExtensionData
+
+	// Application-defined metadata for the channel, which is never sent to
+	// the remote peer
+	metadataLk sync.RWMutex
+	metadata   map[string]string
+
+	// The context passed to data events for the channel
+	ctxLk sync.RWMutex
+	ctx   context.Context
 }
 
 // Info needed to monitor an ongoing graphsync request
 type gsReq struct {
+	// ctx is the context the request was opened with
+	ctx          context.Context
 	channelID    datatransfer.ChannelID
 	responseChan <-chan graphsync.ResponseProgress
 	errChan      <-chan error
@@ -917,6 +2671,17 @@ func (c *dtChannel) open(
 	c.lk.Lock()
 	defer c.lk.Unlock()
 
+	// If a previous attempt to open a request was abandoned, wait for it to
+	// be cleaned up so that its request ID is not mistaken for this one's
+	if c.abandonedOpen != nil {
+		select {
+		case <-c.abandonedOpen:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		c.abandonedOpen = nil
+	}
+
 	// If there is an existing graphsync request for this channelID
 	if c.requestID != nil {
 		// Cancel the existing graphsync request
@@ -940,6 +2705,12 @@ func (c *dtChannel) open(
 		}
 	}
 
+	// The request can be cancelled by closing the channel while we wait for
+	// graphsync to open it
+	openCtx, cancelOpen := context.WithCancel(ctx)
+	c.setPendingOpen(cancelOpen)
+	defer c.setPendingOpen(nil)
+
 	// Set up a completed channel that will be closed when the request
 	// completes (or is cancelled)
 	completed := make(chan struct{})
@@ -947,23 +2718,33 @@ func (c *dtChannel) open(
 	onComplete := func() {
 		// Ensure the channel is only closed once
 		onCompleteOnce.Do(func() {
-			log.Debugw("closing the completion ch for data-transfer channel", "chid", chid)
+			c.t.log.Debugf("%s: closing the completion ch for data-transfer channel", chid)
 			close(completed)
+			cancelOpen()
 		})
 	}
 	c.completed = completed
+	c.resetOutcome()
 
 	// Open a new graphsync request
 	msg := fmt.Sprintf("Opening graphsync request to %s for root %s", dataSender, root)
 	if channel != nil {
 		msg += fmt.Sprintf(" with %d Blocks already received", channel.ReceivedCidsTotal())
 	}
-	log.Info(msg)
-	responseChan, errChan := c.t.gs.Request(ctx, dataSender, root, stor, exts...)
+	c.t.log.Infof("%s", msg)
+	responseChan, errChan := c.t.gs.Request(openCtx, dataSender, root, stor, exts...)
 
 	// Wait for graphsync "request opened" callback
 	select {
-	case <-ctx.Done():
+	case <-openCtx.Done():
+		// Graphsync may still call the request opened callback, so clean up
+		// after the request in the background
+		abandoned := make(chan struct{})
+		c.abandonedOpen = abandoned
+		go c.cleanupAbandonedOpen(responseChan, errChan, onComplete, abandoned)
+		if ctx.Err() == nil {
+			return nil, xerrors.Errorf("%s: channel closed before graphsync request was opened: %w", chid, openCtx.Err())
+		}
 		return nil, ctx.Err()
 	case requestID := <-c.opened:
 		// Mark the channel as open and save the Graphsync request key
@@ -972,6 +2753,7 @@ func (c *dtChannel) open(
 	}
 
 	return &gsReq{
+		ctx:          ctx,
 		channelID:    chid,
 		responseChan: responseChan,
 		errChan:      errChan,
@@ -979,6 +2761,56 @@ func (c *dtChannel) open(
 	}, nil
 }
 
+// Set the function that cancels the graphsync request being opened, or nil
+// once the request has been opened
+func (c *dtChannel) setPendingOpen(cancelOpen context.CancelFunc) {
+	c.pendingOpenLk.Lock()
+	defer c.pendingOpenLk.Unlock()
+
+	c.cancelOpen = cancelOpen
+}
+
+// Cancel the graphsync request if it is still being opened
+func (c *dtChannel) cancelPendingOpen() {
+	c.pendingOpenLk.Lock()
+	defer c.pendingOpenLk.Unlock()
+
+	if c.cancelOpen != nil {
+		c.t.log.Debugf("%s: cancelling graphsync request that is being opened", c.channelID)
+		c.cancelOpen()
+	}
+}
+
+// cleanupAbandonedOpen cleans up after a graphsync request whose context was
+// cancelled before graphsync called the request opened callback. It waits for
+// graphsync to finish with the request, then removes the request ID sent by
+// any late callback, so that it is not mistaken for the ID of a later request.
+// The abandoned channel is closed once clean up is complete.
+func (c *dtChannel) cleanupAbandonedOpen(responseChan <-chan graphsync.ResponseProgress, errChan <-chan error, onComplete func(), abandoned chan struct{}) {
+	defer close(abandoned)
+	defer onComplete()
+
+	for responseChan != nil || errChan != nil {
+		select {
+		case _, ok := <-responseChan:
+			if !ok {
+				responseChan = nil
+			}
+		case _, ok := <-errChan:
+			if !ok {
+				errChan = nil
+			}
+		}
+	}
+
+	select {
+	case requestID := <-c.opened:
+		c.t.log.Debugf("%s: cleaning up abandoned graphsync request, req_id=%s", c.channelID, requestID)
+		c.t.requestIDToChannelID.delete(requestID)
+	default:
+	}
+}
+
 func waitForCompleteHook(ctx context.Context, completed chan struct{}) error {
 	// Wait for the cancel to propagate through to graphsync, and for
 	// the graphsync request to complete
@@ -996,10 +2828,8 @@ func waitForCompleteHook(ctx context.Context, completed chan struct{}) error {
 // gsReqOpened is called when graphsync makes a request to the remote peer to ask for data
 func (c *dtChannel) gsReqOpened(requestID graphsync.RequestID, hookActions graphsync.OutgoingRequestHookActions) {
 	// Tell graphsync to store the received blocks in the registered store
-	if c.hasStore() {
-		hookActions.UsePersistenceOption("data-transfer-" + c.channelID.String())
-	}
-	log.Infow("outgoing graphsync request", "peer", c.channelID.OtherParty(c.t.peerID), "graphsync request id", requestID, "data transfer channel id", c.channelID)
+	c.usePersistenceOption(hookActions.UsePersistenceOption)
+	c.t.log.Infof("%s: outgoing graphsync request to peer %s, req_id=%s", c.channelID, c.channelID.OtherParty(c.t.peerID), requestID)
 	// Save a mapping from the graphsync key to the channel ID so that
 	// subsequent graphsync callbacks are associated with this channel
 	c.t.requestIDToChannelID.set(requestID, false, c.channelID)
@@ -1011,52 +2841,89 @@ func (c *dtChannel) gsReqOpened(requestID graphsync.RequestID, hookActions graph
 // for data.
 // Note: Must be called under the lock.
 func (c *dtChannel) gsDataRequestRcvd(requestID graphsync.RequestID, hookActions graphsync.IncomingRequestHookActions) {
-	log.Debugf("%s: received request for data, req_id=%d", c.channelID, requestID)
+	c.t.log.Debugf("%s: received request for data, req_id=%d", c.channelID, requestID)
 
 	// If the requester had previously cancelled their request, send any
 	// message that was queued since the cancel
 	if c.requesterCancelled {
 		c.requesterCancelled = false
 
-		extensions := c.pendingExtensions
-		c.pendingExtensions = nil
+		extensions := c.takePendingExtensions()
 		for _, ext := range extensions {
 			hookActions.SendExtensionData(ext)
 		}
 	}
 
 	// Tell graphsync to load blocks from the registered store
-	if c.hasStore() {
-		hookActions.UsePersistenceOption("data-transfer-" + c.channelID.String())
-	}
+	c.usePersistenceOption(hookActions.UsePersistenceOption)
 
 	// Save a mapping from the graphsync key to the channel ID so that
 	// subsequent graphsync callbacks are associated with this channel
 	c.requestID = &requestID
-	log.Infow("incoming graphsync request", "peer", c.channelID.OtherParty(c.t.peerID), "graphsync request id", requestID, "data transfer channel id", c.channelID)
+	c.t.log.Infof("%s: incoming graphsync request from peer %s, req_id=%s", c.channelID, c.channelID.OtherParty(c.t.peerID), requestID)
+	c.resetOutcome()
 	c.t.requestIDToChannelID.set(requestID, true, c.channelID)
 
 	c.isOpen = true
 }
 
+// gsPushRequestReopened is called when the remote peer sends a new graphsync
+// request for data on a push channel. If there is already a graphsync request
+// for the channel, the previous response is cancelled so that the same data
+// is not sent twice. The channel's transfer started state is left untouched,
+// so that the new request resumes where the previous one left off.
+// Note: Must be called under the lock.
+func (c *dtChannel) gsPushRequestReopened(requestID graphsync.RequestID) {
+	if c.requestID == nil || *c.requestID == requestID {
+		return
+	}
+
+	prevRequestID := *c.requestID
+	c.t.log.Infof("%s: push request reopened with req_id=%s, cancelling previous graphsync response req_id=%s",
+		c.channelID, requestID, prevRequestID)
+
+	// Remove the mapping first so that the completion of the cancelled
+	// response is not attributed to the channel
+	c.t.requestIDToChannelID.delete(prevRequestID)
+
+	errch := c.cancel(context.TODO())
+	go func() {
+		if err := <-errch; err != nil {
+			c.t.log.Warnf("%s: cancelling previous graphsync response: %s", c.channelID, err)
+		}
+	}()
+}
+
+// setRequestTerms records the base CID and selector of an incoming request
+// for data, if the request carries them.
+// Note: Must be called under the lock.
+func (c *dtChannel) setRequestTerms(request datatransfer.Request) {
+	if baseCid := request.BaseCid(); baseCid.Defined() {
+		c.baseCid = baseCid
+	}
+	if selector, err := request.Selector(); err == nil {
+		c.selector = selector
+	}
+}
+
 func (c *dtChannel) pause(ctx context.Context) error {
 	c.lk.Lock()
 	defer c.lk.Unlock()
 
 	// Check if the channel was already cancelled
 	if c.requestID == nil {
-		log.Debugf("%s: channel was cancelled so not pausing channel", c.channelID)
+		c.t.log.Debugf("%s: channel was cancelled so not pausing channel", c.channelID)
 		return nil
 	}
 
 	// If the requester cancelled, bail out
 	if c.requesterCancelled {
-		log.Debugf("%s: requester has cancelled so not pausing response", c.channelID)
+		c.t.log.Debugf("%s: requester has cancelled so not pausing response", c.channelID)
 		return nil
 	}
 
 	// Pause the response
-	log.Debugf("%s: pausing response", c.channelID)
+	c.t.log.Debugf("%s: pausing response", c.channelID)
 	return c.t.gs.Pause(ctx, *c.requestID)
 }
 
@@ -1066,14 +2933,14 @@ func (c *dtChannel) resume(ctx context.Context, msg datatransfer.Message) error
 
 	// Check if the channel was already cancelled
 	if c.requestID == nil {
-		log.Debugf("%s: channel was cancelled so not resuming channel", c.channelID)
+		c.t.log.Debugf("%s: channel was cancelled so not resuming channel", c.channelID)
 		return nil
 	}
 
 	var extensions []graphsync.ExtensionData
 	if msg != nil {
 		var err error
-		extensions, err = extension.ToExtensionData(msg, c.t.supportedExtensions)
+		extensions, err = c.t.toExtensionData(c.channelID, msg, c.supportedExtensionsOrDefault())
 		if err != nil {
 			return err
 		}
@@ -1084,30 +2951,91 @@ func (c *dtChannel) resume(ctx context.Context, msg datatransfer.Message) error
 		// If there was an associated message, we still want to send it to the
 		// remote peer. We're not sending any message now, so instead queue up
 		// the message to be sent next time the peer makes a request to us.
-		c.pendingExtensions = append(c.pendingExtensions, extensions...)
+		if err := c.queuePendingExtensions(extensions); err != nil {
+			return err
+		}
 
-		log.Debugf("%s: requester has cancelled so not unpausing response", c.channelID)
+		c.t.log.Debugf("%s: requester has cancelled so not unpausing response", c.channelID)
 		return nil
 	}
 
 	// Record that the transfer has started
+	xferStarted := c.xferStarted
 	c.xferStarted = true
 
-	log.Debugf("%s: unpausing response", c.channelID)
-	return c.t.gs.Unpause(ctx, *c.requestID, extensions...)
+	c.t.log.Debugf("%s: unpausing response", c.channelID)
+	err := c.t.gs.Unpause(ctx, *c.requestID, extensions...)
+
+	// The requester may have cancelled the request after the check above,
+	// before the requestor cancelled listener has been called. In that case
+	// graphsync no longer knows about the response, so treat it as if the
+	// requester had already cancelled.
+	var notFound graphsync.RequestNotFoundErr
+	if errors.As(err, &notFound) && c.isSendingData() {
+		c.requesterCancelled = true
+		c.xferStarted = xferStarted
+		if err := c.queuePendingExtensions(extensions); err != nil {
+			return err
+		}
+
+		c.t.log.Debugf("%s: response not found when unpausing, requester has cancelled", c.channelID)
+		return nil
+	}
+	return err
+}
+
+// resumeThrottled unpauses a response that was paused to let the other
+// weighted channels to the peer catch up. A channel that is being drained
+// stays paused.
+func (c *dtChannel) resumeThrottled() {
+	c.drainLk.Lock()
+	draining := c.drained != nil
+	c.drainLk.Unlock()
+	if draining {
+		return
+	}
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	if c.requestID == nil || c.requesterCancelled {
+		return
+	}
+
+	c.t.log.Debugf("%s: unpausing weighted response", c.channelID)
+	if err := c.t.gs.Unpause(context.TODO(), *c.requestID); err != nil {
+		c.t.log.Debugf("%s: unpausing weighted response: %s", c.channelID, err)
+	}
 }
 
 func (c *dtChannel) close(ctx context.Context) error {
+	return c.closeWithMessage(ctx, nil)
+}
+
+// Cancel the graphsync request, first sending the given message (if any) to
+// the remote peer on the request
+func (c *dtChannel) closeWithMessage(ctx context.Context, msg datatransfer.Message) error {
+	// If the graphsync request is still being opened, cancel it rather than
+	// waiting for graphsync to open it, as that may never happen
+	c.cancelPendingOpen()
+
 	var errch chan error
 	c.lk.Lock()
 	{
 		// Check if the channel was already cancelled
 		if c.requestID != nil {
+			if msg != nil && !c.requesterCancelled {
+				c.sendMessageLocked(ctx, msg)
+			}
 			errch = c.cancel(ctx)
 		}
 	}
 	c.lk.Unlock()
 
+	if errch == nil {
+		return nil
+	}
+
 	// Wait for the cancel message to complete
 	select {
 	case err := <-errch:
@@ -1117,6 +3045,63 @@ func (c *dtChannel) close(ctx context.Context) error {
 	}
 }
 
+// Send a data transfer message to the remote peer as an update on the
+// graphsync request. Errors are logged, as the request is about to be
+// cancelled regardless.
+func (c *dtChannel) sendMessageLocked(ctx context.Context, msg datatransfer.Message) {
+	extensions, err := c.t.toExtensionData(c.channelID, msg, c.supportedExtensionsOrDefault())
+	if err == nil {
+		err = c.t.gs.SendUpdate(ctx, *c.requestID, extensions...)
+	}
+	if err != nil {
+		c.t.log.Warnf("%s: sending message to remote peer before cancelling request: %s", c.channelID, err)
+	}
+}
+
+// Validate a request whose response was paused while validation runs, then
+// send the response message and resume or cancel the response
+func (c *dtChannel) validateRequest(dtRequest datatransfer.Request, requestID graphsync.RequestID, stayPaused bool) {
+	// Hold the lock while validating, as the hook does on the synchronous
+	// path, so that the channel is not paused or resumed in the meantime
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	ctx := context.TODO()
+	responseMessages, err := c.t.events.OnRequestReceivedMulti(c.channelID, dtRequest)
+
+	if len(responseMessages) > 0 {
+		extensions, extErr := c.t.responseExtensions(c.channelID, responseMessages)
+		if extErr == nil && len(extensions) > 0 {
+			extErr = c.t.gs.SendUpdate(ctx, requestID, extensions...)
+		}
+		if extErr != nil {
+			c.t.log.Errorf("%s: sending response to request: %s", c.channelID, extErr)
+		}
+	}
+
+	if err != nil && err != datatransfer.ErrPause {
+		c.t.log.Infof("%s: cancelling req_id=%s after validation failed: %s", c.channelID, requestID, err)
+		c.t.pausedChannels.remove(c.channelID)
+		if cancelErr := c.t.gs.Cancel(ctx, requestID); cancelErr != nil {
+			c.t.log.Warnf("%s: cancelling response after validation failed: %s", c.channelID, cancelErr)
+		}
+		return
+	}
+
+	// Stay paused if the callback asked to, or if this is a restart of a
+	// transfer that has not started yet
+	if err == datatransfer.ErrPause || stayPaused {
+		c.t.log.Debugf("%s: leaving graphsync response paused after validation", c.channelID)
+		return
+	}
+
+	c.xferStarted = true
+	c.t.pausedChannels.remove(c.channelID)
+	if err := c.t.gs.Unpause(ctx, requestID); err != nil {
+		c.t.log.Errorf("%s: resuming response after validation: %s", c.channelID, err)
+	}
+}
+
 // Called when the responder gets a cancel message from the requester
 func (c *dtChannel) onRequesterCancelled() {
 	c.lk.Lock()
@@ -1125,50 +3110,440 @@ func (c *dtChannel) onRequesterCancelled() {
 	c.requesterCancelled = true
 }
 
-func (c *dtChannel) hasStore() bool {
-	c.storeLk.RLock()
-	defer c.storeLk.RUnlock()
+func (c *dtChannel) setStickyExtensions(exts []graphsync.ExtensionData) {
+	c.stickyExtsLk.Lock()
+	defer c.stickyExtsLk.Unlock()
+
+	c.stickyExts = append([]graphsync.ExtensionData(nil), exts...)
+}
+
+func (c *dtChannel) getStickyExtensions() []graphsync.ExtensionData {
+	c.stickyExtsLk.RLock()
+	defer c.stickyExtsLk.RUnlock()
+
+	return c.stickyExts
+}
+
+func (c *dtChannel) setMetadata(metadata map[string]string) {
+	c.metadataLk.Lock()
+	defer c.metadataLk.Unlock()
+
+	c.metadata = make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c.metadata[k] = v
+	}
+}
+
+func (c *dtChannel) getMetadata() map[string]string {
+	c.metadataLk.RLock()
+	defer c.metadataLk.RUnlock()
+
+	if c.metadata == nil {
+		return nil
+	}
+	metadata := make(map[string]string, len(c.metadata))
+	for k, v := range c.metadata {
+		metadata[k] = v
+	}
+	return metadata
+}
+
+func (c *dtChannel) setSupportedExtensions(exts []graphsync.ExtensionName) {
+	c.extsLk.Lock()
+	defer c.extsLk.Unlock()
 
-	return c.storeRegistered
+	c.supportedExtensions = exts
+}
+
+func (c *dtChannel) supportedExtensionsOrDefault() []graphsync.ExtensionName {
+	c.extsLk.RLock()
+	defer c.extsLk.RUnlock()
+
+	if c.supportedExtensions == nil {
+		return c.t.supportedExtensions
+	}
+	return c.supportedExtensions
+}
+
+func (c *dtChannel) setProgressHandler(handler ProgressHandler) {
+	c.progressLk.Lock()
+	defer c.progressLk.Unlock()
+
+	c.progressHandler = handler
+}
+
+func (c *dtChannel) getProgressHandler() ProgressHandler {
+	c.progressLk.RLock()
+	defer c.progressLk.RUnlock()
+
+	return c.progressHandler
+}
+
+// The name that the channel's store is registered with graphsync under
+func (c *dtChannel) persistenceOption() string {
+	return c.t.persistencePrefix + c.channelID.String()
 }
 
 // Use the given loader and storer to get / put blocks for the data-transfer.
 // Note that each data-transfer channel uses a separate blockstore.
 func (c *dtChannel) useStore(lsys ipld.LinkSystem) error {
+	// Reserve a place for the store before taking the store lock, as making
+	// room may release the store of another channel
+	if err := c.t.reserveStore(c); err != nil {
+		err = xerrors.Errorf("%s: %w", c.channelID, err)
+		c.storeLk.Lock()
+		c.storeErr = err
+		c.storeLk.Unlock()
+		return err
+	}
+
 	c.storeLk.Lock()
 	defer c.storeLk.Unlock()
 
 	// Register the channel's store with graphsync
-	err := c.t.gs.RegisterPersistenceOption("data-transfer-"+c.channelID.String(), lsys)
+	err := c.t.gs.RegisterPersistenceOption(c.persistenceOption(), lsys)
 	if err != nil {
+		c.t.releaseStoreCount()
+		c.storeErr = err
 		return err
 	}
 
 	c.storeRegistered = true
+	c.storeErr = nil
+	c.storeLsys = lsys
+	c.namedStore = ""
 
 	return nil
 }
 
-func (c *dtChannel) cleanup() {
+// Use the shared store registered under the given name to get / put blocks
+// for the data-transfer, releasing the channel's own store if it has one
+func (c *dtChannel) useNamedStore(name string, lsys ipld.LinkSystem) {
+	c.storeLk.Lock()
+	defer c.storeLk.Unlock()
+
+	c.releaseStoreLocked()
+	c.storeErr = nil
+	c.storeLsys = lsys
+	c.namedStore = name
+}
+
+// The channel's store, if one is registered
+func (c *dtChannel) store() (ipld.LinkSystem, bool) {
+	c.storeLk.RLock()
+	defer c.storeLk.RUnlock()
+	return c.storeLsys, c.storeRegistered || c.namedStore != ""
+}
+
+// Tell graphsync to use the channel's store for the request, if one is
+// registered. If registering the store failed, fire an OnStoreError event,
+// as blocks will go to graphsync's default store instead.
+func (c *dtChannel) usePersistenceOption(use func(name string)) {
+	c.storeLk.RLock()
+	registered, namedStore, storeErr := c.storeRegistered, c.namedStore, c.storeErr
+	c.storeLk.RUnlock()
+
+	if registered {
+		use(c.persistenceOption())
+		return
+	}
+	if namedStore != "" {
+		use(c.t.namedStoreOption(namedStore))
+		return
+	}
+	if storeErr == nil {
+		return
+	}
+
+	c.t.log.Warnf("%s: could not use store for graphsync request: %s", c.channelID, storeErr)
+	err := c.t.events.OnStoreError(c.channelID, storeErr)
+	if err != nil {
+		c.t.log.Errorf("%s: processing OnStoreError: %s", c.channelID, err)
+	}
+}
+
+// Unregister the channel's store from graphsync, if one is registered.
+// Named stores are shared, so they stay registered.
+func (c *dtChannel) releaseStore() {
+	c.storeLk.Lock()
+	defer c.storeLk.Unlock()
+
+	c.releaseStoreLocked()
+}
+
+// Note: must be called under the store lock
+func (c *dtChannel) releaseStoreLocked() {
+	if !c.storeRegistered {
+		return
+	}
+
+	opt := c.persistenceOption()
+	err := c.t.gs.UnregisterPersistenceOption(opt)
+	if err != nil {
+		c.t.log.Errorf("failed to unregister persistence option %s: %s", opt, err)
+	}
+	c.storeRegistered = false
+	c.t.releaseStoreCount()
+}
+
+func (c *dtChannel) getPendingExtensions() []graphsync.ExtensionData {
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+
+	return append([]graphsync.ExtensionData(nil), c.pendingExtensions...)
+}
+
+func (c *dtChannel) clearPendingExtensions() {
 	c.lk.Lock()
 	defer c.lk.Unlock()
 
-	log.Debugf("%s: cleaning up channel", c.channelID)
+	c.takePendingExtensions()
+}
 
-	if c.hasStore() {
-		// Unregister the channel's store from graphsync
-		opt := "data-transfer-" + c.channelID.String()
-		err := c.t.gs.UnregisterPersistenceOption(opt)
-		if err != nil {
-			log.Errorf("failed to unregister persistence option %s: %s", opt, err)
+func (c *dtChannel) addBytesTransferred(size uint64) {
+	atomic.AddUint64(&c.bytesTransferred, size)
+	c.throughput.add(size, time.Now())
+	c.dataActivity()
+}
+
+func (c *dtChannel) getBytesTransferred() uint64 {
+	return atomic.LoadUint64(&c.bytesTransferred)
+}
+
+// Send a status request to the remote peer, returning a Go channel that
+// receives the status response
+func (c *dtChannel) requestStatus(ctx context.Context) (chan datatransfer.Response, error) {
+	statusCh := make(chan datatransfer.Response, 1)
+	c.statusLk.Lock()
+	c.statusWaiters = append(c.statusWaiters, statusCh)
+	c.statusLk.Unlock()
+
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+
+	var err error = datatransfer.ErrChannelNotReady
+	if c.requestID != nil {
+		var extensions []graphsync.ExtensionData
+		extensions, err = c.t.toExtensionData(c.channelID, message.StatusRequest(c.channelID.ID), c.supportedExtensionsOrDefault())
+		if err == nil {
+			err = c.t.gs.SendUpdate(ctx, *c.requestID, extensions...)
+		}
+	}
+	if err != nil {
+		c.stopAwaitingStatus(statusCh)
+		return nil, xerrors.Errorf("%s: sending status request: %w", c.channelID, err)
+	}
+	return statusCh, nil
+}
+
+func (c *dtChannel) stopAwaitingStatus(statusCh chan datatransfer.Response) {
+	c.statusLk.Lock()
+	defer c.statusLk.Unlock()
+
+	for i, waiter := range c.statusWaiters {
+		if waiter == statusCh {
+			c.statusWaiters = append(c.statusWaiters[:i], c.statusWaiters[i+1:]...)
+			return
 		}
 	}
+}
+
+// Pass a status response to everyone waiting for one
+func (c *dtChannel) statusReceived(resp datatransfer.Response) {
+	c.statusLk.Lock()
+	defer c.statusLk.Unlock()
+
+	for _, waiter := range c.statusWaiters {
+		select {
+		case waiter <- resp:
+		default:
+		}
+	}
+	c.statusWaiters = nil
+}
+
+func (c *dtChannel) setLastExtension(name graphsync.ExtensionName, data datamodel.Node) {
+	c.lastExtensionsLk.Lock()
+	defer c.lastExtensionsLk.Unlock()
+
+	if c.lastExtensions == nil {
+		c.lastExtensions = make(map[graphsync.ExtensionName]datamodel.Node)
+	}
+	c.lastExtensions[name] = data
+}
+
+func (c *dtChannel) lastExtension(name graphsync.ExtensionName) (datamodel.Node, bool) {
+	c.lastExtensionsLk.RLock()
+	defer c.lastExtensionsLk.RUnlock()
+
+	data, ok := c.lastExtensions[name]
+	return data, ok
+}
+
+// Start keeping a record of the CIDs received on this channel
+func (c *dtChannel) trackReceivedCids() {
+	c.receivedCidsLk.Lock()
+	defer c.receivedCidsLk.Unlock()
+
+	if c.receivedCids == nil {
+		c.receivedCids = cid.NewSet()
+	}
+}
+
+// Record a received CID, if tracking is enabled for this channel
+func (c *dtChannel) addReceivedCid(lnk ipld.Link) {
+	c.receivedCidsLk.Lock()
+	defer c.receivedCidsLk.Unlock()
+
+	if c.receivedCids == nil {
+		return
+	}
+	if cl, ok := lnk.(cidlink.Link); ok {
+		c.receivedCids.Add(cl.Cid)
+	}
+}
+
+// Get a copy of the set of CIDs received on this channel, or nil if tracking
+// is not enabled
+func (c *dtChannel) receivedCidSet() *cid.Set {
+	c.receivedCidsLk.RLock()
+	defer c.receivedCidsLk.RUnlock()
+
+	if c.receivedCids == nil {
+		return nil
+	}
+	cids := cid.NewSet()
+	_ = c.receivedCids.ForEach(func(k cid.Cid) error {
+		cids.Add(k)
+		return nil
+	})
+	return cids
+}
+
+// Set the encoded CIDs to send in the DoNotSendCIDs extension on restart
+func (c *dtChannel) setDoNotSendCids(doNotSendCids datamodel.Node) {
+	c.receivedCidsLk.Lock()
+	defer c.receivedCidsLk.Unlock()
+
+	c.doNotSendCids = doNotSendCids
+}
+
+// Get the encoded CIDs supplied for the DoNotSendCIDs extension, or nil if
+// none were supplied
+func (c *dtChannel) encodedDoNotSendCids() datamodel.Node {
+	c.receivedCidsLk.RLock()
+	defer c.receivedCidsLk.RUnlock()
+
+	return c.doNotSendCids
+}
+
+// Get the sequence number to assign to the next message sent on the channel
+func (c *dtChannel) nextSequence() uint64 {
+	c.seqLk.Lock()
+	defer c.seqLk.Unlock()
+
+	c.sentSeq++
+	return c.sentSeq
+}
+
+// Record the sequence number of a received message, returning false if it is
+// not newer than the last message received on the channel
+func (c *dtChannel) receivedSequence(seq uint64) bool {
+	c.seqLk.Lock()
+	defer c.seqLk.Unlock()
+
+	if seq <= c.recvSeq {
+		return false
+	}
+	c.recvSeq = seq
+	return true
+}
+
+// Start a new sequence of received messages from the given sequence number
+func (c *dtChannel) resetReceivedSequence(seq uint64) {
+	c.seqLk.Lock()
+	defer c.seqLk.Unlock()
+
+	c.recvSeq = seq
+}
+
+// blockOnWire records that a block was sent or received over the wire, and
+// fires OnTransferStarted the first time it is called for the channel
+func (c *dtChannel) blockOnWire() {
+	c.dataStartedLk.Lock()
+	started := c.dataStarted
+	c.dataStarted = true
+	c.dataStartedLk.Unlock()
+
+	if !started {
+		c.t.events.OnTransferStarted(c.channelID)
+	}
+}
+
+// blockQueued records that a block has been queued to be sent, and returns
+// true if the channel is being drained
+func (c *dtChannel) blockQueued() bool {
+	c.drainLk.Lock()
+	defer c.drainLk.Unlock()
+
+	c.inFlight++
+	return c.drained != nil
+}
+
+// blockSent records that a queued block has been sent
+func (c *dtChannel) blockSent() {
+	c.drainLk.Lock()
+	defer c.drainLk.Unlock()
+
+	if c.inFlight > 0 {
+		c.inFlight--
+	}
+	c.checkDrained()
+}
+
+// drain marks the channel as draining and returns a channel that is closed
+// once there are no more queued blocks waiting to be sent
+func (c *dtChannel) drain() <-chan struct{} {
+	c.drainLk.Lock()
+	defer c.drainLk.Unlock()
+
+	if c.drained == nil {
+		c.drained = make(chan struct{})
+	}
+	c.checkDrained()
+	return c.drained
+}
+
+// Note: must be called under the drain lock
+func (c *dtChannel) checkDrained() {
+	if c.drained == nil || c.inFlight > 0 {
+		return
+	}
+	select {
+	case <-c.drained:
+	default:
+		close(c.drained)
+	}
+}
+
+func (c *dtChannel) cleanup() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.t.log.Debugf("%s: cleaning up channel", c.channelID)
+
+	c.stopStallTimer()
+	c.releaseStore()
+	c.takePendingExtensions()
 
 	// Clean up mapping from gs key to channel ID
 	c.t.requestIDToChannelID.deleteRefs(c.channelID)
 }
 
 func (c *dtChannel) shutdown(ctx context.Context) error {
+	c.setOutcome(xerrors.Errorf("%s: transport shut down: %w", c.channelID, datatransfer.ErrChannelClosed))
+	c.stopStallTimer()
+
 	// Cancel the graphsync request
 	c.lk.Lock()
 	errch := c.cancel(ctx)
@@ -1199,7 +3574,7 @@ func (c *dtChannel) cancel(ctx context.Context) chan error {
 	c.requestID = nil
 
 	go func() {
-		log.Debugf("%s: cancelling request", c.channelID)
+		c.t.log.Debugf("%s: cancelling request", c.channelID)
 		err := c.t.gs.Cancel(ctx, *requestID)
 
 		// Ignore "request not found" errors
@@ -1218,16 +3593,75 @@ type channelInfo struct {
 	channelID datatransfer.ChannelID
 }
 
+// A set of data-transfer channel IDs that is safe for concurrent use
+type channelIDSet struct {
+	lk sync.RWMutex
+	m  map[datatransfer.ChannelID]struct{}
+}
+
+func newChannelIDSet() *channelIDSet {
+	return &channelIDSet{
+		m: make(map[datatransfer.ChannelID]struct{}),
+	}
+}
+
+func (s *channelIDSet) add(chid datatransfer.ChannelID) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.m[chid] = struct{}{}
+}
+
+func (s *channelIDSet) remove(chid datatransfer.ChannelID) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	delete(s.m, chid)
+}
+
+// check whether the channel ID is in the set
+func (s *channelIDSet) has(chid datatransfer.ChannelID) bool {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	_, ok := s.m[chid]
+	return ok
+}
+
+// remove all channel IDs from the set
+func (s *channelIDSet) clear() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	s.m = make(map[datatransfer.ChannelID]struct{})
+}
+
+func (s *channelIDSet) list() []datatransfer.ChannelID {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	chids := make([]datatransfer.ChannelID, 0, len(s.m))
+	for chid := range s.m {
+		chids = append(chids, chid)
+	}
+	return chids
+}
+
 // Used in graphsync callbacks to map from graphsync request to the
 // associated data-transfer channel ID.
 type requestIDToChannelIDMap struct {
 	lk sync.RWMutex
 	m  map[graphsync.RequestID]channelInfo
+	// byPeer indexes the channels in the map by each of their parties, with
+	// the number of request IDs that map to each channel, so that the
+	// channels with a peer can be found without scanning every request
+	byPeer map[peer.ID]map[datatransfer.ChannelID]int
 }
 
 func newRequestIDToChannelIDMap() *requestIDToChannelIDMap {
 	return &requestIDToChannelIDMap{
-		m: make(map[graphsync.RequestID]channelInfo),
+		m:      make(map[graphsync.RequestID]channelInfo),
+		byPeer: make(map[peer.ID]map[datatransfer.ChannelID]int),
 	}
 }
 
@@ -1259,7 +3693,31 @@ func (m *requestIDToChannelIDMap) set(key graphsync.RequestID, sending bool, chi
 	m.lk.Lock()
 	defer m.lk.Unlock()
 
+	if prev, ok := m.m[key]; ok {
+		m.unindex(prev.channelID)
+	}
 	m.m[key] = channelInfo{sending, chid}
+	m.index(chid)
+}
+
+// remove a single key
+func (m *requestIDToChannelIDMap) delete(key graphsync.RequestID) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if prev, ok := m.m[key]; ok {
+		m.unindex(prev.channelID)
+		delete(m.m, key)
+	}
+}
+
+// remove all keys
+func (m *requestIDToChannelIDMap) clear() {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	m.m = make(map[graphsync.RequestID]channelInfo)
+	m.byPeer = make(map[peer.ID]map[datatransfer.ChannelID]int)
 }
 
 // call f for each key / value in the map
@@ -1272,6 +3730,19 @@ func (m *requestIDToChannelIDMap) forEach(f func(k graphsync.RequestID, isSendin
 	}
 }
 
+// check whether any key maps to the channel
+func (m *requestIDToChannelIDMap) hasChannel(id datatransfer.ChannelID) bool {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+
+	for _, ch := range m.m {
+		if ch.channelID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // delete any keys that reference this value
 func (m *requestIDToChannelIDMap) deleteRefs(id datatransfer.ChannelID) {
 	m.lk.Lock()
@@ -1279,7 +3750,111 @@ func (m *requestIDToChannelIDMap) deleteRefs(id datatransfer.ChannelID) {
 
 	for k, ch := range m.m {
 		if ch.channelID == id {
+			m.unindex(ch.channelID)
 			delete(m.m, k)
 		}
 	}
 }
+
+// get the channels that have at least one request ID in the map, and that
+// the peer is a party to
+func (m *requestIDToChannelIDMap) peerChannels(p peer.ID) []datatransfer.ChannelID {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+
+	chids := make([]datatransfer.ChannelID, 0, len(m.byPeer[p]))
+	for chid := range m.byPeer[p] {
+		chids = append(chids, chid)
+	}
+	return chids
+}
+
+// add a reference to the channel to the peer index.
+// Note: Must be called under the lock.
+func (m *requestIDToChannelIDMap) index(chid datatransfer.ChannelID) {
+	for _, p := range []peer.ID{chid.Initiator, chid.Responder} {
+		chids, ok := m.byPeer[p]
+		if !ok {
+			chids = make(map[datatransfer.ChannelID]int)
+			m.byPeer[p] = chids
+		}
+		chids[chid]++
+	}
+}
+
+// remove a reference to the channel from the peer index.
+// Note: Must be called under the lock.
+func (m *requestIDToChannelIDMap) unindex(chid datatransfer.ChannelID) {
+	for _, p := range []peer.ID{chid.Initiator, chid.Responder} {
+		chids := m.byPeer[p]
+		chids[chid]--
+		if chids[chid] > 0 {
+			continue
+		}
+		delete(chids, chid)
+		if len(chids) == 0 {
+			delete(m.byPeer, p)
+		}
+	}
+}
+
+type peerStats struct {
+	sent     uint64
+	received uint64
+}
+
+// Used to keep a running total of bytes sent to and received from each peer
+type peerStatsMap struct {
+	lk sync.RWMutex
+	m  map[peer.ID]peerStats
+}
+
+func newPeerStatsMap() *peerStatsMap {
+	return &peerStatsMap{
+		m: make(map[peer.ID]peerStats),
+	}
+}
+
+// add to the bytes sent to a peer
+func (m *peerStatsMap) addSent(p peer.ID, n uint64) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	st := m.m[p]
+	st.sent += n
+	m.m[p] = st
+}
+
+// add to the bytes received from a peer
+func (m *peerStatsMap) addReceived(p peer.ID, n uint64) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	st := m.m[p]
+	st.received += n
+	m.m[p] = st
+}
+
+// get the bytes sent to a peer
+func (m *peerStatsMap) sent(p peer.ID) uint64 {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+
+	return m.m[p].sent
+}
+
+// get the bytes received from a peer
+func (m *peerStatsMap) received(p peer.ID) uint64 {
+	m.lk.RLock()
+	defer m.lk.RUnlock()
+
+	return m.m[p].received
+}
+
+// zero the counters for a peer
+func (m *peerStatsMap) reset(p peer.ID) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	delete(m.m, p)
+}
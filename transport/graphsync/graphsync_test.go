@@ -1,19 +1,28 @@
 package graphsync_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-graphsync"
+	"github.com/ipfs/go-graphsync/cidset"
 	"github.com/ipfs/go-graphsync/donotsendfirstblocks"
 	"github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
 	"github.com/ipld/go-ipld-prime/datamodel"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipld/go-ipld-prime/node/basicnode"
+	"github.com/ipld/go-ipld-prime/storage/memstore"
+	"github.com/ipld/go-ipld-prime/traversal/selector/builder"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
 	peer "github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/stretchr/testify/require"
@@ -27,6 +36,22 @@ import (
 )
 
 func TestManager(t *testing.T) {
+	reportedLoad := make(chan LoadStats, 1)
+	reportedThroughput := make(chan map[datatransfer.ChannelID]float64, 1)
+	inspectedStore := &memstore.Store{}
+	inspectedLsys := cidlink.DefaultLinkSystem()
+	inspectedLsys.SetReadStorage(inspectedStore)
+	var inspectedData []byte
+	errPolicy := errors.New("block violates policy")
+	requestsReceived := make(chan datatransfer.ChannelID, 1)
+	outgoingRequests := make(chan datatransfer.ChannelID, 1)
+	var ephemeralStore datatransfer.EphemeralStore
+	errTransient := errors.New("transient consumer error")
+	isTransient := func(chid datatransfer.ChannelID, err error) bool {
+		return errors.Is(err, errTransient)
+	}
+	openCtx, cancelOpenCtx := context.WithCancel(context.Background())
+	defer cancelOpenCtx()
 	testCases := map[string]struct {
 		requestConfig  gsRequestConfig
 		responseConfig gsResponseConfig
@@ -35,6 +60,7 @@ func TestManager(t *testing.T) {
 		action         func(gsData *harness)
 		check          func(t *testing.T, events *fakeEvents, gsData *harness)
 		protocol       protocol.ID
+		options        []Option
 	}{
 		"gs outgoing request with recognized dt pull channel will record incoming blocks": {
 			action: func(gsData *harness) {
@@ -47,6 +73,31 @@ func TestManager(t *testing.T) {
 				require.NoError(t, gsData.incomingBlockHookActions.TerminationError)
 			},
 		},
+		"channel history records the events reported for the channel": {
+			options: []Option{RecordChannelHistory(16, 4)},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.CleanupChannel(chid)
+
+				// the history is kept after the channel is cleaned up
+				history := gsData.transport.ChannelHistory(chid)
+				require.NotEmpty(t, history)
+				require.Equal(t, ChannelOpenedEvent, history[0].Code)
+				require.False(t, history[0].Time.IsZero())
+				var codes []TransportEventCode
+				for _, evt := range history {
+					require.Equal(t, chid, evt.ChannelID)
+					codes = append(codes, evt.Code)
+				}
+				require.Contains(t, codes, DataReceivedEvent)
+
+				require.Empty(t, gsData.transport.ChannelHistory(datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.other, Initiator: gsData.self}))
+			},
+		},
 		"gs outgoing request with recognized dt push channel will record incoming blocks": {
 			requestConfig: gsRequestConfig{
 				dtIsResponse: true,
@@ -61,6 +112,62 @@ func TestManager(t *testing.T) {
 				require.NoError(t, gsData.incomingBlockHookActions.TerminationError)
 			},
 		},
+		"outgoing gs request for a pull records the channel as a pull": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				isPull, err := gsData.transport.IsPull(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				require.NoError(t, err)
+				require.True(t, isPull)
+
+				_, err = gsData.transport.IsPull(datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.other, Initiator: gsData.self})
+				require.ErrorIs(t, err, datatransfer.ErrChannelNotFound)
+
+				// a channel that is tracked before any graphsync request is
+				// made or received has no direction yet
+				chid := datatransfer.ChannelID{ID: gsData.transferID + 2, Responder: gsData.other, Initiator: gsData.self}
+				require.NoError(t, gsData.transport.UseStore(chid, cidlink.DefaultLinkSystem()))
+				_, err = gsData.transport.IsPull(chid)
+				require.ErrorIs(t, err, datatransfer.ErrChannelNotReady)
+			},
+		},
+		"outgoing gs request for a push records the channel as a push": {
+			requestConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				isPull, err := gsData.transport.IsPull(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.NoError(t, err)
+				require.False(t, isPull)
+			},
+		},
+		"incoming gs request for a pull records the channel as a pull": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				isPull, err := gsData.transport.IsPull(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.NoError(t, err)
+				require.True(t, isPull)
+			},
+		},
+		"incoming gs request for a push records the channel as a push": {
+			requestConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				isPull, err := gsData.transport.IsPull(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				require.NoError(t, err)
+				require.False(t, isPull)
+			},
+		},
 		"non-data-transfer gs request will not record incoming blocks and send updates": {
 			requestConfig: gsRequestConfig{
 				dtExtensionMissing: true,
@@ -89,6 +196,68 @@ func TestManager(t *testing.T) {
 				require.NoError(t, gsData.incomingBlockHookActions.TerminationError)
 			},
 		},
+		"block inspector is called with the data of each incoming block": {
+			options: []Option{BlockInspector(inspectedLsys, func(chid datatransfer.ChannelID, c cid.Cid, data []byte) error {
+				inspectedData = data
+				return nil
+			})},
+			action: func(gsData *harness) {
+				_ = inspectedStore.Put(gsData.ctx, gsData.block.Link().Binary(), []byte("block data"))
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, []byte("block data"), inspectedData)
+				require.True(t, events.OnDataReceivedCalled)
+				require.NoError(t, gsData.incomingBlockHookActions.TerminationError)
+			},
+		},
+		"block inspector error will halt request": {
+			options: []Option{BlockInspector(inspectedLsys, func(chid datatransfer.ChannelID, c cid.Cid, data []byte) error {
+				return errPolicy
+			})},
+			action: func(gsData *harness) {
+				_ = inspectedStore.Put(gsData.ctx, gsData.block.Link().Binary(), []byte("block data"))
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnDataReceivedCalled)
+				require.ErrorIs(t, gsData.incomingBlockHookActions.TerminationError, errPolicy)
+			},
+		},
+		"block inspector reads blocks from the channel's store": {
+			options: []Option{BlockInspector(inspectedLsys, func(chid datatransfer.ChannelID, c cid.Cid, data []byte) error {
+				inspectedData = data
+				return nil
+			})},
+			action: func(gsData *harness) {
+				store := &memstore.Store{}
+				_ = store.Put(gsData.ctx, gsData.block.Link().Binary(), []byte("channel data"))
+				lsys := cidlink.DefaultLinkSystem()
+				lsys.SetReadStorage(store)
+				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, lsys)
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, []byte("channel data"), inspectedData)
+				require.NoError(t, gsData.incomingBlockHookActions.TerminationError)
+			},
+		},
+		"block inspector halts request if block cannot be read": {
+			options: []Option{BlockInspector(cidlink.DefaultLinkSystem(), func(chid datatransfer.ChannelID, c cid.Cid, data []byte) error {
+				return nil
+			})},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnDataReceivedCalled)
+				require.Error(t, gsData.incomingBlockHookActions.TerminationError)
+			},
+		},
 		"gs incoming block with data receive error will halt request": {
 			events: fakeEvents{
 				OnDataReceivedError: errors.New("something went wrong"),
@@ -103,6 +272,32 @@ func TestManager(t *testing.T) {
 				require.Error(t, gsData.incomingBlockHookActions.TerminationError)
 			},
 		},
+		"gs incoming block with a message from OnDataReceived sends it to the responder": {
+			events: fakeEvents{
+				OnDataReceivedMessage: message.UpdateRequest(datatransfer.TransferID(rand.Uint32()), false),
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.OnDataReceivedCalled)
+				require.NoError(t, gsData.incomingBlockHookActions.TerminationError)
+				update := gsData.fgs.AssertUpdateReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), update.RequestID)
+				assertHasExtensionMessage(t, extension.ExtensionDataTransfer1_1, update.Extensions, events.OnDataReceivedMessage)
+			},
+		},
+		"gs incoming block with no message from OnDataReceived sends no update": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.OnDataReceivedCalled)
+				gsData.fgs.AssertNoUpdateReceived(t)
+			},
+		},
 		"gs outgoing request with recognized dt request can receive request processing listener": {
 			action: func(gsData *harness) {
 				gsData.outgoingRequestHook()
@@ -130,6 +325,113 @@ func TestManager(t *testing.T) {
 				require.NoError(t, gsData.incomingResponseHookActions.TerminationError)
 			},
 		},
+		"outgoing gs request can receive gs response with additional messages": {
+			responseConfig: gsResponseConfig{
+				dtIsResponse:        true,
+				additionalResponses: 2,
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingResponseHOok()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 3, events.OnResponseReceivedCallCount)
+				additional, err := extension.GetAdditionalMessages(gsData.response)
+				require.NoError(t, err)
+				require.Equal(t, additional[1], events.ResponseReceivedResponse)
+				require.NoError(t, gsData.incomingResponseHookActions.TerminationError)
+			},
+		},
+		"error processing response does not process additional messages": {
+			responseConfig: gsResponseConfig{
+				dtIsResponse:        true,
+				additionalResponses: 2,
+			},
+			events: fakeEvents{
+				OnResponseReceivedErrors: []error{errTransient},
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingResponseHOok()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnResponseReceivedCallCount)
+				require.ErrorIs(t, gsData.incomingResponseHookActions.TerminationError, errTransient)
+			},
+		},
+		"paused and partial responses to a request publish non-terminal events": {
+			options: []Option{RecordChannelHistory(16, 4)},
+			responseConfig: gsResponseConfig{
+				dtIsResponse: true,
+				status:       graphsync.PartialResponse,
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingResponseHOok()
+				gsData.incomingResponseHOok()
+				paused := testharness.NewFakeResponse(gsData.request.ID(), nil, graphsync.RequestPaused)
+				gsData.fgs.IncomingResponseHook(gsData.other, paused, gsData.incomingResponseHookActions)
+				gsData.incomingResponseHOok()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnChannelCompletedCalled)
+				require.NoError(t, gsData.incomingResponseHookActions.TerminationError)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				// an event is published each time the status changes
+				require.Equal(t, []TransportEventCode{PartialResponseEvent, RequestPausedEvent, PartialResponseEvent},
+					responseStatusCodes(gsData.transport.ChannelHistory(chid)))
+			},
+		},
+		"error processing response terminates the request by default": {
+			responseConfig: gsResponseConfig{
+				dtIsResponse: true,
+			},
+			events: fakeEvents{
+				OnResponseReceivedErrors: []error{errTransient},
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingResponseHOok()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnResponseReceivedCallCount)
+				require.ErrorIs(t, gsData.incomingResponseHookActions.TerminationError, errTransient)
+			},
+		},
+		"transient error processing response does not terminate the request": {
+			options: []Option{TransientResponseErrors(isTransient)},
+			responseConfig: gsResponseConfig{
+				dtIsResponse: true,
+			},
+			events: fakeEvents{
+				OnResponseReceivedErrors: []error{errTransient},
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingResponseHOok()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnResponseReceivedCallCount)
+				require.NoError(t, gsData.incomingResponseHookActions.TerminationError)
+			},
+		},
+		"fatal error processing response terminates the request when transient errors are classified": {
+			options: []Option{TransientResponseErrors(isTransient)},
+			responseConfig: gsResponseConfig{
+				dtIsResponse: true,
+			},
+			events: fakeEvents{
+				OnResponseReceivedErrors: []error{errors.New("fatal consumer error")},
+			},
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingResponseHOok()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnResponseReceivedCallCount)
+				require.EqualError(t, gsData.incomingResponseHookActions.TerminationError, "fatal consumer error")
+			},
+		},
 		"outgoing gs request with recognized dt request cannot receive gs response with dt request": {
 			action: func(gsData *harness) {
 				gsData.outgoingRequestHook()
@@ -242,6 +544,68 @@ func TestManager(t *testing.T) {
 				require.Error(t, gsData.incomingResponseHookActions.TerminationError)
 			},
 		},
+		"incoming gs request for a rehydrated channel with no data transferred is paused": {
+			action: func(gsData *harness) {
+				chst := testutil.NewMockChannelState(testutil.MockChannelStateParams{
+					ChannelID: datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
+					IsPull:    true,
+					Self:      gsData.self,
+				})
+				_, _ = gsData.transport.RehydrateChannels([]datatransfer.ChannelState{chst})
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.True(t, gsData.incomingRequestHookActions.Validated)
+				require.True(t, gsData.incomingRequestHookActions.Paused)
+				require.Equal(t, []datatransfer.ChannelID{chid}, gsData.transport.PausedChannels())
+				isPull, err := gsData.transport.IsPull(chid)
+				require.NoError(t, err)
+				require.True(t, isPull)
+			},
+		},
+		"incoming gs request for a rehydrated channel that transferred data is not paused": {
+			action: func(gsData *harness) {
+				chst := testutil.NewMockChannelState(testutil.MockChannelStateParams{
+					ChannelID: datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
+					IsPull:    true,
+					Self:      gsData.self,
+					Sent:      1000,
+				})
+				_, _ = gsData.transport.RehydrateChannels([]datatransfer.ChannelState{chst})
+				gsData.incomingRequestHook()
+				gsData.blockSentListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, gsData.incomingRequestHookActions.Validated)
+				require.False(t, gsData.incomingRequestHookActions.Paused)
+				require.Empty(t, gsData.transport.PausedChannels())
+				// the transfer started before the process restarted
+				require.Equal(t, 0, events.TransferStartedCallCount)
+			},
+		},
+		"rehydrating skips completed channels, other peers' channels and tracked channels": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				tracked := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				completed := datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.self, Initiator: gsData.other}
+				otherPeers := datatransfer.ChannelID{ID: gsData.transferID + 2, Responder: gsData.other, Initiator: gsData.other}
+				active := datatransfer.ChannelID{ID: gsData.transferID + 3, Responder: gsData.other, Initiator: gsData.self}
+				rehydrated, err := gsData.transport.RehydrateChannels([]datatransfer.ChannelState{
+					testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: tracked, Self: gsData.self, Sent: 1000}),
+					testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: completed, Self: gsData.self, Complete: true}),
+					testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: otherPeers, Self: gsData.other}),
+					testutil.NewMockChannelState(testutil.MockChannelStateParams{ChannelID: active, Self: gsData.self, IsPull: true}),
+				})
+				require.NoError(t, err)
+				require.Equal(t, []datatransfer.ChannelID{active}, rehydrated)
+				_, err = gsData.transport.IsPull(completed)
+				require.Error(t, err)
+			},
+		},
 		"incoming gs request with recognized dt request will validate gs request & send dt response": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
@@ -270,6 +634,38 @@ func TestManager(t *testing.T) {
 				})
 			},
 		},
+		"channel metadata is kept by the transport and returned with its channels": {
+			action: func(gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				gsData.transport.SetChannelMetadata(chid, map[string]string{"trace": "abc", "tenant": "t1"})
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				expected := map[string]string{"trace": "abc", "tenant": "t1"}
+				metadata, err := gsData.transport.ChannelMetadata(chid)
+				require.NoError(t, err)
+				require.Equal(t, expected, metadata)
+				require.Equal(t, expected, gsData.transport.ChannelsForPeer(gsData.other).SendingChannels[chid].Metadata)
+
+				// the metadata is not sent to the remote peer
+				for _, ext := range gsData.incomingRequestHookActions.SentExtensions {
+					var buf bytes.Buffer
+					require.NoError(t, dagcbor.Encode(ext.Data, &buf))
+					require.NotContains(t, buf.String(), "abc")
+				}
+
+				// changing the returned map does not change the channel's metadata
+				metadata["trace"] = "changed"
+				metadata, err = gsData.transport.ChannelMetadata(chid)
+				require.NoError(t, err)
+				require.Equal(t, expected, metadata)
+
+				gsData.transport.CleanupChannel(chid)
+				_, err = gsData.transport.ChannelMetadata(chid)
+				require.ErrorIs(t, err, datatransfer.ErrChannelNotFound)
+			},
+		},
 		"incoming gs request with recognized dt response will validate gs request": {
 			requestConfig: gsRequestConfig{
 				dtIsResponse: true,
@@ -300,6 +696,30 @@ func TestManager(t *testing.T) {
 				require.Error(t, gsData.incomingRequestHookActions.TerminationError)
 			},
 		},
+		"malformed data transfer extension on incoming request fires OnMalformedMessage": {
+			requestConfig: gsRequestConfig{
+				dtExtensionMalformed: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, gsData.other, events.MalformedMessagePeer)
+				require.Equal(t, []string{string(extension.ExtensionDataTransfer1_1)}, events.MalformedMessageExtensions)
+				require.Error(t, events.MalformedMessageError)
+				require.Equal(t, gsData.incomingRequestHookActions.TerminationError, events.MalformedMessageError)
+			},
+		},
+		"well formed incoming request does not fire OnMalformedMessage": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
+				require.Empty(t, events.MalformedMessagePeer)
+				require.NoError(t, events.MalformedMessageError)
+			},
+		},
 		"unrecognized incoming dt request will terminate but send response": {
 			events: fakeEvents{
 				RequestReceivedResponse: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
@@ -319,30 +739,65 @@ func TestManager(t *testing.T) {
 				require.Error(t, gsData.incomingRequestHookActions.TerminationError)
 			},
 		},
-		"incoming gs request with recognized dt request will record outgoing blocks": {
+		"incoming gs request with recognized dt request sends multiple response messages in order": {
+			events: fakeEvents{
+				RequestReceivedResponse: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
+				RequestReceivedAdditionalResponses: []datatransfer.Response{
+					testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
+					testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
+				},
+			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.outgoingBlockHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
 				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.True(t, events.OnDataQueuedCalled)
-				require.NoError(t, gsData.outgoingBlockHookActions.TerminationError)
+				require.True(t, gsData.incomingRequestHookActions.Validated)
+				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
+				assertHasExtensionMessage(t, extension.ExtensionIncomingRequest1_1, gsData.incomingRequestHookActions.SentExtensions, events.RequestReceivedResponse)
+				assertHasAdditionalMessages(t, gsData.incomingRequestHookActions.SentExtensions, []datatransfer.Message{
+					events.RequestReceivedAdditionalResponses[0],
+					events.RequestReceivedAdditionalResponses[1],
+				})
 			},
 		},
-
-		"incoming gs request with recognized dt response will record outgoing blocks": {
-			requestConfig: gsRequestConfig{
-				dtIsResponse: true,
+		"incoming gs request with a single response message does not send additional messages": {
+			events: fakeEvents{
+				RequestReceivedResponse: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
 			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.outgoingBlockHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnResponseReceivedCallCount)
-				require.True(t, events.OnDataQueuedCalled)
-				require.NoError(t, gsData.outgoingBlockHookActions.TerminationError)
+				for _, ext := range gsData.incomingRequestHookActions.SentExtensions {
+					require.NotEqual(t, extension.ExtensionAdditionalMessages1_1, ext.Name)
+				}
+			},
+		},
+		"incoming gs request with recognized dt request will record outgoing blocks": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.True(t, events.OnDataQueuedCalled)
+				require.NoError(t, gsData.outgoingBlockHookActions.TerminationError)
+			},
+		},
+
+		"incoming gs request with recognized dt response will record outgoing blocks": {
+			requestConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnResponseReceivedCallCount)
+				require.True(t, events.OnDataQueuedCalled)
+				require.NoError(t, gsData.outgoingBlockHookActions.TerminationError)
 			},
 		},
 		"non-data-transfer request will not record outgoing blocks": {
@@ -387,133 +842,122 @@ func TestManager(t *testing.T) {
 				require.NoError(t, gsData.outgoingBlockHookActions.TerminationError)
 			},
 		},
-		"incoming gs request with recognized dt request will send updates": {
-			action: func(gsData *harness) {
-				gsData.incomingRequestHook()
-				gsData.outgoingBlockHook()
-			},
-			events: fakeEvents{
-				OnDataQueuedMessage: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
-			},
-			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.True(t, events.OnDataQueuedCalled)
-				require.NoError(t, gsData.outgoingBlockHookActions.TerminationError)
-				assertHasExtensionMessage(t, extension.ExtensionOutgoingBlock1_1, gsData.outgoingBlockHookActions.SentExtensions,
-					events.OnDataQueuedMessage)
-			},
-		},
-		"incoming gs request with recognized dt request can receive update": {
+		"weighted channel too far ahead of the other channels to the peer is paused until they catch up": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.requestUpdatedHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 2, events.OnRequestReceivedCallCount)
-				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				otherChid := datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.self, Initiator: gsData.other}
+				gsData.transport.SetChannelWeight(chid, 1)
+				gsData.transport.SetChannelWeight(otherChid, 1)
+
+				// the block puts the channel well ahead of the other channel, so
+				// the response is paused rather than blocking the hook
+				gsData.block = testharness.NewFakeBlockData(1<<20, 1, true)
+				gsData.outgoingBlockHook()
+				require.True(t, gsData.outgoingBlockHookActions.Paused)
+				gsData.fgs.AssertNoResumeReceived(t)
+
+				// once the other channel leaves the schedule the response is
+				// resumed
+				gsData.transport.SetChannelWeight(otherChid, 0)
+				resume := gsData.fgs.AssertResumeReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), resume.RequestID)
 			},
 		},
-		"incoming gs request with recognized dt request cannot receive update with dt response": {
-			updatedConfig: gsRequestConfig{
-				dtIsResponse: true,
+		"outgoing data queued error == pause will list channel as paused": {
+			events: fakeEvents{
+				OnDataQueuedError: datatransfer.ErrPause,
 			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.requestUpdatedHook()
+				gsData.outgoingBlockHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.Equal(t, 0, events.OnResponseReceivedCallCount)
-				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Equal(t, []datatransfer.ChannelID{chid}, gsData.transport.PausedChannels())
+
+				err := gsData.transport.ResumeChannel(gsData.ctx, nil, chid)
+				require.NoError(t, err)
+				require.Empty(t, gsData.transport.PausedChannels())
 			},
 		},
-		"incoming gs request with recognized dt response can receive update": {
-			requestConfig: gsRequestConfig{
-				dtIsResponse: true,
-			},
-			updatedConfig: gsRequestConfig{
-				dtIsResponse: true,
+		"incoming data received error == pause will list channel as paused": {
+			events: fakeEvents{
+				OnDataReceivedError: datatransfer.ErrPause,
 			},
 			action: func(gsData *harness) {
-				gsData.incomingRequestHook()
-				gsData.requestUpdatedHook()
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 2, events.OnResponseReceivedCallCount)
-				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+				require.Equal(t, []datatransfer.ChannelID{{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}}, gsData.transport.PausedChannels())
 			},
 		},
-		"incoming gs request with recognized dt response cannot receive update with dt request": {
-			requestConfig: gsRequestConfig{
-				dtIsResponse: true,
-			},
+		"channel paused and resumed with transport is listed as paused only while paused": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.requestUpdatedHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnResponseReceivedCallCount)
-				require.Equal(t, 0, events.OnRequestReceivedCallCount)
-				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Empty(t, gsData.transport.PausedChannels())
+
+				require.NoError(t, gsData.transport.PauseChannel(gsData.ctx, chid))
+				require.Equal(t, []datatransfer.ChannelID{chid}, gsData.transport.PausedChannels())
+
+				require.NoError(t, gsData.transport.ResumeChannel(gsData.ctx, nil, chid))
+				require.Empty(t, gsData.transport.PausedChannels())
 			},
 		},
-		"incoming gs request with recognized dt request will error with malformed update": {
-			updatedConfig: gsRequestConfig{
-				dtExtensionMalformed: true,
-			},
+		"first block received fires OnTransferStarted once": {
 			action: func(gsData *harness) {
-				gsData.incomingRequestHook()
-				gsData.requestUpdatedHook()
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+				gsData.incomingBlockHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+				require.Equal(t, 1, events.TransferStartedCallCount)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, events.TransferStartedChannelID)
 			},
 		},
-		"incoming gs request with recognized dt request will ignore non-data-transfer update": {
-			updatedConfig: gsRequestConfig{
-				dtExtensionMissing: true,
-			},
+		"first block sent fires OnTransferStarted once": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.requestUpdatedHook()
+				gsData.blockSentListener()
+				gsData.blockSentListener()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+				require.Equal(t, 1, events.TransferStartedCallCount)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, events.TransferStartedChannelID)
 			},
 		},
-		"incoming gs request with recognized dt request can send message on update": {
-			events: fakeEvents{
-				RequestReceivedResponse: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
-			},
+		"block not sent over the wire does not fire OnTransferStarted": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.requestUpdatedHook()
+				gsData.fgs.BlockSentListener(gsData.other, gsData.request, testharness.NewFakeBlockData(rand.Uint64(), int64(rand.Uint32()), false))
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 2, events.OnRequestReceivedCallCount)
-				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
-				assertHasOutgoingMessage(t, gsData.requestUpdatedHookActions.SentExtensions,
-					events.RequestReceivedResponse)
+				require.Zero(t, events.TransferStartedCallCount)
 			},
 		},
-		"recognized incoming request will record successful request completion": {
+		"empty DAG response fires OnTransferStarted when it completes": {
 			responseConfig: gsResponseConfig{
 				status: graphsync.RequestCompletedFull,
 			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.responseCompletedListener()
+				gsData.fgs.BlockSentListener(gsData.other, gsData.request, testharness.NewFakeBlockData(0, 0, false))
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.Zero(t, events.TransferStartedCallCount)
+				gsData.responseCompletedListener()
+				require.Equal(t, 1, events.TransferStartedCallCount)
 				require.True(t, events.OnChannelCompletedCalled)
 				require.True(t, events.ChannelCompletedSuccess)
 			},
 		},
-
-		"recognized incoming request will record unsuccessful request completion": {
+		"response that completes with an error does not fire OnTransferStarted": {
 			responseConfig: gsResponseConfig{
 				status: graphsync.RequestCompletedPartial,
 			},
@@ -522,193 +966,1969 @@ func TestManager(t *testing.T) {
 				gsData.responseCompletedListener()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
 				require.True(t, events.OnChannelCompletedCalled)
-				require.False(t, events.ChannelCompletedSuccess)
+				require.Zero(t, events.TransferStartedCallCount)
 			},
 		},
-		"recognized incoming request will not record request cancellation": {
-			responseConfig: gsResponseConfig{
-				status: graphsync.RequestCancelled,
-			},
+		"empty DAG request fires OnDataReceived, then OnTransferStarted when it completes": {
 			action: func(gsData *harness) {
-				gsData.incomingRequestHook()
-				gsData.responseCompletedListener()
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.False(t, events.OnChannelCompletedCalled)
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				gsData.fgs.IncomingBlockHook(gsData.other, gsData.response, testharness.NewFakeBlockData(0, 0, false), gsData.incomingBlockHookActions)
+				require.True(t, events.OnDataReceivedCalled)
+				require.Zero(t, events.TransferStartedCallCount)
+
+				close(requestReceived.ResponseChan)
+				close(requestReceived.ResponseErrChan)
+				require.Eventually(t, func() bool {
+					return events.OnChannelCompletedCalled == true
+				}, 2*time.Second, 100*time.Millisecond)
+				require.True(t, events.ChannelCompletedSuccess)
+				require.Equal(t, 1, events.TransferStartedCallCount)
 			},
 		},
-		"non-data-transfer request will not record request completed": {
-			requestConfig: gsRequestConfig{
-				dtExtensionMissing: true,
-			},
-			responseConfig: gsResponseConfig{
-				status: graphsync.RequestCompletedPartial,
+		"SequenceMessages assigns increasing sequence numbers to sent messages": {
+			options: []Option{SequenceMessages(true)},
+			events: fakeEvents{
+				OnDataQueuedMessage: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
 			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.responseCompletedListener()
+				gsData.outgoingBlockHook()
+				gsData.outgoingBlockHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 0, events.OnRequestReceivedCallCount)
-				require.False(t, events.OnChannelCompletedCalled)
+				var sequences []uint64
+				for _, ext := range gsData.outgoingBlockHookActions.SentExtensions {
+					if ext.Name != extension.ExtensionOutgoingBlock1_1 {
+						continue
+					}
+					msg, err := message.FromIPLD(ext.Data)
+					require.NoError(t, err)
+					sequences = append(sequences, msg.Sequence())
+				}
+				require.Equal(t, []uint64{1, 2}, sequences)
 			},
 		},
-		"recognized incoming request can be closed": {
+		"messages are not sequenced by default": {
+			events: fakeEvents{
+				OnDataQueuedMessage: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
+			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				require.NoError(t, err)
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				gsData.fgs.AssertCancelReceived(gsData.ctx, t)
-			},
-		},
-		"unrecognized request cannot be closed": {
-			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				require.Error(t, err)
+				assertHasExtensionMessage(t, extension.ExtensionOutgoingBlock1_1, gsData.outgoingBlockHookActions.SentExtensions,
+					events.OnDataQueuedMessage)
 			},
 		},
-		"recognized incoming request that requestor cancelled will not close via graphsync": {
+		"out of order request updates are dropped": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.requestorCancelledListener()
+				gsData.sequencedRequestUpdatedHook(2)
+				gsData.sequencedRequestUpdatedHook(1)
+				gsData.sequencedRequestUpdatedHook(2)
+				gsData.sequencedRequestUpdatedHook(3)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				require.NoError(t, err)
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				gsData.fgs.AssertNoCancelReceived(t)
+				// the original request, then the updates with sequence 2 and 3
+				require.Equal(t, 3, events.OnRequestReceivedCallCount)
+				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
 			},
 		},
-		"recognized incoming request can be paused": {
+		"request updates received after their deadline are dropped": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
+				gsData.deadlineRequestUpdatedHook(time.Now().Add(-time.Minute))
+				gsData.deadlineRequestUpdatedHook(time.Now().Add(time.Minute))
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.PauseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				require.NoError(t, err)
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				gsData.fgs.AssertPauseReceived(gsData.ctx, t)
+				// the original request, then the update that has not expired
+				require.Equal(t, 2, events.OnRequestReceivedCallCount)
+				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
 			},
 		},
-		"unrecognized request cannot be paused": {
+		"retained extensions from the last received message can be read": {
+			options: []Option{RetainExtensions("app/metadata")},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.PauseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				require.Error(t, err)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				dtExt, ok := gsData.request.Extension(extension.ExtensionDataTransfer1_1)
+				require.True(t, ok)
+				request := testharness.NewFakeRequest(gsData.request.ID(), map[graphsync.ExtensionName]datamodel.Node{
+					extension.ExtensionDataTransfer1_1: dtExt,
+					"app/metadata":                     basicnode.NewString("first"),
+					"app/ignored":                      basicnode.NewString("ignored"),
+				}, graphsync.RequestTypeNew)
+				gsData.fgs.IncomingRequestHook(gsData.other, request, gsData.incomingRequestHookActions)
+				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
+
+				data, ok := gsData.transport.LastExtension(chid, "app/metadata")
+				require.True(t, ok)
+				require.Equal(t, basicnode.NewString("first"), data)
+				_, ok = gsData.transport.LastExtension(chid, "app/ignored")
+				require.False(t, ok)
+
+				// an update with the extension replaces the retained data
+				update := testharness.NewFakeRequest(gsData.request.ID(), map[graphsync.ExtensionName]datamodel.Node{
+					"app/metadata": basicnode.NewString("second"),
+				}, graphsync.RequestTypeNew)
+				gsData.fgs.RequestUpdatedHook(gsData.other, request, update, gsData.requestUpdatedHookActions)
+				data, ok = gsData.transport.LastExtension(chid, "app/metadata")
+				require.True(t, ok)
+				require.Equal(t, basicnode.NewString("second"), data)
 			},
 		},
-		"recognized incoming request that requestor cancelled will not pause via graphsync": {
+		"status requests are answered from the transport's records": {
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.requestorCancelledListener()
+				// the byte count is encoded as a signed integer
+				gsData.block = testharness.NewFakeBlockData(uint64(rand.Uint32()), int64(rand.Uint32()), true)
+				gsData.blockSentListener()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.PauseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				require.NoError(t, err)
+				update := testharness.NewFakeRequest(gsData.request.ID(), map[graphsync.ExtensionName]datamodel.Node{
+					extension.ExtensionDataTransfer1_1: message.StatusRequest(gsData.transferID).ToIPLD(),
+				}, graphsync.RequestTypeNew)
+				gsData.fgs.RequestUpdatedHook(gsData.other, gsData.request, update, gsData.requestUpdatedHookActions)
+
+				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+				expected := message.StatusResponse(gsData.transferID, gsData.block.BlockSize(), false)
+				assertHasExtensionMessage(t, extension.ExtensionDataTransfer1_1, gsData.requestUpdatedHookActions.SentExtensions, expected)
+				// the status request is not passed to the events handler
 				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				gsData.fgs.AssertNoPauseReceived(t)
 			},
 		},
-
-		"recognized incoming request can begin processing": {
+		"RequestStatus returns the status reported by the remote peer": {
 			action: func(gsData *harness) {
-				gsData.incomingRequestHook()
-				gsData.incomingRequestProcessingListener()
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.True(t, events.TransferInitiatedCalled)
-				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
-					events.TransferInitiatedChannelID)
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+
+				type result struct {
+					status RemoteStatus
+					err    error
+				}
+				results := make(chan result, 1)
+				go func() {
+					status, err := gsData.transport.RequestStatus(gsData.ctx, chid)
+					results <- result{status, err}
+				}()
+
+				update := gsData.fgs.AssertUpdateReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), update.RequestID)
+				require.True(t, update.DTMessage(t).IsStatus())
+				require.True(t, update.DTMessage(t).IsRequest())
+
+				response := testharness.NewFakeResponse(gsData.request.ID(), map[graphsync.ExtensionName]datamodel.Node{
+					extension.ExtensionDataTransfer1_1: message.StatusResponse(gsData.transferID, 1234, true).ToIPLD(),
+				}, graphsync.PartialResponse)
+				gsData.fgs.IncomingResponseHook(gsData.other, response, gsData.incomingResponseHookActions)
+
+				select {
+				case res := <-results:
+					require.NoError(t, res.err)
+					require.Equal(t, RemoteStatus{BytesTransferred: 1234, Paused: true}, res.status)
+				case <-time.After(time.Second):
+					require.FailNow(t, "RequestStatus did not return")
+				}
+				// the status response is not passed to the events handler
+				require.Equal(t, 0, events.OnResponseReceivedCallCount)
 			},
 		},
-
-		"recognized incoming request can be resumed": {
+		"incoming request validated asynchronously is paused until validation succeeds": {
+			options: []Option{AsyncRequestValidation(true)},
+			events: fakeEvents{
+				RequestReceivedResponse: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
+			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.ResumeChannel(gsData.ctx,
-					gsData.incoming,
-					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
-				)
-				require.NoError(t, err)
+				// the hook accepts the request in the paused state
+				require.True(t, gsData.incomingRequestHookActions.Validated)
+				require.True(t, gsData.incomingRequestHookActions.Paused)
+				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
+
+				// the response message is sent once validation completes,
+				// then the response is resumed
+				update := gsData.fgs.AssertUpdateReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), update.RequestID)
+				assertHasExtensionMessage(t, extension.ExtensionIncomingRequest1_1, update.Extensions, events.RequestReceivedResponse)
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertResumeReceived(gsData.ctx, t).RequestID)
 				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				gsData.fgs.AssertResumeReceived(gsData.ctx, t)
+				require.Empty(t, gsData.transport.PausedChannels())
+				gsData.fgs.AssertNoCancelReceived(t)
 			},
 		},
-
-		"unrecognized request cannot be resumed": {
+		"incoming request validated asynchronously is cancelled if validation fails": {
+			options: []Option{AsyncRequestValidation(true)},
+			events: fakeEvents{
+				OnRequestReceivedErrors: []error{errors.New("something went wrong")},
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, gsData.incomingRequestHookActions.Paused)
+				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
+
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+				gsData.fgs.AssertNoResumeReceived(t)
+				require.Empty(t, gsData.transport.PausedChannels())
+			},
+		},
+		"ChannelsByStatus reports paused and requester cancelled responses": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Equal(t, map[TransportStatus][]datatransfer.ChannelID{
+					TransportStatusActive: {chid},
+				}, gsData.transport.ChannelsByStatus())
+
+				require.NoError(t, gsData.transport.PauseChannel(gsData.ctx, chid))
+				require.Equal(t, map[TransportStatus][]datatransfer.ChannelID{
+					TransportStatusPaused: {chid},
+				}, gsData.transport.ChannelsByStatus())
+
+				gsData.requestorCancelledListener()
+				require.Equal(t, map[TransportStatus][]datatransfer.ChannelID{
+					TransportStatusRequesterCancelled: {chid},
+				}, gsData.transport.ChannelsByStatus())
+			},
+		},
+		"ChannelsByStatus reports pending, active and completed requests": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+
+				opened := make(chan error, 1)
+				go func() {
+					opened <- gsData.transport.OpenChannel(
+						gsData.ctx,
+						gsData.other,
+						chid,
+						cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+						stor,
+						nil,
+						gsData.outgoing)
+				}()
+
+				// the request is pending until graphsync opens it
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				require.Equal(t, map[TransportStatus][]datatransfer.ChannelID{
+					TransportStatusPending: {chid},
+				}, gsData.transport.ChannelsByStatus())
+
+				gsData.outgoingRequestHook()
+				require.NoError(t, <-opened)
+				require.Equal(t, map[TransportStatus][]datatransfer.ChannelID{
+					TransportStatusActive: {chid},
+				}, gsData.transport.ChannelsByStatus())
+
+				close(requestReceived.ResponseChan)
+				close(requestReceived.ResponseErrChan)
+				require.Eventually(t, func() bool {
+					_, ok := gsData.transport.ChannelsByStatus()[TransportStatusCompleted]
+					return ok
+				}, 2*time.Second, 10*time.Millisecond)
+				require.Equal(t, map[TransportStatus][]datatransfer.ChannelID{
+					TransportStatusCompleted: {chid},
+				}, gsData.transport.ChannelsByStatus())
+			},
+		},
+		"FindChannels returns channels matching the predicate": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.altIncomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				sending := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				receiving := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				require.ElementsMatch(t, []datatransfer.ChannelID{sending, receiving}, gsData.transport.FindChannels(func(chid datatransfer.ChannelID) bool {
+					return chid.ID == gsData.transferID
+				}))
+				require.Equal(t, []datatransfer.ChannelID{sending}, gsData.transport.FindChannels(func(chid datatransfer.ChannelID) bool {
+					return chid.Initiator == gsData.other
+				}))
+				require.Empty(t, gsData.transport.FindChannels(func(chid datatransfer.ChannelID) bool {
+					return false
+				}))
+			},
+		},
+		"FindChannels predicate can call back into the transport": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.NoError(t, gsData.transport.PauseChannel(gsData.ctx, chid))
+				paused := gsData.transport.FindChannels(func(chid datatransfer.ChannelID) bool {
+					for _, pausedChid := range gsData.transport.PausedChannels() {
+						if pausedChid == chid {
+							return true
+						}
+					}
+					return false
+				})
+				require.Equal(t, []datatransfer.ChannelID{chid}, paused)
+			},
+		},
+		"incoming gs request with recognized dt request will send updates": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
+			},
+			events: fakeEvents{
+				OnDataQueuedMessage: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.True(t, events.OnDataQueuedCalled)
+				require.NoError(t, gsData.outgoingBlockHookActions.TerminationError)
+				assertHasExtensionMessage(t, extension.ExtensionOutgoingBlock1_1, gsData.outgoingBlockHookActions.SentExtensions,
+					events.OnDataQueuedMessage)
+			},
+		},
+		"incoming gs request with recognized dt request can receive update": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2, events.OnRequestReceivedCallCount)
+				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt request cannot receive update with different base CID": {
+			updatedConfig: gsRequestConfig{
+				dtMismatchedBaseCid: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt request cannot receive update with different selector": {
+			updatedConfig: gsRequestConfig{
+				dtMismatchedSelector: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt request cannot receive update with dt response": {
+			updatedConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.Equal(t, 0, events.OnResponseReceivedCallCount)
+				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt response can receive update": {
+			requestConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			updatedConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2, events.OnResponseReceivedCallCount)
+				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt response cannot receive update with dt request": {
+			requestConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnResponseReceivedCallCount)
+				require.Equal(t, 0, events.OnRequestReceivedCallCount)
+				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt request will error with malformed update": {
+			updatedConfig: gsRequestConfig{
+				dtExtensionMalformed: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.Error(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt request will ignore non-data-transfer update": {
+			updatedConfig: gsRequestConfig{
+				dtExtensionMissing: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+			},
+		},
+		"incoming gs request with recognized dt request can send message on update": {
+			events: fakeEvents{
+				RequestReceivedResponse: testutil.NewDTResponse(t, datatransfer.TransferID(rand.Uint32())),
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestUpdatedHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2, events.OnRequestReceivedCallCount)
+				require.NoError(t, gsData.requestUpdatedHookActions.TerminationError)
+				assertHasOutgoingMessage(t, gsData.requestUpdatedHookActions.SentExtensions,
+					events.RequestReceivedResponse)
+			},
+		},
+		"recognized incoming request will record successful request completion": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedFull,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.True(t, events.OnChannelCompletedCalled)
+				require.True(t, events.ChannelCompletedSuccess)
+			},
+		},
+
+		"recognized incoming request will record unsuccessful request completion": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedPartial,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.True(t, events.OnChannelCompletedCalled)
+				require.False(t, events.ChannelCompletedSuccess)
+				var completionErr CompletionError
+				require.ErrorAs(t, events.ChannelCompletedErr, &completionErr)
+				require.Equal(t, graphsync.RequestCompletedPartial, completionErr.StatusCode)
+				require.False(t, datatransfer.IsRetryable(events.ChannelCompletedErr))
+			},
+		},
+		"detailed completion result reports a full response and the bytes sent": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedFull,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.blockSentListener()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.NotNil(t, events.ChannelCompletedResult)
+				require.Equal(t, datatransfer.CompletionResult{
+					Kind:             datatransfer.CompletionFull,
+					Side:             datatransfer.CompletionSideResponder,
+					BytesTransferred: gsData.block.BlockSize(),
+					StatusCode:       int(graphsync.RequestCompletedFull),
+				}, *events.ChannelCompletedResult)
+			},
+		},
+		"detailed completion result reports a partial response": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedPartial,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.NotNil(t, events.ChannelCompletedResult)
+				require.Equal(t, datatransfer.CompletionPartial, events.ChannelCompletedResult.Kind)
+				require.Equal(t, datatransfer.CompletionSideResponder, events.ChannelCompletedResult.Side)
+				require.Equal(t, int(graphsync.RequestCompletedPartial), events.ChannelCompletedResult.StatusCode)
+				require.Equal(t, events.ChannelCompletedErr, events.ChannelCompletedResult.Err)
+				require.Zero(t, events.ChannelCompletedResult.BytesTransferred)
+			},
+		},
+		"paused status on response completion is not reported as a completion": {
+			options: []Option{RecordChannelHistory(16, 4)},
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestPaused,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.False(t, events.OnChannelCompletedCalled)
+				require.Nil(t, events.ChannelCompletedResult)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Equal(t, []TransportEventCode{RequestPausedEvent}, responseStatusCodes(gsData.transport.ChannelHistory(chid)))
+			},
+		},
+		"partial response status on response completion is not reported as a completion": {
+			options: []Option{RecordChannelHistory(16, 4)},
+			responseConfig: gsResponseConfig{
+				status: graphsync.PartialResponse,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnChannelCompletedCalled)
+				require.Nil(t, events.ChannelCompletedResult)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Equal(t, []TransportEventCode{PartialResponseEvent}, responseStatusCodes(gsData.transport.ChannelHistory(chid)))
+			},
+		},
+		"recognized incoming request will not record request cancellation": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCancelled,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.False(t, events.OnChannelCompletedCalled)
+			},
+		},
+		"non-data-transfer request will not record request completed": {
+			requestConfig: gsRequestConfig{
+				dtExtensionMissing: true,
+			},
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedPartial,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 0, events.OnRequestReceivedCallCount)
+				require.False(t, events.OnChannelCompletedCalled)
+			},
+		},
+		"recognized incoming request can be closed": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.NoError(t, err)
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				gsData.fgs.AssertCancelReceived(gsData.ctx, t)
+			},
+		},
+		"channel can be closed while its graphsync request is being opened": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				stor, _ := gsData.outgoing.Selector()
+
+				// open the channel, but never fire the outgoing request hook
+				errChan := make(chan error, 1)
+				go func() {
+					errChan <- gsData.transport.OpenChannel(gsData.ctx, gsData.other, chid, cidlink.Link{Cid: gsData.outgoing.BaseCid()}, stor, nil, gsData.outgoing)
+				}()
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				closeCtx, cancel := context.WithTimeout(gsData.ctx, time.Second)
+				defer cancel()
+				require.NoError(t, gsData.transport.CloseChannel(closeCtx, chid))
+
+				select {
+				case err := <-errChan:
+					require.ErrorIs(t, err, context.Canceled)
+				case <-closeCtx.Done():
+					t.Fatal("OpenChannel did not return after the channel was closed")
+				}
+				gsData.fgs.AssertNoCancelReceived(t)
+			},
+		},
+		"recognized incoming request can be failed with a reason": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				reason := errors.New("provider maintenance")
+				err := gsData.transport.FailChannel(gsData.ctx, chid, reason)
+				require.NoError(t, err)
+
+				update := gsData.fgs.AssertUpdateReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), update.RequestID)
+				expected, err := message.WithReason(message.CancelResponse(gsData.transferID), reason.Error())
+				require.NoError(t, err)
+				assertHasExtensionMessage(t, extension.ExtensionDataTransfer1_1, update.Extensions, expected)
+
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+				require.True(t, events.OnChannelErrorCalled)
+				require.Equal(t, chid, events.OnChannelErrorChannelID)
+				require.Equal(t, reason, events.OnChannelErrorReason)
+			},
+		},
+		"recognized incoming request can be rejected with a reason": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				reason := errors.New("transfer cannot be resumed")
+				err := gsData.transport.RejectResponse(gsData.ctx, chid, reason)
+				require.NoError(t, err)
+
+				update := gsData.fgs.AssertUpdateReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), update.RequestID)
+				rejection, err := message.VoucherResultResponse(gsData.transferID, false, false, nil)
+				require.NoError(t, err)
+				expected, err := message.WithReason(rejection, reason.Error())
+				require.NoError(t, err)
+				assertHasExtensionMessage(t, extension.ExtensionDataTransfer1_1, update.Extensions, expected)
+
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+				require.False(t, events.OnChannelErrorCalled)
+			},
+		},
+		"only responses can be rejected": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				err := gsData.transport.RejectResponse(gsData.ctx, chid, errors.New("transfer cannot be resumed"))
+				require.ErrorIs(t, err, datatransfer.ErrChannelNotReady)
+				gsData.fgs.AssertNoUpdateReceived(t)
+				gsData.fgs.AssertNoCancelReceived(t)
+			},
+		},
+		"cancel response with a reason is passed to OnResponseReceived": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				cancel, err := message.WithReason(message.CancelResponse(gsData.transferID), "provider maintenance")
+				require.NoError(t, err)
+				gsData.response = testharness.NewFakeResponse(gsData.request.ID(), map[graphsync.ExtensionName]datamodel.Node{
+					extension.ExtensionDataTransfer1_1: cancel.ToIPLD(),
+				}, graphsync.PartialResponse)
+				gsData.incomingResponseHOok()
+
+				require.Equal(t, 1, events.OnResponseReceivedCallCount)
+				require.True(t, events.ResponseReceivedResponse.IsCancel())
+				require.Equal(t, "provider maintenance", events.ResponseReceivedResponse.Reason())
+			},
+		},
+		"unrecognized request cannot be closed": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.Error(t, err)
+			},
+		},
+		"recognized incoming request that requestor cancelled will not close via graphsync": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.NoError(t, err)
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				gsData.fgs.AssertNoCancelReceived(t)
+			},
+		},
+		"reopened push request cancels the previous graphsync response": {
+			requestConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.altIncomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2, events.OnResponseReceivedCallCount)
+				require.False(t, gsData.incomingRequestHookActions.Paused)
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+
+				// the channel should now be associated with the new request
+				err := gsData.transport.PauseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				require.NoError(t, err)
+				require.Equal(t, gsData.altRequest.ID(), gsData.fgs.AssertPauseReceived(gsData.ctx, t))
+			},
+		},
+		"repeated push request with same graphsync request id does not cancel response": {
+			requestConfig: gsRequestConfig{
+				dtIsResponse: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2, events.OnResponseReceivedCallCount)
+				gsData.fgs.AssertNoCancelReceived(t)
+			},
+		},
+		"channels with a peer can be paused and resumed together": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+
+				// pausing a different peer does not affect the channel
+				require.Empty(t, gsData.transport.PausePeer(gsData.ctx, peer.ID("unrelated")))
+				gsData.fgs.AssertNoPauseReceived(t)
+
+				require.Empty(t, gsData.transport.PausePeer(gsData.ctx, gsData.other))
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertPauseReceived(gsData.ctx, t))
+				require.Equal(t, []datatransfer.ChannelID{chid}, gsData.transport.PausedChannels())
+
+				// channels that are already paused are not paused again
+				require.Empty(t, gsData.transport.PausePeer(gsData.ctx, gsData.other))
+				gsData.fgs.AssertNoPauseReceived(t)
+
+				var resumed []datatransfer.ChannelID
+				errs := gsData.transport.ResumePeer(gsData.ctx, gsData.other, func(chid datatransfer.ChannelID) datatransfer.Message {
+					resumed = append(resumed, chid)
+					return nil
+				})
+				require.Empty(t, errs)
+				require.Equal(t, []datatransfer.ChannelID{chid}, resumed)
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertResumeReceived(gsData.ctx, t).RequestID)
+				require.Empty(t, gsData.transport.PausedChannels())
+			},
+		},
+		"channel closed after drain waits for queued blocks to be sent": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				errChan := make(chan error, 1)
+				go func() {
+					errChan <- gsData.transport.CloseChannelAfterDrain(gsData.ctx, chid, 2*time.Second)
+				}()
+
+				time.Sleep(50 * time.Millisecond)
+				gsData.fgs.AssertNoCancelReceived(t)
+
+				gsData.blockSentListener()
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+				require.NoError(t, <-errChan)
+			},
+		},
+		"channel closed after drain is cancelled when drain times out": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				err := gsData.transport.CloseChannelAfterDrain(gsData.ctx, chid, 50*time.Millisecond)
+				require.NoError(t, err)
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+			},
+		},
+		"blocks are not queued on a channel after drain starts": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				err := gsData.transport.CloseChannelAfterDrain(gsData.ctx, chid, time.Second)
+				require.NoError(t, err)
+				gsData.fgs.AssertCancelReceived(gsData.ctx, t)
+
+				gsData.outgoingBlockHook()
+				require.True(t, gsData.outgoingBlockHookActions.Paused)
+			},
+		},
+		"restarted incoming request that has not started is paused": {
+			events: fakeEvents{
+				OnRequestReceivedErrors: []error{datatransfer.ErrPause},
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.incomingRequestHookActions = &testharness.FakeIncomingRequestHookActions{}
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2, events.OnRequestReceivedCallCount)
+				require.True(t, gsData.incomingRequestHookActions.Paused)
+			},
+		},
+		"restarted incoming request that has not started is not paused when auto pause restart is disabled": {
+			options: []Option{AutoPauseRestart(false)},
+			events: fakeEvents{
+				OnRequestReceivedErrors: []error{datatransfer.ErrPause},
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.incomingRequestHookActions = &testharness.FakeIncomingRequestHookActions{}
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2, events.OnRequestReceivedCallCount)
+				require.False(t, gsData.incomingRequestHookActions.Paused)
+				require.True(t, gsData.incomingRequestHookActions.Validated)
+			},
+		},
+		"try pause fails on channel without a graphsync request": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				err := gsData.transport.TryPauseChannel(gsData.ctx, chid)
+				require.True(t, errors.Is(err, datatransfer.ErrChannelNotFound))
+
+				gsData.transport.TrackReceivedCids(chid)
+				err = gsData.transport.TryPauseChannel(gsData.ctx, chid)
+				require.True(t, errors.Is(err, datatransfer.ErrChannelNotReady))
+				err = gsData.transport.TryResumeChannel(gsData.ctx, nil, chid)
+				require.True(t, errors.Is(err, datatransfer.ErrChannelNotReady))
+				gsData.fgs.AssertNoPauseReceived(t)
+				gsData.fgs.AssertNoResumeReceived(t)
+			},
+		},
+		"try pause and resume succeed on recognized incoming request": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.NoError(t, gsData.transport.TryPauseChannel(gsData.ctx, chid))
+				gsData.fgs.AssertPauseReceived(gsData.ctx, t)
+				require.NoError(t, gsData.transport.TryResumeChannel(gsData.ctx, nil, chid))
+				gsData.fgs.AssertResumeReceived(gsData.ctx, t)
+			},
+		},
+		"recognized incoming request can be paused": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.PauseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.NoError(t, err)
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				gsData.fgs.AssertPauseReceived(gsData.ctx, t)
+			},
+		},
+		"unrecognized request cannot be paused": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.PauseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.Error(t, err)
+			},
+		},
+		"recognized incoming request that requestor cancelled fires request cancelled event": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.OnRequestCancelledCalled)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, events.OnRequestCancelledChannelId)
+				require.True(t, errors.Is(events.OnRequestCancelledError, datatransfer.ErrRequestorCancelled))
+				require.Contains(t, events.OnRequestCancelledError.Error(), gsData.other.String())
+			},
+		},
+		"unrecognized request cancelled by requestor does not fire event": {
+			action: func(gsData *harness) {
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnRequestCancelledCalled)
+			},
+		},
+		"recognized incoming request that requestor cancelled will not pause via graphsync": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.PauseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				require.NoError(t, err)
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				gsData.fgs.AssertNoPauseReceived(t)
+			},
+		},
+
+		"recognized incoming request can begin processing": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.incomingRequestProcessingListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.TransferInitiatedCalled)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
+					events.TransferInitiatedChannelID)
+			},
+		},
+
+		"recognized incoming request can be resumed": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.ResumeChannel(gsData.ctx,
+					gsData.incoming,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
+				)
+				require.NoError(t, err)
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				gsData.fgs.AssertResumeReceived(gsData.ctx, t)
+			},
+		},
+
+		"unrecognized request cannot be resumed": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.ResumeChannel(gsData.ctx,
+					gsData.incoming,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
+				)
+				require.Error(t, err)
+			},
+		},
+		"recognized incoming request that requestor cancelled will not resume via graphsync but will resume otherwise": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				err := gsData.transport.ResumeChannel(gsData.ctx,
+					gsData.incoming,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
+				)
+				require.NoError(t, err)
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				gsData.fgs.AssertNoResumeReceived(t)
+				gsData.incomingRequestHook()
+				assertHasOutgoingMessage(t, gsData.incomingRequestHookActions.SentExtensions, gsData.incoming)
+			},
+		},
+		"resuming a response the requestor cancelled before the cancel is recorded queues the message": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+
+				// graphsync has already dropped the cancelled response, but
+				// the requestor cancelled listener has not been called yet
+				gsData.fgs.ReturnedResumeError = graphsync.RequestNotFoundErr{}
+				err := gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.NoError(t, err)
+				gsData.fgs.AssertResumeReceived(gsData.ctx, t)
+				pending, err := gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.NotEmpty(t, pending)
+
+				// the listener is called late, and the queued message is sent
+				// when the requestor restarts the request
+				gsData.requestorCancelledListener()
+				gsData.fgs.ReturnedResumeError = nil
+				gsData.incomingRequestHook()
+				assertHasOutgoingMessage(t, gsData.incomingRequestHookActions.SentExtensions, gsData.incoming)
+			},
+		},
+		"other errors resuming a response are returned": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				gsData.fgs.ReturnedResumeError = errors.New("request is not paused")
+				err := gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.EqualError(t, err, "request is not paused")
+				pending, err := gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.Empty(t, pending)
+			},
+		},
+		"extensions queued for a cancelled request can be inspected and cleared": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				pending, err := gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.Empty(t, pending)
+
+				err = gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.NoError(t, err)
+				pending, err = gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.NotEmpty(t, pending)
+
+				require.NoError(t, gsData.transport.ClearPendingExtensions(chid))
+				cleared, err := gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.Empty(t, cleared)
+
+				// the cleared extensions are not sent when the request is restarted
+				gsData.incomingRequestHook()
+				for _, ext := range pending {
+					require.NotContains(t, gsData.incomingRequestHookActions.SentExtensions, ext)
+				}
+
+				_, err = gsData.transport.PendingExtensions(datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.self, Initiator: gsData.other})
+				require.ErrorIs(t, err, datatransfer.ErrChannelNotFound)
+			},
+		},
+		"queuing extensions for a cancelled request beyond the per-channel limit fails": {
+			options: []Option{MaxPendingExtensionBytes(1, 0)},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				err := gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.ErrorIs(t, err, datatransfer.ErrPendingExtensionsLimit)
+				pending, err := gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.Empty(t, pending)
+				require.Zero(t, gsData.transport.PendingExtensionBytes())
+			},
+		},
+		"queuing extensions for a cancelled request beyond the total limit fails": {
+			options: []Option{MaxPendingExtensionBytes(0, 1)},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.fgs.ReturnedResumeError = graphsync.RequestNotFoundErr{}
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				err := gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.ErrorIs(t, err, datatransfer.ErrPendingExtensionsLimit)
+				pending, err := gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.Empty(t, pending)
+				require.Zero(t, gsData.transport.PendingExtensionBytes())
+			},
+		},
+		"extensions queued within the limits are counted until they are sent or cleared": {
+			options: []Option{MaxPendingExtensionBytes(1<<20, 1<<20)},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.requestorCancelledListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				err := gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.NoError(t, err)
+				pending, err := gsData.transport.PendingExtensions(chid)
+				require.NoError(t, err)
+				require.NotEmpty(t, pending)
+				size := gsData.transport.PendingExtensionBytes()
+				require.NotZero(t, size)
+
+				require.NoError(t, gsData.transport.ClearPendingExtensions(chid))
+				require.Zero(t, gsData.transport.PendingExtensionBytes())
+
+				err = gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.NoError(t, err)
+				require.Equal(t, size, gsData.transport.PendingExtensionBytes())
+
+				// the queued message is sent when the requestor restarts the request
+				gsData.incomingRequestHook()
+				assertHasOutgoingMessage(t, gsData.incomingRequestHookActions.SentExtensions, gsData.incoming)
+				require.Zero(t, gsData.transport.PendingExtensionBytes())
+			},
+		},
+		"recognized incoming request will record network send error": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.networkErrorListener(errors.New("something went wrong"))
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.True(t, events.OnSendDataErrorCalled)
+			},
+		},
+		"recognized outgoing request will record network send error": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.networkErrorListener(errors.New("something went wrong"))
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.OnSendDataErrorCalled)
+			},
+		},
+		"recognized incoming request will record network receive error": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.receiverNetworkErrorListener(errors.New("something went wrong"))
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.True(t, events.OnReceiveDataErrorCalled)
+			},
+		},
+		"recognized outgoing request will record network receive error": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.receiverNetworkErrorListener(errors.New("something went wrong"))
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.OnReceiveDataErrorCalled)
+			},
+		},
+		"open channel cancelled before the request hook fires cleans up the late request": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				stor, _ := gsData.outgoing.Selector()
+
+				ctx, cancel := context.WithCancel(gsData.ctx)
+				errChan := make(chan error, 1)
+				go func() {
+					errChan <- gsData.transport.OpenChannel(ctx, gsData.other, chid, cidlink.Link{Cid: gsData.outgoing.BaseCid()}, stor, nil, gsData.outgoing)
+				}()
+				gsRequest := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				cancel()
+				require.ErrorIs(t, <-errChan, context.Canceled)
+
+				// the request hook fires after OpenChannel has given up
+				gsData.outgoingRequestHook()
+				require.Contains(t, gsData.transport.ChannelsForPeer(gsData.other).ReceivingChannels, chid)
+
+				// once graphsync finishes with the request, its mapping is removed
+				close(gsRequest.ResponseChan)
+				close(gsRequest.ResponseErrChan)
+				require.Eventually(t, func() bool {
+					return len(gsData.transport.ChannelsForPeer(gsData.other).ReceivingChannels) == 0
+				}, time.Second, 10*time.Millisecond)
+
+				// the next request for the channel is not confused with the late one
+				go gsData.altOutgoingRequestHook()
+				err := gsData.transport.OpenChannel(gsData.ctx, gsData.other, chid, cidlink.Link{Cid: gsData.outgoing.BaseCid()}, stor, nil, gsData.outgoing)
+				require.NoError(t, err)
+				require.Equal(t, gsData.altRequest.ID(), gsData.transport.ChannelsForPeer(gsData.other).ReceivingChannels[chid].Current)
+			},
+		},
+		"progress handler returning ErrPause pauses the request": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.UseProgressHandler(chid, func(graphsync.ResponseProgress) error {
+					return datatransfer.ErrPause
+				})
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				err := gsData.transport.OpenChannel(gsData.ctx, gsData.other, chid, cidlink.Link{Cid: gsData.outgoing.BaseCid()}, stor, nil, gsData.outgoing)
+				require.NoError(t, err)
+				gsRequest := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				gsRequest.ResponseChan <- graphsync.ResponseProgress{}
+				requestID := gsData.fgs.AssertPauseReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), requestID)
+				require.Equal(t, []datatransfer.ChannelID{chid}, gsData.transport.PausedChannels())
+			},
+		},
+		"progress handler returning an error cancels the request": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.UseProgressHandler(chid, func(graphsync.ResponseProgress) error {
+					return errors.New("something went wrong")
+				})
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				err := gsData.transport.OpenChannel(gsData.ctx, gsData.other, chid, cidlink.Link{Cid: gsData.outgoing.BaseCid()}, stor, nil, gsData.outgoing)
+				require.NoError(t, err)
+				gsRequest := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				gsRequest.ResponseChan <- graphsync.ResponseProgress{}
+				requestID := gsData.fgs.AssertCancelReceived(gsData.ctx, t)
+				require.Equal(t, gsData.request.ID(), requestID)
+
+				close(gsRequest.ResponseChan)
+				close(gsRequest.ResponseErrChan)
+				require.Eventually(t, func() bool {
+					return events.OnChannelCompletedCalled
+				}, time.Second, 10*time.Millisecond)
+				require.False(t, events.ChannelCompletedSuccess)
+			},
+		},
+		"open channel adds block count to the DoNotSendFirstBlocks extension for v1.2 protocol": {
+			action: func(gsData *harness) {
+				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 2})
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					channel,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				ext := requestReceived.Extensions
+				require.Len(t, ext, 2)
+				doNotSend := ext[1]
+
+				name := doNotSend.Name
+				require.Equal(t, graphsync.ExtensionsDoNotSendFirstBlocks, name)
+				data := doNotSend.Data
+				blockCount, err := donotsendfirstblocks.DecodeDoNotSendFirstBlocks(data)
+				require.NoError(t, err)
+				require.EqualValues(t, blockCount, 2)
+			},
+		},
+		"request received listener is called once an incoming request is processed": {
+			options: []Option{RegisterRequestReceivedListener(func(chid datatransfer.ChannelID) {
+				requestsReceived <- chid
+			})},
+			action: func(gsData *harness) {
+				go gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				select {
+				case <-gsData.ctx.Done():
+					t.Fatal("request received listener was not called")
+				case chid := <-requestsReceived:
+					require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, chid)
+				}
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
+			},
+		},
+		"outgoing request listener is called once graphsync opens a request": {
+			options: []Option{RegisterOutgoingRequestListener(func(chid datatransfer.ChannelID) {
+				outgoingRequests <- chid
+			})},
+			action: func(gsData *harness) {
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				select {
+				case <-gsData.ctx.Done():
+					t.Fatal("outgoing request listener was not called")
+				case chid := <-outgoingRequests:
+					require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, chid)
+				}
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, events.ChannelOpenedChannelID)
+			},
+		},
+		"open channel attaches extra extensions to the graphsync request": {
+			options: []Option{ExtraExtensions([]graphsync.ExtensionData{{
+				Name: graphsync.ExtensionName("app/extension"),
+				Data: basicnode.NewString("hello"),
+			}})},
+			action: func(gsData *harness) {
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				ext := requestReceived.Extensions
+				require.Len(t, ext, 2)
+				assertHasOutgoingMessage(t, ext, gsData.outgoing)
+				// extensions are sorted by name
+				require.Equal(t, graphsync.ExtensionName("app/extension"), ext[0].Name)
+				require.True(t, ipld.DeepEqual(basicnode.NewString("hello"), ext[0].Data))
+			},
+		},
+		"sticky extensions are attached to every request opened for the channel": {
+			options: []Option{ExtraExtensions([]graphsync.ExtensionData{{
+				Name: graphsync.ExtensionName("app/extension"),
+				Data: basicnode.NewString("extra"),
+			}, {
+				Name: graphsync.ExtensionName("app/other"),
+				Data: basicnode.NewString("other"),
+			}})},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.SetStickyExtensions(chid, []graphsync.ExtensionData{{
+					Name: graphsync.ExtensionName("app/extension"),
+					Data: basicnode.NewString("sticky"),
+				}})
+				stor, _ := gsData.outgoing.Selector()
+
+				// the sticky extensions are sent on the first request and on
+				// the request opened when the channel is restarted
+				for i := 0; i < 2; i++ {
+					go gsData.outgoingRequestHook()
+					err := gsData.transport.OpenChannel(
+						gsData.ctx,
+						gsData.other,
+						chid,
+						cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+						stor,
+						nil,
+						gsData.outgoing)
+					require.NoError(t, err)
+
+					requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+					ext := requestReceived.Extensions
+					require.Len(t, ext, 3)
+					assertHasOutgoingMessage(t, ext, gsData.outgoing)
+					// the sticky extension takes precedence over the extra
+					// extension with the same name
+					require.Equal(t, graphsync.ExtensionName("app/extension"), ext[0].Name)
+					require.True(t, ipld.DeepEqual(basicnode.NewString("sticky"), ext[0].Data))
+					require.Equal(t, graphsync.ExtensionName("app/other"), ext[1].Name)
+				}
+			},
+		},
+		"open channel errors if sticky extensions collide with data transfer extensions": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.SetStickyExtensions(chid, []graphsync.ExtensionData{{
+					Name: extension.ExtensionDataTransfer1_1,
+					Data: basicnode.NewString("hello"),
+				}})
+				stor, _ := gsData.outgoing.Selector()
+				err := gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					chid,
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+				require.Error(t, err)
+				gsData.fgs.AssertNoRequestReceived(t)
+			},
+		},
+		"open channel uses per-channel supported extensions": {
+			action: func(gsData *harness) {
+				stor, _ := gsData.outgoing.Selector()
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.UseExtensions(chid, []graphsync.ExtensionName{extension.ExtensionIncomingRequest1_1})
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					chid,
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				ext := requestReceived.Extensions
+				require.Len(t, ext, 1)
+				require.Equal(t, extension.ExtensionIncomingRequest1_1, ext[0].Name)
+				assertDecodesToMessage(t, ext[0].Data, gsData.outgoing)
+			},
+		},
+		"resume uses per-channel supported extensions": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				gsData.transport.UseExtensions(chid, []graphsync.ExtensionName{extension.ExtensionOutgoingBlock1_1})
+				err := gsData.transport.ResumeChannel(gsData.ctx, gsData.incoming, chid)
+				require.NoError(t, err)
+
+				resume := gsData.fgs.AssertResumeReceived(gsData.ctx, t)
+				require.Len(t, resume.Extensions, 1)
+				require.Equal(t, extension.ExtensionOutgoingBlock1_1, resume.Extensions[0].Name)
+			},
+		},
+		"open channel errors if extra extensions collide with data transfer extensions": {
+			options: []Option{ExtraExtensions([]graphsync.ExtensionData{{
+				Name: extension.ExtensionDataTransfer1_1,
+				Data: basicnode.NewString("hello"),
+			}})},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				stor, _ := gsData.outgoing.Selector()
+				err := gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+				require.Error(t, err)
+				gsData.fgs.AssertNoRequestReceived(t)
+			},
+		},
+		"received cids are recorded when tracking is enabled for the channel": {
+			action: func(gsData *harness) {
+				gsData.transport.TrackReceivedCids(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				cids, err := gsData.transport.ReceivedCids(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				require.NoError(t, err)
+				require.Equal(t, []cid.Cid{gsData.block.Link().(cidlink.Link).Cid}, cids)
+			},
+		},
+		"received cids are not available when tracking is not enabled for the channel": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				_, err := gsData.transport.ReceivedCids(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				require.Error(t, err)
+			},
+		},
+		"open channel adds tracked received cids to the DoNotSendCIDs extension": {
+			action: func(gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.TrackReceivedCids(chid)
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+
+				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 1})
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.altOutgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					chid,
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					channel,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				ext := requestReceived.Extensions
+				require.Len(t, ext, 3)
+				doNotSend := ext[1]
+				require.Equal(t, graphsync.ExtensionDoNotSendCIDs, doNotSend.Name)
+				cids, err := cidset.DecodeCidSet(doNotSend.Data)
+				require.NoError(t, err)
+				require.Equal(t, 1, cids.Len())
+				require.True(t, cids.Has(gsData.block.Link().(cidlink.Link).Cid))
+			},
+		},
+		"open channel sends supplied do not send cids in place of tracked received cids": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.transport.TrackReceivedCids(chid)
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+
+				doNotSendCids := testutil.GenerateCids(3)
+				cids := make(chan cid.Cid, len(doNotSendCids))
+				for _, c := range doNotSendCids {
+					cids <- c
+				}
+				close(cids)
+				encoded, err := EncodeDoNotSendCids(gsData.ctx, cids)
+				require.NoError(t, err)
+				gsData.transport.UseDoNotSendCids(chid, encoded)
+
+				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 1})
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.altOutgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					chid,
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					channel,
+					gsData.outgoing)
+
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				ext := requestReceived.Extensions
+				require.Len(t, ext, 3)
+				doNotSend := ext[1]
+				require.Equal(t, graphsync.ExtensionDoNotSendCIDs, doNotSend.Name)
+				received, err := cidset.DecodeCidSet(doNotSend.Data)
+				require.NoError(t, err)
+				require.ElementsMatch(t, doNotSendCids, received.Keys())
+			},
+		},
+		"ChannelsForPeer when request is open": {
+			action: func(gsData *harness) {
+				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 2})
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					channel,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				channelsForPeer := gsData.transport.ChannelsForPeer(gsData.other)
+				require.Equal(t, channelsForPeer, ChannelsForPeer{
+					ReceivingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{
+						events.ChannelOpenedChannelID: {
+							Current: gsData.request.ID(),
+						},
+					},
+					SendingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{},
+				})
+			},
+		},
+		"open channel cancels an existing request with the same channel ID": {
+			action: func(gsData *harness) {
+				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 2})
+				stor, _ := gsData.outgoing.Selector()
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					channel,
+					gsData.outgoing)
+
+				go gsData.altOutgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					channel,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				gsData.fgs.AssertCancelReceived(ctxt, t)
+
+				channelsForPeer := gsData.transport.ChannelsForPeer(gsData.other)
+				require.Equal(t, channelsForPeer, ChannelsForPeer{
+					ReceivingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{
+						events.ChannelOpenedChannelID: {
+							Current:  gsData.altRequest.ID(),
+							Previous: []graphsync.RequestID{gsData.request.ID()},
+						},
+					},
+					SendingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{},
+				})
+			},
+		},
+		"OnChannelCompleted called when outgoing request completes successfully": {
+			action: func(gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				close(requestReceived.ResponseChan)
+				close(requestReceived.ResponseErrChan)
+
+				require.Eventually(t, func() bool {
+					return events.OnChannelCompletedCalled == true
+				}, 2*time.Second, 100*time.Millisecond)
+				require.True(t, events.ChannelCompletedSuccess)
+			},
+		},
+		"OnChannelCompleted called when outgoing request completes with error": {
+			action: func(gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestFailedUnknownErr{}
+				close(requestReceived.ResponseErrChan)
+
+				require.Eventually(t, func() bool {
+					return events.OnChannelCompletedCalled == true
+				}, 2*time.Second, 100*time.Millisecond)
+				require.False(t, events.ChannelCompletedSuccess)
+			},
+		},
+		"StallTimeout cancels a channel that stops sending data": {
+			options: []Option{StallTimeout(50 * time.Millisecond)},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
+				gsData.blockSentListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+				require.Eventually(t, func() bool {
+					return events.OnRequestDisconnectedCalled
+				}, time.Second, 10*time.Millisecond)
+				require.ErrorIs(t, events.OnRequestDisconnectedError, datatransfer.ErrChannelStalled)
+				require.ErrorIs(t, gsData.transport.WaitForCompletion(gsData.ctx, chid), datatransfer.ErrChannelStalled)
+			},
+		},
+		"StallTimeout does not cancel a channel that keeps transferring data": {
+			options: []Option{StallTimeout(100 * time.Millisecond)},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				for i := 0; i < 6; i++ {
+					gsData.outgoingBlockHook()
+					gsData.blockSentListener()
+					time.Sleep(40 * time.Millisecond)
+				}
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnRequestDisconnectedCalled)
+				gsData.fgs.AssertNoCancelReceived(t)
+			},
+		},
+		"StallTimeout is suspended while the channel is paused": {
+			options: []Option{StallTimeout(50 * time.Millisecond)},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.outgoingBlockHook()
+				gsData.blockSentListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.NoError(t, gsData.transport.PauseChannel(gsData.ctx, chid))
+				time.Sleep(150 * time.Millisecond)
+				require.False(t, events.OnRequestDisconnectedCalled)
+				gsData.fgs.AssertNoCancelReceived(t)
+
+				// once resumed, the channel stalls if no more data is sent
+				require.NoError(t, gsData.transport.ResumeChannel(gsData.ctx, nil, chid))
+				require.Equal(t, gsData.request.ID(), gsData.fgs.AssertCancelReceived(gsData.ctx, t))
+				require.Eventually(t, func() bool {
+					return events.OnRequestDisconnectedCalled
+				}, time.Second, 10*time.Millisecond)
+				require.ErrorIs(t, events.OnRequestDisconnectedError, datatransfer.ErrChannelStalled)
+			},
+		},
+		"WaitForCompletion returns when outgoing request completes": {
+			action: func(gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				waitErr := make(chan error, 1)
+				go func() {
+					waitErr <- gsData.transport.WaitForCompletion(gsData.ctx, chid)
+				}()
+				select {
+				case err := <-waitErr:
+					require.FailNow(t, "WaitForCompletion returned before the request completed", err)
+				case <-time.After(50 * time.Millisecond):
+				}
+
+				close(requestReceived.ResponseChan)
+				close(requestReceived.ResponseErrChan)
+				select {
+				case err := <-waitErr:
+					require.NoError(t, err)
+				case <-time.After(time.Second):
+					require.FailNow(t, "WaitForCompletion did not return")
+				}
+				require.True(t, events.OnChannelCompletedCalled)
+
+				// waiting on a completed channel returns immediately
+				require.NoError(t, gsData.transport.WaitForCompletion(gsData.ctx, chid))
+			},
+		},
+		"WaitForCompletion returns the error an outgoing request completes with": {
+			action: func(gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.ResumeChannel(gsData.ctx,
-					gsData.incoming,
-					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
-				)
-				require.Error(t, err)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestFailedUnknownErr{}
+				close(requestReceived.ResponseErrChan)
+
+				ctx, cancel := context.WithTimeout(gsData.ctx, time.Second)
+				defer cancel()
+				err := gsData.transport.WaitForCompletion(ctx, chid)
+				require.ErrorIs(t, err, graphsync.RequestFailedUnknownErr{})
 			},
 		},
-		"recognized incoming request that requestor cancelled will not resume via graphsync but will resume otherwise": {
+		"WaitForCompletion returns when the channel is closed": {
 			action: func(gsData *harness) {
-				gsData.incomingRequestHook()
-				gsData.requestorCancelledListener()
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				err := gsData.transport.ResumeChannel(gsData.ctx,
-					gsData.incoming,
-					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other},
-				)
-				require.NoError(t, err)
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				gsData.fgs.AssertNoResumeReceived(t)
-				gsData.incomingRequestHook()
-				assertHasOutgoingMessage(t, gsData.incomingRequestHookActions.SentExtensions, gsData.incoming)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				ctx, cancel := context.WithTimeout(gsData.ctx, 50*time.Millisecond)
+				defer cancel()
+				require.ErrorIs(t, gsData.transport.WaitForCompletion(ctx, chid), context.DeadlineExceeded)
+
+				require.NoError(t, gsData.transport.CloseChannel(gsData.ctx, chid))
+				require.ErrorIs(t, gsData.transport.WaitForCompletion(gsData.ctx, chid), datatransfer.ErrChannelClosed)
+
+				gsData.transport.CleanupChannel(chid)
+				require.ErrorIs(t, gsData.transport.WaitForCompletion(gsData.ctx, chid), datatransfer.ErrChannelNotFound)
 			},
 		},
-		"recognized incoming request will record network send error": {
+		"WaitForCompletion returns when incoming request completes": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedFull,
+			},
 			action: func(gsData *harness) {
 				gsData.incomingRequestHook()
-				gsData.networkErrorListener(errors.New("something went wrong"))
+				gsData.responseCompletedListener()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.True(t, events.OnSendDataErrorCalled)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				ctx, cancel := context.WithTimeout(gsData.ctx, time.Second)
+				defer cancel()
+				require.NoError(t, gsData.transport.WaitForCompletion(ctx, chid))
 			},
 		},
-		"recognized outgoing request will record network send error": {
+		"detailed completion result reports the status of a failed outgoing request": {
 			action: func(gsData *harness) {
-				gsData.outgoingRequestHook()
-				gsData.networkErrorListener(errors.New("something went wrong"))
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.True(t, events.OnSendDataErrorCalled)
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestFailedContentNotFoundErr{}
+				close(requestReceived.ResponseErrChan)
+
+				require.Eventually(t, func() bool {
+					return events.ChannelCompletedResult != nil
+				}, 2*time.Second, 100*time.Millisecond)
+				require.Equal(t, datatransfer.CompletionFailed, events.ChannelCompletedResult.Kind)
+				require.Equal(t, datatransfer.CompletionSideRequestor, events.ChannelCompletedResult.Side)
+				require.Equal(t, int(graphsync.RequestFailedContentNotFound), events.ChannelCompletedResult.StatusCode)
+				require.ErrorIs(t, events.ChannelCompletedResult.Err, graphsync.RequestFailedContentNotFoundErr{})
+				// the responder does not have the content, so there is no
+				// point retrying
+				require.False(t, datatransfer.IsRetryable(events.ChannelCompletedResult.Err))
 			},
 		},
-		"recognized incoming request will record network receive error": {
+		"outgoing request that fails because the responder is busy is retryable": {
 			action: func(gsData *harness) {
-				gsData.incomingRequestHook()
-				gsData.receiverNetworkErrorListener(errors.New("something went wrong"))
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.Equal(t, 1, events.OnRequestReceivedCallCount)
-				require.True(t, events.OnReceiveDataErrorCalled)
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestFailedBusyErr{}
+				close(requestReceived.ResponseErrChan)
+
+				require.Eventually(t, func() bool {
+					return events.ChannelCompletedResult != nil
+				}, 2*time.Second, 100*time.Millisecond)
+				var completionErr CompletionError
+				require.ErrorAs(t, events.ChannelCompletedErr, &completionErr)
+				require.Equal(t, graphsync.RequestFailedBusy, completionErr.StatusCode)
+				require.ErrorIs(t, events.ChannelCompletedErr, graphsync.RequestFailedBusyErr{})
+				require.True(t, datatransfer.IsRetryable(events.ChannelCompletedErr))
 			},
 		},
-		"recognized outgoing request will record network receive error": {
+		"OnChannelComplete when outgoing request cancelled by caller": {
 			action: func(gsData *harness) {
-				gsData.outgoingRequestHook()
-				gsData.receiverNetworkErrorListener(errors.New("something went wrong"))
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				require.True(t, events.OnReceiveDataErrorCalled)
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				extensions := make(map[graphsync.ExtensionName]datamodel.Node)
+				for _, ext := range requestReceived.Extensions {
+					extensions[ext.Name] = ext.Data
+				}
+				request := testharness.NewFakeRequest(graphsync.NewRequestID(), extensions, graphsync.RequestTypeNew)
+				gsData.fgs.OutgoingRequestHook(gsData.other, request, gsData.outgoingRequestHookActions)
+				_ = gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				gsData.fgs.AssertCancelReceived(ctxt, t)
 			},
 		},
-		"open channel adds block count to the DoNotSendFirstBlocks extension for v1.2 protocol": {
+		"request times out if we get request context cancelled error": {
 			action: func(gsData *harness) {
-				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 2})
+				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
 
 				go gsData.outgoingRequestHook()
@@ -718,57 +2938,60 @@ func TestManager(t *testing.T) {
 					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
 					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
 					stor,
-					channel,
+					nil,
 					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
 				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestClientCancelledErr{}
+				close(requestReceived.ResponseErrChan)
 
-				ext := requestReceived.Extensions
-				require.Len(t, ext, 2)
-				doNotSend := ext[1]
-
-				name := doNotSend.Name
-				require.Equal(t, graphsync.ExtensionsDoNotSendFirstBlocks, name)
-				data := doNotSend.Data
-				blockCount, err := donotsendfirstblocks.DecodeDoNotSendFirstBlocks(data)
-				require.NoError(t, err)
-				require.EqualValues(t, blockCount, 2)
+				require.Eventually(t, func() bool {
+					return events.OnRequestCancelledCalled == true
+				}, 2*time.Second, 100*time.Millisecond)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, events.OnRequestCancelledChannelId)
 			},
 		},
-		"ChannelsForPeer when request is open": {
+		"request completes with an error if its context is cancelled": {
 			action: func(gsData *harness) {
-				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 2})
+				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
 
 				go gsData.outgoingRequestHook()
 				_ = gsData.transport.OpenChannel(
-					gsData.ctx,
+					openCtx,
 					gsData.other,
 					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
 					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
 					stor,
-					channel,
+					nil,
 					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
 
-				channelsForPeer := gsData.transport.ChannelsForPeer(gsData.other)
-				require.Equal(t, channelsForPeer, ChannelsForPeer{
-					ReceivingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{
-						events.ChannelOpenedChannelID: {
-							Current: gsData.request.ID(),
-						},
-					},
-					SendingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{},
-				})
+				// cancel the context mid-transfer, and graphsync reports that
+				// the request was cancelled by the client
+				cancelOpenCtx()
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestClientCancelledErr{}
+				close(requestReceived.ResponseErrChan)
+
+				require.Eventually(t, func() bool {
+					return events.OnChannelCompletedCalled == true
+				}, 2*time.Second, 100*time.Millisecond)
+				require.False(t, events.ChannelCompletedSuccess)
+				require.ErrorIs(t, events.ChannelCompletedErr, context.Canceled)
+				require.False(t, events.OnRequestCancelledCalled)
 			},
 		},
-		"open channel cancels an existing request with the same channel ID": {
+		"channels to a peer fail fast after repeated failures when circuit breaker is enabled": {
+			options: []Option{PeerCircuitBreaker(1, time.Minute)},
 			action: func(gsData *harness) {
-				channel := testutil.NewMockChannelState(testutil.MockChannelStateParams{ReceivedCidsTotal: 2})
+				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
+
 				go gsData.outgoingRequestHook()
 				_ = gsData.transport.OpenChannel(
 					gsData.ctx,
@@ -776,40 +2999,103 @@ func TestManager(t *testing.T) {
 					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
 					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
 					stor,
-					channel,
+					nil,
 					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- errors.New("something went wrong")
+				close(requestReceived.ResponseErrChan)
 
-				go gsData.altOutgoingRequestHook()
-				_ = gsData.transport.OpenChannel(
-					gsData.ctx,
+				require.Eventually(t, func() bool {
+					return events.OnChannelCompletedCalled == true
+				}, 2*time.Second, 100*time.Millisecond)
+				require.False(t, events.ChannelCompletedSuccess)
+
+				ctx, cancel := context.WithTimeout(gsData.ctx, time.Second)
+				defer cancel()
+				stor, _ := gsData.outgoing.Selector()
+				err := gsData.transport.OpenChannel(
+					ctx,
 					gsData.other,
-					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.other, Initiator: gsData.self},
 					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
 					stor,
-					channel,
+					nil,
 					gsData.outgoing)
+				require.ErrorIs(t, err, datatransfer.ErrPeerCircuitOpen)
+				gsData.fgs.AssertNoRequestReceived(t)
+			},
+		},
+		"network send errors do not count towards the circuit breaker": {
+			options: []Option{PeerCircuitBreaker(1, time.Minute)},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.networkErrorListener(errors.New("something went wrong"))
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
-				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				require.True(t, events.OnSendDataErrorCalled)
 
-				ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				ctx, cancel := context.WithTimeout(gsData.ctx, 100*time.Millisecond)
 				defer cancel()
-				gsData.fgs.AssertCancelReceived(ctxt, t)
+				stor, _ := gsData.outgoing.Selector()
+				err := gsData.transport.OpenChannel(
+					ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+				require.NotErrorIs(t, err, datatransfer.ErrPeerCircuitOpen)
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+			},
+		},
+		"cancelled channels do not count towards the circuit breaker": {
+			options: []Option{PeerCircuitBreaker(1, time.Minute)},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
 
-				channelsForPeer := gsData.transport.ChannelsForPeer(gsData.other)
-				require.Equal(t, channelsForPeer, ChannelsForPeer{
-					ReceivingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{
-						events.ChannelOpenedChannelID: {
-							Current:  gsData.altRequest.ID(),
-							Previous: []graphsync.RequestID{gsData.request.ID()},
-						},
-					},
-					SendingChannels: map[datatransfer.ChannelID]ChannelGraphsyncRequests{},
-				})
+				ctx, cancel := context.WithCancel(gsData.ctx)
+				go gsData.outgoingRequestHook()
+				require.NoError(t, gsData.transport.OpenChannel(
+					ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing))
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				// the local node cancels the transfer
+				cancel()
+				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestClientCancelledErr{}
+				close(requestReceived.ResponseErrChan)
+				require.Eventually(t, func() bool {
+					return events.OnChannelCompletedCalled == true
+				}, 2*time.Second, 100*time.Millisecond)
+				require.ErrorIs(t, events.ChannelCompletedErr, context.Canceled)
+
+				ctx, cancel = context.WithTimeout(gsData.ctx, 100*time.Millisecond)
+				defer cancel()
+				err := gsData.transport.OpenChannel(
+					ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+				require.NotErrorIs(t, err, datatransfer.ErrPeerCircuitOpen)
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
 			},
 		},
-		"OnChannelCompleted called when outgoing request completes successfully": {
+		"request cancelled by graphsync fires channel cancelled when enabled": {
+			options: []Option{FireChannelCancelled(true)},
 			action: func(gsData *harness) {
 				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
@@ -827,15 +3113,17 @@ func TestManager(t *testing.T) {
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
 				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
 				close(requestReceived.ResponseChan)
+				requestReceived.ResponseErrChan <- graphsync.RequestCancelledErr{}
 				close(requestReceived.ResponseErrChan)
 
 				require.Eventually(t, func() bool {
-					return events.OnChannelCompletedCalled == true
+					return events.OnChannelCancelledCalled == true
 				}, 2*time.Second, 100*time.Millisecond)
-				require.True(t, events.ChannelCompletedSuccess)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, events.OnChannelCancelledChannelID)
+				require.False(t, events.OnChannelCompletedCalled)
 			},
 		},
-		"OnChannelCompleted called when outgoing request completes with error": {
+		"request cancelled by graphsync does not fire channel cancelled by default": {
 			action: func(gsData *harness) {
 				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
@@ -853,16 +3141,15 @@ func TestManager(t *testing.T) {
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
 				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
 				close(requestReceived.ResponseChan)
-				requestReceived.ResponseErrChan <- graphsync.RequestFailedUnknownErr{}
+				requestReceived.ResponseErrChan <- graphsync.RequestCancelledErr{}
 				close(requestReceived.ResponseErrChan)
 
-				require.Eventually(t, func() bool {
-					return events.OnChannelCompletedCalled == true
-				}, 2*time.Second, 100*time.Millisecond)
-				require.False(t, events.ChannelCompletedSuccess)
+				time.Sleep(100 * time.Millisecond)
+				require.False(t, events.OnChannelCancelledCalled)
+				require.False(t, events.OnChannelCompletedCalled)
 			},
 		},
-		"OnChannelComplete when outgoing request cancelled by caller": {
+		"request cancelled out if transport shuts down": {
 			action: func(gsData *harness) {
 				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
@@ -878,20 +3165,73 @@ func TestManager(t *testing.T) {
 					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
-				extensions := make(map[graphsync.ExtensionName]datamodel.Node)
-				for _, ext := range requestReceived.Extensions {
-					extensions[ext.Name] = ext.Data
-				}
-				request := testharness.NewFakeRequest(graphsync.NewRequestID(), extensions, graphsync.RequestTypeNew)
-				gsData.fgs.OutgoingRequestHook(gsData.other, request, gsData.outgoingRequestHookActions)
-				_ = gsData.transport.CloseChannel(gsData.ctx, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				gsData.transport.Shutdown(gsData.ctx)
+
+				ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				gsData.fgs.AssertCancelReceived(ctxt, t)
+
+				require.Nil(t, gsData.fgs.IncomingRequestHook)
+				require.Nil(t, gsData.fgs.CompletedResponseListener)
+				require.Nil(t, gsData.fgs.IncomingBlockHook)
+				require.Nil(t, gsData.fgs.OutgoingBlockHook)
+				require.Nil(t, gsData.fgs.BlockSentListener)
+				require.Nil(t, gsData.fgs.OutgoingRequestHook)
+				require.Nil(t, gsData.fgs.IncomingResponseHook)
+				require.Nil(t, gsData.fgs.RequestUpdatedHook)
+				require.Nil(t, gsData.fgs.RequestorCancelledListener)
+				require.Nil(t, gsData.fgs.NetworkErrorListener)
+			},
+		},
+		"reset cancels requests and clears channel state but keeps hooks registered": {
+			action: func(gsData *harness) {
+				gsData.fgs.LeaveRequestsOpen()
+				stor, _ := gsData.outgoing.Selector()
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				_ = gsData.transport.UseStore(chid, cidlink.DefaultLinkSystem())
+
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					chid,
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				expectedChannel := "data-transfer-" + chid.String()
+				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
+
+				require.NoError(t, gsData.transport.Reset(gsData.ctx))
+
 				ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 				defer cancel()
 				gsData.fgs.AssertCancelReceived(ctxt, t)
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+				require.Empty(t, gsData.transport.ChannelsForPeer(gsData.other).SendingChannels)
+				require.Empty(t, gsData.transport.ChannelsForPeer(gsData.other).ReceivingChannels)
+
+				require.NotNil(t, gsData.fgs.IncomingRequestHook)
+				require.NotNil(t, gsData.fgs.CompletedResponseListener)
+				require.NotNil(t, gsData.fgs.IncomingBlockHook)
+				require.NotNil(t, gsData.fgs.OutgoingBlockHook)
+				require.NotNil(t, gsData.fgs.OutgoingRequestHook)
+				require.NotNil(t, gsData.fgs.IncomingResponseHook)
 			},
 		},
-		"request times out if we get request context cancelled error": {
+		"transport load counts requests that are open": {
+			options: []Option{LoadCallback(10*time.Millisecond, func(stats LoadStats) {
+				select {
+				case reportedLoad <- stats:
+				default:
+				}
+			})},
 			action: func(gsData *harness) {
 				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
@@ -907,18 +3247,45 @@ func TestManager(t *testing.T) {
 					gsData.outgoing)
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
-				close(requestReceived.ResponseChan)
-				requestReceived.ResponseErrChan <- graphsync.RequestClientCancelledErr{}
-				close(requestReceived.ResponseErrChan)
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+
+				expected := LoadStats{
+					Peers:  map[peer.ID]PeerLoad{gsData.other: {Active: 1}},
+					Paused: 0,
+				}
+				require.Equal(t, expected, gsData.transport.TransportLoad())
+
+				// the callback is called periodically with the same stats
+				select {
+				case stats := <-reportedLoad:
+					require.Equal(t, expected, stats)
+				case <-time.After(time.Second):
+					require.FailNow(t, "load callback was not called")
+				}
+			},
+		},
+		"transport load does not count completed requests": {
+			action: func(gsData *harness) {
+				stor, _ := gsData.outgoing.Selector()
 
+				go gsData.outgoingRequestHook()
+				_ = gsData.transport.OpenChannel(
+					gsData.ctx,
+					gsData.other,
+					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
+					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
+					stor,
+					nil,
+					gsData.outgoing)
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
 				require.Eventually(t, func() bool {
-					return events.OnRequestCancelledCalled == true
-				}, 2*time.Second, 100*time.Millisecond)
-				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, events.OnRequestCancelledChannelId)
+					return len(gsData.transport.TransportLoad().Peers) == 0
+				}, time.Second, 10*time.Millisecond)
 			},
 		},
-		"request cancelled out if transport shuts down": {
+		"request pause works even if called when request is still pending": {
 			action: func(gsData *harness) {
 				gsData.fgs.LeaveRequestsOpen()
 				stor, _ := gsData.outgoing.Selector()
@@ -932,111 +3299,344 @@ func TestManager(t *testing.T) {
 					stor,
 					nil,
 					gsData.outgoing)
+
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
+				assertHasOutgoingMessage(t, requestReceived.Extensions, gsData.outgoing)
+				completed := make(chan struct{})
+				go func() {
+					err := gsData.transport.PauseChannel(context.Background(), datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+					require.NoError(t, err)
+					close(completed)
+				}()
+				time.Sleep(100 * time.Millisecond)
+				extensions := make(map[graphsync.ExtensionName]datamodel.Node)
+				for _, ext := range requestReceived.Extensions {
+					extensions[ext.Name] = ext.Data
+				}
+				request := testharness.NewFakeRequest(graphsync.NewRequestID(), extensions, graphsync.RequestTypeNew)
+				gsData.fgs.OutgoingRequestHook(gsData.other, request, gsData.outgoingRequestHookActions)
+				select {
+				case <-gsData.ctx.Done():
+					t.Fatal("never paused channel")
+				case <-completed:
+				}
+			},
+		},
+		"recognized outgoing request will record bytes received from peer": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+				gsData.incomingBlockHook()
+				gsData.incomingBlockHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 2*gsData.block.BlockSizeOnWire(), gsData.transport.PeerBytesReceived(gsData.other))
+				require.Zero(t, gsData.transport.PeerBytesSent(gsData.other))
+				gsData.transport.ResetPeerStats(gsData.other)
+				require.Zero(t, gsData.transport.PeerBytesReceived(gsData.other))
+			},
+		},
+		"recognized incoming request will record bytes sent to peer": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.blockSentListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.OnDataSentCalled)
+				require.Equal(t, gsData.block.BlockSizeOnWire(), gsData.transport.PeerBytesSent(gsData.other))
+				require.Zero(t, gsData.transport.PeerBytesReceived(gsData.other))
+				gsData.transport.ResetPeerStats(gsData.other)
+				require.Zero(t, gsData.transport.PeerBytesSent(gsData.other))
+			},
+		},
+		"throughput is measured for blocks sent on a channel": {
+			options: []Option{ThroughputCallback(10*time.Millisecond, func(throughputs map[datatransfer.ChannelID]float64) {
+				select {
+				case reportedThroughput <- throughputs:
+				default:
+				}
+			})},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.blockSentListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Greater(t, gsData.transport.Throughput(chid), float64(0))
+				require.Zero(t, gsData.transport.Throughput(datatransfer.ChannelID{ID: gsData.transferID + 1, Responder: gsData.self, Initiator: gsData.other}))
+
+				// the callback is called periodically with the throughput of
+				// each channel
+				select {
+				case throughputs := <-reportedThroughput:
+					require.Greater(t, throughputs[chid], float64(0))
+				case <-time.After(time.Second):
+					require.FailNow(t, "throughput callback was not called")
+				}
+			},
+		},
+		"non-data-transfer request will not record bytes sent to peer": {
+			requestConfig: gsRequestConfig{
+				dtExtensionMissing: true,
+			},
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+				gsData.blockSentListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnDataSentCalled)
+				require.Zero(t, gsData.transport.PeerBytesSent(gsData.other))
+			},
+		},
+		"UseStore can change store used for outgoing requests": {
+			action: func(gsData *harness) {
+				lsys := cidlink.DefaultLinkSystem()
+				lsys.StorageReadOpener = func(ipld.LinkContext, ipld.Link) (io.Reader, error) {
+					return nil, nil
+				}
+				lsys.StorageWriteOpener = func(ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+					return nil, nil, nil
+				}
+				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, lsys)
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				expectedChannel := "data-transfer-" + datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}.String()
+				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
+				require.Equal(t, expectedChannel, gsData.outgoingRequestHookActions.PersistenceOption)
+				gsData.transport.CleanupChannel(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+			},
+		},
+		"UseStore can change store used for incoming requests": {
+			action: func(gsData *harness) {
+				lsys := cidlink.DefaultLinkSystem()
+				lsys.StorageReadOpener = func(ipld.LinkContext, ipld.Link) (io.Reader, error) {
+					return nil, nil
+				}
+				lsys.StorageWriteOpener = func(ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+					return nil, nil, nil
+				}
+				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, lsys)
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				expectedChannel := "data-transfer-" + datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}.String()
+				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
+				require.Equal(t, expectedChannel, gsData.incomingRequestHookActions.PersistenceOption)
+				gsData.transport.CleanupChannel(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+			},
+		},
+		"UseNamedStore uses a shared store for outgoing requests": {
+			action: func(gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				_ = gsData.transport.RegisterNamedStore("shared", cidlink.DefaultLinkSystem())
+				_ = gsData.transport.UseNamedStore(chid, "shared")
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				gsData.fgs.AssertHasPersistenceOption(t, "data-transfer-store-shared")
+				require.Equal(t, "data-transfer-store-shared", gsData.outgoingRequestHookActions.PersistenceOption)
+				// the channel doesn't register a store of its own
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, "data-transfer-"+chid.String())
+
+				// the shared store stays registered after the channel is
+				// cleaned up
+				gsData.transport.CleanupChannel(chid)
+				gsData.fgs.AssertHasPersistenceOption(t, "data-transfer-store-shared")
+			},
+		},
+		"UseNamedStore uses a shared store for incoming requests": {
+			action: func(gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				_ = gsData.transport.RegisterNamedStore("shared", cidlink.DefaultLinkSystem())
+				_ = gsData.transport.UseStore(chid, cidlink.DefaultLinkSystem())
+				_ = gsData.transport.UseNamedStore(chid, "shared")
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Equal(t, "data-transfer-store-shared", gsData.incomingRequestHookActions.PersistenceOption)
+				// the store the channel registered before is released
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, "data-transfer-"+chid.String())
+			},
+		},
+		"RegisterNamedStore and UseNamedStore fail for duplicate and unknown names": {
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.NoError(t, gsData.transport.RegisterNamedStore("shared", cidlink.DefaultLinkSystem()))
+				require.Error(t, gsData.transport.RegisterNamedStore("shared", cidlink.DefaultLinkSystem()))
+				require.Error(t, gsData.transport.UseNamedStore(chid, "unknown"))
+			},
+		},
+		"UseEphemeralStore keeps received blocks in memory until cleanup": {
+			action: func(gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				ephemeralStore, _ = gsData.transport.UseEphemeralStore(chid)
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
+				expectedChannel := "data-transfer-" + chid.String()
+				require.NotNil(t, ephemeralStore)
+				require.Equal(t, expectedChannel, gsData.outgoingRequestHookActions.PersistenceOption)
+
+				// write a block through the store registered with graphsync
+				lsys := gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
+				lnk, err := lsys.Store(ipld.LinkContext{}, cidlink.LinkPrototype{Prefix: cid.Prefix{
+					Version:  1,
+					Codec:    cid.DagCBOR,
+					MhType:   0x12, // sha2-256
+					MhLength: -1,
+				}}, basicnode.NewString("block"))
+				require.NoError(t, err)
+
+				has, err := ephemeralStore.Has(gsData.ctx, lnk.(cidlink.Link).Cid)
+				require.NoError(t, err)
+				require.True(t, has)
+				data, err := ephemeralStore.Get(gsData.ctx, lnk.(cidlink.Link).Cid)
+				require.NoError(t, err)
+				require.NotEmpty(t, data)
+				require.Equal(t, 1, ephemeralStore.Len())
+
+				gsData.transport.CleanupChannel(chid)
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+			},
+		},
+		"UseStore registers store under the configured persistence prefix": {
+			options: []Option{PersistencePrefix("my-app-")},
+			action: func(gsData *harness) {
+				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, cidlink.DefaultLinkSystem())
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				expectedChannel := "my-app-" + chid.String()
+				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, "data-transfer-"+chid.String())
+				require.Equal(t, expectedChannel, gsData.incomingRequestHookActions.PersistenceOption)
+				gsData.transport.CleanupChannel(chid)
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+			},
+		},
+		"incoming request reusing the request ID of another channel is rejected": {
+			action: func(gsData *harness) {
+				gsData.incomingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				require.NoError(t, gsData.incomingRequestHookActions.TerminationError)
+
+				// a request for a different channel with the same request ID
+				// must not take over the first channel's request ID
+				reqConfig := gsRequestConfig{}
+				colliding := reqConfig.makeRequest(t, gsData.transferID+1, gsData.request.ID())
+				hookActions := &testharness.FakeIncomingRequestHookActions{}
+				gsData.fgs.IncomingRequestHook(gsData.other, colliding, hookActions)
+
+				require.ErrorIs(t, hookActions.TerminationError, datatransfer.ErrRequestIDCollision)
+				require.False(t, hookActions.Validated)
+				require.Equal(t, 1, events.OnRequestReceivedCallCount)
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
+				require.Contains(t, gsData.transport.ChannelsForPeer(gsData.other).SendingChannels, chid)
+				require.Len(t, gsData.transport.ChannelsForPeer(gsData.other).SendingChannels, 1)
+			},
+		},
+		"store is unregistered when incoming request completes with an error": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedPartial,
+			},
+			action: func(gsData *harness) {
+				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, cidlink.DefaultLinkSystem())
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.True(t, events.OnChannelCompletedCalled)
+				require.False(t, events.ChannelCompletedSuccess)
+				expectedChannel := "data-transfer-" + datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}.String()
+				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+			},
+		},
+		"store is kept when incoming request completes successfully": {
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedFull,
+			},
+			action: func(gsData *harness) {
+				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, cidlink.DefaultLinkSystem())
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				gsData.fgs.AssertRequestReceived(gsData.ctx, t)
-
-				gsData.transport.Shutdown(gsData.ctx)
-
-				ctxt, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-				defer cancel()
-				gsData.fgs.AssertCancelReceived(ctxt, t)
-
-				require.Nil(t, gsData.fgs.IncomingRequestHook)
-				require.Nil(t, gsData.fgs.CompletedResponseListener)
-				require.Nil(t, gsData.fgs.IncomingBlockHook)
-				require.Nil(t, gsData.fgs.OutgoingBlockHook)
-				require.Nil(t, gsData.fgs.BlockSentListener)
-				require.Nil(t, gsData.fgs.OutgoingRequestHook)
-				require.Nil(t, gsData.fgs.IncomingResponseHook)
-				require.Nil(t, gsData.fgs.RequestUpdatedHook)
-				require.Nil(t, gsData.fgs.RequestorCancelledListener)
-				require.Nil(t, gsData.fgs.NetworkErrorListener)
+				require.True(t, events.ChannelCompletedSuccess)
+				expectedChannel := "data-transfer-" + datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}.String()
+				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
 			},
 		},
-		"request pause works even if called when request is still pending": {
+		"store is kept after an error completion if CleanupStoreOnError is disabled": {
+			options: []Option{CleanupStoreOnError(false)},
+			responseConfig: gsResponseConfig{
+				status: graphsync.RequestCompletedPartial,
+			},
 			action: func(gsData *harness) {
-				gsData.fgs.LeaveRequestsOpen()
-				stor, _ := gsData.outgoing.Selector()
-
-				go gsData.outgoingRequestHook()
-				_ = gsData.transport.OpenChannel(
-					gsData.ctx,
-					gsData.other,
-					datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self},
-					cidlink.Link{Cid: gsData.outgoing.BaseCid()},
-					stor,
-					nil,
-					gsData.outgoing)
-
+				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, cidlink.DefaultLinkSystem())
+				gsData.incomingRequestHook()
+				gsData.responseCompletedListener()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				requestReceived := gsData.fgs.AssertRequestReceived(gsData.ctx, t)
-				assertHasOutgoingMessage(t, requestReceived.Extensions, gsData.outgoing)
-				completed := make(chan struct{})
-				go func() {
-					err := gsData.transport.PauseChannel(context.Background(), datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
-					require.NoError(t, err)
-					close(completed)
-				}()
-				time.Sleep(100 * time.Millisecond)
-				extensions := make(map[graphsync.ExtensionName]datamodel.Node)
-				for _, ext := range requestReceived.Extensions {
-					extensions[ext.Name] = ext.Data
-				}
-				request := testharness.NewFakeRequest(graphsync.NewRequestID(), extensions, graphsync.RequestTypeNew)
-				gsData.fgs.OutgoingRequestHook(gsData.other, request, gsData.outgoingRequestHookActions)
-				select {
-				case <-gsData.ctx.Done():
-					t.Fatal("never paused channel")
-				case <-completed:
-				}
+				require.False(t, events.ChannelCompletedSuccess)
+				expectedChannel := "data-transfer-" + datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}.String()
+				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
 			},
 		},
-		"UseStore can change store used for outgoing requests": {
+		"failure to register store for outgoing requests fires OnStoreError": {
 			action: func(gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}
 				lsys := cidlink.DefaultLinkSystem()
-				lsys.StorageReadOpener = func(ipld.LinkContext, ipld.Link) (io.Reader, error) {
-					return nil, nil
-				}
-				lsys.StorageWriteOpener = func(ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
-					return nil, nil, nil
-				}
-				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, lsys)
+				_ = gsData.fgs.RegisterPersistenceOption("data-transfer-"+chid.String(), lsys)
+				_ = gsData.transport.UseStore(chid, lsys)
 				gsData.outgoingRequestHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				expectedChannel := "data-transfer-" + datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}.String()
-				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
-				require.Equal(t, expectedChannel, gsData.outgoingRequestHookActions.PersistenceOption)
-				gsData.transport.CleanupChannel(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self})
-				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+				require.True(t, events.OnStoreErrorCalled)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.other, Initiator: gsData.self}, events.OnStoreErrorChannelID)
+				require.Error(t, events.OnStoreErrorError)
+				require.Empty(t, gsData.outgoingRequestHookActions.PersistenceOption)
 			},
 		},
-		"UseStore can change store used for incoming requests": {
+		"failure to register store for incoming requests fires OnStoreError": {
 			action: func(gsData *harness) {
+				chid := datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}
 				lsys := cidlink.DefaultLinkSystem()
-				lsys.StorageReadOpener = func(ipld.LinkContext, ipld.Link) (io.Reader, error) {
-					return nil, nil
-				}
-				lsys.StorageWriteOpener = func(ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
-					return nil, nil, nil
-				}
-				_ = gsData.transport.UseStore(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, lsys)
+				_ = gsData.fgs.RegisterPersistenceOption("data-transfer-"+chid.String(), lsys)
+				_ = gsData.transport.UseStore(chid, lsys)
 				gsData.incomingRequestHook()
 			},
 			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
-				expectedChannel := "data-transfer-" + datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}.String()
-				gsData.fgs.AssertHasPersistenceOption(t, expectedChannel)
-				require.Equal(t, expectedChannel, gsData.incomingRequestHookActions.PersistenceOption)
-				gsData.transport.CleanupChannel(datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other})
-				gsData.fgs.AssertDoesNotHavePersistenceOption(t, expectedChannel)
+				require.True(t, events.OnStoreErrorCalled)
+				require.Equal(t, datatransfer.ChannelID{ID: gsData.transferID, Responder: gsData.self, Initiator: gsData.other}, events.OnStoreErrorChannelID)
+				require.Error(t, events.OnStoreErrorError)
+				require.Empty(t, gsData.incomingRequestHookActions.PersistenceOption)
+			},
+		},
+		"no store registered does not fire OnStoreError": {
+			action: func(gsData *harness) {
+				gsData.outgoingRequestHook()
+			},
+			check: func(t *testing.T, events *fakeEvents, gsData *harness) {
+				require.False(t, events.OnStoreErrorCalled)
 			},
 		},
 	}
 
 	ctx := context.Background()
 	for testCase, data := range testCases {
+		// requests opened by a test case may complete after it returns, so
+		// each test case needs its own copy of the events
+		data := data
 		t.Run(testCase, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 			defer cancel()
@@ -1051,7 +3651,7 @@ func TestManager(t *testing.T) {
 			fgs := testharness.NewFakeGraphSync()
 			outgoing := testutil.NewDTRequest(t, transferID)
 			incoming := testutil.NewDTResponse(t, transferID)
-			transport := NewTransport(peers[0], fgs)
+			transport := NewTransport(peers[0], fgs, data.options...)
 			gsData := &harness{
 				ctx:                         ctx,
 				outgoing:                    outgoing,
@@ -1082,6 +3682,466 @@ func TestManager(t *testing.T) {
 	}
 }
 
+func TestWithLogger(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	transferID := datatransfer.TransferID(rand.Uint32())
+	request := (&gsRequestConfig{}).makeRequest(t, transferID, graphsync.NewRequestID())
+	fgs := testharness.NewFakeGraphSync()
+	logger := &fakeLogger{}
+	transport := NewTransport(peers[0], fgs, WithLogger(logger))
+	require.NoError(t, transport.SetEventHandler(&fakeEvents{}))
+
+	fgs.IncomingRequestHook(peers[1], request, &testharness.FakeIncomingRequestHookActions{})
+	require.NotEmpty(t, logger.lines)
+}
+
+func TestMessageSigning(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	self, other := peers[0], peers[1]
+	transferID := datatransfer.TransferID(rand.Uint32())
+	fgs := testharness.NewFakeGraphSync()
+	events := &fakeEvents{
+		OnDataQueuedMessage: testutil.NewDTResponse(t, transferID),
+	}
+	transport := NewTransport(self, fgs, MessageSigning(testutil.FakeSigner{Self: self}, testutil.FakeVerifier{Self: self}))
+	require.NoError(t, transport.SetEventHandler(events))
+
+	// an unsigned request is rejected
+	unsigned := (&gsRequestConfig{}).makeRequest(t, transferID, graphsync.NewRequestID())
+	unsignedActions := &testharness.FakeIncomingRequestHookActions{}
+	fgs.IncomingRequestHook(other, unsigned, unsignedActions)
+	require.ErrorIs(t, unsignedActions.TerminationError, datatransfer.ErrInvalidSignature)
+	require.Zero(t, events.OnRequestReceivedCallCount)
+
+	// a request signed by another peer is rejected
+	signedByOther, err := message.Sign(testutil.NewDTRequest(t, transferID), self, testutil.FakeSigner{Self: testutil.GeneratePeers(1)[0]})
+	require.NoError(t, err)
+	forgedActions := &testharness.FakeIncomingRequestHookActions{}
+	fgs.IncomingRequestHook(other, testharness.NewFakeRequest(graphsync.NewRequestID(), map[graphsync.ExtensionName]datamodel.Node{
+		extension.ExtensionDataTransfer1_1: signedByOther.ToIPLD(),
+	}, graphsync.RequestTypeNew), forgedActions)
+	require.ErrorIs(t, forgedActions.TerminationError, datatransfer.ErrInvalidSignature)
+	require.Zero(t, events.OnRequestReceivedCallCount)
+
+	// a request signed by the sender is accepted
+	signed, err := message.Sign(testutil.NewDTRequest(t, transferID), self, testutil.FakeSigner{Self: other})
+	require.NoError(t, err)
+	request := testharness.NewFakeRequest(graphsync.NewRequestID(), map[graphsync.ExtensionName]datamodel.Node{
+		extension.ExtensionDataTransfer1_1: signed.ToIPLD(),
+	}, graphsync.RequestTypeNew)
+	signedActions := &testharness.FakeIncomingRequestHookActions{}
+	fgs.IncomingRequestHook(other, request, signedActions)
+	require.NoError(t, signedActions.TerminationError)
+	require.Equal(t, 1, events.OnRequestReceivedCallCount)
+
+	// messages sent to the other peer are signed
+	blockActions := &testharness.FakeOutgoingBlockHookActions{}
+	fgs.OutgoingBlockHook(other, request, testharness.NewFakeBlockData(rand.Uint64(), int64(rand.Uint32()), true), blockActions)
+	var sent datatransfer.Message
+	for _, ext := range blockActions.SentExtensions {
+		if ext.Name == extension.ExtensionOutgoingBlock1_1 {
+			sent, err = message.FromIPLD(ext.Data)
+			require.NoError(t, err)
+		}
+	}
+	require.NotNil(t, sent)
+	require.NoError(t, message.Verify(sent, self, testutil.FakeVerifier{Self: other}))
+}
+
+func TestSetEventHandlerHookRegistrationFailure(t *testing.T) {
+	peers := testutil.GeneratePeers(1)
+	fgs := &failingRegistrationGraphSync{FakeGraphSync: testharness.NewFakeGraphSync()}
+	transport := NewTransport(peers[0], fgs)
+
+	err := transport.SetEventHandler(&fakeEvents{})
+	require.ErrorIs(t, err, datatransfer.ErrHookRegistrationFailed)
+
+	// hooks registered before the failure should have been unregistered
+	require.Nil(t, fgs.IncomingRequestProcessingListener)
+	require.Nil(t, fgs.IncomingRequestHook)
+	require.Nil(t, fgs.OutgoingBlockHook)
+
+	// the event handler should not be set, so the transport reports no handler
+	err = transport.OpenChannel(context.Background(), peers[0], datatransfer.ChannelID{}, nil, nil, nil, nil)
+	require.ErrorIs(t, err, datatransfer.ErrHandlerNotSet)
+}
+
+// failingRegistrationGraphSync fails to register a block sent listener
+type failingRegistrationGraphSync struct {
+	*testharness.FakeGraphSync
+}
+
+func (fgs *failingRegistrationGraphSync) RegisterBlockSentListener(graphsync.OnBlockSentListener) graphsync.UnregisterHookFunc {
+	return nil
+}
+
+func TestSetEventHandlerDisabledHooks(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+
+	// a requestor-only node registers only the requestor hooks
+	fgs := testharness.NewFakeGraphSync()
+	transport := NewTransport(peers[0], fgs, DisableResponderHooks())
+	require.NoError(t, transport.SetEventHandler(&fakeEvents{}))
+	require.NotNil(t, fgs.OutgoingRequestHook)
+	require.NotNil(t, fgs.IncomingResponseHook)
+	require.NotNil(t, fgs.IncomingBlockHook)
+	require.Nil(t, fgs.IncomingRequestHook)
+	require.Nil(t, fgs.OutgoingBlockHook)
+	require.Nil(t, fgs.BlockSentListener)
+	require.Nil(t, fgs.CompletedResponseListener)
+	require.NoError(t, transport.Shutdown(context.Background()))
+
+	// a responder-only node registers only the responder hooks, and cannot
+	// open channels
+	fgs = testharness.NewFakeGraphSync()
+	transport = NewTransport(peers[0], fgs, DisableRequestorHooks())
+	require.NoError(t, transport.SetEventHandler(&fakeEvents{}))
+	require.NotNil(t, fgs.IncomingRequestHook)
+	require.NotNil(t, fgs.OutgoingBlockHook)
+	require.NotNil(t, fgs.BlockSentListener)
+	require.NotNil(t, fgs.CompletedResponseListener)
+	require.Nil(t, fgs.OutgoingRequestHook)
+	require.Nil(t, fgs.IncomingResponseHook)
+	require.Nil(t, fgs.IncomingBlockHook)
+	err := transport.OpenChannel(context.Background(), peers[1], datatransfer.ChannelID{ID: 1, Initiator: peers[0], Responder: peers[1]}, nil, nil, nil, nil)
+	require.ErrorIs(t, err, datatransfer.ErrUnsupported)
+	require.NoError(t, transport.Shutdown(context.Background()))
+
+	// disabling both sets of hooks is rejected
+	fgs = testharness.NewFakeGraphSync()
+	transport = NewTransport(peers[0], fgs, DisableResponderHooks(), DisableRequestorHooks())
+	err = transport.SetEventHandler(&fakeEvents{})
+	require.ErrorIs(t, err, datatransfer.ErrUnsupported)
+	require.Nil(t, fgs.OutgoingRequestHook)
+	require.Nil(t, fgs.IncomingRequestHook)
+	err = transport.OpenChannel(context.Background(), peers[1], datatransfer.ChannelID{}, nil, nil, nil, nil)
+	require.ErrorIs(t, err, datatransfer.ErrHandlerNotSet)
+}
+
+func TestEncodeDoNotSendCids(t *testing.T) {
+	ctx := context.Background()
+	expected := testutil.GenerateCids(10)
+	cids := make(chan cid.Cid)
+	go func() {
+		defer close(cids)
+		for _, c := range expected {
+			cids <- c
+		}
+	}()
+	encoded, err := EncodeDoNotSendCids(ctx, cids)
+	require.NoError(t, err)
+	decoded, err := cidset.DecodeCidSet(encoded)
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, decoded.Keys())
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = EncodeDoNotSendCids(ctx, make(chan cid.Cid))
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestToExtensionDataIsDeterministic(t *testing.T) {
+	request := newDTRequest(t, datatransfer.TransferID(rand.Uint32()), false, false)
+	supported := []graphsync.ExtensionName{
+		extension.ExtensionOutgoingBlock1_1,
+		extension.ExtensionDataTransfer1_1,
+		extension.ExtensionIncomingRequest1_1,
+	}
+	reversed := []graphsync.ExtensionName{supported[2], supported[1], supported[0]}
+
+	encode := func(exts []graphsync.ExtensionData) []byte {
+		var buf bytes.Buffer
+		for _, ext := range exts {
+			buf.WriteString(string(ext.Name))
+			require.NoError(t, dagcbor.Encode(ext.Data, &buf))
+		}
+		return buf.Bytes()
+	}
+
+	exts, err := extension.ToExtensionData(request, supported)
+	require.NoError(t, err)
+	expected := encode(exts)
+	for i := 1; i < len(exts); i++ {
+		require.Less(t, string(exts[i-1].Name), string(exts[i].Name))
+	}
+
+	for i := 0; i < 10; i++ {
+		names := supported
+		if i%2 == 1 {
+			names = reversed
+		}
+		exts, err := extension.ToExtensionData(request, names)
+		require.NoError(t, err)
+		require.Equal(t, expected, encode(exts))
+	}
+}
+
+func TestTransportEventsRecorded(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	transferID := datatransfer.TransferID(rand.Uint32())
+	requestID := graphsync.NewRequestID()
+	request := (&gsRequestConfig{}).makeRequest(t, transferID, requestID)
+	response := (&gsResponseConfig{}).makeResponse(t, transferID, requestID)
+	block := testharness.NewFakeBlockData(rand.Uint64(), int64(rand.Uint32()), true)
+	fgs := testharness.NewFakeGraphSync()
+	events := testutil.NewFakeEventsHandler()
+	transport := NewTransport(peers[0], fgs)
+	require.NoError(t, transport.SetEventHandler(events))
+
+	fgs.OutgoingRequestHook(peers[1], request, &testharness.FakeOutgoingRequestHookActions{})
+	fgs.IncomingBlockHook(peers[1], response, block, &testharness.FakeIncomingBlockHookActions{})
+
+	chid := datatransfer.ChannelID{ID: transferID, Initiator: peers[0], Responder: peers[1]}
+	events.AssertChannelOpened(t, chid)
+	events.AssertDataReceived(t, chid, block.Link())
+}
+
+func TestSubscribe(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	transferID := datatransfer.TransferID(rand.Uint32())
+	requestID := graphsync.NewRequestID()
+	request := (&gsRequestConfig{}).makeRequest(t, transferID, requestID)
+	response := (&gsResponseConfig{}).makeResponse(t, transferID, requestID)
+	block := testharness.NewFakeBlockData(rand.Uint64(), int64(rand.Uint32()), true)
+	fgs := testharness.NewFakeGraphSync()
+	events := testutil.NewFakeEventsHandler()
+	transport := NewTransport(peers[0], fgs)
+	require.NoError(t, transport.SetEventHandler(events))
+
+	sub, unsubscribe := transport.Subscribe()
+
+	fgs.OutgoingRequestHook(peers[1], request, &testharness.FakeOutgoingRequestHookActions{})
+	fgs.IncomingBlockHook(peers[1], response, block, &testharness.FakeIncomingBlockHookActions{})
+
+	// the events handler still receives every event
+	chid := datatransfer.ChannelID{ID: transferID, Initiator: peers[0], Responder: peers[1]}
+	events.AssertChannelOpened(t, chid)
+	events.AssertDataReceived(t, chid, block.Link())
+
+	var codes []TransportEventCode
+	for len(sub) > 0 {
+		evt := <-sub
+		require.Equal(t, chid, evt.ChannelID)
+		if evt.Code == DataReceivedEvent {
+			require.Equal(t, block.Link(), evt.Link)
+		}
+		codes = append(codes, evt.Code)
+	}
+	require.Equal(t, []TransportEventCode{ChannelOpenedEvent, TransferStartedEvent, DataReceivedEvent}, codes)
+
+	unsubscribe()
+	_, ok := <-sub
+	require.False(t, ok)
+	unsubscribe()
+}
+
+func TestSubscribeDropsEventsForSlowSubscribers(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	transferID := datatransfer.TransferID(rand.Uint32())
+	requestID := graphsync.NewRequestID()
+	request := (&gsRequestConfig{}).makeRequest(t, transferID, requestID)
+	response := (&gsResponseConfig{}).makeResponse(t, transferID, requestID)
+	block := testharness.NewFakeBlockData(rand.Uint64(), int64(rand.Uint32()), true)
+	fgs := testharness.NewFakeGraphSync()
+	transport := NewTransport(peers[0], fgs)
+	require.NoError(t, transport.SetEventHandler(testutil.NewFakeEventsHandler()))
+
+	sub, unsubscribe := transport.Subscribe()
+	defer unsubscribe()
+
+	fgs.OutgoingRequestHook(peers[1], request, &testharness.FakeOutgoingRequestHookActions{})
+	const blockCount = 200
+	for i := 0; i < blockCount; i++ {
+		fgs.IncomingBlockHook(peers[1], response, block, &testharness.FakeIncomingBlockHookActions{})
+	}
+
+	// the subscriber's buffer fills up, and the rest of the events are dropped
+	// (channel opened + transfer started + one data received per block)
+	require.Equal(t, cap(sub), len(sub))
+	require.Equal(t, uint64(blockCount+2-cap(sub)), transport.DroppedEvents())
+}
+
+func TestFetch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+	self, other := peers[0], peers[1]
+	transferID := datatransfer.TransferID(rand.Uint32())
+	chid := datatransfer.ChannelID{ID: transferID, Initiator: self, Responder: other}
+	msg := testutil.NewDTRequest(t, transferID)
+	fgs := testharness.NewFakeGraphSync()
+	fgs.LeaveRequestsOpen()
+	transport := NewTransport(self, fgs)
+	require.NoError(t, transport.SetEventHandler(testutil.NewFakeEventsHandler()))
+
+	type fetchResult struct {
+		events <-chan FetchEvent
+		err    error
+	}
+	fetched := make(chan fetchResult, 1)
+	go func() {
+		events, err := transport.Fetch(ctx, other, chid, cidlink.Link{Cid: msg.BaseCid()}, selectorparse.CommonSelector_ExploreAllRecursively, msg)
+		fetched <- fetchResult{events, err}
+	}()
+
+	requestReceived := fgs.AssertRequestReceived(ctx, t)
+	requestID := graphsync.NewRequestID()
+	fgs.OutgoingRequestHook(other, (&gsRequestConfig{}).makeRequest(t, transferID, requestID), &testharness.FakeOutgoingRequestHookActions{})
+	result := <-fetched
+	require.NoError(t, result.err)
+
+	// each block received is reported with the running total
+	response := (&gsResponseConfig{}).makeResponse(t, transferID, requestID)
+	first := testharness.NewFakeBlockData(100, 1, true)
+	second := testharness.NewFakeBlockData(50, 2, true)
+	fgs.IncomingBlockHook(other, response, first, &testharness.FakeIncomingBlockHookActions{})
+	fgs.IncomingBlockHook(other, response, second, &testharness.FakeIncomingBlockHookActions{})
+	evt := <-result.events
+	require.Equal(t, FetchEvent{Link: first.Link(), Size: 100, Received: 100}, evt)
+	evt = <-result.events
+	require.Equal(t, FetchEvent{Link: second.Link(), Size: 50, Received: 150}, evt)
+
+	// the last event is sent once the request completes
+	close(requestReceived.ResponseChan)
+	close(requestReceived.ResponseErrChan)
+	evt = <-result.events
+	require.Equal(t, FetchEvent{Received: 150, Done: true}, evt)
+	_, ok := <-result.events
+	require.False(t, ok)
+}
+
+func TestFetchCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	peers := testutil.GeneratePeers(2)
+	self, other := peers[0], peers[1]
+	transferID := datatransfer.TransferID(rand.Uint32())
+	chid := datatransfer.ChannelID{ID: transferID, Initiator: self, Responder: other}
+	msg := testutil.NewDTRequest(t, transferID)
+	fgs := testharness.NewFakeGraphSync()
+	fgs.LeaveRequestsOpen()
+	transport := NewTransport(self, fgs)
+	require.NoError(t, transport.SetEventHandler(testutil.NewFakeEventsHandler()))
+
+	// fetching fails if the channel cannot be opened
+	_, err := NewTransport(self, fgs).Fetch(ctx, other, chid, cidlink.Link{Cid: msg.BaseCid()}, selectorparse.CommonSelector_ExploreAllRecursively, msg)
+	require.ErrorIs(t, err, datatransfer.ErrHandlerNotSet)
+
+	fetchCtx, fetchCancel := context.WithCancel(ctx)
+	fetchErr := make(chan error, 1)
+	var events <-chan FetchEvent
+	go func() {
+		var err error
+		events, err = transport.Fetch(fetchCtx, other, chid, cidlink.Link{Cid: msg.BaseCid()}, selectorparse.CommonSelector_ExploreAllRecursively, msg)
+		fetchErr <- err
+	}()
+	fgs.AssertRequestReceived(ctx, t)
+	fgs.OutgoingRequestHook(other, (&gsRequestConfig{}).makeRequest(t, transferID, graphsync.NewRequestID()), &testharness.FakeOutgoingRequestHookActions{})
+	require.NoError(t, <-fetchErr)
+
+	// cancelling the fetch closes the channel and reports the context error
+	fetchCancel()
+	evt := <-events
+	require.True(t, evt.Done)
+	require.ErrorIs(t, evt.Err, context.Canceled)
+	_, ok := <-events
+	require.False(t, ok)
+	fgs.AssertCancelReceived(ctx, t)
+}
+
+type ctxKey struct{}
+
+// contextEvents records the context passed with each data event
+type contextEvents struct {
+	*testutil.FakeEventsHandler
+	lk       sync.Mutex
+	received []interface{}
+	queued   []interface{}
+	sent     []interface{}
+}
+
+func (ce *contextEvents) OnDataReceivedContext(ctx context.Context, chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	ce.lk.Lock()
+	ce.received = append(ce.received, ctx.Value(ctxKey{}))
+	ce.lk.Unlock()
+	return ce.OnDataReceived(chid, link, size, index, unique)
+}
+
+func (ce *contextEvents) OnDataQueuedContext(ctx context.Context, chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	ce.lk.Lock()
+	ce.queued = append(ce.queued, ctx.Value(ctxKey{}))
+	ce.lk.Unlock()
+	return ce.OnDataQueued(chid, link, size, index, unique)
+}
+
+func (ce *contextEvents) OnDataSentContext(ctx context.Context, chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) error {
+	ce.lk.Lock()
+	ce.sent = append(ce.sent, ctx.Value(ctxKey{}))
+	ce.lk.Unlock()
+	return ce.OnDataSent(chid, link, size, index, unique)
+}
+
+func TestChannelContext(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	self, other := peers[0], peers[1]
+	fgs := testharness.NewFakeGraphSync()
+	events := &contextEvents{FakeEventsHandler: testutil.NewFakeEventsHandler()}
+	transport := NewTransport(self, fgs)
+	require.NoError(t, transport.SetEventHandler(events))
+	sub, unsubscribe := transport.Subscribe()
+	defer unsubscribe()
+
+	// a pull channel opened by the local node receives data with its context
+	pullID := datatransfer.TransferID(rand.Uint32())
+	pullRequestID := graphsync.NewRequestID()
+	pullChid := datatransfer.ChannelID{ID: pullID, Initiator: self, Responder: other}
+	transport.SetChannelContext(pullChid, context.WithValue(context.Background(), ctxKey{}, "pull"))
+	fgs.OutgoingRequestHook(other, (&gsRequestConfig{}).makeRequest(t, pullID, pullRequestID), &testharness.FakeOutgoingRequestHookActions{})
+	block := testharness.NewFakeBlockData(rand.Uint64(), 1, true)
+	fgs.IncomingBlockHook(other, (&gsResponseConfig{}).makeResponse(t, pullID, pullRequestID), block, &testharness.FakeIncomingBlockHookActions{})
+	events.AssertDataReceived(t, pullChid, block.Link())
+
+	// a pull channel opened by the remote peer has no context, so the events
+	// carry the background context
+	remotePullID := datatransfer.TransferID(rand.Uint32())
+	request := (&gsRequestConfig{}).makeRequest(t, remotePullID, graphsync.NewRequestID())
+	fgs.IncomingRequestHook(other, request, &testharness.FakeIncomingRequestHookActions{})
+	fgs.OutgoingBlockHook(other, request, block, &testharness.FakeOutgoingBlockHookActions{})
+	fgs.BlockSentListener(other, request, block)
+
+	events.lk.Lock()
+	require.Equal(t, []interface{}{"pull"}, events.received)
+	require.Equal(t, []interface{}{nil}, events.queued)
+	require.Equal(t, []interface{}{nil}, events.sent)
+	events.lk.Unlock()
+
+	// the data events are still published to subscribers
+	var codes []TransportEventCode
+	for len(sub) > 0 {
+		evt := <-sub
+		if evt.Code == DataReceivedEvent || evt.Code == DataQueuedEvent || evt.Code == DataSentEvent {
+			codes = append(codes, evt.Code)
+		}
+	}
+	require.Equal(t, []TransportEventCode{DataReceivedEvent, DataQueuedEvent, DataSentEvent}, codes)
+}
+
+type fakeLogger struct {
+	lk    sync.Mutex
+	lines []string
+}
+
+func (l *fakeLogger) logf(format string, args ...interface{}) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func (l *fakeLogger) Debugf(format string, args ...interface{}) { l.logf(format, args...) }
+func (l *fakeLogger) Infof(format string, args ...interface{})  { l.logf(format, args...) }
+func (l *fakeLogger) Warnf(format string, args ...interface{})  { l.logf(format, args...) }
+func (l *fakeLogger) Errorf(format string, args ...interface{}) { l.logf(format, args...) }
+
 type fakeEvents struct {
 	ChannelOpenedChannelID      datatransfer.ChannelID
 	RequestReceivedChannelID    datatransfer.ChannelID
@@ -1089,6 +4149,7 @@ type fakeEvents struct {
 	OnChannelOpenedError        error
 	OnDataReceivedCalled        bool
 	OnDataReceivedError         error
+	OnDataReceivedMessage       datatransfer.Message
 	OnDataSentCalled            bool
 	OnRequestReceivedCallCount  int
 	OnRequestReceivedErrors     []error
@@ -1102,18 +4163,38 @@ type fakeEvents struct {
 
 	OnRequestCancelledCalled    bool
 	OnRequestCancelledChannelId datatransfer.ChannelID
+	OnRequestCancelledError     error
+	OnChannelCancelledCalled    bool
+	OnChannelCancelledChannelID datatransfer.ChannelID
+	OnRequestDisconnectedCalled bool
+	OnRequestDisconnectedError  error
 	OnSendDataErrorCalled       bool
 	OnSendDataErrorChannelID    datatransfer.ChannelID
 	OnReceiveDataErrorCalled    bool
 	OnReceiveDataErrorChannelID datatransfer.ChannelID
+	OnStoreErrorCalled          bool
+	OnStoreErrorChannelID       datatransfer.ChannelID
+	OnStoreErrorError           error
+	OnChannelErrorCalled        bool
+	OnChannelErrorChannelID     datatransfer.ChannelID
+	OnChannelErrorReason        error
+	MalformedMessagePeer        peer.ID
+	MalformedMessageExtensions  []string
+	MalformedMessageError       error
 	OnContextAugmentFunc        func(context.Context) context.Context
 	TransferInitiatedCalled     bool
 	TransferInitiatedChannelID  datatransfer.ChannelID
+	TransferStartedCallCount    int
+	TransferStartedChannelID    datatransfer.ChannelID
 
 	ChannelCompletedSuccess  bool
+	ChannelCompletedErr      error
+	ChannelCompletedResult   *datatransfer.CompletionResult
 	RequestReceivedRequest   datatransfer.Request
 	RequestReceivedResponse  datatransfer.Response
 	ResponseReceivedResponse datatransfer.Response
+
+	RequestReceivedAdditionalResponses []datatransfer.Response
 }
 
 func (fe *fakeEvents) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
@@ -1125,6 +4206,14 @@ func (fe *fakeEvents) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link,
 func (fe *fakeEvents) OnRequestCancelled(chid datatransfer.ChannelID, err error) error {
 	fe.OnRequestCancelledCalled = true
 	fe.OnRequestCancelledChannelId = chid
+	fe.OnRequestCancelledError = err
+
+	return nil
+}
+
+func (fe *fakeEvents) OnChannelCancelled(chid datatransfer.ChannelID) error {
+	fe.OnChannelCancelledCalled = true
+	fe.OnChannelCancelledChannelID = chid
 
 	return nil
 }
@@ -1134,7 +4223,14 @@ func (fe *fakeEvents) OnTransferInitiated(chid datatransfer.ChannelID) {
 	fe.TransferInitiatedChannelID = chid
 }
 
+func (fe *fakeEvents) OnTransferStarted(chid datatransfer.ChannelID) {
+	fe.TransferStartedCallCount++
+	fe.TransferStartedChannelID = chid
+}
+
 func (fe *fakeEvents) OnRequestDisconnected(chid datatransfer.ChannelID, err error) error {
+	fe.OnRequestDisconnectedCalled = true
+	fe.OnRequestDisconnectedError = err
 	return nil
 }
 
@@ -1150,14 +4246,35 @@ func (fe *fakeEvents) OnReceiveDataError(chid datatransfer.ChannelID, err error)
 	return nil
 }
 
+func (fe *fakeEvents) OnStoreError(chid datatransfer.ChannelID, err error) error {
+	fe.OnStoreErrorCalled = true
+	fe.OnStoreErrorChannelID = chid
+	fe.OnStoreErrorError = err
+	return nil
+}
+
+func (fe *fakeEvents) OnChannelError(chid datatransfer.ChannelID, reason error) error {
+	fe.OnChannelErrorCalled = true
+	fe.OnChannelErrorChannelID = chid
+	fe.OnChannelErrorReason = reason
+	return nil
+}
+
+func (fe *fakeEvents) OnMalformedMessage(p peer.ID, extensions []string, err error) error {
+	fe.MalformedMessagePeer = p
+	fe.MalformedMessageExtensions = extensions
+	fe.MalformedMessageError = err
+	return nil
+}
+
 func (fe *fakeEvents) OnChannelOpened(chid datatransfer.ChannelID) error {
 	fe.ChannelOpenedChannelID = chid
 	return fe.OnChannelOpenedError
 }
 
-func (fe *fakeEvents) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) error {
+func (fe *fakeEvents) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
 	fe.OnDataReceivedCalled = true
-	return fe.OnDataReceivedError
+	return fe.OnDataReceivedMessage, fe.OnDataReceivedError
 }
 
 func (fe *fakeEvents) OnDataSent(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) error {
@@ -1176,6 +4293,15 @@ func (fe *fakeEvents) OnRequestReceived(chid datatransfer.ChannelID, request dat
 	return fe.RequestReceivedResponse, err
 }
 
+func (fe *fakeEvents) OnRequestReceivedMulti(chid datatransfer.ChannelID, request datatransfer.Request) ([]datatransfer.Response, error) {
+	response, err := fe.OnRequestReceived(chid, request)
+	var responses []datatransfer.Response
+	if response != nil {
+		responses = append(responses, response)
+	}
+	return append(responses, fe.RequestReceivedAdditionalResponses...), err
+}
+
 func (fe *fakeEvents) OnResponseReceived(chid datatransfer.ChannelID, response datatransfer.Response) error {
 	fe.OnResponseReceivedCallCount++
 	fe.ResponseReceivedResponse = response
@@ -1190,9 +4316,15 @@ func (fe *fakeEvents) OnResponseReceived(chid datatransfer.ChannelID, response d
 func (fe *fakeEvents) OnChannelCompleted(chid datatransfer.ChannelID, completeErr error) error {
 	fe.OnChannelCompletedCalled = true
 	fe.ChannelCompletedSuccess = completeErr == nil
+	fe.ChannelCompletedErr = completeErr
 	return fe.OnChannelCompletedErr
 }
 
+func (fe *fakeEvents) OnChannelCompletedDetailed(chid datatransfer.ChannelID, result datatransfer.CompletionResult) error {
+	fe.ChannelCompletedResult = &result
+	return nil
+}
+
 func (fe *fakeEvents) OnContextAugment(chid datatransfer.ChannelID) func(context.Context) context.Context {
 	return fe.OnContextAugmentFunc
 }
@@ -1234,10 +4366,39 @@ func (ha *harness) outgoingBlockHook() {
 	ha.fgs.OutgoingBlockHook(ha.other, ha.request, ha.block, ha.outgoingBlockHookActions)
 }
 
+func (ha *harness) blockSentListener() {
+	ha.fgs.BlockSentListener(ha.other, ha.request, ha.block)
+}
+
+func (ha *harness) sequencedRequestUpdatedHook(seq uint64) {
+	msg, err := message.WithSequence(message.UpdateRequest(ha.transferID, false), seq)
+	if err != nil {
+		panic(err)
+	}
+	update := testharness.NewFakeRequest(ha.request.ID(), map[graphsync.ExtensionName]datamodel.Node{
+		extension.ExtensionDataTransfer1_1: msg.ToIPLD(),
+	}, graphsync.RequestTypeNew)
+	ha.fgs.RequestUpdatedHook(ha.other, ha.request, update, ha.requestUpdatedHookActions)
+}
+
+func (ha *harness) deadlineRequestUpdatedHook(deadline time.Time) {
+	msg, err := message.WithDeadline(message.UpdateRequest(ha.transferID, false), deadline)
+	if err != nil {
+		panic(err)
+	}
+	update := testharness.NewFakeRequest(ha.request.ID(), map[graphsync.ExtensionName]datamodel.Node{
+		extension.ExtensionDataTransfer1_1: msg.ToIPLD(),
+	}, graphsync.RequestTypeNew)
+	ha.fgs.RequestUpdatedHook(ha.other, ha.request, update, ha.requestUpdatedHookActions)
+}
+
 func (ha *harness) incomingRequestHook() {
 	ha.fgs.IncomingRequestHook(ha.other, ha.request, ha.incomingRequestHookActions)
 }
 
+func (ha *harness) altIncomingRequestHook() {
+	ha.fgs.IncomingRequestHook(ha.other, ha.altRequest, ha.incomingRequestHookActions)
+}
 func (ha *harness) requestUpdatedHook() {
 	ha.fgs.RequestUpdatedHook(ha.other, ha.request, ha.updatedRequest, ha.requestUpdatedHookActions)
 }
@@ -1264,10 +4425,16 @@ func (ha *harness) incomingRequestProcessingListener() {
 	ha.fgs.IncomingRequestProcessingListener(ha.other, ha.request, 0)
 }
 
+// dt requests generated by the tests share a base CID so that update requests
+// match the original request unless configured otherwise
+var testBaseCid = testutil.GenerateCids(1)[0]
+
 type dtConfig struct {
 	dtExtensionMissing   bool
 	dtIsResponse         bool
 	dtExtensionMalformed bool
+	dtMismatchedBaseCid  bool
+	dtMismatchedSelector bool
 }
 
 func (dtc *dtConfig) extensions(t *testing.T, transferID datatransfer.TransferID, extName graphsync.ExtensionName) map[graphsync.ExtensionName]datamodel.Node {
@@ -1280,7 +4447,7 @@ func (dtc *dtConfig) extensions(t *testing.T, transferID datatransfer.TransferID
 			if dtc.dtIsResponse {
 				msg = testutil.NewDTResponse(t, transferID)
 			} else {
-				msg = testutil.NewDTRequest(t, transferID)
+				msg = newDTRequest(t, transferID, dtc.dtMismatchedBaseCid, dtc.dtMismatchedSelector)
 			}
 			nd := msg.ToIPLD()
 			extensions[extName] = nd
@@ -1289,10 +4456,27 @@ func (dtc *dtConfig) extensions(t *testing.T, transferID datatransfer.TransferID
 	return extensions
 }
 
+func newDTRequest(t *testing.T, transferID datatransfer.TransferID, mismatchedBaseCid bool, mismatchedSelector bool) datatransfer.Request {
+	voucher := testutil.NewTestTypedVoucher()
+	baseCid := testBaseCid
+	if mismatchedBaseCid {
+		baseCid = testutil.GenerateCids(1)[0]
+	}
+	selector := builder.NewSelectorSpecBuilder(basicnode.Prototype.Any).Matcher().Node()
+	if mismatchedSelector {
+		selector = selectorparse.CommonSelector_ExploreAllRecursively
+	}
+	r, err := message.NewRequest(transferID, false, false, &voucher, baseCid, selector)
+	require.NoError(t, err)
+	return r
+}
+
 type gsRequestConfig struct {
 	dtExtensionMissing   bool
 	dtIsResponse         bool
 	dtExtensionMalformed bool
+	dtMismatchedBaseCid  bool
+	dtMismatchedSelector bool
 }
 
 func (grc *gsRequestConfig) makeRequest(t *testing.T, transferID datatransfer.TransferID, requestID graphsync.RequestID) graphsync.RequestData {
@@ -1300,6 +4484,8 @@ func (grc *gsRequestConfig) makeRequest(t *testing.T, transferID datatransfer.Tr
 		dtExtensionMissing:   grc.dtExtensionMissing,
 		dtIsResponse:         grc.dtIsResponse,
 		dtExtensionMalformed: grc.dtExtensionMalformed,
+		dtMismatchedBaseCid:  grc.dtMismatchedBaseCid,
+		dtMismatchedSelector: grc.dtMismatchedSelector,
 	}
 	extensions := dtConfig.extensions(t, transferID, extension.ExtensionDataTransfer1_1)
 	return testharness.NewFakeRequest(requestID, extensions, graphsync.RequestTypeNew)
@@ -1310,6 +4496,7 @@ type gsResponseConfig struct {
 	dtIsResponse         bool
 	dtExtensionMalformed bool
 	status               graphsync.ResponseStatusCode
+	additionalResponses  int
 }
 
 func (grc *gsResponseConfig) makeResponse(t *testing.T, transferID datatransfer.TransferID, requestID graphsync.RequestID) graphsync.ResponseData {
@@ -1319,6 +4506,15 @@ func (grc *gsResponseConfig) makeResponse(t *testing.T, transferID datatransfer.
 		dtExtensionMalformed: grc.dtExtensionMalformed,
 	}
 	extensions := dtConfig.extensions(t, transferID, extension.ExtensionDataTransfer1_1)
+	if grc.additionalResponses > 0 {
+		additional := make([]datatransfer.Message, 0, grc.additionalResponses)
+		for i := 0; i < grc.additionalResponses; i++ {
+			additional = append(additional, testutil.NewDTResponse(t, transferID))
+		}
+		ext, err := extension.ToAdditionalMessagesExtensionData(additional)
+		require.NoError(t, err)
+		extensions[ext.Name] = ext.Data
+	}
 	return testharness.NewFakeResponse(requestID, extensions, grc.status)
 }
 
@@ -1342,6 +4538,33 @@ func assertHasOutgoingMessage(t *testing.T, extensions []graphsync.ExtensionData
 	}
 }
 
+// responseStatusCodes returns the codes of the response status events in the
+// history
+func responseStatusCodes(history []RecordedEvent) []TransportEventCode {
+	var codes []TransportEventCode
+	for _, evt := range history {
+		if evt.Code == RequestPausedEvent || evt.Code == PartialResponseEvent {
+			codes = append(codes, evt.Code)
+		}
+	}
+	return codes
+}
+
+func assertHasAdditionalMessages(t *testing.T, extensions []graphsync.ExtensionData, expected []datatransfer.Message) {
+	for _, e := range extensions {
+		if e.Name == extension.ExtensionAdditionalMessages1_1 {
+			require.Equal(t, int64(len(expected)), e.Data.Length())
+			for i, msg := range expected {
+				nd, err := e.Data.LookupByIndex(int64(i))
+				require.NoError(t, err)
+				require.True(t, ipld.DeepEqual(msg.ToIPLD(), nd), "message %d matches", i)
+			}
+			return
+		}
+	}
+	require.Fail(t, "additional messages extension not found")
+}
+
 func assertHasExtensionMessage(t *testing.T, name graphsync.ExtensionName, extensions []graphsync.ExtensionData, expected datatransfer.Message) {
 	nd := expected.ToIPLD()
 	found := false
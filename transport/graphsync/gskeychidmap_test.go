@@ -60,3 +60,43 @@ func TestRequestIDToChannelIDMap(t *testing.T) {
 	_, ok = m.load(requestID2)
 	require.False(t, ok)
 }
+
+func TestRequestIDToChannelIDMapPeerIndex(t *testing.T) {
+	m := newRequestIDToChannelIDMap()
+	requestID1 := graphsync.NewRequestID()
+	requestID2 := graphsync.NewRequestID()
+	requestID3 := graphsync.NewRequestID()
+	chid1 := datatransfer.ChannelID{Initiator: "i", Responder: "r1", ID: 1}
+	chid2 := datatransfer.ChannelID{Initiator: "i", Responder: "r2", ID: 2}
+
+	require.Empty(t, m.peerChannels("i"))
+
+	// a channel is listed once for each of its parties, however many
+	// request IDs map to it
+	m.set(requestID1, false, chid1)
+	m.set(requestID2, false, chid1)
+	m.set(requestID3, true, chid2)
+	require.ElementsMatch(t, []datatransfer.ChannelID{chid1, chid2}, m.peerChannels("i"))
+	require.Equal(t, []datatransfer.ChannelID{chid1}, m.peerChannels("r1"))
+	require.Equal(t, []datatransfer.ChannelID{chid2}, m.peerChannels("r2"))
+
+	// the channel stays indexed until its last request ID is removed
+	m.delete(requestID1)
+	require.Equal(t, []datatransfer.ChannelID{chid1}, m.peerChannels("r1"))
+	m.delete(requestID2)
+	require.Empty(t, m.peerChannels("r1"))
+	require.Equal(t, []datatransfer.ChannelID{chid2}, m.peerChannels("i"))
+
+	// re-mapping a request ID moves it to the new channel's peers
+	m.set(requestID3, true, chid1)
+	require.Empty(t, m.peerChannels("r2"))
+	require.Equal(t, []datatransfer.ChannelID{chid1}, m.peerChannels("r1"))
+
+	m.deleteRefs(chid1)
+	require.Empty(t, m.peerChannels("i"))
+	require.Empty(t, m.byPeer)
+
+	m.set(requestID1, false, chid2)
+	m.clear()
+	require.Empty(t, m.peerChannels("i"))
+}
@@ -0,0 +1,118 @@
+package graphsync
+
+import (
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// RecordedEvent is a transport event recorded in a channel's history
+type RecordedEvent struct {
+	TransportEvent
+	// Time is when the transport reported the event
+	Time time.Time
+}
+
+// eventRing holds the most recent events for a channel, overwriting the
+// oldest event once it is full
+type eventRing struct {
+	events []RecordedEvent
+	// next is the index the next event is written to once the ring is full
+	next int
+}
+
+func (r *eventRing) add(evt RecordedEvent, size int) {
+	if len(r.events) < size {
+		r.events = append(r.events, evt)
+		return
+	}
+	r.events[r.next] = evt
+	r.next = (r.next + 1) % size
+}
+
+// list returns the events from oldest to newest
+func (r *eventRing) list() []RecordedEvent {
+	events := make([]RecordedEvent, 0, len(r.events))
+	events = append(events, r.events[r.next:]...)
+	return append(events, r.events[:r.next]...)
+}
+
+// channelHistory records the last events for each channel. Histories are
+// kept after a channel is cleaned up so that they can be inspected after a
+// failure, so only the histories of the most recently opened channels are
+// kept.
+type channelHistory struct {
+	lk          sync.Mutex
+	eventsSize  int
+	maxChannels int
+	rings       map[datatransfer.ChannelID]*eventRing
+	// order is the channels in the order their histories were started
+	order []datatransfer.ChannelID
+}
+
+func newChannelHistory(eventsSize int, maxChannels int) *channelHistory {
+	return &channelHistory{
+		eventsSize:  eventsSize,
+		maxChannels: maxChannels,
+		rings:       make(map[datatransfer.ChannelID]*eventRing),
+	}
+}
+
+func (h *channelHistory) record(evt TransportEvent) {
+	if h == nil {
+		return
+	}
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	ring, ok := h.rings[evt.ChannelID]
+	if !ok {
+		// Make room by dropping the oldest channel's history
+		if len(h.order) >= h.maxChannels {
+			delete(h.rings, h.order[0])
+			h.order = h.order[1:]
+		}
+		ring = &eventRing{}
+		h.rings[evt.ChannelID] = ring
+		h.order = append(h.order, evt.ChannelID)
+	}
+	ring.add(RecordedEvent{TransportEvent: evt, Time: time.Now()}, h.eventsSize)
+}
+
+func (h *channelHistory) get(chid datatransfer.ChannelID) []RecordedEvent {
+	if h == nil {
+		return nil
+	}
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	ring, ok := h.rings[chid]
+	if !ok {
+		return nil
+	}
+	return ring.list()
+}
+
+func (h *channelHistory) clear() {
+	if h == nil {
+		return
+	}
+
+	h.lk.Lock()
+	defer h.lk.Unlock()
+
+	h.rings = make(map[datatransfer.ChannelID]*eventRing)
+	h.order = nil
+}
+
+// ChannelHistory returns the last events the transport reported for the
+// channel, from oldest to newest, with the time each event was reported.
+// It returns nil unless history recording was enabled with the
+// RecordChannelHistory option, or if the channel's history is not (or no
+// longer) recorded.
+func (t *Transport) ChannelHistory(chid datatransfer.ChannelID) []RecordedEvent {
+	return t.history.get(chid)
+}
@@ -0,0 +1,49 @@
+package graphsync
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+func TestChannelHistory(t *testing.T) {
+	chid := func(id datatransfer.TransferID) datatransfer.ChannelID {
+		return datatransfer.ChannelID{Initiator: "i", Responder: "r", ID: id}
+	}
+	codes := func(events []RecordedEvent) []TransportEventCode {
+		var codes []TransportEventCode
+		for _, evt := range events {
+			codes = append(codes, evt.Code)
+		}
+		return codes
+	}
+
+	h := newChannelHistory(3, 2)
+	h.record(TransportEvent{Code: ChannelOpenedEvent, ChannelID: chid(1)})
+	h.record(TransportEvent{Code: DataReceivedEvent, ChannelID: chid(1)})
+	require.Equal(t, []TransportEventCode{ChannelOpenedEvent, DataReceivedEvent}, codes(h.get(chid(1))))
+
+	// once a channel's history is full the oldest events are overwritten
+	h.record(TransportEvent{Code: DataReceivedEvent, ChannelID: chid(1)})
+	h.record(TransportEvent{Code: ChannelCompletedEvent, ChannelID: chid(1)})
+	require.Equal(t, []TransportEventCode{DataReceivedEvent, DataReceivedEvent, ChannelCompletedEvent}, codes(h.get(chid(1))))
+
+	// once the maximum number of channels have a history, the oldest
+	// channel's history is dropped
+	h.record(TransportEvent{Code: ChannelOpenedEvent, ChannelID: chid(2)})
+	require.Len(t, h.get(chid(1)), 3)
+	h.record(TransportEvent{Code: ChannelOpenedEvent, ChannelID: chid(3)})
+	require.Nil(t, h.get(chid(1)))
+	require.Equal(t, []TransportEventCode{ChannelOpenedEvent}, codes(h.get(chid(2))))
+	require.Equal(t, []TransportEventCode{ChannelOpenedEvent}, codes(h.get(chid(3))))
+
+	h.clear()
+	require.Nil(t, h.get(chid(2)))
+
+	// recording is a no-op when history is disabled
+	var disabled *channelHistory
+	disabled.record(TransportEvent{Code: ChannelOpenedEvent, ChannelID: chid(1)})
+	require.Nil(t, disabled.get(chid(1)))
+}
@@ -0,0 +1,114 @@
+package graphsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// PeerLoad is the number of graphsync requests for data that the transport
+// has issued to a peer
+type PeerLoad struct {
+	// Active is the number of requests that graphsync has opened and that
+	// have not yet completed
+	Active int
+	// Pending is the number of requests that are waiting for graphsync to
+	// open them
+	Pending int
+}
+
+// LoadStats describes how many graphsync requests the transport has
+// outstanding
+type LoadStats struct {
+	// Peers is the load for each peer that has outstanding requests
+	Peers map[peer.ID]PeerLoad
+	// Paused is the number of channels that are paused at the transport
+	Paused int
+}
+
+// requestLoad counts the graphsync requests the transport has issued to each
+// peer
+type requestLoad struct {
+	lk    sync.Mutex
+	peers map[peer.ID]PeerLoad
+}
+
+func newRequestLoad() *requestLoad {
+	return &requestLoad{peers: make(map[peer.ID]PeerLoad)}
+}
+
+// record that a request to the peer is being opened
+func (l *requestLoad) opening(p peer.ID) {
+	l.update(p, func(pl *PeerLoad) {
+		pl.Pending++
+	})
+}
+
+// record that a request that was being opened either opened successfully or
+// failed to open
+func (l *requestLoad) opened(p peer.ID, ok bool) {
+	l.update(p, func(pl *PeerLoad) {
+		pl.Pending--
+		if ok {
+			pl.Active++
+		}
+	})
+}
+
+// record that an open request completed
+func (l *requestLoad) completed(p peer.ID) {
+	l.update(p, func(pl *PeerLoad) {
+		pl.Active--
+	})
+}
+
+func (l *requestLoad) update(p peer.ID, f func(pl *PeerLoad)) {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	pl := l.peers[p]
+	f(&pl)
+	if pl.Active == 0 && pl.Pending == 0 {
+		delete(l.peers, p)
+		return
+	}
+	l.peers[p] = pl
+}
+
+// snapshot returns a copy of the load for each peer
+func (l *requestLoad) snapshot() map[peer.ID]PeerLoad {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+
+	peers := make(map[peer.ID]PeerLoad, len(l.peers))
+	for p, pl := range l.peers {
+		peers[p] = pl
+	}
+	return peers
+}
+
+// TransportLoad returns the number of graphsync requests for data that the
+// transport has outstanding with each peer, and the number of paused channels
+func (t *Transport) TransportLoad() LoadStats {
+	return LoadStats{
+		Peers:  t.requestLoad.snapshot(),
+		Paused: len(t.pausedChannels.list()),
+	}
+}
+
+// reportLoad calls the load callback with the transport load at each
+// interval, until the transport is shut down
+func (t *Transport) reportLoad() {
+	ticker := time.NewTicker(t.loadCallbackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.loadCallback(t.TransportLoad())
+		case <-t.stopLoadReports:
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package graphsync
+
+import (
+	"github.com/ipfs/go-graphsync"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/go-data-transfer/v2/transport/graphsync/extension"
+)
+
+var _ datatransfer.MultiResponseEventsHandler = (*mirroredEvents)(nil)
+
+func (me *mirroredEvents) OnRequestReceivedMulti(chid datatransfer.ChannelID, msg datatransfer.Request) ([]datatransfer.Response, error) {
+	mr, ok := me.events.(datatransfer.MultiResponseEventsHandler)
+	if !ok {
+		response, err := me.OnRequestReceived(chid, msg)
+		if response == nil {
+			return nil, err
+		}
+		return []datatransfer.Response{response}, err
+	}
+	responses, err := mr.OnRequestReceivedMulti(chid, msg)
+	me.publish(TransportEvent{Code: RequestReceivedEvent, ChannelID: chid, Request: msg})
+	return responses, err
+}
+
+// responseExtensions converts the messages sent in reply to a new request to
+// graphsync extensions. The first message is attached as a single message
+// always is, so that peers that don't know about additional messages still
+// receive it. The rest are attached in order in the
+// ExtensionAdditionalMessages1_1 extension: graphsync keeps only one
+// extension of each name per message, so they can't be attached separately.
+func (t *Transport) responseExtensions(chid datatransfer.ChannelID, responses []datatransfer.Response) ([]graphsync.ExtensionData, error) {
+	msgs := make([]datatransfer.Message, 0, len(responses))
+	for _, response := range responses {
+		if response != nil {
+			msgs = append(msgs, response)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil, nil
+	}
+
+	// gsReqRecdHook uses a unique extension name so it can be attached with data from a different hook
+	// incomingReqExtensions also includes default extension name so it remains compatible with previous data-transfer
+	// protocol versions out there.
+	exts, err := t.toExtensionData(chid, msgs[0], incomingReqExtensions)
+	if err != nil || len(msgs) == 1 {
+		return exts, err
+	}
+
+	additional := make([]datatransfer.Message, 0, len(msgs)-1)
+	for _, msg := range msgs[1:] {
+		prepared, err := t.prepareMessage(chid, msg)
+		if err != nil {
+			return nil, err
+		}
+		additional = append(additional, prepared)
+	}
+	ext, err := extension.ToAdditionalMessagesExtensionData(additional)
+	if err != nil {
+		return nil, err
+	}
+	exts = append(exts, ext)
+	extension.SortExtensions(exts)
+	return exts, nil
+}
+
+// processAdditionalMessages passes the messages in the
+// ExtensionAdditionalMessages1_1 extension to the events handler in the
+// order they were sent, stopping at the first error
+func (t *Transport) processAdditionalMessages(chid datatransfer.ChannelID, gsMsg extension.GsExtended, p peer.ID) error {
+	msgs, err := extension.GetAdditionalMessages(gsMsg)
+	if err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		reply, err := t.processMessage(chid, msg, p)
+		if err != nil {
+			return err
+		}
+		// Only one message can be sent to the other peer with each update,
+		// and that is the reply to the first message
+		if reply != nil {
+			t.log.Warnf("%s: dropping reply to additional message from %s", chid, p)
+		}
+	}
+	return nil
+}
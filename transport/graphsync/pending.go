@@ -0,0 +1,99 @@
+package graphsync
+
+import (
+	"github.com/ipfs/go-graphsync"
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/ipld/go-ipld-prime/codec/dagcbor"
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// queuePendingExtensions queues extension data to be sent when the requester
+// restarts the request it cancelled. Returns an error wrapping
+// ErrPendingExtensionsLimit if queuing the data would exceed the per-channel
+// or total limit (see MaxPendingExtensionBytes).
+// Note: must be called under the lock.
+func (c *dtChannel) queuePendingExtensions(exts []graphsync.ExtensionData) error {
+	if len(exts) == 0 {
+		return nil
+	}
+	if c.t.maxPendingExtBytesPerChannel == 0 && c.t.maxPendingExtBytesTotal == 0 {
+		c.pendingExtensions = append(c.pendingExtensions, exts...)
+		return nil
+	}
+
+	size, err := extensionsSize(exts)
+	if err != nil {
+		return xerrors.Errorf("%s: measuring extensions to queue: %w", c.channelID, err)
+	}
+	if limit := c.t.maxPendingExtBytesPerChannel; limit > 0 && c.pendingExtensionsSize+size > limit {
+		return xerrors.Errorf("%s: queuing %d bytes would exceed the limit of %d bytes per channel: %w",
+			c.channelID, size, limit, datatransfer.ErrPendingExtensionsLimit)
+	}
+	if err := c.t.reservePendingExtensionBytes(size); err != nil {
+		return xerrors.Errorf("%s: %w", c.channelID, err)
+	}
+
+	c.pendingExtensions = append(c.pendingExtensions, exts...)
+	c.pendingExtensionsSize += size
+	return nil
+}
+
+// takePendingExtensions removes and returns the queued extension data.
+// Note: must be called under the lock.
+func (c *dtChannel) takePendingExtensions() []graphsync.ExtensionData {
+	exts := c.pendingExtensions
+	c.pendingExtensions = nil
+	c.t.releasePendingExtensionBytes(c.pendingExtensionsSize)
+	c.pendingExtensionsSize = 0
+	return exts
+}
+
+// reservePendingExtensionBytes counts size bytes towards the total limit on
+// queued extension data, or returns an error if that would exceed the limit
+func (t *Transport) reservePendingExtensionBytes(size uint64) error {
+	t.pendingExtLk.Lock()
+	defer t.pendingExtLk.Unlock()
+
+	if limit := t.maxPendingExtBytesTotal; limit > 0 && t.pendingExtBytes+size > limit {
+		return xerrors.Errorf("queuing %d bytes would exceed the total limit of %d bytes: %w",
+			size, limit, datatransfer.ErrPendingExtensionsLimit)
+	}
+	t.pendingExtBytes += size
+	return nil
+}
+
+func (t *Transport) releasePendingExtensionBytes(size uint64) {
+	t.pendingExtLk.Lock()
+	defer t.pendingExtLk.Unlock()
+
+	t.pendingExtBytes -= size
+}
+
+// PendingExtensionBytes returns the size of the messages queued to be sent to
+// requesters that cancelled, across all channels. It is only counted when the
+// size is limited with MaxPendingExtensionBytes.
+func (t *Transport) PendingExtensionBytes() uint64 {
+	t.pendingExtLk.Lock()
+	defer t.pendingExtLk.Unlock()
+
+	return t.pendingExtBytes
+}
+
+// The size of the extensions' names and encoded data
+func extensionsSize(exts []graphsync.ExtensionData) (uint64, error) {
+	var size uint64
+	for _, ext := range exts {
+		size += uint64(len(ext.Name))
+		if ext.Data == nil {
+			continue
+		}
+		data, err := ipld.Encode(ext.Data, dagcbor.Encode)
+		if err != nil {
+			return 0, err
+		}
+		size += uint64(len(data))
+	}
+	return size, nil
+}
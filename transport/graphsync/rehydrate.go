@@ -0,0 +1,102 @@
+package graphsync
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// RehydrateChannels rebuilds the transport's bookkeeping for channels that
+// were in progress before the process restarted, from their persisted state,
+// so that restarting them behaves as it would have before the process
+// restarted. It returns the IDs of the channels it rebuilt.
+//
+// The following is rebuilt for each channel:
+//   - whether it is a push or a pull (see IsPull)
+//   - the base CID and selector it was opened with, which updates from the
+//     requester are checked against
+//   - the number of bytes transferred, and whether any data has crossed the
+//     wire (so OnTransferStarted does not fire again)
+//   - that the channel was opened before, so that a restart request received
+//     before any data was transferred is paused as usual (see
+//     AutoPauseRestart)
+//
+// The following can't be rebuilt, and is replaced when the channel is
+// restarted, which opens a fresh graphsync request:
+//   - the graphsync request and its request ID, which graphsync forgot when
+//     the process restarted
+//   - the set of CIDs received, if it was tracked (see TrackReceivedCids).
+//     The restart request still asks the responder to skip the number of
+//     blocks already received
+//   - message sequence numbers, which the restart message starts again
+//   - the channel's store and other per-channel configuration (see UseStore,
+//     UseExtensions etc), which must be set again by the caller
+//   - messages queued for a requester that cancelled (see
+//     PendingExtensions)
+//
+// Channels whose transfer has completed, that belong to another peer or that
+// the transport is already tracking are skipped.
+func (t *Transport) RehydrateChannels(chsts []datatransfer.ChannelState) ([]datatransfer.ChannelID, error) {
+	if err := t.checkOpen(); err != nil {
+		return nil, xerrors.Errorf("rehydrating channels: %w", err)
+	}
+
+	rehydrated := make([]datatransfer.ChannelID, 0, len(chsts))
+	for _, chst := range chsts {
+		chid := chst.ChannelID()
+		if chst.SelfPeer() != t.peerID || chst.Status().TransferComplete() {
+			continue
+		}
+
+		// Restore the channel before tracking it, so that graphsync hooks
+		// never see it half restored
+		ch := t.newDTChannel(chid)
+		ch.rehydrate(chst)
+
+		t.dtChannelsLk.Lock()
+		_, ok := t.dtChannels[chid]
+		if !ok {
+			t.dtChannels[chid] = ch
+		}
+		t.dtChannelsLk.Unlock()
+		if ok {
+			continue
+		}
+
+		t.log.Debugf("%s: rehydrated channel from persisted state", chid)
+		rehydrated = append(rehydrated, chid)
+	}
+	return rehydrated, nil
+}
+
+// rehydrate restores the state of the channel from its persisted state
+func (c *dtChannel) rehydrate(chst datatransfer.ChannelState) {
+	c.setDirection(chst.IsPull())
+
+	transferred := chst.Received()
+	if chst.Sender() == c.t.peerID {
+		transferred = chst.Sent()
+	}
+	atomic.StoreUint64(&c.bytesTransferred, transferred)
+
+	c.dataStartedLk.Lock()
+	c.dataStarted = transferred > 0
+	c.dataStartedLk.Unlock()
+
+	c.lk.Lock()
+	defer c.lk.Unlock()
+
+	c.rehydrated = true
+	c.xferStarted = transferred > 0
+	c.baseCid = chst.BaseCID()
+	c.selector = chst.Selector()
+}
+
+// isRestart returns true if the channel was opened before, either since the
+// process started or before it restarted (see RehydrateChannels).
+// Note: Must be called under the lock.
+func (c *dtChannel) isRestart() bool {
+	return c.isOpen || c.rehydrated
+}
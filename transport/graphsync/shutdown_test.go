@@ -0,0 +1,64 @@
+package graphsync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/go-data-transfer/v2/testutil"
+	"github.com/filecoin-project/go-data-transfer/v2/transport/graphsync/testharness"
+)
+
+func TestShutdownPartialWiring(t *testing.T) {
+	ctx := context.Background()
+	peers := testutil.GeneratePeers(1)
+
+	// a fully wired transport shuts down cleanly
+	transport := NewTransport(peers[0], testharness.NewFakeGraphSync())
+	require.NoError(t, transport.SetEventHandler(&noopEvents{}))
+	require.NoError(t, transport.Shutdown(ctx))
+
+	// simulate a transport that was only partially wired up to graphsync
+	transport = NewTransport(peers[0], testharness.NewFakeGraphSync())
+	unregistered := 0
+	transport.unregisterFuncs = []namedUnregisterFunc{
+		{name: "incoming request hook", unregister: func() { unregistered++ }},
+		{name: "incoming block hook"},
+		{name: "outgoing block hook", unregister: func() { unregistered++ }},
+		{name: "block sent listener"},
+	}
+
+	err := transport.Shutdown(ctx)
+	require.ErrorIs(t, err, datatransfer.ErrHookRegistrationFailed)
+	require.Contains(t, err.Error(), "incoming block hook, block sent listener")
+	// the hooks that were registered are still unregistered
+	require.Equal(t, 2, unregistered)
+}
+
+func TestUseAfterShutdown(t *testing.T) {
+	ctx := context.Background()
+	peers := testutil.GeneratePeers(2)
+	fgs := testharness.NewFakeGraphSync()
+	transport := NewTransport(peers[0], fgs)
+	require.NoError(t, transport.SetEventHandler(&noopEvents{}))
+	require.NoError(t, transport.Shutdown(ctx))
+
+	// opening a channel fails without making a graphsync request
+	msg := testutil.NewDTRequest(t, datatransfer.TransferID(1))
+	chid := datatransfer.ChannelID{ID: 1, Initiator: peers[0], Responder: peers[1]}
+	err := transport.OpenChannel(ctx, peers[1], chid, nil, nil, nil, msg)
+	require.ErrorIs(t, err, datatransfer.ErrTransportClosed)
+	fgs.AssertNoRequestReceived(t)
+
+	err = transport.PauseChannel(ctx, chid)
+	require.ErrorIs(t, err, datatransfer.ErrTransportClosed)
+	err = transport.ResumeChannel(ctx, nil, chid)
+	require.ErrorIs(t, err, datatransfer.ErrTransportClosed)
+}
+
+// noopEvents is an events handler that ignores all events
+type noopEvents struct {
+	datatransfer.EventsHandler
+}
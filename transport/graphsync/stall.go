@@ -0,0 +1,100 @@
+package graphsync
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// dataActivity records that data was sent or received on the channel. The
+// first block starts the stall timer (see StallTimeout)
+func (c *dtChannel) dataActivity() {
+	if c.t.stallTimeout <= 0 {
+		return
+	}
+
+	c.stallLk.Lock()
+	defer c.stallLk.Unlock()
+
+	c.lastActivity = time.Now()
+	if c.stallTimer == nil {
+		c.stallTimer = time.AfterFunc(c.t.stallTimeout, c.checkStalled)
+	}
+}
+
+// stallResumed is called when the channel is resumed, so that the time the
+// channel spent paused does not count towards the stall timeout
+func (c *dtChannel) stallResumed() {
+	c.stallLk.Lock()
+	defer c.stallLk.Unlock()
+
+	if c.stallTimer != nil {
+		c.lastActivity = time.Now()
+	}
+}
+
+// stopStallTimer stops watching the channel for stalls
+func (c *dtChannel) stopStallTimer() {
+	c.stallLk.Lock()
+	defer c.stallLk.Unlock()
+
+	if c.stallTimer != nil {
+		c.stallTimer.Stop()
+		c.stallTimer = nil
+	}
+}
+
+// checkStalled is called when the stall timer fires. It cancels the channel
+// if no data has crossed the wire for the stall timeout, or otherwise waits
+// until the timeout would next expire.
+func (c *dtChannel) checkStalled() {
+	c.stallLk.Lock()
+
+	// The timer was stopped, or the channel completed
+	if c.stallTimer == nil || c.getOutcome().isSet() {
+		c.stallTimer = nil
+		c.stallLk.Unlock()
+		return
+	}
+
+	// A paused channel is not expected to transfer data, so the timer is
+	// suspended until it is resumed
+	now := time.Now()
+	if c.t.pausedChannels.has(c.channelID) {
+		c.lastActivity = now
+		c.stallTimer.Reset(c.t.stallTimeout)
+		c.stallLk.Unlock()
+		return
+	}
+
+	idle := now.Sub(c.lastActivity)
+	if idle < c.t.stallTimeout {
+		c.stallTimer.Reset(c.t.stallTimeout - idle)
+		c.stallLk.Unlock()
+		return
+	}
+
+	c.stallTimer = nil
+	c.stallLk.Unlock()
+
+	c.t.channelStalled(c, idle)
+}
+
+// channelStalled cancels the graphsync request for a channel that has
+// stalled and tells the events handler that the channel was disconnected
+func (t *Transport) channelStalled(ch *dtChannel, idle time.Duration) {
+	chid := ch.channelID
+	err := xerrors.Errorf("%s: no data transferred for %s: %w", chid, idle.Round(time.Millisecond), datatransfer.ErrChannelStalled)
+	t.log.Warnf("%s: cancelling stalled channel: %s", chid, err)
+
+	ch.setOutcome(err)
+	if closeErr := ch.close(context.TODO()); closeErr != nil {
+		t.log.Warnf("%s: cancelling stalled channel: %s", chid, closeErr)
+	}
+	if handlerErr := t.events.OnRequestDisconnected(chid, err); handlerErr != nil {
+		t.log.Errorf("%s: processing OnRequestDisconnected: %s", chid, handlerErr)
+	}
+}
@@ -0,0 +1,86 @@
+package graphsync
+
+import (
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// TransportStatus is a coarse description of the state of a channel at the
+// transport level. Each channel the transport is tracking has exactly one
+// status: where more than one applies, the first status in the order below
+// is used.
+type TransportStatus int
+
+const (
+	// TransportStatusCompleted is a channel whose outgoing graphsync request
+	// has completed (successfully or not), but that has not yet been cleaned
+	// up
+	TransportStatusCompleted TransportStatus = iota
+
+	// TransportStatusRequesterCancelled is a channel on which the local node
+	// is responding to a graphsync request that the requester cancelled
+	TransportStatusRequesterCancelled
+
+	// TransportStatusPaused is a channel that is paused at the transport
+	// (see PausedChannels)
+	TransportStatusPaused
+
+	// TransportStatusActive is a channel with an open graphsync request, in
+	// either direction
+	TransportStatusActive
+
+	// TransportStatusPending is a channel that the transport is tracking but
+	// that does not yet have an open graphsync request, for example because
+	// the request is still being opened
+	TransportStatusPending
+)
+
+// status returns the transport status of the channel
+func (c *dtChannel) status() TransportStatus {
+	// The lock is held while the graphsync request is being opened, so check
+	// for a pending open first to avoid waiting for it
+	c.pendingOpenLk.Lock()
+	opening := c.cancelOpen != nil
+	c.pendingOpenLk.Unlock()
+	if opening {
+		return TransportStatusPending
+	}
+
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+
+	if c.completed != nil {
+		select {
+		case <-c.completed:
+			return TransportStatusCompleted
+		default:
+		}
+	}
+	if c.requesterCancelled {
+		return TransportStatusRequesterCancelled
+	}
+	if c.t.pausedChannels.has(c.channelID) {
+		return TransportStatusPaused
+	}
+	if c.isOpen {
+		return TransportStatusActive
+	}
+	return TransportStatusPending
+}
+
+// ChannelsByStatus returns the channels the transport is tracking, grouped by
+// their transport status. Statuses without any channels are omitted.
+func (t *Transport) ChannelsByStatus() map[TransportStatus][]datatransfer.ChannelID {
+	t.dtChannelsLk.RLock()
+	chs := make([]*dtChannel, 0, len(t.dtChannels))
+	for _, ch := range t.dtChannels {
+		chs = append(chs, ch)
+	}
+	t.dtChannelsLk.RUnlock()
+
+	byStatus := make(map[TransportStatus][]datatransfer.ChannelID)
+	for _, ch := range chs {
+		status := ch.status()
+		byStatus[status] = append(byStatus[status], ch.channelID)
+	}
+	return byStatus
+}
@@ -0,0 +1,86 @@
+package graphsync
+
+import (
+	"golang.org/x/xerrors"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// RegisteredStoreCount returns the number of stores the transport has
+// registered with graphsync, counting the store of each channel (see
+// UseStore) and each named store (see RegisterNamedStore)
+func (t *Transport) RegisteredStoreCount() int {
+	t.storesLk.Lock()
+	defer t.storesLk.Unlock()
+
+	return t.storeCount
+}
+
+// reserveStore counts a store that is about to be registered, making room for
+// it if the limit has been reached by releasing the store of a completed
+// channel other than ch. Returns ErrStoreLimit if there is no room.
+func (t *Transport) reserveStore(ch *dtChannel) error {
+	if t.tryReserveStore() {
+		return nil
+	}
+	if t.evictCompletedStore(ch) && t.tryReserveStore() {
+		return nil
+	}
+	return xerrors.Errorf("%d stores registered: %w", t.maxStores, datatransfer.ErrStoreLimit)
+}
+
+func (t *Transport) tryReserveStore() bool {
+	t.storesLk.Lock()
+	defer t.storesLk.Unlock()
+
+	if t.maxStores > 0 && t.storeCount >= t.maxStores {
+		return false
+	}
+	t.storeCount++
+	return true
+}
+
+// releaseStoreCount stops counting a store that was unregistered, or that
+// failed to register
+func (t *Transport) releaseStoreCount() {
+	t.storesLk.Lock()
+	defer t.storesLk.Unlock()
+
+	t.storeCount--
+}
+
+// evictCompletedStore releases the store of a channel that has completed,
+// other than ch, returning false if there is no such channel
+func (t *Transport) evictCompletedStore(ch *dtChannel) bool {
+	t.dtChannelsLk.RLock()
+	completed := make([]*dtChannel, 0, len(t.dtChannels))
+	for _, other := range t.dtChannels {
+		if other != ch && other.getOutcome().isSet() {
+			completed = append(completed, other)
+		}
+	}
+	t.dtChannelsLk.RUnlock()
+
+	for _, other := range completed {
+		if other.evictStore() {
+			t.log.Debugf("%s: released store of completed channel to make room for another store", other.channelID)
+			return true
+		}
+	}
+	return false
+}
+
+// evictStore releases the channel's store, if it has one registered. If the
+// channel is restarted, its graphsync request fires an OnStoreError event
+// rather than silently using the default store.
+func (c *dtChannel) evictStore() bool {
+	c.storeLk.Lock()
+	defer c.storeLk.Unlock()
+
+	if !c.storeRegistered {
+		return false
+	}
+	c.releaseStoreLocked()
+	c.storeErr = xerrors.Errorf("%s: store released after channel completed: %w", c.channelID, datatransfer.ErrStoreLimit)
+	return true
+}
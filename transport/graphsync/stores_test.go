@@ -0,0 +1,73 @@
+package graphsync
+
+import (
+	"testing"
+
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+	"github.com/filecoin-project/go-data-transfer/v2/testutil"
+	"github.com/filecoin-project/go-data-transfer/v2/transport/graphsync/testharness"
+)
+
+func TestMaxRegisteredStores(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	chids := testutil.NewChannelIDs(peers[0], peers[1])
+	lsys := cidlink.DefaultLinkSystem()
+
+	transport := NewTransport(peers[0], testharness.NewFakeGraphSync(), MaxRegisteredStores(2))
+	require.NoError(t, transport.SetEventHandler(&noopEvents{}))
+
+	// stores are registered up to the limit
+	require.NoError(t, transport.UseStore(chids.ID(1), lsys))
+	require.NoError(t, transport.UseStore(chids.ID(2), lsys))
+	require.Equal(t, 2, transport.RegisteredStoreCount())
+
+	// once the limit is reached, no more stores are registered while all the
+	// channels are in progress
+	err := transport.UseStore(chids.ID(3), lsys)
+	require.ErrorIs(t, err, datatransfer.ErrStoreLimit)
+	err = transport.RegisterNamedStore("shared", lsys)
+	require.ErrorIs(t, err, datatransfer.ErrStoreLimit)
+	require.Equal(t, 2, transport.RegisteredStoreCount())
+
+	// the store of a completed channel is released to make room
+	ch1, err := transport.getDTChannel(chids.ID(1))
+	require.NoError(t, err)
+	ch1.setOutcome(nil)
+	require.NoError(t, transport.UseStore(chids.ID(3), lsys))
+	require.Equal(t, 2, transport.RegisteredStoreCount())
+	_, ok := ch1.store()
+	require.False(t, ok)
+	ch1.storeLk.RLock()
+	require.ErrorIs(t, ch1.storeErr, datatransfer.ErrStoreLimit)
+	ch1.storeLk.RUnlock()
+
+	// cleaning up a channel releases its store
+	transport.CleanupChannel(chids.ID(2))
+	require.Equal(t, 1, transport.RegisteredStoreCount())
+	require.NoError(t, transport.RegisterNamedStore("shared", lsys))
+	require.Equal(t, 2, transport.RegisteredStoreCount())
+}
+
+func TestRegisteredStoreCountUnlimited(t *testing.T) {
+	peers := testutil.GeneratePeers(2)
+	chids := testutil.NewChannelIDs(peers[0], peers[1])
+	lsys := cidlink.DefaultLinkSystem()
+
+	transport := NewTransport(peers[0], testharness.NewFakeGraphSync())
+	require.NoError(t, transport.SetEventHandler(&noopEvents{}))
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, transport.UseStore(chids.ID(datatransfer.TransferID(i)), lsys))
+	}
+	require.Equal(t, 5, transport.RegisteredStoreCount())
+
+	// a store that fails to register is not counted
+	require.Error(t, transport.UseStore(chids.ID(1), lsys))
+	require.Equal(t, 5, transport.RegisteredStoreCount())
+
+	transport.CleanupChannel(chids.ID(1))
+	require.Equal(t, 4, transport.RegisteredStoreCount())
+}
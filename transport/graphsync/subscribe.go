@@ -0,0 +1,320 @@
+package graphsync
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	ipld "github.com/ipld/go-ipld-prime"
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// The number of events buffered for each subscriber. Once a subscriber's
+// buffer is full, further events are dropped until it catches up.
+const subscriberBufferSize = 128
+
+// TransportEventCode identifies the kind of a TransportEvent
+type TransportEventCode int
+
+const (
+	// ChannelOpenedEvent mirrors OnChannelOpened
+	ChannelOpenedEvent TransportEventCode = iota
+
+	// ResponseReceivedEvent mirrors OnResponseReceived
+	ResponseReceivedEvent
+
+	// DataReceivedEvent mirrors OnDataReceived
+	DataReceivedEvent
+
+	// DataQueuedEvent mirrors OnDataQueued
+	DataQueuedEvent
+
+	// DataSentEvent mirrors OnDataSent
+	DataSentEvent
+
+	// TransferInitiatedEvent mirrors OnTransferInitiated
+	TransferInitiatedEvent
+
+	// TransferStartedEvent mirrors OnTransferStarted
+	TransferStartedEvent
+
+	// RequestReceivedEvent mirrors OnRequestReceived
+	RequestReceivedEvent
+
+	// ChannelCompletedEvent mirrors OnChannelCompleted
+	ChannelCompletedEvent
+
+	// RequestCancelledEvent mirrors OnRequestCancelled
+	RequestCancelledEvent
+
+	// ChannelCancelledEvent mirrors OnChannelCancelled
+	ChannelCancelledEvent
+
+	// RequestDisconnectedEvent mirrors OnRequestDisconnected
+	RequestDisconnectedEvent
+
+	// SendDataErrorEvent mirrors OnSendDataError
+	SendDataErrorEvent
+
+	// ReceiveDataErrorEvent mirrors OnReceiveDataError
+	ReceiveDataErrorEvent
+
+	// StoreErrorEvent mirrors OnStoreError
+	StoreErrorEvent
+
+	// ChannelErrorEvent mirrors OnChannelError
+	ChannelErrorEvent
+
+	// ChannelCompletedDetailedEvent mirrors OnChannelCompletedDetailed
+	ChannelCompletedDetailedEvent
+
+	// MalformedMessageEvent mirrors OnMalformedMessage
+	MalformedMessageEvent
+
+	// RequestPausedEvent is published when graphsync reports that the
+	// response for a channel is paused. It does not mirror an events handler
+	// method, and the channel has not completed
+	RequestPausedEvent
+
+	// PartialResponseEvent is published when graphsync reports that the
+	// response for a channel is partway through. It does not mirror an events
+	// handler method, and the channel has not completed
+	PartialResponseEvent
+)
+
+// TransportEventCodes are human readable names for transport events
+var TransportEventCodes = map[TransportEventCode]string{
+	ChannelOpenedEvent:       "ChannelOpened",
+	ResponseReceivedEvent:    "ResponseReceived",
+	DataReceivedEvent:        "DataReceived",
+	DataQueuedEvent:          "DataQueued",
+	DataSentEvent:            "DataSent",
+	TransferInitiatedEvent:   "TransferInitiated",
+	TransferStartedEvent:     "TransferStarted",
+	RequestReceivedEvent:     "RequestReceived",
+	ChannelCompletedEvent:    "ChannelCompleted",
+	RequestCancelledEvent:    "RequestCancelled",
+	ChannelCancelledEvent:    "ChannelCancelled",
+	RequestDisconnectedEvent: "RequestDisconnected",
+	SendDataErrorEvent:       "SendDataError",
+	ReceiveDataErrorEvent:    "ReceiveDataError",
+	StoreErrorEvent:          "StoreError",
+	ChannelErrorEvent:        "ChannelError",
+
+	ChannelCompletedDetailedEvent: "ChannelCompletedDetailed",
+	MalformedMessageEvent:         "MalformedMessage",
+	RequestPausedEvent:            "RequestPaused",
+	PartialResponseEvent:          "PartialResponse",
+}
+
+func (c TransportEventCode) String() string {
+	return TransportEventCodes[c]
+}
+
+// TransportEvent is an event the transport reported to its events handler.
+// Fields that do not apply to the event's Code are left at their zero value.
+type TransportEvent struct {
+	Code      TransportEventCode
+	ChannelID datatransfer.ChannelID
+
+	// Link, Size, Index and Unique are set for data events
+	Link   ipld.Link
+	Size   uint64
+	Index  int64
+	Unique bool
+
+	// Request is set for RequestReceivedEvent
+	Request datatransfer.Request
+	// Response is set for ResponseReceivedEvent
+	Response datatransfer.Response
+	// Result is set for ChannelCompletedDetailedEvent
+	Result datatransfer.CompletionResult
+	// Peer and Extensions are set for MalformedMessageEvent, which has no
+	// ChannelID
+	Peer       peer.ID
+	Extensions []string
+
+	// Err is set for events that report an error, and for
+	// ChannelCompletedEvent if the channel completed with an error
+	Err error
+}
+
+// subscribers fans transport events out to each subscribed Go channel
+type subscribers struct {
+	lk     sync.RWMutex
+	nextID uint64
+	subs   map[uint64]chan TransportEvent
+
+	dropped uint64
+}
+
+func newSubscribers() *subscribers {
+	return &subscribers{subs: make(map[uint64]chan TransportEvent)}
+}
+
+func (s *subscribers) subscribe() (<-chan TransportEvent, func()) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+
+	id := s.nextID
+	s.nextID++
+	sub := make(chan TransportEvent, subscriberBufferSize)
+	s.subs[id] = sub
+
+	return sub, func() {
+		s.lk.Lock()
+		defer s.lk.Unlock()
+
+		if sub, ok := s.subs[id]; ok {
+			delete(s.subs, id)
+			close(sub)
+		}
+	}
+}
+
+// publish sends the event to each subscriber without blocking, dropping it
+// for any subscriber whose buffer is full
+func (s *subscribers) publish(evt TransportEvent) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	for _, sub := range s.subs {
+		select {
+		case sub <- evt:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+func (s *subscribers) droppedCount() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// mirroredEvents passes each event to the registered events handler, then
+// publishes it to the transport's subscribers and records it in the channel's
+// history
+type mirroredEvents struct {
+	events  datatransfer.EventsHandler
+	subs    *subscribers
+	history *channelHistory
+}
+
+var _ datatransfer.EventsHandler = (*mirroredEvents)(nil)
+
+func (me *mirroredEvents) publish(evt TransportEvent) {
+	me.history.record(evt)
+	me.subs.publish(evt)
+}
+
+func (me *mirroredEvents) OnChannelOpened(chid datatransfer.ChannelID) error {
+	err := me.events.OnChannelOpened(chid)
+	me.publish(TransportEvent{Code: ChannelOpenedEvent, ChannelID: chid})
+	return err
+}
+
+func (me *mirroredEvents) OnResponseReceived(chid datatransfer.ChannelID, msg datatransfer.Response) error {
+	err := me.events.OnResponseReceived(chid, msg)
+	me.publish(TransportEvent{Code: ResponseReceivedEvent, ChannelID: chid, Response: msg})
+	return err
+}
+
+func (me *mirroredEvents) OnDataReceived(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	msg, err := me.events.OnDataReceived(chid, link, size, index, unique)
+	me.publish(TransportEvent{Code: DataReceivedEvent, ChannelID: chid, Link: link, Size: size, Index: index, Unique: unique})
+	return msg, err
+}
+
+func (me *mirroredEvents) OnDataQueued(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) (datatransfer.Message, error) {
+	msg, err := me.events.OnDataQueued(chid, link, size, index, unique)
+	me.publish(TransportEvent{Code: DataQueuedEvent, ChannelID: chid, Link: link, Size: size, Index: index, Unique: unique})
+	return msg, err
+}
+
+func (me *mirroredEvents) OnDataSent(chid datatransfer.ChannelID, link ipld.Link, size uint64, index int64, unique bool) error {
+	err := me.events.OnDataSent(chid, link, size, index, unique)
+	me.publish(TransportEvent{Code: DataSentEvent, ChannelID: chid, Link: link, Size: size, Index: index, Unique: unique})
+	return err
+}
+
+func (me *mirroredEvents) OnTransferInitiated(chid datatransfer.ChannelID) {
+	me.events.OnTransferInitiated(chid)
+	me.publish(TransportEvent{Code: TransferInitiatedEvent, ChannelID: chid})
+}
+
+func (me *mirroredEvents) OnTransferStarted(chid datatransfer.ChannelID) {
+	me.events.OnTransferStarted(chid)
+	me.publish(TransportEvent{Code: TransferStartedEvent, ChannelID: chid})
+}
+
+func (me *mirroredEvents) OnRequestReceived(chid datatransfer.ChannelID, msg datatransfer.Request) (datatransfer.Response, error) {
+	response, err := me.events.OnRequestReceived(chid, msg)
+	me.publish(TransportEvent{Code: RequestReceivedEvent, ChannelID: chid, Request: msg})
+	return response, err
+}
+
+func (me *mirroredEvents) OnChannelCompleted(chid datatransfer.ChannelID, err error) error {
+	handlerErr := me.events.OnChannelCompleted(chid, err)
+	me.publish(TransportEvent{Code: ChannelCompletedEvent, ChannelID: chid, Err: err})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnChannelCompletedDetailed(chid datatransfer.ChannelID, result datatransfer.CompletionResult) error {
+	err := me.events.OnChannelCompletedDetailed(chid, result)
+	me.publish(TransportEvent{Code: ChannelCompletedDetailedEvent, ChannelID: chid, Result: result, Err: result.Err})
+	return err
+}
+
+func (me *mirroredEvents) OnRequestCancelled(chid datatransfer.ChannelID, err error) error {
+	handlerErr := me.events.OnRequestCancelled(chid, err)
+	me.publish(TransportEvent{Code: RequestCancelledEvent, ChannelID: chid, Err: err})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnChannelCancelled(chid datatransfer.ChannelID) error {
+	err := me.events.OnChannelCancelled(chid)
+	me.publish(TransportEvent{Code: ChannelCancelledEvent, ChannelID: chid})
+	return err
+}
+
+func (me *mirroredEvents) OnRequestDisconnected(chid datatransfer.ChannelID, err error) error {
+	handlerErr := me.events.OnRequestDisconnected(chid, err)
+	me.publish(TransportEvent{Code: RequestDisconnectedEvent, ChannelID: chid, Err: err})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnSendDataError(chid datatransfer.ChannelID, err error) error {
+	handlerErr := me.events.OnSendDataError(chid, err)
+	me.publish(TransportEvent{Code: SendDataErrorEvent, ChannelID: chid, Err: err})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnReceiveDataError(chid datatransfer.ChannelID, err error) error {
+	handlerErr := me.events.OnReceiveDataError(chid, err)
+	me.publish(TransportEvent{Code: ReceiveDataErrorEvent, ChannelID: chid, Err: err})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnStoreError(chid datatransfer.ChannelID, err error) error {
+	handlerErr := me.events.OnStoreError(chid, err)
+	me.publish(TransportEvent{Code: StoreErrorEvent, ChannelID: chid, Err: err})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnChannelError(chid datatransfer.ChannelID, reason error) error {
+	handlerErr := me.events.OnChannelError(chid, reason)
+	me.publish(TransportEvent{Code: ChannelErrorEvent, ChannelID: chid, Err: reason})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnMalformedMessage(p peer.ID, extensions []string, err error) error {
+	handlerErr := me.events.OnMalformedMessage(p, extensions, err)
+	me.publish(TransportEvent{Code: MalformedMessageEvent, Peer: p, Extensions: extensions, Err: err})
+	return handlerErr
+}
+
+func (me *mirroredEvents) OnContextAugment(chid datatransfer.ChannelID) func(context.Context) context.Context {
+	return me.events.OnContextAugment(chid)
+}
@@ -211,6 +211,22 @@ func (fgs *FakeGraphSync) AssertCancelReceived(ctx context.Context, t *testing.T
 	return cancelReceived
 }
 
+// AssertNoUpdateReceived asserts that no updates were sent by this graphsync implementation
+func (fgs *FakeGraphSync) AssertNoUpdateReceived(t *testing.T) {
+	require.Empty(t, fgs.updates, "should not send update")
+}
+
+// AssertUpdateReceived asserts an update was sent on a request before the context closes (and returns said update)
+func (fgs *FakeGraphSync) AssertUpdateReceived(ctx context.Context, t *testing.T) Update {
+	var updateReceived Update
+	select {
+	case <-ctx.Done():
+		t.Fatal("did not receive message sent")
+	case updateReceived = <-fgs.updates:
+	}
+	return updateReceived
+}
+
 // AssertHasPersistenceOption verifies that a persistence option was registered
 func (fgs *FakeGraphSync) AssertHasPersistenceOption(t *testing.T, name string) ipld.LinkSystem {
 	fgs.persistenceOptionsLk.RLock()
@@ -0,0 +1,106 @@
+package graphsync
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// throughputWindow is the time constant of the moving average used to
+// estimate channel throughput: the weight of the data transferred decays by a
+// factor of e every window
+const throughputWindow = 5 * time.Second
+
+// throughputMeter keeps an exponentially-weighted moving average of the rate
+// at which data is transferred on a channel, in bytes per second
+type throughputMeter struct {
+	lk   sync.Mutex
+	rate float64
+	last time.Time
+}
+
+func newThroughputMeter(now time.Time) *throughputMeter {
+	return &throughputMeter{last: now}
+}
+
+// record that size bytes were transferred at the given time
+func (m *throughputMeter) add(size uint64, now time.Time) {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	elapsed := now.Sub(m.last)
+	if elapsed <= 0 {
+		// the block arrived at the same time as the last sample, so weight
+		// it as if it arrived a nanosecond later
+		elapsed = time.Nanosecond
+	}
+	// the rate at which data was transferred since the last sample, weighted
+	// by how long ago the last sample was
+	instant := float64(size) / elapsed.Seconds()
+	alpha := 1 - math.Exp(-elapsed.Seconds()/throughputWindow.Seconds())
+	m.rate += alpha * (instant - m.rate)
+	if now.After(m.last) {
+		m.last = now
+	}
+}
+
+// the estimated throughput at the given time. When no data has been
+// transferred since the last sample the estimate decays towards zero
+func (m *throughputMeter) estimate(now time.Time) float64 {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	elapsed := now.Sub(m.last)
+	if elapsed <= 0 {
+		return m.rate
+	}
+	return m.rate * math.Exp(-elapsed.Seconds()/throughputWindow.Seconds())
+}
+
+// Throughput returns an estimate of the rate at which data is currently being
+// sent or received on the channel, in bytes per second. The estimate is a
+// moving average over the last few seconds, so it responds to changes in speed
+// while smoothing out bursts of blocks. It returns zero if the transport is not
+// tracking the channel.
+func (t *Transport) Throughput(chid datatransfer.ChannelID) float64 {
+	t.dtChannelsLk.RLock()
+	ch, ok := t.dtChannels[chid]
+	t.dtChannelsLk.RUnlock()
+	if !ok {
+		return 0
+	}
+	return ch.throughput.estimate(time.Now())
+}
+
+// channelThroughputs returns the throughput of each channel the transport is
+// tracking
+func (t *Transport) channelThroughputs() map[datatransfer.ChannelID]float64 {
+	now := time.Now()
+
+	t.dtChannelsLk.RLock()
+	defer t.dtChannelsLk.RUnlock()
+
+	throughputs := make(map[datatransfer.ChannelID]float64, len(t.dtChannels))
+	for chid, ch := range t.dtChannels {
+		throughputs[chid] = ch.throughput.estimate(now)
+	}
+	return throughputs
+}
+
+// reportThroughput calls the throughput callback with the throughput of each
+// channel at each interval, until the transport is shut down
+func (t *Transport) reportThroughput() {
+	ticker := time.NewTicker(t.throughputCallbackInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.throughputCallback(t.channelThroughputs())
+		case <-t.stopLoadReports:
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package graphsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestThroughputMeter(t *testing.T) {
+	start := time.Now()
+	m := newThroughputMeter(start)
+	require.Zero(t, m.estimate(start))
+
+	// a steady rate of 1000 bytes/sec converges on 1000 bytes/sec
+	now := start
+	for i := 0; i < 500; i++ {
+		now = now.Add(100 * time.Millisecond)
+		m.add(100, now)
+	}
+	require.InDelta(t, 1000, m.estimate(now), 10)
+
+	// blocks that arrive at the same instant still count
+	before := m.estimate(now)
+	m.add(1000, now)
+	require.Greater(t, m.estimate(now), before)
+
+	// when data stops flowing the estimate decays towards zero
+	require.Less(t, m.estimate(now.Add(throughputWindow)), m.estimate(now)/2)
+	require.InDelta(t, 0, m.estimate(now.Add(10*throughputWindow)), 1)
+}
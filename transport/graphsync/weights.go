@@ -0,0 +1,226 @@
+package graphsync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// The number of bytes per unit of weight that a channel may send ahead of the
+// slowest active weighted channel to the same peer before it is throttled
+const weightQuantum = 256 << 10
+
+// A weighted channel that has not sent a block for this long is not waited
+// for by the other channels to the same peer, so that a paused or stalled
+// channel cannot hold up the rest
+const weightIdleTimeout = 5 * time.Second
+
+// weightedChannel tracks the bytes a weighted channel has sent
+type weightedChannel struct {
+	weight   uint64
+	sent     uint64
+	lastSent time.Time
+	// throttled is set while the channel's response is paused because the
+	// channel got too far ahead of the other channels to the peer
+	throttled bool
+}
+
+// usage is the bytes sent per unit of weight
+func (wc *weightedChannel) usage() float64 {
+	return float64(wc.sent) / float64(wc.weight)
+}
+
+// channelWeights throttles the blocks sent on weighted channels so that the
+// channels to each peer send data in proportion to their weights. A channel
+// that gets too far ahead is throttled: its response is paused by the caller,
+// and resume is called once the other channels have caught up.
+type channelWeights struct {
+	lk    sync.Mutex
+	peers map[peer.ID]map[datatransfer.ChannelID]*weightedChannel
+	// resume is called, outside of the lock and of graphsync's goroutines,
+	// for each throttled channel that may send again
+	resume func(datatransfer.ChannelID)
+	// idleTimer checks the throttled channels again once the channels they
+	// are waiting on may have become idle
+	idleTimer *time.Timer
+}
+
+func newChannelWeights(resume func(datatransfer.ChannelID)) *channelWeights {
+	return &channelWeights{
+		peers:  make(map[peer.ID]map[datatransfer.ChannelID]*weightedChannel),
+		resume: resume,
+	}
+}
+
+// set the weight of a channel to a peer. A weight of zero stops the channel
+// from being scheduled.
+func (cw *channelWeights) set(p peer.ID, chid datatransfer.ChannelID, weight uint64) {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+
+	if weight == 0 {
+		cw.removeLocked(p, chid)
+		return
+	}
+
+	channels, ok := cw.peers[p]
+	if !ok {
+		channels = make(map[datatransfer.ChannelID]*weightedChannel)
+		cw.peers[p] = channels
+	}
+
+	if wc, ok := channels[chid]; ok {
+		// keep the channel's place in the schedule when its weight changes
+		wc.sent = uint64(wc.usage() * float64(weight))
+		wc.weight = weight
+		cw.releaseLocked(p, time.Now())
+		return
+	}
+
+	// a channel joining the schedule starts level with the slowest active
+	// channel, rather than sending on its own until it catches up
+	wc := &weightedChannel{weight: weight, lastSent: time.Now()}
+	if minUsage, ok := cw.minUsageLocked(channels, chid, wc.lastSent); ok {
+		wc.sent = uint64(minUsage * float64(weight))
+	}
+	channels[chid] = wc
+}
+
+// remove a channel from the schedule
+func (cw *channelWeights) remove(p peer.ID, chid datatransfer.ChannelID) {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+
+	cw.removeLocked(p, chid)
+}
+
+func (cw *channelWeights) removeLocked(p peer.ID, chid datatransfer.ChannelID) {
+	channels, ok := cw.peers[p]
+	if !ok {
+		return
+	}
+	if _, ok := channels[chid]; !ok {
+		return
+	}
+
+	delete(channels, chid)
+	if len(channels) == 0 {
+		delete(cw.peers, p)
+		return
+	}
+	cw.releaseLocked(p, time.Now())
+}
+
+// clear removes all channels from the schedule
+func (cw *channelWeights) clear() {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+
+	cw.peers = make(map[peer.ID]map[datatransfer.ChannelID]*weightedChannel)
+	if cw.idleTimer != nil {
+		cw.idleTimer.Stop()
+		cw.idleTimer = nil
+	}
+}
+
+// sent records a block of the given size sent on the channel. It returns true
+// if the channel is now too far ahead of the other active weighted channels
+// to the peer, in which case the caller must pause the channel's response
+// until resume is called for the channel. Channels that have no weight are
+// never throttled.
+func (cw *channelWeights) sent(p peer.ID, chid datatransfer.ChannelID, size uint64) bool {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+
+	channels := cw.peers[p]
+	wc, ok := channels[chid]
+	if !ok {
+		return false
+	}
+
+	now := time.Now()
+	wc.sent += size
+	wc.lastSent = now
+	wc.throttled = false
+
+	// the block may let other channels catch up with this one
+	cw.releaseLocked(p, now)
+
+	if minUsage, ok := cw.minUsageLocked(channels, chid, now); ok && wc.usage()-minUsage > weightQuantum {
+		wc.throttled = true
+		cw.startIdleTimerLocked()
+	}
+	return wc.throttled
+}
+
+// releaseLocked clears the throttled flag of the channels to the peer that
+// may send again, and resumes them
+func (cw *channelWeights) releaseLocked(p peer.ID, now time.Time) {
+	channels := cw.peers[p]
+	var released []datatransfer.ChannelID
+	for chid, wc := range channels {
+		if !wc.throttled {
+			continue
+		}
+		minUsage, ok := cw.minUsageLocked(channels, chid, now)
+		if !ok || wc.usage()-minUsage <= weightQuantum {
+			wc.throttled = false
+			released = append(released, chid)
+		}
+	}
+	if len(released) > 0 && cw.resume != nil {
+		go func() {
+			for _, chid := range released {
+				cw.resume(chid)
+			}
+		}()
+	}
+}
+
+// startIdleTimerLocked arms the idle timer, if it is not already running
+func (cw *channelWeights) startIdleTimerLocked() {
+	if cw.idleTimer == nil {
+		cw.idleTimer = time.AfterFunc(weightIdleTimeout, cw.checkIdle)
+	}
+}
+
+// checkIdle releases the throttled channels that were waiting on channels
+// that have since become idle, and arms the idle timer again while any
+// channel is still throttled
+func (cw *channelWeights) checkIdle() {
+	cw.lk.Lock()
+	defer cw.lk.Unlock()
+
+	cw.idleTimer = nil
+	now := time.Now()
+	throttled := false
+	for p, channels := range cw.peers {
+		cw.releaseLocked(p, now)
+		for _, wc := range channels {
+			throttled = throttled || wc.throttled
+		}
+	}
+	if throttled {
+		cw.startIdleTimerLocked()
+	}
+}
+
+// minUsageLocked returns the lowest usage of the active channels to a peer,
+// other than the given channel
+func (cw *channelWeights) minUsageLocked(channels map[datatransfer.ChannelID]*weightedChannel, chid datatransfer.ChannelID, now time.Time) (float64, bool) {
+	var minUsage float64
+	found := false
+	for other, wc := range channels {
+		if other == chid || now.Sub(wc.lastSent) > weightIdleTimeout {
+			continue
+		}
+		if usage := wc.usage(); !found || usage < minUsage {
+			minUsage = usage
+			found = true
+		}
+	}
+	return minUsage, found
+}
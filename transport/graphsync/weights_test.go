@@ -0,0 +1,102 @@
+package graphsync
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+func TestChannelWeights(t *testing.T) {
+	p := peer.ID("p")
+	chid1 := datatransfer.ChannelID{Initiator: "i", Responder: p, ID: 1}
+	chid2 := datatransfer.ChannelID{Initiator: "i", Responder: p, ID: 2}
+	unweighted := datatransfer.ChannelID{Initiator: "i", Responder: p, ID: 3}
+
+	resumed := make(chan datatransfer.ChannelID, 1)
+	cw := newChannelWeights(func(chid datatransfer.ChannelID) { resumed <- chid })
+	cw.set(p, chid1, 1)
+	cw.set(p, chid2, 3)
+
+	// channels without a weight are never throttled
+	for i := 0; i < 10; i++ {
+		require.False(t, cw.sent(p, unweighted, weightQuantum))
+	}
+
+	// a channel can keep sending until it is more than a quantum ahead of
+	// the other channel
+	require.False(t, cw.sent(p, chid1, weightQuantum))
+	require.True(t, cw.sent(p, chid1, weightQuantum))
+
+	// the other channel has three times the weight, so it must send three
+	// times as much data before the first channel is resumed
+	require.False(t, cw.sent(p, chid2, weightQuantum))
+	require.False(t, cw.sent(p, chid2, weightQuantum))
+	require.Empty(t, resumed)
+	require.False(t, cw.sent(p, chid2, weightQuantum))
+	select {
+	case chid := <-resumed:
+		require.Equal(t, chid1, chid)
+	case <-time.After(time.Second):
+		require.FailNow(t, "channel should be resumed once the other channel has caught up")
+	}
+
+	// removing the other channel stops the first channel being throttled
+	cw.remove(p, chid2)
+	for i := 0; i < 10; i++ {
+		require.False(t, cw.sent(p, chid1, weightQuantum))
+	}
+}
+
+func TestChannelWeightsResumeOnRemove(t *testing.T) {
+	p := peer.ID("p")
+	chid1 := datatransfer.ChannelID{Initiator: "i", Responder: p, ID: 1}
+	chid2 := datatransfer.ChannelID{Initiator: "i", Responder: p, ID: 2}
+
+	resumed := make(chan datatransfer.ChannelID, 1)
+	cw := newChannelWeights(func(chid datatransfer.ChannelID) { resumed <- chid })
+	cw.set(p, chid1, 1)
+	cw.set(p, chid2, 1)
+	require.False(t, cw.sent(p, chid1, weightQuantum))
+	require.True(t, cw.sent(p, chid1, weightQuantum))
+
+	// a throttled channel is resumed when the channel it is waiting on
+	// leaves the schedule
+	cw.remove(p, chid2)
+	select {
+	case chid := <-resumed:
+		require.Equal(t, chid1, chid)
+	case <-time.After(time.Second):
+		require.FailNow(t, "channel should be resumed once the other channel is removed")
+	}
+}
+
+func TestChannelWeightsNewChannelStartsLevel(t *testing.T) {
+	p := peer.ID("p")
+	chid1 := datatransfer.ChannelID{Initiator: "i", Responder: p, ID: 1}
+	chid2 := datatransfer.ChannelID{Initiator: "i", Responder: p, ID: 2}
+
+	resumed := make(chan datatransfer.ChannelID, 1)
+	cw := newChannelWeights(func(chid datatransfer.ChannelID) { resumed <- chid })
+	cw.set(p, chid1, 1)
+	for i := 0; i < 10; i++ {
+		require.False(t, cw.sent(p, chid1, weightQuantum))
+	}
+
+	// a channel that joins late does not get to send on its own until it
+	// has caught up with the existing channel
+	cw.set(p, chid2, 1)
+	require.False(t, cw.sent(p, chid2, weightQuantum))
+	require.True(t, cw.sent(p, chid2, 2*weightQuantum))
+
+	require.False(t, cw.sent(p, chid1, 2*weightQuantum))
+	select {
+	case chid := <-resumed:
+		require.Equal(t, chid2, chid)
+	case <-time.After(time.Second):
+		require.FailNow(t, "late channel should be resumed once the existing channel has caught up")
+	}
+}
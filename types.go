@@ -1,7 +1,10 @@
 package datatransfer
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -9,6 +12,7 @@ import (
 	"github.com/ipld/go-ipld-prime/datamodel"
 	"github.com/libp2p/go-libp2p/core/peer"
 	cbg "github.com/whyrusleeping/cbor-gen"
+	"golang.org/x/xerrors"
 )
 
 //go:generate cbor-gen-for ChannelID ChannelStages ChannelStage Log
@@ -37,6 +41,17 @@ func (tv1 TypedVoucher) Equals(tv2 TypedVoucher) bool {
 // request/responder and unique to the requester
 type TransferID uint64
 
+// TransferIDGenerator allocates the transfer IDs for the channels a node
+// initiates
+type TransferIDGenerator interface {
+	// Next returns a transfer ID that is greater than any it has returned
+	// before
+	Next() TransferID
+	// Current returns the last transfer ID that was allocated, so that it can
+	// be persisted and used to seed a new generator after a restart
+	Current() TransferID
+}
+
 // ChannelID is a unique identifier for a channel, distinct by both the other
 // party's peer ID + the transfer ID
 type ChannelID struct {
@@ -49,6 +64,72 @@ func (c ChannelID) String() string {
 	return fmt.Sprintf("%s-%s-%d", c.Initiator, c.Responder, c.ID)
 }
 
+// ParseChannelID parses a channel ID from the form produced by
+// ChannelID.String()
+func ParseChannelID(s string) (ChannelID, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 3 {
+		return ChannelID{}, xerrors.Errorf("parsing channel ID %q: expected initiator-responder-id", s)
+	}
+	initiator, err := peer.Decode(parts[0])
+	if err != nil {
+		return ChannelID{}, xerrors.Errorf("parsing channel ID %q: initiator: %w", s, err)
+	}
+	responder, err := peer.Decode(parts[1])
+	if err != nil {
+		return ChannelID{}, xerrors.Errorf("parsing channel ID %q: responder: %w", s, err)
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 64)
+	if err != nil {
+		return ChannelID{}, xerrors.Errorf("parsing channel ID %q: transfer ID: %w", s, err)
+	}
+	return ChannelID{Initiator: initiator, Responder: responder, ID: TransferID(id)}, nil
+}
+
+// channelIDJSON is the JSON representation of a ChannelID
+type channelIDJSON struct {
+	Initiator string `json:"initiator"`
+	Responder string `json:"responder"`
+	ID        uint64 `json:"id"`
+}
+
+// MarshalJSON encodes the channel ID as a JSON object with the peer IDs in
+// their string form
+func (c ChannelID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(channelIDJSON{
+		Initiator: c.Initiator.String(),
+		Responder: c.Responder.String(),
+		ID:        uint64(c.ID),
+	})
+}
+
+// UnmarshalJSON decodes a channel ID encoded with MarshalJSON
+func (c *ChannelID) UnmarshalJSON(data []byte) error {
+	var cj channelIDJSON
+	if err := json.Unmarshal(data, &cj); err != nil {
+		return err
+	}
+	initiator, err := decodeJSONPeer(cj.Initiator)
+	if err != nil {
+		return xerrors.Errorf("decoding channel ID initiator: %w", err)
+	}
+	responder, err := decodeJSONPeer(cj.Responder)
+	if err != nil {
+		return xerrors.Errorf("decoding channel ID responder: %w", err)
+	}
+	*c = ChannelID{Initiator: initiator, Responder: responder, ID: TransferID(cj.ID)}
+	return nil
+}
+
+// decodeJSONPeer decodes a peer ID written by MarshalJSON. An empty peer ID
+// is written as an empty string, which peer.Decode rejects
+func decodeJSONPeer(s string) (peer.ID, error) {
+	if s == "" {
+		return "", nil
+	}
+	return peer.Decode(s)
+}
+
 // OtherParty returns the peer on the other side of the request, depending
 // on whether this peer is the initiator or responder
 func (c ChannelID) OtherParty(thisPeer peer.ID) peer.ID {
@@ -79,7 +160,8 @@ type Channel interface {
 	// Recipient returns the peer id for the node that is receiving data
 	Recipient() peer.ID
 
-	// TotalSize returns the total size for the data being transferred
+	// TotalSize returns the total size for the data being transferred, as
+	// reported by the responder, or 0 if it is not known
 	TotalSize() uint64
 
 	// IsPull returns whether this is a pull request
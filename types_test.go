@@ -0,0 +1,59 @@
+package datatransfer_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/require"
+
+	datatransfer "github.com/filecoin-project/go-data-transfer/v2"
+)
+
+// testutil.GeneratePeers does not generate valid peer IDs, so generate peer
+// IDs that can be parsed back from their string form
+func generateValidPeers(t *testing.T, n int) []peer.ID {
+	peers := make([]peer.ID, 0, n)
+	for i := 0; i < n; i++ {
+		peers = append(peers, test.RandPeerIDFatal(t))
+	}
+	return peers
+}
+
+func TestChannelIDJSON(t *testing.T) {
+	peers := generateValidPeers(t, 2)
+	chid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 42}
+
+	data, err := json.Marshal(chid)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"initiator":"`+peers[0].String()+`","responder":"`+peers[1].String()+`","id":42}`, string(data))
+
+	var decoded datatransfer.ChannelID
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, chid, decoded)
+
+	require.Error(t, json.Unmarshal([]byte(`{"initiator":"not a peer","responder":"","id":1}`), &decoded))
+
+	// the zero value round trips
+	data, err = json.Marshal(datatransfer.ChannelID{})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"initiator":"","responder":"","id":0}`, string(data))
+	decoded = datatransfer.ChannelID{Initiator: peers[0], ID: 1}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, datatransfer.ChannelID{}, decoded)
+}
+
+func TestParseChannelID(t *testing.T) {
+	peers := generateValidPeers(t, 2)
+	chid := datatransfer.ChannelID{Initiator: peers[0], Responder: peers[1], ID: 7}
+
+	parsed, err := datatransfer.ParseChannelID(chid.String())
+	require.NoError(t, err)
+	require.Equal(t, chid, parsed)
+
+	for _, s := range []string{"", "a-b", peers[0].String() + "-" + peers[1].String() + "-x", "a-" + peers[1].String() + "-1"} {
+		_, err := datatransfer.ParseChannelID(s)
+		require.Error(t, err, s)
+	}
+}